@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"os"
+	"path/filepath"
+
+	"github.com/openshift/machine-config-operator/internal/clients"
+	"github.com/openshift/machine-config-operator/pkg/daemon"
+	"github.com/openshift/machine-config-operator/pkg/daemon/constants"
+	"github.com/spf13/cobra"
+	"k8s.io/klog/v2"
+)
+
+var (
+	planCmd = &cobra.Command{
+		Use:   "plan",
+		Short: "Prints the update plan of the node without changing it",
+		Long:  "Prints what the Machine Config Daemon would do to update the node from its current config to its desired config, or to the given config, without changing the node",
+		Args:  cobra.NoArgs,
+		Run:   runPlanCmd,
+	}
+
+	planOpts struct {
+		kubeconfig string
+		nodeName   string
+		rootMount  string
+		config     string
+		output     string
+	}
+)
+
+func init() {
+	rootCmd.AddCommand(planCmd)
+	planCmd.PersistentFlags().StringVar(&planOpts.kubeconfig, "kubeconfig", "", "Kubeconfig file to access a remote cluster (testing only)")
+	planCmd.PersistentFlags().StringVar(&planOpts.nodeName, "node-name", "", "kubernetes node name to plan the update of.")
+	planCmd.PersistentFlags().StringVar(&planOpts.rootMount, "root-mount", "/rootfs", "where the nodes root filesystem is mounted.")
+	planCmd.PersistentFlags().StringVar(&planOpts.config, "config", "", "MachineConfig to update to, defaults to the desired config of the node")
+	planCmd.PersistentFlags().StringVar(&planOpts.output, "output", daemon.UpdatePlanOutputText, "Output format: text or json")
+}
+
+func runPlanCmd(_ *cobra.Command, _ []string) {
+	flag.Set("logtostderr", "true")
+	flag.Parse()
+
+	if planOpts.nodeName == "" {
+		name, ok := os.LookupEnv("NODE_NAME")
+		if !ok || name == "" {
+			klog.Fatalf("node-name is required")
+		}
+		planOpts.nodeName = name
+	}
+
+	cb, err := clients.NewBuilder(planOpts.kubeconfig)
+	if err != nil {
+		klog.Fatalf("Failed to initialize ClientBuilder: %v", err)
+	}
+	kubeClient, err := cb.KubeClient(componentName)
+	if err != nil {
+		klog.Fatalf("Cannot initialize kubeClient: %v", err)
+	}
+
+	_, err = os.Stat(filepath.Join(planOpts.rootMount, constants.MachineConfigDaemonForceFile))
+	force := err == nil
+
+	plan, err := daemon.PlanClusterUpdate(
+		context.TODO(),
+		kubeClient,
+		cb.MachineConfigClientOrDie(componentName),
+		cb.OperatorClientOrDie(componentName),
+		planOpts.nodeName,
+		planOpts.config,
+		force,
+	)
+	if err != nil {
+		klog.Fatalf("%v", err)
+	}
+	if err := daemon.WriteUpdatePlan(os.Stdout, plan, planOpts.output); err != nil {
+		klog.Fatalf("%v", err)
+	}
+}
@@ -37,10 +37,13 @@ var (
 		hypershiftDesiredConfigMap string
 		onceFrom                   string
 		skipReboot                 bool
+		dryRun                     bool
+		output                     string
 		fromIgnition               bool
 		kubeletHealthzEnabled      bool
 		kubeletHealthzEndpoint     string
 		promMetricsURL             string
+		statusURL                  string
 	}
 )
 
@@ -52,9 +55,12 @@ func init() {
 	startCmd.PersistentFlags().StringVar(&startOpts.hypershiftDesiredConfigMap, "desired-configmap", "", "Runs the daemon for a Hypershift hosted cluster node. Requires a configmap with desired config as input.")
 	startCmd.PersistentFlags().StringVar(&startOpts.onceFrom, "once-from", "", "Runs the daemon once using a provided file path or URL endpoint as its machine config or ignition (.ign) file source")
 	startCmd.PersistentFlags().BoolVar(&startOpts.skipReboot, "skip-reboot", false, "Skips reboot after a sync, applies only in once-from")
+	startCmd.PersistentFlags().BoolVar(&startOpts.dryRun, "dry-run", false, "Prints the update plan without changing the node, applies only in once-from")
+	startCmd.PersistentFlags().StringVar(&startOpts.output, "output", daemon.UpdatePlanOutputText, "Output format of the update plan in dry-run: text or json")
 	startCmd.PersistentFlags().BoolVar(&startOpts.kubeletHealthzEnabled, "kubelet-healthz-enabled", true, "kubelet healthz endpoint monitoring")
 	startCmd.PersistentFlags().StringVar(&startOpts.kubeletHealthzEndpoint, "kubelet-healthz-endpoint", "http://localhost:10248/healthz", "healthz endpoint to check health")
 	startCmd.PersistentFlags().StringVar(&startOpts.promMetricsURL, "metrics-url", "127.0.0.1:8797", "URL for prometheus metrics listener")
+	startCmd.PersistentFlags().StringVar(&startOpts.statusURL, "status-url", "127.0.0.1:8799", "URL for the node status listener")
 }
 
 //nolint:gocritic
@@ -71,6 +77,9 @@ func runStartCmd(_ *cobra.Command, _ []string) {
 	os.Setenv("RPMOSTREE_CLIENT_ID", "machine-config-operator")
 
 	onceFromMode := startOpts.onceFrom != ""
+	if startOpts.dryRun && !onceFromMode {
+		klog.Fatalf("dry-run requires once-from")
+	}
 	if !onceFromMode {
 		// in the daemon case
 		if err := daemon.PrepareNamespace(startOpts.rootMount); err != nil {
@@ -109,6 +118,16 @@ func runStartCmd(_ *cobra.Command, _ []string) {
 	// If we are asked to run once and it's a valid file system path use
 	// the bare Daemon
 	if startOpts.onceFrom != "" {
+		if startOpts.dryRun {
+			plan, err := dn.PlanOnceFrom(startOpts.onceFrom)
+			if err != nil {
+				klog.Fatalf("%v", err)
+			}
+			if err := daemon.WriteUpdatePlan(os.Stdout, plan, startOpts.output); err != nil {
+				klog.Fatalf("%v", err)
+			}
+			return
+		}
 		err = dn.RunOnceFrom(startOpts.onceFrom, startOpts.skipReboot)
 		if err != nil {
 			klog.Fatalf("%v", err)
@@ -175,6 +194,11 @@ func runStartCmd(_ *cobra.Command, _ []string) {
 	// Start local metrics listener
 	go ctrlcommon.StartMetricsListener(startOpts.promMetricsURL, stopCh, daemon.RegisterMCDMetrics)
 
+	// Start the node status listener. It is started before connecting to the
+	// cluster, so the status is available while the node is still booting or
+	// cannot reach the API server.
+	go dn.RunStatusListener(startOpts.statusURL, stopCh)
+
 	ctrlctx := ctrlcommon.CreateControllerContext(ctx, cb)
 
 	// create the daemon instance. this also initializes kube client items
@@ -1,9 +1,17 @@
 package common
 
+import (
+	mcfgv1 "github.com/openshift/api/machineconfiguration/v1"
+)
+
 const (
 	// MCONamespace is the namespace that should be used for all API objects owned by the MCO by default
 	MCONamespace = "openshift-machine-config-operator"
 
+	// FileSourcesNamespace is the namespace of the Secrets MachineConfig files can reference as their source.
+	// Nodes can read any Secret of it, so it should only hold Secrets meant to be written to nodes.
+	FileSourcesNamespace = "openshift-machine-config-file-sources"
+
 	// OpenshiftConfigManagedNamespace is the namespace that has the etc-pki-entitlement/Simple Content Access Cert
 	OpenshiftConfigManagedNamespace = "openshift-config-managed"
 
@@ -30,6 +38,91 @@ const (
 	// OSImageURLOverriddenKey is used to tag a rendered machineconfig when OSImageURL has been overridden from default using machineconfig
 	OSImageURLOverriddenKey = "machineconfiguration.openshift.io/os-image-url-overridden"
 
+	// RenderedConfigRetentionAnnotationKey is set on a MachineConfigPool to opt in to garbage collection of its
+	// rendered MachineConfigs. The value is the number of most recent rendered MachineConfigs to keep.
+	RenderedConfigRetentionAnnotationKey = "machineconfiguration.openshift.io/rendered-config-retention"
+
+	// MachineConfigProvenanceAnnotationKey is set on rendered MachineConfigs and holds a JSON encoded MachineConfigProvenance
+	// describing which source MachineConfig produced each file, unit and drop-in.
+	MachineConfigProvenanceAnnotationKey = "machineconfiguration.openshift.io/provenance"
+
+	// CanaryNodeSelectorAnnotationKey is set on a MachineConfigPool to opt in to canary rollouts. The value is a label
+	// selector choosing the nodes of the pool which are updated, and must succeed, before the rest of the pool.
+	CanaryNodeSelectorAnnotationKey = "machineconfiguration.openshift.io/canary-node-selector"
+
+	// CanaryNodeCountAnnotationKey is set on a MachineConfigPool to opt in to canary rollouts. The value is the number
+	// of canary nodes; combined with CanaryNodeSelectorAnnotationKey it limits the number of selected nodes.
+	CanaryNodeCountAnnotationKey = "machineconfiguration.openshift.io/canary-node-count"
+
+	// CanarySoakPeriodAnnotationKey is the duration the canary nodes of a MachineConfigPool must stay updated and
+	// healthy before the rest of the pool is updated, e.g. "30m".
+	CanarySoakPeriodAnnotationKey = "machineconfiguration.openshift.io/canary-soak-period"
+
+	// UpdateTopologyKeyAnnotationKey is set on a MachineConfigPool to spread node updates across failure domains. The
+	// value is a node label key, e.g. "topology.kubernetes.io/zone"; only nodes of one domain are updated at a time.
+	UpdateTopologyKeyAnnotationKey = "machineconfiguration.openshift.io/update-topology-key"
+
+	// MaintenanceWindowScheduleAnnotationKey is set on a MachineConfigPool to only start updating nodes inside
+	// maintenance windows. The value is a standard 5-field cron schedule of the window starts, e.g. "0 2 * * 6".
+	MaintenanceWindowScheduleAnnotationKey = "machineconfiguration.openshift.io/maintenance-window-schedule"
+
+	// MaintenanceWindowDurationAnnotationKey is the duration of the maintenance windows of a MachineConfigPool, e.g. "4h".
+	MaintenanceWindowDurationAnnotationKey = "machineconfiguration.openshift.io/maintenance-window-duration"
+
+	// MaintenanceWindowTimezoneAnnotationKey is the IANA timezone the maintenance window schedule of a
+	// MachineConfigPool is evaluated in, e.g. "Europe/Berlin". Defaults to UTC.
+	MaintenanceWindowTimezoneAnnotationKey = "machineconfiguration.openshift.io/maintenance-window-timezone"
+
+	// PinnedRenderedConfigAnnotationKey is set on a MachineConfigPool to pin it to one of its rendered MachineConfigs,
+	// e.g. to roll back to an earlier config. The render controller targets the pinned config instead of the latest one.
+	PinnedRenderedConfigAnnotationKey = "machineconfiguration.openshift.io/pinned-rendered-config"
+
+	// AutoRollbackDegradedNodesAnnotationKey is set on a MachineConfigPool to roll it back automatically. When the given
+	// number of nodes degrade on the pool's desired config, the pool is pinned to its previous rendered MachineConfig.
+	AutoRollbackDegradedNodesAnnotationKey = "machineconfiguration.openshift.io/auto-rollback-degraded-nodes"
+
+	// MaxDisruptedNodesAnnotationKey is set on the MachineConfiguration cluster object to limit the number of nodes
+	// which are updating or unavailable at once across all MachineConfigPools. The value is a number of nodes or a
+	// percentage of all nodes, e.g. "3" or "10%".
+	MaxDisruptedNodesAnnotationKey = "machineconfiguration.openshift.io/max-disrupted-nodes"
+
+	// UpdatePriorityAnnotationKey is set on a MachineConfigPool to order updates across pools when the number of
+	// disrupted nodes is limited by MaxDisruptedNodesAnnotationKey. Pools with a higher integer value go first; the
+	// default is 0.
+	UpdatePriorityAnnotationKey = "machineconfiguration.openshift.io/update-priority"
+
+	// HealthGatesAnnotationKey is set on a MachineConfigPool to declare the health checks the machine-config-daemon
+	// runs after updating a node and before marking it Done. The value is a JSON list of health gates.
+	HealthGatesAnnotationKey = "machineconfiguration.openshift.io/health-gates"
+
+	// LifecycleHooksAnnotationKey is set on a MachineConfigPool to launch Jobs before each of its nodes is drained
+	// and after it is updated. The value is a JSON list of LifecycleHooks.
+	LifecycleHooksAnnotationKey = "machineconfiguration.openshift.io/lifecycle-hooks"
+
+	// LifecycleHookNameLabelKey and LifecycleHookNodeLabelKey are set on the Jobs launched for lifecycle hooks.
+	LifecycleHookNameLabelKey = "machineconfiguration.openshift.io/lifecycle-hook"
+	LifecycleHookNodeLabelKey = "machineconfiguration.openshift.io/lifecycle-hook-node"
+
+	// LifecycleHookServiceAccountName is the service account the Jobs of lifecycle hooks run as, in the MCO namespace.
+	// It is granted no permissions.
+	LifecycleHookServiceAccountName = "machine-config-lifecycle-hook"
+
+	// DrainBlockersAnnotationKey is set on a Node by the drain controller while its drain is failing. The value is a
+	// JSON encoded DrainBlockerReport listing the pods which cannot be evicted.
+	DrainBlockersAnnotationKey = "machineconfiguration.openshift.io/drain-blockers"
+
+	// DrainPoliciesAnnotationKey is set on the MachineConfiguration cluster object to customize how the drain controller
+	// evicts pods matched by namespace or label selector. The value is a JSON list of drain policies.
+	DrainPoliciesAnnotationKey = "machineconfiguration.openshift.io/drain-policies"
+
+	// ConfigDriftPolicyAnnotationKey is set on a MachineConfigPool to choose what the machine-config-daemon does when
+	// the files or units of a node drift from its MachineConfig: Degrade (the default), Remediate or Report.
+	ConfigDriftPolicyAnnotationKey = "machineconfiguration.openshift.io/config-drift-policy"
+
+	// StageOSBeforeDrainAnnotationKey is set to "true" on a MachineConfigPool to have the machine-config-daemon pull
+	// and stage the new OS deployment before draining its nodes, so that only the reboot disrupts workloads.
+	StageOSBeforeDrainAnnotationKey = "machineconfiguration.openshift.io/stage-os-before-drain"
+
 	// ControllerConfigName is the name of the ControllerConfig object that controllers use
 	ControllerConfigName = "machine-config-controller"
 
@@ -149,3 +242,28 @@ const (
 	// The name of the machine-config-osimageurl ConfigMap.
 	MachineConfigOSImageURLConfigMapName string = "machine-config-osimageurl"
 )
+
+// MachineConfigPool condition types set by the MCO in addition to the ones defined by the API.
+const (
+	// MachineConfigPoolRenderConflicts is true when more than one MachineConfig selected by the pool writes the same
+	// file, unit or drop-in, and a later MachineConfig therefore overrides an earlier one.
+	MachineConfigPoolRenderConflicts mcfgv1.MachineConfigPoolConditionType = "RenderConflicts"
+	// MachineConfigPoolNodeDisruptionPreview is true while the pool's nodes have not yet been updated to the pool's
+	// desired config, and lists the actions they are expected to take according to the NodeDisruptionPolicy.
+	MachineConfigPoolNodeDisruptionPreview mcfgv1.MachineConfigPoolConditionType = "NodeDisruptionPreview"
+	// MachineConfigPoolCanaryRollout is true while the rollout of the pool's desired config is held back to its canary
+	// nodes, and false once the canary nodes have passed.
+	MachineConfigPoolCanaryRollout mcfgv1.MachineConfigPoolConditionType = "CanaryRollout"
+	// MachineConfigPoolWaitingForMaintenanceWindow is true while nodes of the pool are waiting for its next
+	// maintenance window to be updated.
+	MachineConfigPoolWaitingForMaintenanceWindow mcfgv1.MachineConfigPoolConditionType = "WaitingForMaintenanceWindow"
+	// MachineConfigPoolRenderedConfigPinned is true while the pool targets the rendered MachineConfig it is pinned to
+	// instead of the latest one.
+	MachineConfigPoolRenderedConfigPinned mcfgv1.MachineConfigPoolConditionType = "RenderedConfigPinned"
+	// MachineConfigPoolWaitingForDisruptionBudget is true while nodes of the pool are not updated because the
+	// cluster-wide limit of disrupted nodes is reached, or pools with a higher update priority are updating.
+	MachineConfigPoolWaitingForDisruptionBudget mcfgv1.MachineConfigPoolConditionType = "WaitingForDisruptionBudget"
+	// MachineConfigPoolNodeDrainBlocked is true while the drain of nodes of the pool keeps failing, and lists the pods
+	// which cannot be evicted.
+	MachineConfigPoolNodeDrainBlocked mcfgv1.MachineConfigPoolConditionType = "NodeDrainBlocked"
+)
@@ -0,0 +1,114 @@
+package common
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// Reasons a pod blocks the drain of a node.
+const (
+	DrainBlockerReasonPodDisruptionBudget = "PodDisruptionBudget"
+	DrainBlockerReasonTerminating         = "Terminating"
+	DrainBlockerReasonEvictionFailed      = "EvictionFailed"
+)
+
+// MaxDrainBlockerPods is the number of blocking pods listed in a
+// DrainBlockerReport, to keep the node annotation small.
+const MaxDrainBlockerPods = 20
+
+// DrainBlockerReport describes why the drain of a node keeps failing. The
+// drain controller stores it as JSON in the DrainBlockersAnnotationKey
+// annotation of the node while the drain fails.
+type DrainBlockerReport struct {
+	// Since is when the drain first failed.
+	Since metav1.Time `json:"since"`
+	// Pods lists up to MaxDrainBlockerPods of the pods blocking the drain.
+	Pods []DrainBlocker `json:"pods"`
+	// TotalPods is the number of pods blocking the drain.
+	TotalPods int `json:"totalPods"`
+}
+
+// DrainBlocker is a pod which could not be evicted from a node.
+type DrainBlocker struct {
+	Namespace string `json:"namespace"`
+	Name      string `json:"name"`
+	Reason    string `json:"reason"`
+	// PodDisruptionBudget is the name of the PodDisruptionBudget preventing the
+	// eviction, if any.
+	PodDisruptionBudget string `json:"podDisruptionBudget,omitempty"`
+	Message             string `json:"message,omitempty"`
+}
+
+// String returns a short human readable description of the blocking pod.
+func (b DrainBlocker) String() string {
+	msg := fmt.Sprintf("%s/%s: %s", b.Namespace, b.Name, b.Reason)
+	if b.PodDisruptionBudget != "" {
+		msg = fmt.Sprintf("%s %s", msg, b.PodDisruptionBudget)
+	}
+	if b.Message != "" {
+		msg = fmt.Sprintf("%s (%s)", msg, b.Message)
+	}
+	return msg
+}
+
+// NewDrainBlockerReport returns the report of the given blocking pods, which
+// are sorted and truncated to MaxDrainBlockerPods.
+func NewDrainBlockerReport(since time.Time, blockers []DrainBlocker) *DrainBlockerReport {
+	sorted := append([]DrainBlocker{}, blockers...)
+	sort.Slice(sorted, func(i, j int) bool {
+		if sorted[i].Namespace != sorted[j].Namespace {
+			return sorted[i].Namespace < sorted[j].Namespace
+		}
+		return sorted[i].Name < sorted[j].Name
+	})
+	report := &DrainBlockerReport{Since: metav1.NewTime(since), TotalPods: len(sorted)}
+	if len(sorted) > MaxDrainBlockerPods {
+		sorted = sorted[:MaxDrainBlockerPods]
+	}
+	report.Pods = sorted
+	return report
+}
+
+// Summary returns a human readable description of the report.
+func (r *DrainBlockerReport) Summary() string {
+	pods := []string{}
+	for _, pod := range r.Pods {
+		pods = append(pods, pod.String())
+	}
+	if more := r.TotalPods - len(r.Pods); more > 0 {
+		pods = append(pods, fmt.Sprintf("and %d more", more))
+	}
+	return fmt.Sprintf("Drain failing since %s, %d pods cannot be evicted: %s",
+		r.Since.UTC().Format(time.RFC3339), r.TotalPods, strings.Join(pods, "; "))
+}
+
+// Reason returns the most common reason the pods of the report block the drain.
+func (r *DrainBlockerReport) Reason() string {
+	counts := map[string]int{}
+	reason := ""
+	for _, pod := range r.Pods {
+		counts[pod.Reason]++
+		if counts[pod.Reason] > counts[reason] || (counts[pod.Reason] == counts[reason] && pod.Reason < reason) {
+			reason = pod.Reason
+		}
+	}
+	return reason
+}
+
+// ParseDrainBlockerReport returns the report stored in the annotation value,
+// or nil if it is empty.
+func ParseDrainBlockerReport(val string) (*DrainBlockerReport, error) {
+	if val == "" {
+		return nil, nil
+	}
+	report := &DrainBlockerReport{}
+	if err := json.Unmarshal([]byte(val), report); err != nil {
+		return nil, fmt.Errorf("invalid %s annotation: %w", DrainBlockersAnnotationKey, err)
+	}
+	return report, nil
+}
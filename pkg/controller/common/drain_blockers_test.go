@@ -0,0 +1,53 @@
+package common
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDrainBlockerReport(t *testing.T) {
+	since := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+
+	report := NewDrainBlockerReport(since, []DrainBlocker{
+		{Namespace: "db", Name: "postgres-1", Reason: DrainBlockerReasonPodDisruptionBudget, PodDisruptionBudget: "postgres"},
+		{Namespace: "app", Name: "web-0", Reason: DrainBlockerReasonEvictionFailed, Message: "admission webhook denied the request"},
+		{Namespace: "db", Name: "postgres-0", Reason: DrainBlockerReasonPodDisruptionBudget, PodDisruptionBudget: "postgres"},
+	})
+	assert.Equal(t, 3, report.TotalPods)
+	assert.Equal(t, DrainBlockerReasonPodDisruptionBudget, report.Reason())
+	assert.Equal(t, "Drain failing since 2024-05-01T10:00:00Z, 3 pods cannot be evicted: "+
+		"app/web-0: EvictionFailed (admission webhook denied the request); "+
+		"db/postgres-0: PodDisruptionBudget postgres; "+
+		"db/postgres-1: PodDisruptionBudget postgres", report.Summary())
+
+	raw, err := json.Marshal(report)
+	require.NoError(t, err)
+	parsed, err := ParseDrainBlockerReport(string(raw))
+	require.NoError(t, err)
+	assert.Equal(t, report.Summary(), parsed.Summary())
+
+	parsed, err = ParseDrainBlockerReport("")
+	assert.NoError(t, err)
+	assert.Nil(t, parsed)
+
+	_, err = ParseDrainBlockerReport("{")
+	assert.Error(t, err)
+}
+
+func TestDrainBlockerReportTruncated(t *testing.T) {
+	blockers := []DrainBlocker{}
+	for i := 0; i < MaxDrainBlockerPods+5; i++ {
+		blockers = append(blockers, DrainBlocker{Namespace: "app", Name: fmt.Sprintf("web-%02d", i), Reason: DrainBlockerReasonTerminating})
+	}
+
+	report := NewDrainBlockerReport(time.Now(), blockers)
+	assert.Len(t, report.Pods, MaxDrainBlockerPods)
+	assert.Equal(t, MaxDrainBlockerPods+5, report.TotalPods)
+	assert.Equal(t, "web-00", report.Pods[0].Name)
+	assert.Contains(t, report.Summary(), "; and 5 more")
+}
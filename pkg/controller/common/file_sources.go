@@ -0,0 +1,198 @@
+package common
+
+import (
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"net/url"
+	"strings"
+
+	ign3types "github.com/coreos/ignition/v2/config/v3_5/types"
+	"github.com/vincent-petithory/dataurl"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// InClusterSourceHost is the host of file sources referencing an object in
+// the MCO namespace. Such sources are the URL of the object on the API server
+// with the key holding the file contents as query, e.g.
+// https://kubernetes.default.svc/api/v1/namespaces/openshift-machine-config-operator/configmaps/<name>?key=<key>
+// They are resolved with the credentials of the MCD and MCS rather than
+// fetched over HTTPS.
+//
+// Secrets are referenced the same way, but live in the FileSourcesNamespace
+// so nodes are not granted access to the Secrets of the MCO namespace. Their
+// contents never make it into a MachineConfig: the render controller only
+// sets their hash in the rendered MachineConfig, so updating the Secret rolls
+// it out like any other change.
+const InClusterSourceHost = "kubernetes.default.svc"
+
+// InClusterSource is a file source referencing a key of a ConfigMap in the
+// MCO namespace or of a Secret in the FileSourcesNamespace.
+type InClusterSource struct {
+	// Resource is the plural resource of the object, e.g. configmaps.
+	Resource string
+	Name     string
+	Key      string
+}
+
+// inClusterSourceNamespaces are the resources file sources can reference,
+// with the namespace of their objects.
+var inClusterSourceNamespaces = map[string]string{
+	"configmaps": MCONamespace,
+	"secrets":    FileSourcesNamespace,
+}
+
+// IsSecret returns whether the source references a Secret.
+func (s InClusterSource) IsSecret() bool {
+	return s.Resource == "secrets"
+}
+
+// Namespace returns the namespace of the object the source references.
+func (s InClusterSource) Namespace() string {
+	return inClusterSourceNamespaces[s.Resource]
+}
+
+func (s InClusterSource) String() string {
+	u := url.URL{
+		Scheme:   "https",
+		Host:     InClusterSourceHost,
+		Path:     fmt.Sprintf("/api/v1/namespaces/%s/%s/%s", s.Namespace(), s.Resource, s.Name),
+		RawQuery: url.Values{"key": []string{s.Key}}.Encode(),
+	}
+	return u.String()
+}
+
+// ParseInClusterSource returns the object a file source references, or nil
+// if the source is not an in-cluster source.
+func ParseInClusterSource(source string) (*InClusterSource, error) {
+	u, err := url.Parse(source)
+	if err != nil {
+		return nil, fmt.Errorf("invalid file source: %w", err)
+	}
+	if u.Scheme != "https" || u.Host != InClusterSourceHost {
+		return nil, nil
+	}
+	parts := strings.Split(strings.TrimPrefix(u.Path, "/"), "/")
+	if len(parts) != 6 || parts[0] != "api" || parts[1] != "v1" || parts[2] != "namespaces" {
+		return nil, fmt.Errorf("in-cluster file source %q is not the URL of an object", source)
+	}
+	namespace, ok := inClusterSourceNamespaces[parts[4]]
+	if !ok {
+		return nil, fmt.Errorf("in-cluster file source %q references unsupported resource %q", source, parts[4])
+	}
+	if parts[3] != namespace {
+		return nil, fmt.Errorf("in-cluster file source %q must reference %s in the %s namespace", source, parts[4], namespace)
+	}
+	key := u.Query().Get("key")
+	if parts[5] == "" || key == "" {
+		return nil, fmt.Errorf("in-cluster file source %q must have an object name and a key", source)
+	}
+	return &InClusterSource{Resource: parts[4], Name: parts[5], Key: key}, nil
+}
+
+// ConfigMapSourceData returns the contents of the key of a ConfigMap an
+// in-cluster file source references, from either its data or binary data.
+func ConfigMapSourceData(cm *corev1.ConfigMap, key string) ([]byte, error) {
+	if data, ok := cm.Data[key]; ok {
+		return []byte(data), nil
+	}
+	if data, ok := cm.BinaryData[key]; ok {
+		return data, nil
+	}
+	return nil, fmt.Errorf("configmap %s/%s has no key %q", cm.Namespace, cm.Name, key)
+}
+
+// SecretSourceData returns the contents of the key of a Secret an in-cluster
+// file source references.
+func SecretSourceData(secret *corev1.Secret, key string) ([]byte, error) {
+	if data, ok := secret.Data[key]; ok {
+		return data, nil
+	}
+	return nil, fmt.Errorf("secret %s/%s has no key %q", secret.Namespace, secret.Name, key)
+}
+
+// IsRemoteFileSource returns whether a file source is fetched when the file
+// is written rather than inline as a data URL.
+func IsRemoteFileSource(source *string) bool {
+	return source != nil && !strings.HasPrefix(*source, "data:")
+}
+
+// InlineFileContents returns a copy of a file with contents fetched from its
+// remote source inlined as a data URL.
+func InlineFileContents(file ign3types.File, contents []byte) ign3types.File {
+	source := dataurl.EncodeBytes(contents)
+	file.Contents.Source = &source
+	return file
+}
+
+// FileSourceHash returns the Ignition verification hash of contents.
+func FileSourceHash(contents []byte) string {
+	return fmt.Sprintf("sha256-%x", sha256.Sum256(contents))
+}
+
+// VerifyFileSourceHash checks contents against an Ignition verification hash
+// of the form <function>-<hex digest>.
+func VerifyFileSourceHash(verification ign3types.Verification, contents []byte) error {
+	if verification.Hash == nil {
+		return fmt.Errorf("no verification hash")
+	}
+	function, sum, err := verification.HashParts()
+	if err != nil {
+		return err
+	}
+	var h hash.Hash
+	switch function {
+	case "sha256":
+		h = sha256.New()
+	case "sha512":
+		h = sha512.New()
+	default:
+		return fmt.Errorf("unsupported hash function %q", function)
+	}
+	h.Write(contents)
+	if got := hex.EncodeToString(h.Sum(nil)); got != strings.ToLower(sum) {
+		return fmt.Errorf("%s hash mismatch: expected %s, got %s", function, sum, got)
+	}
+	return nil
+}
+
+// validateIgn3FileSources validates the sources of Ignition V3 files. Besides
+// data URLs, only HTTPS and in-cluster sources verified by a hash are
+// supported. Their contents are not compressed nor appended to, so the hash
+// is that of the file on disk and config drift can be checked against it.
+// Secret sources may leave the hash to the render controller.
+func validateIgn3FileSources(cfg ign3types.Config) error {
+	for _, file := range cfg.Storage.Files {
+		for _, fragment := range file.Append {
+			if IsRemoteFileSource(fragment.Source) {
+				return fmt.Errorf("invalid append source for %s: only data URLs can be appended", file.Path)
+			}
+		}
+		if !IsRemoteFileSource(file.Contents.Source) {
+			continue
+		}
+		source := *file.Contents.Source
+		if !strings.HasPrefix(source, "https://") {
+			return fmt.Errorf("invalid source for %s: only data URLs and https sources are supported", file.Path)
+		}
+		ref, err := ParseInClusterSource(source)
+		if err != nil {
+			return fmt.Errorf("invalid source for %s: %w", file.Path, err)
+		}
+		if ref != nil && len(file.Contents.HTTPHeaders) > 0 {
+			return fmt.Errorf("invalid source for %s: in-cluster sources cannot set HTTP headers", file.Path)
+		}
+		if file.Contents.Verification.Hash == nil && (ref == nil || !ref.IsSecret()) {
+			return fmt.Errorf("invalid source for %s: https sources require a verification hash", file.Path)
+		}
+		if file.Contents.Compression != nil && *file.Contents.Compression != "" {
+			return fmt.Errorf("invalid source for %s: https sources cannot be compressed", file.Path)
+		}
+		if len(file.Append) > 0 {
+			return fmt.Errorf("invalid source for %s: https sources cannot be appended to", file.Path)
+		}
+	}
+	return nil
+}
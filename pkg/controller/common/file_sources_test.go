@@ -0,0 +1,131 @@
+package common
+
+import (
+	"crypto/sha256"
+	"crypto/sha512"
+	"fmt"
+	"testing"
+
+	ign3types "github.com/coreos/ignition/v2/config/v3_5/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseInClusterSource(t *testing.T) {
+	ref := InClusterSource{Resource: "configmaps", Name: "bundles", Key: "ca bundle.pem"}
+	parsed, err := ParseInClusterSource(ref.String())
+	require.NoError(t, err)
+	assert.Equal(t, &ref, parsed)
+
+	secret := InClusterSource{Resource: "secrets", Name: "tokens", Key: "token"}
+	parsed, err = ParseInClusterSource(secret.String())
+	require.NoError(t, err)
+	assert.True(t, parsed.IsSecret())
+	assert.Equal(t, FileSourcesNamespace, parsed.Namespace())
+
+	parsed, err = ParseInClusterSource("https://example.com/api/v1/namespaces/openshift-machine-config-operator/configmaps/bundles?key=ca")
+	require.NoError(t, err)
+	assert.Nil(t, parsed)
+
+	for _, source := range []string{
+		"https://kubernetes.default.svc/api/v1/namespaces/openshift-machine-config-operator/configmaps/bundles",
+		"https://kubernetes.default.svc/api/v1/namespaces/default/configmaps/bundles?key=ca",
+		"https://kubernetes.default.svc/api/v1/namespaces/openshift-machine-config-operator/pods/bundles?key=ca",
+		"https://kubernetes.default.svc/api/v1/namespaces/openshift-machine-config-operator/secrets/tokens?key=token",
+		"https://kubernetes.default.svc/apis/v1/bundles?key=ca",
+	} {
+		_, err := ParseInClusterSource(source)
+		assert.Error(t, err, source)
+	}
+}
+
+func TestVerifyFileSourceHash(t *testing.T) {
+	contents := []byte("contents")
+	sha256Hash := fmt.Sprintf("sha256-%x", sha256.Sum256(contents))
+	sha512Hash := fmt.Sprintf("sha512-%x", sha512.Sum512(contents))
+
+	assert.NoError(t, VerifyFileSourceHash(ign3types.Verification{Hash: &sha256Hash}, contents))
+	assert.NoError(t, VerifyFileSourceHash(ign3types.Verification{Hash: &sha512Hash}, contents))
+	assert.ErrorContains(t, VerifyFileSourceHash(ign3types.Verification{Hash: &sha256Hash}, []byte("other")), "hash mismatch")
+	assert.Error(t, VerifyFileSourceHash(ign3types.Verification{}, contents))
+}
+
+func TestValidateIgn3FileSources(t *testing.T) {
+	hash := fmt.Sprintf("sha256-%x", sha256.Sum256([]byte("contents")))
+	inCluster := InClusterSource{Resource: "configmaps", Name: "bundles", Key: "ca"}.String()
+
+	tests := []struct {
+		name    string
+		file    ign3types.File
+		wantErr bool
+	}{
+		{
+			name: "data URL",
+			file: newTestFile(ign3types.Resource{Source: strToPtr("data:,contents")}),
+		},
+		{
+			name: "https with hash",
+			file: newTestFile(ign3types.Resource{Source: strToPtr("https://example.com/contents"), Verification: ign3types.Verification{Hash: &hash}}),
+		},
+		{
+			name: "in-cluster with hash",
+			file: newTestFile(ign3types.Resource{Source: &inCluster, Verification: ign3types.Verification{Hash: &hash}}),
+		},
+		{
+			name: "secret without hash",
+			file: newTestFile(ign3types.Resource{Source: strToPtr(InClusterSource{Resource: "secrets", Name: "tokens", Key: "token"}.String())}),
+		},
+		{
+			name:    "configmap without hash",
+			file:    newTestFile(ign3types.Resource{Source: &inCluster}),
+			wantErr: true,
+		},
+		{
+			name:    "https without hash",
+			file:    newTestFile(ign3types.Resource{Source: strToPtr("https://example.com/contents")}),
+			wantErr: true,
+		},
+		{
+			name:    "http",
+			file:    newTestFile(ign3types.Resource{Source: strToPtr("http://example.com/contents"), Verification: ign3types.Verification{Hash: &hash}}),
+			wantErr: true,
+		},
+		{
+			name:    "compressed https",
+			file:    newTestFile(ign3types.Resource{Source: strToPtr("https://example.com/contents"), Compression: strToPtr("gzip"), Verification: ign3types.Verification{Hash: &hash}}),
+			wantErr: true,
+		},
+		{
+			name:    "invalid in-cluster",
+			file:    newTestFile(ign3types.Resource{Source: strToPtr("https://kubernetes.default.svc/api/v1/namespaces/default/configmaps/bundles?key=ca"), Verification: ign3types.Verification{Hash: &hash}}),
+			wantErr: true,
+		},
+		{
+			name: "https append",
+			file: ign3types.File{
+				Node:          ign3types.Node{Path: "/etc/test"},
+				FileEmbedded1: ign3types.FileEmbedded1{Append: []ign3types.Resource{{Source: strToPtr("https://example.com/contents"), Verification: ign3types.Verification{Hash: &hash}}}},
+			},
+			wantErr: true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			cfg := NewIgnConfig()
+			cfg.Storage.Files = []ign3types.File{test.file}
+			err := ValidateIgnition(cfg)
+			if test.wantErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func newTestFile(contents ign3types.Resource) ign3types.File {
+	return ign3types.File{
+		Node:          ign3types.Node{Path: "/etc/test"},
+		FileEmbedded1: ign3types.FileEmbedded1{Contents: contents},
+	}
+}
@@ -87,26 +87,9 @@ func MergeMachineConfigs(configs []*mcfgv1.MachineConfig, cconfig *mcfgv1.Contro
 		return nil, nil
 	}
 
-	// Overall the sort is alphanumerical, but custom pool configuration should take priority.
-	// Generally speaking if a custom pool is created, the expectation is that custom pool configuration should override base
-	// worker configuration.
-	// This mostly aims to help with generated configs (e.g. kubelet or containerruntime configs) where the pool name is
-	// part of the MachineConfig name, which cannot be directly modified.
-	var workerConfigs, otherConfigs []*mcfgv1.MachineConfig
-	for _, config := range configs {
-		if config.ObjectMeta.Labels == nil {
-			// This shouldn't really be possible
-			return nil, fmt.Errorf("Cannot find label in MachineConfig %s", config.ObjectMeta.Name)
-		}
-		if config.ObjectMeta.Labels[MachineConfigRoleLabel] == MachineConfigPoolWorker {
-			workerConfigs = append(workerConfigs, config)
-		} else {
-			otherConfigs = append(otherConfigs, config)
-		}
+	if err := sortMachineConfigsForMerge(configs); err != nil {
+		return nil, err
 	}
-	sort.SliceStable(workerConfigs, func(i, j int) bool { return workerConfigs[i].Name < workerConfigs[j].Name })
-	sort.SliceStable(otherConfigs, func(i, j int) bool { return otherConfigs[i].Name < otherConfigs[j].Name })
-	configs = append(configs[:0], append(workerConfigs, otherConfigs...)...)
 
 	var fips bool
 	var kernelType string
@@ -216,6 +199,32 @@ func MergeMachineConfigs(configs []*mcfgv1.MachineConfig, cconfig *mcfgv1.Contro
 	}, nil
 }
 
+// sortMachineConfigsForMerge sorts the given configs in place into the order
+// in which MergeMachineConfigs applies them.
+func sortMachineConfigsForMerge(configs []*mcfgv1.MachineConfig) error {
+	// Overall the sort is alphanumerical, but custom pool configuration should take priority.
+	// Generally speaking if a custom pool is created, the expectation is that custom pool configuration should override base
+	// worker configuration.
+	// This mostly aims to help with generated configs (e.g. kubelet or containerruntime configs) where the pool name is
+	// part of the MachineConfig name, which cannot be directly modified.
+	var workerConfigs, otherConfigs []*mcfgv1.MachineConfig
+	for _, config := range configs {
+		if config.ObjectMeta.Labels == nil {
+			// This shouldn't really be possible
+			return fmt.Errorf("Cannot find label in MachineConfig %s", config.ObjectMeta.Name)
+		}
+		if config.ObjectMeta.Labels[MachineConfigRoleLabel] == MachineConfigPoolWorker {
+			workerConfigs = append(workerConfigs, config)
+		} else {
+			otherConfigs = append(otherConfigs, config)
+		}
+	}
+	sort.SliceStable(workerConfigs, func(i, j int) bool { return workerConfigs[i].Name < workerConfigs[j].Name })
+	sort.SliceStable(otherConfigs, func(i, j int) bool { return otherConfigs[i].Name < otherConfigs[j].Name })
+	copy(configs, append(workerConfigs, otherConfigs...))
+	return nil
+}
+
 // PointerConfig generates the stub ignition for the machine to boot properly
 // NOTE: If you change this, you also need to change the pointer configuration in openshift/installer, see
 // https://github.com/openshift/installer/blob/master/pkg/asset/ignition/machine/node.go#L20
@@ -584,7 +593,10 @@ func ValidateIgnition(ignconfig interface{}) error {
 		if report := validate3.ValidateWithContext(cfg, nil); report.IsFatal() {
 			return fmt.Errorf("invalid ignition V3 config found: %v", report)
 		}
-		return validateIgn3FileModes(cfg)
+		if err := validateIgn3FileModes(cfg); err != nil {
+			return err
+		}
+		return validateIgn3FileSources(cfg)
 	default:
 		return fmt.Errorf("unrecognized ignition type")
 	}
@@ -616,7 +628,7 @@ func validateIgn3FileModes(cfg ign3types.Config) error {
 
 // DecodeIgnitionFileContents returns uncompressed, decoded inline file contents.
 // This function does not handle remote resources; it assumes they have already
-// been fetched.
+// been fetched and inlined, see InlineFileContents.
 func DecodeIgnitionFileContents(source, compression *string) ([]byte, error) {
 	var contentsBytes []byte
 
@@ -1105,20 +1117,12 @@ func dedupePasswdUserSSHKeys(passwdUser ign2types.PasswdUser) ign2types.PasswdUs
 	return passwdUser
 }
 
-// CalculateConfigFileDiffs compares the files present in two ignition configurations and returns the list of files
-// that are different between them
-//
-//nolint:dupl
+// CalculateConfigFileDiffs compares the files, directories and links present in two ignition configurations and
+// returns the list of paths that are different between them
 func CalculateConfigFileDiffs(oldIgnConfig, newIgnConfig *ign3types.Config) []string {
-	// Go through the files and see what is new or different
-	oldFileSet := make(map[string]ign3types.File)
-	for _, f := range oldIgnConfig.Storage.Files {
-		oldFileSet[f.Path] = f
-	}
-	newFileSet := make(map[string]ign3types.File)
-	for _, f := range newIgnConfig.Storage.Files {
-		newFileSet[f.Path] = f
-	}
+	// Go through the files, directories and links and see what is new or different
+	oldFileSet := getStoragePathSet(oldIgnConfig)
+	newFileSet := getStoragePathSet(newIgnConfig)
 	diffFileSet := []string{}
 
 	// First check if any files were removed
@@ -1141,6 +1145,22 @@ func CalculateConfigFileDiffs(oldIgnConfig, newIgnConfig *ign3types.Config) []st
 	return diffFileSet
 }
 
+// getStoragePathSet maps the paths of the files, directories and links of an
+// ignition configuration to their entries.
+func getStoragePathSet(ignConfig *ign3types.Config) map[string]interface{} {
+	pathSet := make(map[string]interface{})
+	for _, f := range ignConfig.Storage.Files {
+		pathSet[f.Path] = f
+	}
+	for _, d := range ignConfig.Storage.Directories {
+		pathSet[d.Path] = d
+	}
+	for _, l := range ignConfig.Storage.Links {
+		pathSet[l.Path] = l
+	}
+	return pathSet
+}
+
 // CalculateConfigUnitDiffs compares the units present in two ignition configurations and returns the list of units
 // that are different between them
 //
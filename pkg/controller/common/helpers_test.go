@@ -763,6 +763,13 @@ func TestCalculateConfigFileDiffs(t *testing.T) {
 	if !reflect.DeepEqual(unchangedDiffFileset, []string{}) {
 		t.Errorf("File changes detected where there should have been none: %s", unchangedDiffFileset)
 	}
+
+	// Directories and links are compared by path as well
+	testIgn3ConfigOld.Storage.Links = []ign3types.Link{{Node: ign3types.Node{Path: "/etc/foo"}, LinkEmbedded1: ign3types.LinkEmbedded1{Target: helpers.StrToPtr("/etc/bar")}}}
+	testIgn3ConfigNew.Storage.Files = testIgn3ConfigOld.Storage.Files
+	testIgn3ConfigNew.Storage.Directories = []ign3types.Directory{{Node: ign3types.Node{Path: "/etc/foo.d"}}}
+	testIgn3ConfigNew.Storage.Links = []ign3types.Link{{Node: ign3types.Node{Path: "/etc/foo"}, LinkEmbedded1: ign3types.LinkEmbedded1{Target: helpers.StrToPtr("/etc/baz")}}}
+	assert.ElementsMatch(t, []string{"/etc/foo.d", "/etc/foo"}, CalculateConfigFileDiffs(&testIgn3ConfigOld, &testIgn3ConfigNew))
 }
 
 func TestParseAndConvertGzippedConfig(t *testing.T) {
@@ -0,0 +1,58 @@
+package common
+
+import (
+	"fmt"
+	"sync"
+
+	mcfgv1 "github.com/openshift/api/machineconfiguration/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/tools/record"
+)
+
+// InvalidConfigReporter reports the invalid settings of pools with a Warning
+// event. Pools are synced repeatedly, so each invalid setting is only reported
+// when it becomes invalid or its error changes, not on every sync.
+type InvalidConfigReporter struct {
+	mu sync.Mutex
+	// reported holds the message last reported for each pool and event
+	// reason.
+	reported map[string]string
+}
+
+func invalidConfigKey(pool *mcfgv1.MachineConfigPool, reason string) string {
+	return pool.Name + "/" + reason
+}
+
+// ShouldReport records the message of an invalid setting and returns whether
+// it differs from the one last reported.
+func (r *InvalidConfigReporter) ShouldReport(pool *mcfgv1.MachineConfigPool, reason, message string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.reported == nil {
+		r.reported = map[string]string{}
+	}
+	key := invalidConfigKey(pool, reason)
+	if reported, ok := r.reported[key]; ok && reported == message {
+		return false
+	}
+	r.reported[key] = message
+	return true
+}
+
+// Clear forgets an invalid setting once it is valid again, so it is reported
+// again should it become invalid.
+func (r *InvalidConfigReporter) Clear(pool *mcfgv1.MachineConfigPool, reason string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.reported, invalidConfigKey(pool, reason))
+}
+
+// Report emits a Warning event for an invalid setting of a pool, unless it
+// was already reported.
+func (r *InvalidConfigReporter) Report(recorder record.EventRecorder, pool *mcfgv1.MachineConfigPool, reason, messageFmt string, args ...interface{}) {
+	message := fmt.Sprintf(messageFmt, args...)
+	if !r.ShouldReport(pool, reason, message) {
+		return
+	}
+	recorder.Eventf(pool, corev1.EventTypeWarning, reason, "%s", message)
+}
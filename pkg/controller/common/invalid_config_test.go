@@ -0,0 +1,27 @@
+package common
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/openshift/machine-config-operator/test/helpers"
+)
+
+func TestInvalidConfigReporter(t *testing.T) {
+	worker := helpers.NewMachineConfigPool("worker", nil, helpers.WorkerSelector, "rendered-worker-1")
+	infra := helpers.NewMachineConfigPool("infra", nil, helpers.InfraSelector, "rendered-infra-1")
+	r := &InvalidConfigReporter{}
+
+	assert.True(t, r.ShouldReport(worker, "InvalidFoo", "bad foo"))
+	assert.False(t, r.ShouldReport(worker, "InvalidFoo", "bad foo"))
+	// Other pools and reasons are reported separately.
+	assert.True(t, r.ShouldReport(infra, "InvalidFoo", "bad foo"))
+	assert.True(t, r.ShouldReport(worker, "InvalidBar", "bad foo"))
+	// A changed error is reported again.
+	assert.True(t, r.ShouldReport(worker, "InvalidFoo", "worse foo"))
+
+	// Once valid again, an invalid setting is reported again.
+	r.Clear(worker, "InvalidFoo")
+	assert.True(t, r.ShouldReport(worker, "InvalidFoo", "worse foo"))
+}
@@ -0,0 +1,172 @@
+package common
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	mcfgv1 "github.com/openshift/api/machineconfiguration/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+// LifecycleHookStage is the point of a node update at which a lifecycle hook runs.
+type LifecycleHookStage string
+
+const (
+	// LifecycleHookStagePreDrain hooks run before the node is cordoned and drained.
+	LifecycleHookStagePreDrain LifecycleHookStage = "PreDrain"
+	// LifecycleHookStagePostUpdate hooks run after the node is updated, before it is uncordoned.
+	LifecycleHookStagePostUpdate LifecycleHookStage = "PostUpdate"
+)
+
+// LifecycleHookFailurePolicy determines what happens to the update of a node when one of its hooks fails.
+type LifecycleHookFailurePolicy string
+
+const (
+	// LifecycleHookFailurePolicyFail blocks the update of the node until the hook succeeds.
+	LifecycleHookFailurePolicyFail LifecycleHookFailurePolicy = "Fail"
+	// LifecycleHookFailurePolicyIgnore continues the update of the node.
+	LifecycleHookFailurePolicyIgnore LifecycleHookFailurePolicy = "Ignore"
+)
+
+// DefaultLifecycleHookTimeout is how long a lifecycle hook may run if it does not set its own timeout.
+const DefaultLifecycleHookTimeout = 30 * time.Minute
+
+// LifecycleHook is a Job launched in the MCO namespace for each node of a
+// MachineConfigPool at a given stage of its update. The update of the node
+// waits for the Job to complete. The Job runs as the
+// LifecycleHookServiceAccountName service account and cannot use the host
+// namespaces, host paths nor privileged containers, since the MCO namespace
+// allows privileged pods.
+type LifecycleHook struct {
+	// Name identifies the hook; it must be a valid DNS label.
+	Name string `json:"name"`
+	// Stage is when the hook runs.
+	Stage LifecycleHookStage `json:"stage"`
+	// PinToNode runs the pods of the Job on the node being updated.
+	PinToNode bool `json:"pinToNode,omitempty"`
+	// Timeout is how long the Job may run, as a duration string. Defaults to 30m.
+	Timeout string `json:"timeout,omitempty"`
+	// FailurePolicy defaults to Fail.
+	FailurePolicy LifecycleHookFailurePolicy `json:"failurePolicy,omitempty"`
+	// Template is the Job to launch.
+	Template batchv1.JobTemplateSpec `json:"template"`
+}
+
+// GetTimeout returns how long the hook may run. It must only be called on
+// hooks returned by GetLifecycleHooks.
+func (h *LifecycleHook) GetTimeout() time.Duration {
+	if h.Timeout == "" {
+		return DefaultLifecycleHookTimeout
+	}
+	// Validated by GetLifecycleHooks.
+	timeout, _ := time.ParseDuration(h.Timeout)
+	return timeout
+}
+
+func (h *LifecycleHook) validate() error {
+	if errs := validation.IsDNS1123Label(h.Name); len(errs) > 0 {
+		return fmt.Errorf("invalid name %q: %v", h.Name, errs)
+	}
+	if h.Stage != LifecycleHookStagePreDrain && h.Stage != LifecycleHookStagePostUpdate {
+		return fmt.Errorf("%s: stage must be %s or %s", h.Name, LifecycleHookStagePreDrain, LifecycleHookStagePostUpdate)
+	}
+	if h.Timeout != "" {
+		if timeout, err := time.ParseDuration(h.Timeout); err != nil || timeout <= 0 {
+			return fmt.Errorf("%s: invalid timeout %q", h.Name, h.Timeout)
+		}
+	}
+	switch h.FailurePolicy {
+	case "":
+		h.FailurePolicy = LifecycleHookFailurePolicyFail
+	case LifecycleHookFailurePolicyFail, LifecycleHookFailurePolicyIgnore:
+	default:
+		return fmt.Errorf("%s: failurePolicy must be %s or %s", h.Name, LifecycleHookFailurePolicyFail, LifecycleHookFailurePolicyIgnore)
+	}
+	if len(h.Template.Spec.Template.Spec.Containers) == 0 {
+		return fmt.Errorf("%s: template must have at least one container", h.Name)
+	}
+	if err := validateLifecycleHookPodSpec(&h.Template.Spec.Template.Spec); err != nil {
+		return fmt.Errorf("%s: %w", h.Name, err)
+	}
+	return nil
+}
+
+// validateLifecycleHookPodSpec rejects the pod settings which would let a hook
+// escape its service account or the node isolation of its pods.
+func validateLifecycleHookPodSpec(spec *corev1.PodSpec) error {
+	if spec.ServiceAccountName != "" || spec.DeprecatedServiceAccount != "" {
+		return fmt.Errorf("template cannot set a service account, hooks run as %s", LifecycleHookServiceAccountName)
+	}
+	if spec.HostPID || spec.HostNetwork || spec.HostIPC {
+		return fmt.Errorf("template cannot use the host namespaces")
+	}
+	for _, volume := range spec.Volumes {
+		if volume.HostPath != nil {
+			return fmt.Errorf("template cannot mount host path %s", volume.HostPath.Path)
+		}
+	}
+	containers := append(append([]corev1.Container{}, spec.InitContainers...), spec.Containers...)
+	for _, container := range containers {
+		sc := container.SecurityContext
+		if sc == nil {
+			continue
+		}
+		if sc.Privileged != nil && *sc.Privileged {
+			return fmt.Errorf("container %s cannot be privileged", container.Name)
+		}
+		if sc.AllowPrivilegeEscalation != nil && *sc.AllowPrivilegeEscalation {
+			return fmt.Errorf("container %s cannot allow privilege escalation", container.Name)
+		}
+		if sc.Capabilities != nil && len(sc.Capabilities.Add) > 0 {
+			return fmt.Errorf("container %s cannot add capabilities", container.Name)
+		}
+	}
+	return nil
+}
+
+// GetLifecycleHooks returns the validated lifecycle hooks of the given stage
+// declared on a MachineConfigPool, in the order they must run.
+func GetLifecycleHooks(pool *mcfgv1.MachineConfigPool, stage LifecycleHookStage) ([]LifecycleHook, error) {
+	val, ok := pool.Annotations[LifecycleHooksAnnotationKey]
+	if !ok {
+		return nil, nil
+	}
+
+	var hooks []LifecycleHook
+	if err := json.Unmarshal([]byte(val), &hooks); err != nil {
+		return nil, fmt.Errorf("invalid %s annotation on pool %s: %w", LifecycleHooksAnnotationKey, pool.Name, err)
+	}
+
+	names := map[string]bool{}
+	staged := []LifecycleHook{}
+	for i := range hooks {
+		if err := hooks[i].validate(); err != nil {
+			return nil, fmt.Errorf("invalid %s annotation on pool %s: %w", LifecycleHooksAnnotationKey, pool.Name, err)
+		}
+		if names[hooks[i].Name] {
+			return nil, fmt.Errorf("invalid %s annotation on pool %s: duplicate hook %s", LifecycleHooksAnnotationKey, pool.Name, hooks[i].Name)
+		}
+		names[hooks[i].Name] = true
+		if hooks[i].Stage == stage {
+			staged = append(staged, hooks[i])
+		}
+	}
+	return staged, nil
+}
+
+// GetLifecycleHooksTimeout returns how long the lifecycle hooks of the given
+// stage of a MachineConfigPool may run in total, or zero if it has none.
+func GetLifecycleHooksTimeout(pool *mcfgv1.MachineConfigPool, stage LifecycleHookStage) (time.Duration, error) {
+	hooks, err := GetLifecycleHooks(pool, stage)
+	if err != nil {
+		return 0, err
+	}
+	var total time.Duration
+	for i := range hooks {
+		total += hooks[i].GetTimeout()
+	}
+	return total, nil
+}
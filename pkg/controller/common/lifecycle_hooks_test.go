@@ -0,0 +1,122 @@
+package common
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/openshift/machine-config-operator/test/helpers"
+)
+
+func TestGetLifecycleHooks(t *testing.T) {
+	const template = `"template": {"spec": {"template": {"spec": {"containers": [{"name": "hook", "image": "quay.io/example/hook"}], "restartPolicy": "Never"}}}}`
+
+	tests := []struct {
+		name            string
+		annotation      string
+		expectedPre     []string
+		expectedPost    []string
+		expectedTimeout time.Duration
+		expectErr       bool
+	}{{
+		name: "no hooks",
+	}, {
+		name: "hooks of both stages",
+		annotation: `[
+			{"name": "evacuate", "stage": "PreDrain", "timeout": "1h", ` + template + `},
+			{"name": "notify", "stage": "PreDrain", "failurePolicy": "Ignore", ` + template + `},
+			{"name": "rejoin", "stage": "PostUpdate", "pinToNode": true, ` + template + `}
+		]`,
+		expectedPre:     []string{"evacuate", "notify"},
+		expectedPost:    []string{"rejoin"},
+		expectedTimeout: time.Hour + DefaultLifecycleHookTimeout,
+	}, {
+		name:       "invalid JSON",
+		annotation: `{"name": "evacuate"}`,
+		expectErr:  true,
+	}, {
+		name:       "invalid name",
+		annotation: `[{"name": "Evacuate", "stage": "PreDrain", ` + template + `}]`,
+		expectErr:  true,
+	}, {
+		name:       "invalid stage",
+		annotation: `[{"name": "evacuate", "stage": "PreReboot", ` + template + `}]`,
+		expectErr:  true,
+	}, {
+		name:       "service account",
+		annotation: `[{"name": "evacuate", "stage": "PreDrain", "template": {"spec": {"template": {"spec": {"serviceAccountName": "admin", "containers": [{"name": "hook", "image": "quay.io/example/hook"}]}}}}}]`,
+		expectErr:  true,
+	}, {
+		name:       "host PID",
+		annotation: `[{"name": "evacuate", "stage": "PreDrain", "template": {"spec": {"template": {"spec": {"hostPID": true, "containers": [{"name": "hook", "image": "quay.io/example/hook"}]}}}}}]`,
+		expectErr:  true,
+	}, {
+		name:       "host network",
+		annotation: `[{"name": "evacuate", "stage": "PreDrain", "template": {"spec": {"template": {"spec": {"hostNetwork": true, "containers": [{"name": "hook", "image": "quay.io/example/hook"}]}}}}}]`,
+		expectErr:  true,
+	}, {
+		name:       "host path",
+		annotation: `[{"name": "evacuate", "stage": "PreDrain", "template": {"spec": {"template": {"spec": {"volumes": [{"name": "root", "hostPath": {"path": "/"}}], "containers": [{"name": "hook", "image": "quay.io/example/hook"}]}}}}}]`,
+		expectErr:  true,
+	}, {
+		name:       "privileged init container",
+		annotation: `[{"name": "evacuate", "stage": "PreDrain", "template": {"spec": {"template": {"spec": {"initContainers": [{"name": "init", "image": "quay.io/example/hook", "securityContext": {"privileged": true}}], "containers": [{"name": "hook", "image": "quay.io/example/hook"}]}}}}}]`,
+		expectErr:  true,
+	}, {
+		name:       "invalid timeout",
+		annotation: `[{"name": "evacuate", "stage": "PreDrain", "timeout": "-1m", ` + template + `}]`,
+		expectErr:  true,
+	}, {
+		name:       "invalid failure policy",
+		annotation: `[{"name": "evacuate", "stage": "PreDrain", "failurePolicy": "Retry", ` + template + `}]`,
+		expectErr:  true,
+	}, {
+		name:       "missing template",
+		annotation: `[{"name": "evacuate", "stage": "PreDrain"}]`,
+		expectErr:  true,
+	}, {
+		name: "duplicate name",
+		annotation: `[
+			{"name": "evacuate", "stage": "PreDrain", ` + template + `},
+			{"name": "evacuate", "stage": "PostUpdate", ` + template + `}
+		]`,
+		expectErr: true,
+	}}
+
+	names := func(hooks []LifecycleHook) []string {
+		var out []string
+		for _, hook := range hooks {
+			out = append(out, hook.Name)
+		}
+		return out
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			pool := helpers.NewMachineConfigPool("worker", nil, helpers.WorkerSelector, "rendered-worker-1")
+			if test.annotation != "" {
+				pool.Annotations = map[string]string{LifecycleHooksAnnotationKey: test.annotation}
+			}
+
+			pre, err := GetLifecycleHooks(pool, LifecycleHookStagePreDrain)
+			if test.expectErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, test.expectedPre, names(pre))
+			for _, hook := range pre {
+				assert.NotEmpty(t, hook.FailurePolicy)
+			}
+
+			post, err := GetLifecycleHooks(pool, LifecycleHookStagePostUpdate)
+			assert.NoError(t, err)
+			assert.Equal(t, test.expectedPost, names(post))
+
+			timeout, err := GetLifecycleHooksTimeout(pool, LifecycleHookStagePreDrain)
+			assert.NoError(t, err)
+			assert.Equal(t, test.expectedTimeout, timeout)
+		})
+	}
+}
@@ -0,0 +1,205 @@
+package common
+
+import (
+	"fmt"
+	"reflect"
+
+	mcfgv1 "github.com/openshift/api/machineconfiguration/v1"
+	opv1 "github.com/openshift/api/operator/v1"
+	"k8s.io/klog/v2"
+
+	"github.com/openshift/machine-config-operator/pkg/apihelpers"
+)
+
+// MachineConfigDiff is what differs between two MachineConfigs, as far as the
+// actions of an update from one to the other are concerned.
+type MachineConfigDiff struct {
+	OSUpdate   bool
+	Kargs      bool
+	FIPS       bool
+	Passwd     bool
+	Files      bool
+	Units      bool
+	KernelType bool
+	Extensions bool
+}
+
+// NewMachineConfigDiff compares two MachineConfigs.
+func NewMachineConfigDiff(oldConfig, newConfig *mcfgv1.MachineConfig) (*MachineConfigDiff, error) {
+	oldIgn, err := ParseAndConvertConfig(oldConfig.Spec.Config.Raw)
+	if err != nil {
+		return nil, fmt.Errorf("parsing old Ignition config failed with error: %w", err)
+	}
+	newIgn, err := ParseAndConvertConfig(newConfig.Spec.Config.Raw)
+	if err != nil {
+		return nil, fmt.Errorf("parsing new Ignition config failed with error: %w", err)
+	}
+
+	// Both nil and empty slices are of zero length,
+	// consider them as equal while comparing KernelArguments in both MachineConfigs
+	kargsEmpty := len(oldConfig.Spec.KernelArguments) == 0 && len(newConfig.Spec.KernelArguments) == 0
+	extensionsEmpty := len(oldConfig.Spec.Extensions) == 0 && len(newConfig.Spec.Extensions) == 0
+
+	return &MachineConfigDiff{
+		OSUpdate:   oldConfig.Spec.OSImageURL != newConfig.Spec.OSImageURL,
+		Kargs:      !(kargsEmpty || reflect.DeepEqual(oldConfig.Spec.KernelArguments, newConfig.Spec.KernelArguments)),
+		FIPS:       oldConfig.Spec.FIPS != newConfig.Spec.FIPS,
+		Passwd:     !reflect.DeepEqual(oldIgn.Passwd, newIgn.Passwd),
+		Files:      !reflect.DeepEqual(oldIgn.Storage.Files, newIgn.Storage.Files) || !reflect.DeepEqual(oldIgn.Storage.Directories, newIgn.Storage.Directories) || !reflect.DeepEqual(oldIgn.Storage.Links, newIgn.Storage.Links),
+		Units:      !reflect.DeepEqual(oldIgn.Systemd.Units, newIgn.Systemd.Units),
+		KernelType: CanonicalizeKernelType(oldConfig.Spec.KernelType) != CanonicalizeKernelType(newConfig.Spec.KernelType),
+		Extensions: !(extensionsEmpty || reflect.DeepEqual(oldConfig.Spec.Extensions, newConfig.Spec.Extensions)),
+	}, nil
+}
+
+// CalculateNodeDisruptionActions predicts the actions a node will take when
+// updating from oldConfig to newConfig, based on the cluster's
+// NodeDisruptionPolicies. This is the calculation of the MCD, except for the
+// checks which need the node itself: force is whether the node has the
+// machine-config-daemon-force file, which makes it reboot whatever the changes
+// are. An error is returned if the update cannot be applied in place.
+func CalculateNodeDisruptionActions(oldConfig, newConfig *mcfgv1.MachineConfig, clusterPolicies opv1.NodeDisruptionPolicyClusterStatus, force bool) ([]opv1.NodeDisruptionPolicyStatusAction, error) {
+	if err := IsRenderedConfigReconcilable(oldConfig, newConfig); err != nil {
+		return nil, fmt.Errorf("configs %s, %s are not reconcilable: %w", oldConfig.Name, newConfig.Name, err)
+	}
+	if force {
+		return []opv1.NodeDisruptionPolicyStatusAction{{
+			Type: opv1.RebootStatusAction,
+		}}, nil
+	}
+
+	oldIgnConfig, err := ParseAndConvertConfig(oldConfig.Spec.Config.Raw)
+	if err != nil {
+		return nil, fmt.Errorf("parsing Ignition config failed for MachineConfig %s: %w", oldConfig.Name, err)
+	}
+	newIgnConfig, err := ParseAndConvertConfig(newConfig.Spec.Config.Raw)
+	if err != nil {
+		return nil, fmt.Errorf("parsing Ignition config failed for MachineConfig %s: %w", newConfig.Name, err)
+	}
+	diff, err := NewMachineConfigDiff(oldConfig, newConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	diffFileSet := CalculateConfigFileDiffs(&oldIgnConfig, &newIgnConfig)
+	diffUnitSet := CalculateConfigUnitDiffs(&oldIgnConfig, &newIgnConfig)
+	return CalculateNodeDisruptionActionsForDiff(diff, diffFileSet, diffUnitSet, clusterPolicies), nil
+}
+
+// CalculateNodeDisruptionActionsForDiff returns the node disruption actions
+// for a MachineConfig diff under the given cluster policies.
+func CalculateNodeDisruptionActionsForDiff(diff *MachineConfigDiff, diffFileSet, diffUnitSet []string, clusterPolicies opv1.NodeDisruptionPolicyClusterStatus) []opv1.NodeDisruptionPolicyStatusAction {
+	if diff.OSUpdate || diff.Kargs || diff.FIPS || diff.KernelType || diff.Extensions {
+		// must reboot
+		return []opv1.NodeDisruptionPolicyStatusAction{{
+			Type: opv1.RebootStatusAction,
+		}}
+	}
+	if !diff.Files && !diff.Units && !diff.Passwd {
+		// This is a diff which requires no actions
+		klog.Infof("No changes in files, units or SSH keys, no NodeDisruptionPolicies are in effect")
+		return []opv1.NodeDisruptionPolicyStatusAction{{
+			Type: opv1.NoneStatusAction,
+		}}
+	}
+
+	// Calculate actions based on file, unit and ssh diffs
+	return CalculateNodeDisruptionActionsFromMCDiffs(diff.Passwd, diffFileSet, diffUnitSet, clusterPolicies)
+}
+
+// CalculateNodeDisruptionActionsFromMCDiffs determines the actions required
+// for the given file, unit and SSH key changes based on the cluster's
+// NodeDisruptionPolicies.
+func CalculateNodeDisruptionActionsFromMCDiffs(diffSSH bool, diffFileSet, diffUnitSet []string, clusterPolicies opv1.NodeDisruptionPolicyClusterStatus) []opv1.NodeDisruptionPolicyStatusAction {
+	actions := []opv1.NodeDisruptionPolicyStatusAction{}
+
+	// Step through all file based policies, and build out the actions object
+	for _, diffPath := range diffFileSet {
+		pathFound, actionsFound := FindClosestFilePolicyPathMatch(diffPath, clusterPolicies.Files)
+		if pathFound {
+			klog.Infof("NodeDisruptionPolicy %v found for diff file %s", actionsFound, diffPath)
+			actions = append(actions, actionsFound...)
+		} else {
+			// If this file path has no policy defined, default to reboot
+			klog.V(4).Infof("no policy found for diff path %s", diffPath)
+			return []opv1.NodeDisruptionPolicyStatusAction{{
+				Type: opv1.RebootStatusAction,
+			}}
+		}
+	}
+
+	// Step through all unit based policies, and build out the actions object
+	for _, diffUnit := range diffUnitSet {
+		unitFound := false
+		for _, policyUnit := range clusterPolicies.Units {
+			klog.V(4).Infof("comparing policy unit name %s to diff unit name %s", string(policyUnit.Name), diffUnit)
+			if string(policyUnit.Name) == diffUnit {
+				klog.Infof("NodeDisruptionPolicy %v found for diff unit %s!", policyUnit.Actions, diffUnit)
+				actions = append(actions, policyUnit.Actions...)
+				unitFound = true
+				break
+			}
+		}
+		if !unitFound {
+			// If this unit has no policy defined, default to reboot
+			klog.V(4).Infof("no policy found for diff unit %s", diffUnit)
+			return []opv1.NodeDisruptionPolicyStatusAction{{
+				Type: opv1.RebootStatusAction,
+			}}
+		}
+	}
+
+	// SSH only has one possible policy(and there is a default), so blindly add that if there is an SSH diff
+	if diffSSH {
+		klog.Infof("SSH diff detected, applying SSH policy %v", clusterPolicies.SSHKey.Actions)
+		actions = append(actions, clusterPolicies.SSHKey.Actions...)
+	}
+
+	// If any of the actions need a reboot, then just return a single Reboot action
+	if apihelpers.CheckNodeDisruptionActionsForTargetActions(actions, opv1.RebootStatusAction) {
+		return []opv1.NodeDisruptionPolicyStatusAction{{
+			Type: opv1.RebootStatusAction,
+		}}
+	}
+
+	// If there is a "None" action in conjunction with other kinds of actions, strip out the "None" action elements as it is redundant
+	if apihelpers.CheckNodeDisruptionActionsForTargetActions(actions, opv1.NoneStatusAction) {
+		if apihelpers.CheckNodeDisruptionActionsForTargetActions(actions, opv1.DrainStatusAction, opv1.ReloadStatusAction, opv1.RestartStatusAction, opv1.DaemonReloadStatusAction, opv1.SpecialStatusAction) {
+			finalActions := []opv1.NodeDisruptionPolicyStatusAction{}
+			for _, action := range actions {
+				if action.Type != opv1.NoneStatusAction {
+					finalActions = append(finalActions, action)
+				}
+			}
+			return finalActions
+		}
+		// If we're here, this means that the action list has only "None" actions; return a single "None" Action
+		return []opv1.NodeDisruptionPolicyStatusAction{{
+			Type: opv1.NoneStatusAction,
+		}}
+	}
+
+	// If we're here, return as is - this means action list had zero "None" actions in the list
+	return actions
+}
+
+// NodeDisruptionActionToString returns a short human readable description of
+// a NodeDisruptionPolicy action, e.g. "Reboot" or "Restart crio.service".
+func NodeDisruptionActionToString(action opv1.NodeDisruptionPolicyStatusAction) string {
+	switch {
+	case action.Type == opv1.ReloadStatusAction && action.Reload != nil:
+		return fmt.Sprintf("%s %s", action.Type, action.Reload.ServiceName)
+	case action.Type == opv1.RestartStatusAction && action.Restart != nil:
+		return fmt.Sprintf("%s %s", action.Type, action.Restart.ServiceName)
+	default:
+		return string(action.Type)
+	}
+}
+
+// CanonicalizeKernelType returns a valid kernelType. We consider empty("") and default kernelType as same
+func CanonicalizeKernelType(kernelType string) string {
+	if kernelType == KernelTypeRealtime || kernelType == KernelType64kPages {
+		return kernelType
+	}
+	return KernelTypeDefault
+}
@@ -0,0 +1,185 @@
+package common
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	mcfgv1 "github.com/openshift/api/machineconfiguration/v1"
+)
+
+// Kinds of Ignition entries tracked by MachineConfigProvenance.
+const (
+	ProvenanceKindFile   = "file"
+	ProvenanceKindUnit   = "unit"
+	ProvenanceKindDropin = "dropin"
+)
+
+// MachineConfigProvenance records which source MachineConfig produced each
+// file, systemd unit and systemd drop-in of a rendered MachineConfig. Drop-ins
+// are keyed as "<unit>/<drop-in>".
+type MachineConfigProvenance struct {
+	Files     map[string]string           `json:"files,omitempty"`
+	Units     map[string]string           `json:"units,omitempty"`
+	Dropins   map[string]string           `json:"dropins,omitempty"`
+	Overrides []MachineConfigOverrideInfo `json:"overrides,omitempty"`
+}
+
+// MachineConfigOverrideInfo describes an entry which was written by more than
+// one MachineConfig. The MachineConfigs in OverriddenBy replaced the content
+// written by the earlier MachineConfigs, in merge order; the last one wins.
+type MachineConfigOverrideInfo struct {
+	Kind         string   `json:"kind"`
+	Name         string   `json:"name"`
+	Source       string   `json:"source"`
+	OverriddenBy []string `json:"overriddenBy"`
+}
+
+// String returns a short human readable description of the override.
+func (o MachineConfigOverrideInfo) String() string {
+	return fmt.Sprintf("%s %s from %s overridden by %s", o.Kind, o.Name, o.Source, strings.Join(o.OverriddenBy, ", "))
+}
+
+// CalculateMachineConfigProvenance determines which of the given
+// MachineConfigs produced each file, unit and drop-in when they are merged by
+// MergeMachineConfigs. Overrides between two MachineConfigs which are both
+// generated by the controller are intentional and are not reported.
+func CalculateMachineConfigProvenance(configs []*mcfgv1.MachineConfig) (*MachineConfigProvenance, error) {
+	sorted := make([]*mcfgv1.MachineConfig, len(configs))
+	copy(sorted, configs)
+	if err := sortMachineConfigsForMerge(sorted); err != nil {
+		return nil, err
+	}
+
+	files := newProvenanceTracker(ProvenanceKindFile)
+	units := newProvenanceTracker(ProvenanceKindUnit)
+	dropins := newProvenanceTracker(ProvenanceKindDropin)
+
+	for _, config := range sorted {
+		if config.Spec.Config.Raw == nil {
+			continue
+		}
+
+		ignCfg, err := ParseAndConvertConfig(config.Spec.Config.Raw)
+		if err != nil {
+			return nil, fmt.Errorf("parsing Ignition config failed for MachineConfig %s: %w", config.Name, err)
+		}
+
+		for _, file := range ignCfg.Storage.Files {
+			files.record(file.Path, config)
+		}
+
+		for _, unit := range ignCfg.Systemd.Units {
+			// A unit which only carries drop-ins or enablement state does not
+			// replace the contents of the unit itself, so it is only the
+			// source of units no other MachineConfig writes.
+			if unit.Contents != nil || (unit.Mask != nil && *unit.Mask) {
+				units.record(unit.Name, config)
+			} else if _, ok := units.sources[unit.Name]; !ok {
+				units.sources[unit.Name] = config.Name
+			}
+			for _, dropin := range unit.Dropins {
+				dropins.record(unit.Name+"/"+dropin.Name, config)
+			}
+		}
+	}
+
+	provenance := &MachineConfigProvenance{
+		Files:   files.sources,
+		Units:   units.sources,
+		Dropins: dropins.sources,
+	}
+
+	for _, tracker := range []*provenanceTracker{files, units, dropins} {
+		provenance.Overrides = append(provenance.Overrides, tracker.getOverrides()...)
+	}
+
+	return provenance, nil
+}
+
+// ParseMachineConfigProvenance reads the provenance stored on a rendered
+// MachineConfig, if any.
+func ParseMachineConfigProvenance(mc *mcfgv1.MachineConfig) (*MachineConfigProvenance, error) {
+	val, ok := mc.Annotations[MachineConfigProvenanceAnnotationKey]
+	if !ok {
+		return nil, nil
+	}
+
+	provenance := &MachineConfigProvenance{}
+	if err := json.Unmarshal([]byte(val), provenance); err != nil {
+		return nil, fmt.Errorf("could not parse %s annotation on MachineConfig %s: %w", MachineConfigProvenanceAnnotationKey, mc.Name, err)
+	}
+
+	return provenance, nil
+}
+
+type provenanceTracker struct {
+	kind string
+	// sources maps each entry to the MachineConfig which last wrote it.
+	sources map[string]string
+	// writers maps each entry to every MachineConfig which wrote it, in merge order.
+	writers map[string][]*mcfgv1.MachineConfig
+}
+
+func newProvenanceTracker(kind string) *provenanceTracker {
+	return &provenanceTracker{
+		kind:    kind,
+		sources: map[string]string{},
+		writers: map[string][]*mcfgv1.MachineConfig{},
+	}
+}
+
+func (p *provenanceTracker) record(name string, config *mcfgv1.MachineConfig) {
+	writers := p.writers[name]
+	// A MachineConfig may list the same entry more than once; Ignition
+	// validation takes care of that case.
+	if len(writers) > 0 && writers[len(writers)-1].Name == config.Name {
+		return
+	}
+	p.writers[name] = append(writers, config)
+	p.sources[name] = config.Name
+}
+
+func (p *provenanceTracker) getOverrides() []MachineConfigOverrideInfo {
+	names := make([]string, 0, len(p.writers))
+	for name, writers := range p.writers {
+		if len(writers) > 1 {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	overrides := []MachineConfigOverrideInfo{}
+	for _, name := range names {
+		writers := p.writers[name]
+
+		userInvolved := false
+		for _, writer := range writers {
+			if !isControllerGeneratedMachineConfig(writer) {
+				userInvolved = true
+				break
+			}
+		}
+		if !userInvolved {
+			continue
+		}
+
+		override := MachineConfigOverrideInfo{
+			Kind:   p.kind,
+			Name:   name,
+			Source: writers[0].Name,
+		}
+		for _, writer := range writers[1:] {
+			override.OverriddenBy = append(override.OverriddenBy, writer.Name)
+		}
+		overrides = append(overrides, override)
+	}
+
+	return overrides
+}
+
+func isControllerGeneratedMachineConfig(config *mcfgv1.MachineConfig) bool {
+	_, ok := config.Annotations[GeneratedByControllerVersionAnnotationKey]
+	return ok
+}
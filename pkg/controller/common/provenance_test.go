@@ -0,0 +1,144 @@
+package common
+
+import (
+	"encoding/json"
+	"testing"
+
+	ign3types "github.com/coreos/ignition/v2/config/v3_5/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	mcfgv1 "github.com/openshift/api/machineconfiguration/v1"
+	"github.com/openshift/machine-config-operator/test/helpers"
+)
+
+func TestCalculateMachineConfigProvenance(t *testing.T) {
+	workerLabels := map[string]string{MachineConfigRoleLabel: MachineConfigPoolWorker}
+	generatedAnnotations := map[string]string{GeneratedByControllerVersionAnnotationKey: "test"}
+
+	fooFile := func(content string) ign3types.File {
+		return helpers.CreateEncodedIgn3File("/etc/foo", content, 0o644)
+	}
+	barFile := helpers.CreateEncodedIgn3File("/etc/bar", "bar", 0o644)
+
+	unitContents := "[Unit]\nDescription=test\n"
+	unit := ign3types.Unit{Name: "test.service", Contents: &unitContents}
+	dropinContents := "[Service]\nEnvironment=FOO=bar\n"
+	dropin := ign3types.Unit{Name: "test.service", Dropins: []ign3types.Dropin{{Name: "10-foo.conf", Contents: &dropinContents}}}
+	enabled := true
+	enablement := ign3types.Unit{Name: "test.service", Enabled: &enabled}
+
+	newMC := func(name string, annotations map[string]string, files []ign3types.File, units []ign3types.Unit) *mcfgv1.MachineConfig {
+		return helpers.NewMachineConfigExtended(name, workerLabels, annotations, files, units, nil, nil, false, nil, "", "")
+	}
+
+	testCases := []struct {
+		name              string
+		configs           []*mcfgv1.MachineConfig
+		expectedFiles     map[string]string
+		expectedUnits     map[string]string
+		expectedDropins   map[string]string
+		expectedOverrides []MachineConfigOverrideInfo
+	}{
+		{
+			name: "no overrides",
+			configs: []*mcfgv1.MachineConfig{
+				newMC("00-worker", generatedAnnotations, []ign3types.File{fooFile("foo")}, []ign3types.Unit{unit}),
+				newMC("50-user", nil, []ign3types.File{barFile}, []ign3types.Unit{dropin}),
+			},
+			expectedFiles:     map[string]string{"/etc/foo": "00-worker", "/etc/bar": "50-user"},
+			expectedUnits:     map[string]string{"test.service": "00-worker"},
+			expectedDropins:   map[string]string{"test.service/10-foo.conf": "50-user"},
+			expectedOverrides: []MachineConfigOverrideInfo{},
+		},
+		{
+			name: "user config overrides generated file and unit",
+			configs: []*mcfgv1.MachineConfig{
+				newMC("99-user", nil, []ign3types.File{fooFile("user")}, []ign3types.Unit{unit}),
+				newMC("00-worker", generatedAnnotations, []ign3types.File{fooFile("foo")}, []ign3types.Unit{unit}),
+				newMC("50-user", nil, []ign3types.File{fooFile("other")}, nil),
+			},
+			expectedFiles:   map[string]string{"/etc/foo": "99-user"},
+			expectedUnits:   map[string]string{"test.service": "99-user"},
+			expectedDropins: map[string]string{},
+			expectedOverrides: []MachineConfigOverrideInfo{
+				{Kind: ProvenanceKindFile, Name: "/etc/foo", Source: "00-worker", OverriddenBy: []string{"50-user", "99-user"}},
+				{Kind: ProvenanceKindUnit, Name: "test.service", Source: "00-worker", OverriddenBy: []string{"99-user"}},
+			},
+		},
+		{
+			name: "overrides between generated configs are not reported",
+			configs: []*mcfgv1.MachineConfig{
+				newMC("00-worker", generatedAnnotations, []ign3types.File{fooFile("foo")}, nil),
+				newMC("99-worker-generated-kubelet", generatedAnnotations, []ign3types.File{fooFile("kubelet")}, nil),
+			},
+			expectedFiles:     map[string]string{"/etc/foo": "99-worker-generated-kubelet"},
+			expectedUnits:     map[string]string{},
+			expectedDropins:   map[string]string{},
+			expectedOverrides: []MachineConfigOverrideInfo{},
+		},
+		{
+			name: "drop-in only entries do not override unit contents",
+			configs: []*mcfgv1.MachineConfig{
+				newMC("00-worker", generatedAnnotations, nil, []ign3types.Unit{unit}),
+				newMC("50-user", nil, nil, []ign3types.Unit{dropin}),
+				newMC("60-user", nil, nil, []ign3types.Unit{dropin}),
+			},
+			expectedFiles:   map[string]string{},
+			expectedUnits:   map[string]string{"test.service": "00-worker"},
+			expectedDropins: map[string]string{"test.service/10-foo.conf": "60-user"},
+			expectedOverrides: []MachineConfigOverrideInfo{
+				{Kind: ProvenanceKindDropin, Name: "test.service/10-foo.conf", Source: "50-user", OverriddenBy: []string{"60-user"}},
+			},
+		},
+		{
+			name: "enablement only entries do not override unit contents",
+			configs: []*mcfgv1.MachineConfig{
+				newMC("00-worker", generatedAnnotations, nil, []ign3types.Unit{enablement}),
+				newMC("50-user", nil, nil, []ign3types.Unit{unit}),
+				newMC("60-user", nil, nil, []ign3types.Unit{enablement}),
+			},
+			expectedFiles:     map[string]string{},
+			expectedUnits:     map[string]string{"test.service": "50-user"},
+			expectedDropins:   map[string]string{},
+			expectedOverrides: []MachineConfigOverrideInfo{},
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			provenance, err := CalculateMachineConfigProvenance(testCase.configs)
+			require.NoError(t, err)
+
+			assert.Equal(t, testCase.expectedFiles, provenance.Files)
+			assert.Equal(t, testCase.expectedUnits, provenance.Units)
+			assert.Equal(t, testCase.expectedDropins, provenance.Dropins)
+			assert.ElementsMatch(t, testCase.expectedOverrides, provenance.Overrides)
+		})
+	}
+}
+
+func TestParseMachineConfigProvenance(t *testing.T) {
+	mc := helpers.NewMachineConfig("rendered-worker-1", nil, "", nil)
+
+	provenance, err := ParseMachineConfigProvenance(mc)
+	require.NoError(t, err)
+	assert.Nil(t, provenance)
+
+	mc.Annotations[MachineConfigProvenanceAnnotationKey] = `{"files":{"/etc/foo":"50-user"},"overrides":[{"kind":"file","name":"/etc/foo","source":"00-worker","overriddenBy":["50-user"]}]}`
+	provenance, err = ParseMachineConfigProvenance(mc)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"/etc/foo": "50-user"}, provenance.Files)
+	require.Len(t, provenance.Overrides, 1)
+	assert.Equal(t, "file /etc/foo from 00-worker overridden by 50-user", provenance.Overrides[0].String())
+
+	// The annotation round-trips through the encoder used by the render
+	// controller.
+	raw, err := json.Marshal(provenance)
+	require.NoError(t, err)
+	assert.Equal(t, mc.Annotations[MachineConfigProvenanceAnnotationKey], string(raw))
+
+	mc.Annotations[MachineConfigProvenanceAnnotationKey] = "not json"
+	_, err = ParseMachineConfigProvenance(mc)
+	assert.Error(t, err)
+}
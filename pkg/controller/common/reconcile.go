@@ -3,6 +3,7 @@ package common
 import (
 	"fmt"
 	"reflect"
+	"regexp"
 
 	ign3types "github.com/coreos/ignition/v2/config/v3_5/types"
 	mcfgv1 "github.com/openshift/api/machineconfiguration/v1"
@@ -17,8 +18,8 @@ import (
 // rationale.
 //
 // We can only update machine configs that have changes to the files,
-// directories, links, and systemd units sections of the included ignition
-// config currently.
+// directories, links, users, groups and systemd units sections of the included
+// ignition config currently.
 func IsRenderedConfigReconcilable(oldConfig, newConfig *mcfgv1.MachineConfig) error {
 	return IsComponentConfigsReconcilable(oldConfig, []*mcfgv1.MachineConfig{newConfig})
 }
@@ -69,9 +70,9 @@ func isReconcilable(oldIgn ign3types.Config, oldConfig, newConfig *mcfgv1.Machin
 func isConfigReconcilable(oldIgn, newIgn ign3types.Config, oldConfig, newConfig *mcfgv1.MachineConfig) error {
 	// Passwd section
 
-	// we don't currently configure Groups in place. we don't configure Users except
-	// for setting/updating SSHAuthorizedKeys for the only allowed user "core".
-	// otherwise we can't fix it if something changed here.
+	// we configure Groups and Users other than "core" in place. for "core" we
+	// only set/update SSHAuthorizedKeys and the password hash. otherwise we
+	// can't fix it if something changed here.
 	if !reflect.DeepEqual(oldIgn.Passwd, newIgn.Passwd) {
 		if err := validatePasswdChanges(oldIgn, newIgn); err != nil {
 			return fmt.Errorf("invalid passwd change(s): %w", err)
@@ -87,8 +88,8 @@ func isConfigReconcilable(oldIgn, newIgn ign3types.Config, oldConfig, newConfig
 
 	// Storage section
 
-	// we can only reconcile files right now. make sure the sections we can't
-	// fix aren't changed.
+	// we can reconcile files, directories and links. make sure the sections we
+	// can't fix aren't changed.
 	if !reflect.DeepEqual(oldIgn.Storage.Disks, newIgn.Storage.Disks) {
 		return fmt.Errorf("ignition disks section contains changes")
 	}
@@ -98,25 +99,11 @@ func isConfigReconcilable(oldIgn, newIgn ign3types.Config, oldConfig, newConfig
 	if !reflect.DeepEqual(oldIgn.Storage.Raid, newIgn.Storage.Raid) {
 		return fmt.Errorf("ignition raid section contains changes")
 	}
-	if !reflect.DeepEqual(oldIgn.Storage.Directories, newIgn.Storage.Directories) {
-		return fmt.Errorf("ignition directories section contains changes")
-	}
-	if !reflect.DeepEqual(oldIgn.Storage.Links, newIgn.Storage.Links) {
-		// This means links have been added, as opposed as being removed as it happened with
-		// https://bugzilla.redhat.com/show_bug.cgi?id=1677198. This doesn't really change behavior
-		// since we still don't support links but we allow old MC to remove links when upgrading.
-		if len(newIgn.Storage.Links) != 0 {
-			return fmt.Errorf("ignition links section contains changes")
-		}
-	}
 
-	// Special case files append: if the new config wants us to append, then we
-	// have to force a reprovision since it's not idempotent
+	// Files append is reconcilable: the MCD keeps the original file and
+	// rebuilds it with the fragments on every update.
 	for _, f := range newIgn.Storage.Files {
-		if len(f.Append) > 0 {
-			return fmt.Errorf("ignition file %v includes append", f.Path)
-		}
-		// We also disallow writing some special files
+		// We disallow writing some special files
 		if f.Path == constants.MachineConfigDaemonForceFile {
 			return fmt.Errorf("cannot create %s via Ignition", f.Path)
 		}
@@ -133,15 +120,35 @@ func isConfigReconcilable(oldIgn, newIgn ign3types.Config, oldConfig, newConfig
 	return nil
 }
 
+// accountNameRegexp matches the user and group names useradd and groupadd
+// accept by default.
+var accountNameRegexp = regexp.MustCompile(`^[a-z_][a-z0-9_.-]{0,30}\$?$`)
+
+// verifyAccountName returns an error if a user or group cannot be managed by
+// the MCD. root is never managed, as breaking it cannot be fixed in place.
+func verifyAccountName(kind, name string) error {
+	if !accountNameRegexp.MatchString(name) {
+		return fmt.Errorf("ignition passwd %s section contains unsupported changes: invalid name %q", kind, name)
+	}
+	if name == "root" {
+		return fmt.Errorf("ignition passwd %s section contains unsupported changes: %s root cannot be managed", kind, kind)
+	}
+	return nil
+}
+
 // verifyUserFields returns nil for the user Name = "core" if 1 or more SSHKeys exist for
 // this user or if a password exists for this user and if all other fields in User are empty.
+// Other users are created and modified by the MCD, so any of their fields may change.
 // Otherwise, an error will be returned and the proposed config will not be reconcilable.
-// At this time we do not support non-"core" users or any changes to the "core" user
-// outside of SSHAuthorizedKeys and passwordHash.
+// At this time we do not support any changes to the "core" user outside of
+// SSHAuthorizedKeys and passwordHash.
 func verifyUserFields(pwdUser ign3types.PasswdUser) error {
+	if pwdUser.Name != constants.CoreUserName {
+		return verifyAccountName("user", pwdUser.Name)
+	}
 	emptyUser := ign3types.PasswdUser{}
 	tempUser := pwdUser
-	if tempUser.Name == constants.CoreUserName && ((tempUser.PasswordHash) != nil || len(tempUser.SSHAuthorizedKeys) >= 1) {
+	if (tempUser.PasswordHash) != nil || len(tempUser.SSHAuthorizedKeys) >= 1 {
 		tempUser.Name = ""
 		tempUser.SSHAuthorizedKeys = nil
 		tempUser.PasswordHash = nil
@@ -150,7 +157,7 @@ func verifyUserFields(pwdUser ign3types.PasswdUser) error {
 		}
 		klog.Info("SSH Keys reconcilable")
 	} else {
-		return fmt.Errorf("ignition passwd user section contains unsupported changes: user must be core and have 1 or more sshKeys")
+		return fmt.Errorf("ignition passwd user section contains unsupported changes: user core must have 1 or more sshKeys")
 	}
 	return nil
 }
@@ -159,21 +166,21 @@ func verifyUserFields(pwdUser ign3types.PasswdUser) error {
 // reconcilable.
 func validatePasswdChanges(oldIgn, newIgn ign3types.Config) error {
 	if !reflect.DeepEqual(oldIgn.Passwd.Groups, newIgn.Passwd.Groups) {
-		return fmt.Errorf("ignition Passwd Groups section contains changes")
+		for _, group := range newIgn.Passwd.Groups {
+			if err := verifyAccountName("group", group.Name); err != nil {
+				return err
+			}
+		}
 	}
 
 	if !reflect.DeepEqual(oldIgn.Passwd.Users, newIgn.Passwd.Users) {
 		// there is an update to Users, we must verify that it is ONLY making an acceptable
-		// change to the SSHAuthorizedKeys for the user "core"
+		// change to the SSHAuthorizedKeys for the user "core", and that the other
+		// users can be managed.
+		// We don't want to panic if the "new" users is empty, and it's still reconcilable because the absence of the core user here does not mean "remove the user from the system"
 		for _, user := range newIgn.Passwd.Users {
-			if user.Name != constants.CoreUserName {
-				return fmt.Errorf("ignition passwd user section contains unsupported changes: non-core user")
-			}
-		}
-		// We don't want to panic if the "new" users is empty, and it's still reconcilable because the absence of a user here does not mean "remove the user from the system"
-		if len(newIgn.Passwd.Users) != 0 {
-			klog.Infof("user data to be verified before ssh update: %v", newIgn.Passwd.Users[len(newIgn.Passwd.Users)-1])
-			if err := verifyUserFields(newIgn.Passwd.Users[len(newIgn.Passwd.Users)-1]); err != nil {
+			klog.Infof("user data to be verified before passwd update: %v", user.Name)
+			if err := verifyUserFields(user); err != nil {
 				return err
 			}
 		}
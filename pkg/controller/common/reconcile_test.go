@@ -83,7 +83,23 @@ func TestIsRenderedConfigReconcilable(t *testing.T) {
 	isReconcilable = IsRenderedConfigReconcilable(oldConfig, newConfig)
 	checkReconcilableResults(t, "Raid", isReconcilable)
 
-	// Verify Passwd Groups changes unsupported
+	// Verify Directories and Links changes are supported
+	newIgnCfg.Storage.Directories = []ign3types.Directory{{Node: ign3types.Node{Path: "/etc/foo.d"}}}
+	newIgnCfg.Storage.Links = []ign3types.Link{{Node: ign3types.Node{Path: "/etc/foo"}, LinkEmbedded1: ign3types.LinkEmbedded1{Target: helpers.StrToPtr("/etc/bar")}}}
+	newConfig = helpers.CreateMachineConfigFromIgnition(newIgnCfg)
+	isReconcilable = IsRenderedConfigReconcilable(oldConfig, newConfig)
+	checkReconcilableResults(t, "DirectoriesAndLinks", isReconcilable)
+
+	// Verify files append is supported
+	newIgnCfg.Storage.Files = []ign3types.File{{
+		Node:          ign3types.Node{Path: "/etc/hosts"},
+		FileEmbedded1: ign3types.FileEmbedded1{Append: []ign3types.Resource{{Source: helpers.StrToPtr("data:,10.0.0.1%20foo%0A")}}},
+	}}
+	newConfig = helpers.CreateMachineConfigFromIgnition(newIgnCfg)
+	isReconcilable = IsRenderedConfigReconcilable(oldConfig, newConfig)
+	checkReconcilableResults(t, "Append", isReconcilable)
+
+	// Verify Passwd Groups changes supported
 	oldIgnCfg = NewIgnConfig()
 	oldConfig = helpers.CreateMachineConfigFromIgnition(oldIgnCfg)
 	newIgnCfg = NewIgnConfig()
@@ -93,11 +109,19 @@ func TestIsRenderedConfigReconcilable(t *testing.T) {
 	checkReconcilableResults(t, "PasswdGroups", isReconcilable)
 
 	tempGroup := ign3types.PasswdGroup{}
-	tempGroup.Name = "testGroup"
+	tempGroup.Name = "testgroup"
 	newIgnCfg.Passwd.Groups = []ign3types.PasswdGroup{tempGroup}
 	newConfig = helpers.CreateMachineConfigFromIgnition(newIgnCfg)
 	isReconcilable = IsRenderedConfigReconcilable(oldConfig, newConfig)
-	checkIrreconcilableResults(t, "PasswdGroups", isReconcilable)
+	checkReconcilableResults(t, "PasswdGroups", isReconcilable)
+
+	// Verify Passwd Groups with invalid names or root unsupported
+	for _, name := range []string{"testGroup", "root"} {
+		newIgnCfg.Passwd.Groups = []ign3types.PasswdGroup{{Name: name}}
+		newConfig = helpers.CreateMachineConfigFromIgnition(newIgnCfg)
+		isReconcilable = IsRenderedConfigReconcilable(oldConfig, newConfig)
+		checkIrreconcilableResults(t, "PasswdGroups", isReconcilable)
+	}
 
 	// Verify Ignition kernelArguments changes unsupported
 	oldIgnCfg = NewIgnConfig()
@@ -166,7 +190,7 @@ func TestReconcilableSSH(t *testing.T) {
 	errMsg := IsRenderedConfigReconcilable(oldMcfg, newMcfg)
 	checkReconcilableResults(t, "SSH", errMsg)
 
-	// 	Check that updating User with User that is not core is not supported
+	// 	Check that updating User with User that is not core and has an invalid name is not supported
 	tempUser2 := ign3types.PasswdUser{Name: "core", SSHAuthorizedKeys: []ign3types.SSHAuthorizedKey{"1234"}}
 	oldIgnCfg.Passwd.Users = append(oldIgnCfg.Passwd.Users, tempUser2)
 	oldMcfg = helpers.CreateMachineConfigFromIgnition(oldIgnCfg)
@@ -183,7 +207,7 @@ func TestReconcilableSSH(t *testing.T) {
 	errMsg = IsRenderedConfigReconcilable(oldMcfg, newMcfg)
 	checkIrreconcilableResults(t, "SSH", errMsg)
 
-	// check that we cannot add a user or have len(Passwd.Users)> 1
+	// check that we cannot add a user with an invalid name
 	tempUser5 := ign3types.PasswdUser{Name: "some user", SSHAuthorizedKeys: []ign3types.SSHAuthorizedKey{"5678"}}
 	newIgnCfg.Passwd.Users = append(newIgnCfg.Passwd.Users, tempUser5)
 	newMcfg = helpers.CreateMachineConfigFromIgnition(newIgnCfg)
@@ -198,6 +222,23 @@ func TestReconcilableSSH(t *testing.T) {
 	errMsg = IsRenderedConfigReconcilable(oldMcfg, newMcfg)
 	checkIrreconcilableResults(t, "SSH", errMsg)
 
+	// check that adding, or changing any field of, a user other than core is supported
+	newIgnCfg.Passwd.Users = []ign3types.PasswdUser{tempUser1, {
+		Name:              "breakglass",
+		SSHAuthorizedKeys: []ign3types.SSHAuthorizedKey{"5678"},
+		HomeDir:           helpers.StrToPtr("/var/home/breakglass"),
+		Groups:            []ign3types.Group{"wheel"},
+	}}
+	newMcfg = helpers.CreateMachineConfigFromIgnition(newIgnCfg)
+	errMsg = IsRenderedConfigReconcilable(oldMcfg, newMcfg)
+	checkReconcilableResults(t, "SSH", errMsg)
+
+	// check that root cannot be managed
+	newIgnCfg.Passwd.Users = []ign3types.PasswdUser{tempUser1, {Name: "root", PasswordHash: helpers.StrToPtr("$6$hash")}}
+	newMcfg = helpers.CreateMachineConfigFromIgnition(newIgnCfg)
+	errMsg = IsRenderedConfigReconcilable(oldMcfg, newMcfg)
+	checkIrreconcilableResults(t, "SSH", errMsg)
+
 	//check that empty Users does not cause panic
 	newIgnCfg.Passwd.Users = nil
 	newMcfg = helpers.CreateMachineConfigFromIgnition(newIgnCfg)
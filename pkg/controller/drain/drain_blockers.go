@@ -0,0 +1,184 @@
+package drain
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/openshift/api/machineconfiguration/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	policyv1 "k8s.io/api/policy/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	kubeErrs "k8s.io/apimachinery/pkg/util/errors"
+	"k8s.io/klog/v2"
+	"k8s.io/kubectl/pkg/drain"
+
+	ctrlcommon "github.com/openshift/machine-config-operator/pkg/controller/common"
+	"github.com/openshift/machine-config-operator/pkg/upgrademonitor"
+)
+
+// machineConfigNodeDrainBlocked is the MachineConfigNode condition listing the
+// pods blocking the drain of the node.
+const machineConfigNodeDrainBlocked v1alpha1.StateProgress = "DrainBlocked"
+
+// drainBlockerMessageLimit is the number of characters of an eviction error
+// kept for each blocking pod.
+const drainBlockerMessageLimit = 256
+
+// getDrainBlockers returns why each of the pods left on a node after a failed
+// drain could not be evicted, given the PodDisruptionBudgets of their
+// namespaces and the error of the drain.
+func getDrainBlockers(pods []corev1.Pod, pdbs []policyv1.PodDisruptionBudget, drainErr error) []ctrlcommon.DrainBlocker {
+	var drainErrs []error
+	var agg kubeErrs.Aggregate
+	if errors.As(drainErr, &agg) {
+		drainErrs = agg.Errors()
+	} else if drainErr != nil {
+		drainErrs = []error{drainErr}
+	}
+
+	blockers := []ctrlcommon.DrainBlocker{}
+	for i := range pods {
+		pod := &pods[i]
+		blocker := ctrlcommon.DrainBlocker{Namespace: pod.Namespace, Name: pod.Name}
+
+		if pdb := getBlockingPodDisruptionBudget(pod, pdbs); pdb != nil {
+			blocker.Reason = ctrlcommon.DrainBlockerReasonPodDisruptionBudget
+			blocker.PodDisruptionBudget = pdb.Name
+			blocker.Message = fmt.Sprintf("%d of %d desired healthy pods are healthy, no disruptions allowed", pdb.Status.CurrentHealthy, pdb.Status.DesiredHealthy)
+		} else if pod.DeletionTimestamp != nil {
+			blocker.Reason = ctrlcommon.DrainBlockerReasonTerminating
+			blocker.Message = fmt.Sprintf("terminating since %s", pod.DeletionTimestamp.UTC().Format(time.RFC3339))
+		} else {
+			blocker.Reason = ctrlcommon.DrainBlockerReasonEvictionFailed
+			blocker.Message = getPodDrainError(pod, drainErrs)
+		}
+		blockers = append(blockers, blocker)
+	}
+	return blockers
+}
+
+// getBlockingPodDisruptionBudget returns a PodDisruptionBudget selecting the pod
+// which does not allow any disruption, if any.
+func getBlockingPodDisruptionBudget(pod *corev1.Pod, pdbs []policyv1.PodDisruptionBudget) *policyv1.PodDisruptionBudget {
+	for i := range pdbs {
+		pdb := &pdbs[i]
+		if pdb.Namespace != pod.Namespace || pdb.Spec.Selector == nil || pdb.Status.DisruptionsAllowed > 0 {
+			continue
+		}
+		selector, err := metav1.LabelSelectorAsSelector(pdb.Spec.Selector)
+		if err != nil {
+			continue
+		}
+		if selector.Matches(labels.Set(pod.Labels)) {
+			return pdb
+		}
+	}
+	return nil
+}
+
+// getPodDrainError returns the error the drain reported for the pod, if any.
+func getPodDrainError(pod *corev1.Pod, drainErrs []error) string {
+	// The drain helper reports the pods it failed to evict or to wait for in
+	// either of these forms.
+	evicting := fmt.Sprintf("pods/%q -n %q", pod.Name, pod.Namespace)
+	waiting := fmt.Sprintf("pod %q in namespace %q", pod.Name, pod.Namespace)
+	for _, err := range drainErrs {
+		if msg := err.Error(); strings.Contains(msg, evicting) || strings.Contains(msg, waiting) {
+			if len(msg) > drainBlockerMessageLimit {
+				msg = msg[:drainBlockerMessageLimit] + "..."
+			}
+			return msg
+		}
+	}
+	return ""
+}
+
+// reportDrainBlockers records the pods blocking the drain of a node on the
+// node and its MachineConfigNode.
+func (ctrl *Controller) reportDrainBlockers(node *corev1.Node, drainer *drain.Helper, drainErr error, pool string) {
+	list, errs := drainer.GetPodsForDeletion(node.Name)
+	if list == nil {
+		klog.Errorf("node %s: could not list pods blocking the drain: %v", node.Name, kubeErrs.NewAggregate(errs))
+		return
+	}
+	pods := list.Pods()
+
+	namespaces := map[string]bool{}
+	pdbs := []policyv1.PodDisruptionBudget{}
+	for _, pod := range pods {
+		if namespaces[pod.Namespace] {
+			continue
+		}
+		namespaces[pod.Namespace] = true
+		nsPDBs, err := ctrl.kubeClient.PolicyV1().PodDisruptionBudgets(pod.Namespace).List(context.TODO(), metav1.ListOptions{})
+		if err != nil {
+			klog.Warningf("node %s: could not list PodDisruptionBudgets in namespace %s: %v", node.Name, pod.Namespace, err)
+			continue
+		}
+		pdbs = append(pdbs, nsPDBs.Items...)
+	}
+
+	// Keep the time of the first failure across retries and controller restarts.
+	since := time.Now()
+	if previous, err := ctrlcommon.ParseDrainBlockerReport(node.Annotations[ctrlcommon.DrainBlockersAnnotationKey]); err == nil && previous != nil {
+		since = previous.Since.Time
+	}
+	report := ctrlcommon.NewDrainBlockerReport(since, getDrainBlockers(pods, pdbs, drainErr))
+
+	raw, err := json.Marshal(report)
+	if err != nil {
+		klog.Errorf("node %s: could not encode drain blockers: %v", node.Name, err)
+		return
+	}
+	if err := ctrl.setNodeAnnotations(node.Name, map[string]string{ctrlcommon.DrainBlockersAnnotationKey: string(raw)}); err != nil {
+		klog.Errorf("node %s: could not record drain blockers: %v", node.Name, err)
+	}
+
+	err = upgrademonitor.GenerateAndApplyMachineConfigNodes(
+		&upgrademonitor.Condition{State: machineConfigNodeDrainBlocked, Reason: report.Reason(), Message: report.Summary()},
+		nil,
+		metav1.ConditionTrue,
+		metav1.ConditionFalse,
+		node,
+		ctrl.client,
+		ctrl.featureGatesAccessor,
+		pool,
+	)
+	if err != nil {
+		klog.Errorf("Error making MCN for drain blockers: %v", err)
+	}
+}
+
+// clearDrainBlockers removes the report of the pods blocking the drain of a
+// node once the drain succeeded or the node is uncordoned, with the reason and
+// message of the cleared MachineConfigNode condition.
+func (ctrl *Controller) clearDrainBlockers(node *corev1.Node, pool, reason, message string) {
+	if _, ok := node.Annotations[ctrlcommon.DrainBlockersAnnotationKey]; !ok {
+		return
+	}
+
+	patch := fmt.Sprintf(`{"metadata":{"annotations":{%q:null}}}`, ctrlcommon.DrainBlockersAnnotationKey)
+	if _, err := ctrl.kubeClient.CoreV1().Nodes().Patch(context.TODO(), node.Name, types.MergePatchType, []byte(patch), metav1.PatchOptions{}); err != nil {
+		klog.Errorf("node %s: could not clear drain blockers: %v", node.Name, err)
+	}
+
+	err := upgrademonitor.GenerateAndApplyMachineConfigNodes(
+		&upgrademonitor.Condition{State: machineConfigNodeDrainBlocked, Reason: reason, Message: message},
+		nil,
+		metav1.ConditionFalse,
+		metav1.ConditionFalse,
+		node,
+		ctrl.client,
+		ctrl.featureGatesAccessor,
+		pool,
+	)
+	if err != nil {
+		klog.Errorf("Error making MCN for drain blockers: %v", err)
+	}
+}
@@ -0,0 +1,74 @@
+package drain
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	policyv1 "k8s.io/api/policy/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	kubeErrs "k8s.io/apimachinery/pkg/util/errors"
+
+	ctrlcommon "github.com/openshift/machine-config-operator/pkg/controller/common"
+)
+
+func TestGetDrainBlockers(t *testing.T) {
+	now := metav1.Now()
+	newPod := func(namespace, name string, labels map[string]string) corev1.Pod {
+		return corev1.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: name, Labels: labels}}
+	}
+
+	terminating := newPod("app", "worker-0", nil)
+	terminating.DeletionTimestamp = &now
+
+	pods := []corev1.Pod{
+		newPod("db", "postgres-0", map[string]string{"app": "postgres"}),
+		newPod("app", "web-0", map[string]string{"app": "web"}),
+		terminating,
+		newPod("app", "cache-0", map[string]string{"app": "cache"}),
+	}
+	pdbs := []policyv1.PodDisruptionBudget{{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "db", Name: "postgres"},
+		Spec:       policyv1.PodDisruptionBudgetSpec{Selector: &metav1.LabelSelector{MatchLabels: map[string]string{"app": "postgres"}}},
+		Status:     policyv1.PodDisruptionBudgetStatus{DisruptionsAllowed: 0, CurrentHealthy: 2, DesiredHealthy: 2},
+	}, {
+		// Allows disruptions, so does not block the eviction.
+		ObjectMeta: metav1.ObjectMeta{Namespace: "app", Name: "web"},
+		Spec:       policyv1.PodDisruptionBudgetSpec{Selector: &metav1.LabelSelector{MatchLabels: map[string]string{"app": "web"}}},
+		Status:     policyv1.PodDisruptionBudgetStatus{DisruptionsAllowed: 1},
+	}, {
+		// Same labels in another namespace.
+		ObjectMeta: metav1.ObjectMeta{Namespace: "other", Name: "cache"},
+		Spec:       policyv1.PodDisruptionBudgetSpec{Selector: &metav1.LabelSelector{MatchLabels: map[string]string{"app": "cache"}}},
+	}}
+	drainErr := kubeErrs.NewAggregate([]error{
+		errors.New(`error when evicting pods/"web-0" -n "app": admission webhook "deny" denied the request`),
+		errors.New(`error when evicting pods/"cache-0" -n "app": global timeout reached: 1m30s`),
+	})
+
+	blockers := getDrainBlockers(pods, pdbs, drainErr)
+	assert.Equal(t, []ctrlcommon.DrainBlocker{{
+		Namespace:           "db",
+		Name:                "postgres-0",
+		Reason:              ctrlcommon.DrainBlockerReasonPodDisruptionBudget,
+		PodDisruptionBudget: "postgres",
+		Message:             "2 of 2 desired healthy pods are healthy, no disruptions allowed",
+	}, {
+		Namespace: "app",
+		Name:      "web-0",
+		Reason:    ctrlcommon.DrainBlockerReasonEvictionFailed,
+		Message:   `error when evicting pods/"web-0" -n "app": admission webhook "deny" denied the request`,
+	}, {
+		Namespace: "app",
+		Name:      "worker-0",
+		Reason:    ctrlcommon.DrainBlockerReasonTerminating,
+		Message:   "terminating since " + now.UTC().Format(time.RFC3339),
+	}, {
+		Namespace: "app",
+		Name:      "cache-0",
+		Reason:    ctrlcommon.DrainBlockerReasonEvictionFailed,
+		Message:   `error when evicting pods/"cache-0" -n "app": global timeout reached: 1m30s`,
+	}}, blockers)
+}
@@ -26,6 +26,8 @@ import (
 
 	mcfginformersv1 "github.com/openshift/client-go/machineconfiguration/informers/externalversions/machineconfiguration/v1"
 	mcfglistersv1 "github.com/openshift/client-go/machineconfiguration/listers/machineconfiguration/v1"
+	mcopinformersv1 "github.com/openshift/client-go/operator/informers/externalversions/operator/v1"
+	mcoplistersv1 "github.com/openshift/client-go/operator/listers/operator/v1"
 	corev1 "k8s.io/api/core/v1"
 	coreinformersv1 "k8s.io/client-go/informers/core/v1"
 	clientset "k8s.io/client-go/kubernetes"
@@ -104,6 +106,9 @@ type Controller struct {
 	mcpLister       mcfglistersv1.MachineConfigPoolLister
 	mcpListerSynced cache.InformerSynced
 
+	mcopLister       mcoplistersv1.MachineConfigurationLister
+	mcopListerSynced cache.InformerSynced
+
 	queue         workqueue.TypedRateLimitingInterface[string]
 	ongoingDrains map[string]time.Time
 
@@ -117,6 +122,7 @@ func New(
 	cfg Config,
 	nodeInformer coreinformersv1.NodeInformer,
 	mcpInformer mcfginformersv1.MachineConfigPoolInformer,
+	mcopInformer mcopinformersv1.MachineConfigurationInformer,
 	kubeClient clientset.Interface,
 	mcfgClient mcfgclientset.Interface,
 	fgAccessor featuregates.FeatureGateAccess,
@@ -150,6 +156,9 @@ func New(
 	ctrl.mcpLister = mcpInformer.Lister()
 	ctrl.mcpListerSynced = mcpInformer.Informer().HasSynced
 
+	ctrl.mcopLister = mcopInformer.Lister()
+	ctrl.mcopListerSynced = mcopInformer.Informer().HasSynced
+
 	return ctrl
 }
 
@@ -169,7 +178,7 @@ func (ctrl *Controller) Run(workers int, stopCh <-chan struct{}) {
 	defer utilruntime.HandleCrash()
 	defer ctrl.queue.ShutDown()
 
-	if !cache.WaitForCacheSync(stopCh, ctrl.nodeListerSynced, ctrl.mcpListerSynced) {
+	if !cache.WaitForCacheSync(stopCh, ctrl.nodeListerSynced, ctrl.mcpListerSynced, ctrl.mcopListerSynced) {
 		return
 	}
 
@@ -324,6 +333,9 @@ func (ctrl *Controller) syncNode(key string) error {
 	desiredVerb := strings.Split(desiredState, "-")[0]
 	switch desiredVerb {
 	case daemonconsts.DrainerStateUncordon:
+		if done, err := ctrl.runLifecycleHooks(node, ctrlcommon.LifecycleHookStagePostUpdate, desiredState); !done {
+			return err
+		}
 		ctrl.logNode(node, "uncordoning")
 		// perform uncordon
 		if err := ctrl.cordonOrUncordonNode(false, node, drainer); err != nil {
@@ -355,9 +367,29 @@ func (ctrl *Controller) syncNode(key string) error {
 		if err != nil {
 			klog.Errorf("Error making MCN for UnCordon success: %v", err)
 		}
+
+		// A drain which was still failing when the node was uncordoned, e.g.
+		// because its update was rolled back, is over. Delete it and its
+		// blockers, so the node and its pool do not keep reporting them.
+		delete(ctrl.ongoingDrains, node.Name)
+		ctrl.clearDrainBlockers(node, pool, "NodeUncordoned", "The node was uncordoned, no drain is in progress")
 	case daemonconsts.DrainerStateDrain:
+		if done, err := ctrl.runLifecycleHooks(node, ctrlcommon.LifecycleHookStagePreDrain, desiredState); !done {
+			return err
+		}
 
-		if err := ctrl.drainNode(node, drainer); err != nil {
+		policies, err := ctrl.getDrainPoliciesForCluster()
+		if err != nil {
+			// Do not drain with the default behavior pods the policies may
+			// have been meant to skip.
+			ctrl.eventRecorder.Eventf(node, corev1.EventTypeWarning, "InvalidDrainPolicies", "Not draining node: %v", err)
+			return fmt.Errorf("node %s: could not get drain policies: %w", node.Name, err)
+		}
+		if len(policies) > 0 {
+			drainer.AdditionalFilters = append(drainer.AdditionalFilters, skipDrainPolicyFilter(policies))
+		}
+
+		if err := ctrl.drainNode(node, drainer, policies); err != nil {
 			// If we get an error from drainNode, that means the drain failed.
 			// However, we want to requeue and try again. So we need to return nil
 			// from here so that we can requeue.
@@ -379,7 +411,7 @@ func (ctrl *Controller) syncNode(key string) error {
 	return nil
 }
 
-func (ctrl *Controller) drainNode(node *corev1.Node, drainer *drain.Helper) error {
+func (ctrl *Controller) drainNode(node *corev1.Node, drainer *drain.Helper, policies []drainPolicy) error {
 	// First check if we have an ongoing drain
 	// This is currently stored in the object itself as a map but,
 	// Practically during upgrades the control plane node this controller
@@ -459,7 +491,14 @@ func (ctrl *Controller) drainNode(node *corev1.Node, drainer *drain.Helper) erro
 	if err != nil {
 		klog.Errorf("Error making MCN for Drain beginning: %v", err)
 	}
-	if err := drain.RunNodeDrain(drainer, node.Name); err != nil {
+	timedOut, err := runNodeDrain(drainer, node.Name, policies, duration)
+	if len(timedOut) > 0 {
+		logMessage := fmt.Sprintf("node %s: drain exceeded the timeout of drain policies %s. Will continue to retry.", node.Name, strings.Join(timedOut, ", "))
+		klog.Error(logMessage)
+		ctrl.eventRecorder.Eventf(node, corev1.EventTypeWarning, "DrainFailed", logMessage)
+		ctrlcommon.MCCDrainErr.WithLabelValues(node.Name).Set(1)
+	}
+	if err != nil {
 		// To mimic our old daemon logic, we should probably have a more nuanced backoff.
 		// However since the controller is processing all drains, it is less deterministic how soon the next drain will retry,
 		// Anywhere between instant (if a node change happened) or up to hours (if there are many nodes competing for resources)
@@ -489,6 +528,7 @@ func (ctrl *Controller) drainNode(node *corev1.Node, drainer *drain.Helper) erro
 		if nErr != nil {
 			klog.Errorf("Error making MCN for Drain failure: %v", nErr)
 		}
+		ctrl.reportDrainBlockers(node, drainer, err, pool)
 
 		// Return early without deleting the ongoing drain.
 		return err
@@ -507,8 +547,9 @@ func (ctrl *Controller) drainNode(node *corev1.Node, drainer *drain.Helper) erro
 		klog.Errorf("Error making MCN for Drain success: %v", err)
 	}
 
-	// Drain was successful. Delete the ongoing drain.
+	// Drain was successful. Delete the ongoing drain and its blockers, if any.
 	delete(ctrl.ongoingDrains, node.Name)
+	ctrl.clearDrainBlockers(node, pool, "DrainSucceeded", "No pods are blocking the drain")
 
 	// Clear the MCCDrainErr, if any.
 	if ctrlcommon.MCCDrainErr.DeleteLabelValues(node.Name) {
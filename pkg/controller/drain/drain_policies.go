@@ -0,0 +1,226 @@
+package drain
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	opv1 "github.com/openshift/api/operator/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	kubeErrs "k8s.io/apimachinery/pkg/util/errors"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/kubectl/pkg/drain"
+
+	ctrlcommon "github.com/openshift/machine-config-operator/pkg/controller/common"
+)
+
+// drainPolicyAction is how the pods matched by a drain policy are removed from
+// the node.
+type drainPolicyAction string
+
+const (
+	// drainPolicyActionEvict evicts the pods, respecting PodDisruptionBudgets.
+	drainPolicyActionEvict drainPolicyAction = "Evict"
+	// drainPolicyActionDelete deletes the pods, bypassing PodDisruptionBudgets.
+	drainPolicyActionDelete drainPolicyAction = "Delete"
+	// drainPolicyActionSkip leaves the pods on the node.
+	drainPolicyActionSkip drainPolicyAction = "Skip"
+)
+
+// drainPolicy customizes the drain of the pods it matches. A pod matches a
+// policy when it runs in one of its namespaces, if any, and matches its pod
+// selector, if any. The first matching policy applies.
+type drainPolicy struct {
+	Name        string                `json:"name"`
+	Namespaces  []string              `json:"namespaces,omitempty"`
+	PodSelector *metav1.LabelSelector `json:"podSelector,omitempty"`
+	// Action defaults to Evict.
+	Action drainPolicyAction `json:"action,omitempty"`
+	// GracePeriodSeconds overrides the terminationGracePeriodSeconds of the pods.
+	GracePeriodSeconds *int `json:"gracePeriodSeconds,omitempty"`
+	// Timeout is how long the pods may block the drain of a node, e.g. "30m".
+	// Past it, the drain is reported failed, or the pods are deleted if
+	// ForceAfterTimeout is set.
+	Timeout           string `json:"timeout,omitempty"`
+	ForceAfterTimeout bool   `json:"forceAfterTimeout,omitempty"`
+
+	namespaces sets.Set[string]
+	selector   labels.Selector
+	timeout    time.Duration
+}
+
+func (p *drainPolicy) validate() error {
+	if p.Name == "" {
+		return fmt.Errorf("name is required")
+	}
+	if len(p.Namespaces) == 0 && p.PodSelector == nil {
+		return fmt.Errorf("namespaces or podSelector is required")
+	}
+	switch p.Action {
+	case "":
+		p.Action = drainPolicyActionEvict
+	case drainPolicyActionEvict, drainPolicyActionDelete, drainPolicyActionSkip:
+	default:
+		return fmt.Errorf("invalid action %q: must be one of %s, %s or %s", p.Action, drainPolicyActionEvict, drainPolicyActionDelete, drainPolicyActionSkip)
+	}
+	if p.GracePeriodSeconds != nil && *p.GracePeriodSeconds < 0 {
+		return fmt.Errorf("gracePeriodSeconds must not be negative")
+	}
+	if p.Timeout != "" {
+		timeout, err := time.ParseDuration(p.Timeout)
+		if err != nil || timeout <= 0 {
+			return fmt.Errorf("invalid timeout %q: must be a positive duration", p.Timeout)
+		}
+		p.timeout = timeout
+	}
+	if p.ForceAfterTimeout && p.timeout == 0 {
+		return fmt.Errorf("forceAfterTimeout requires a timeout")
+	}
+
+	p.namespaces = sets.New(p.Namespaces...)
+	p.selector = labels.Everything()
+	if p.PodSelector != nil {
+		selector, err := metav1.LabelSelectorAsSelector(p.PodSelector)
+		if err != nil {
+			return fmt.Errorf("invalid podSelector: %w", err)
+		}
+		p.selector = selector
+	}
+	return nil
+}
+
+func (p *drainPolicy) matches(pod *corev1.Pod) bool {
+	if p.namespaces.Len() > 0 && !p.namespaces.Has(pod.Namespace) {
+		return false
+	}
+	return p.selector.Matches(labels.Set(pod.Labels))
+}
+
+// getDrainPolicies returns the drain policies set on the MachineConfiguration
+// cluster object, if any.
+func getDrainPolicies(mcop *opv1.MachineConfiguration) ([]drainPolicy, error) {
+	if mcop == nil {
+		return nil, nil
+	}
+	val, ok := mcop.Annotations[ctrlcommon.DrainPoliciesAnnotationKey]
+	if !ok {
+		return nil, nil
+	}
+
+	var policies []drainPolicy
+	if err := json.Unmarshal([]byte(val), &policies); err != nil {
+		return nil, fmt.Errorf("invalid %s annotation: %w", ctrlcommon.DrainPoliciesAnnotationKey, err)
+	}
+	names := sets.New[string]()
+	for i := range policies {
+		if err := policies[i].validate(); err != nil {
+			return nil, fmt.Errorf("invalid drain policy %q: %w", policies[i].Name, err)
+		}
+		if names.Has(policies[i].Name) {
+			return nil, fmt.Errorf("duplicate drain policy %q", policies[i].Name)
+		}
+		names.Insert(policies[i].Name)
+	}
+	return policies, nil
+}
+
+// getDrainPoliciesForCluster returns the drain policies of the cluster.
+func (ctrl *Controller) getDrainPoliciesForCluster() ([]drainPolicy, error) {
+	mcop, err := ctrl.mcopLister.Get(ctrlcommon.MCOOperatorKnobsObjectName)
+	if apierrors.IsNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return getDrainPolicies(mcop)
+}
+
+// matchDrainPolicy returns the index of the first policy matching the pod, or
+// -1 if none does.
+func matchDrainPolicy(policies []drainPolicy, pod *corev1.Pod) int {
+	for i := range policies {
+		if policies[i].matches(pod) {
+			return i
+		}
+	}
+	return -1
+}
+
+// skipDrainPolicyFilter returns a drain filter leaving the pods matched by a
+// policy with the Skip action on the node.
+func skipDrainPolicyFilter(policies []drainPolicy) drain.PodFilter {
+	return func(pod corev1.Pod) drain.PodDeleteStatus {
+		if i := matchDrainPolicy(policies, &pod); i >= 0 && policies[i].Action == drainPolicyActionSkip {
+			return drain.MakePodDeleteStatusSkip()
+		}
+		return drain.MakePodDeleteStatusOkay()
+	}
+}
+
+// drainPolicyHelper returns the drain helper used for the pods of a policy,
+// after the drain of the node has been going on for the given duration.
+func drainPolicyHelper(drainer *drain.Helper, policy *drainPolicy, elapsed time.Duration) *drain.Helper {
+	helper := *drainer
+	if policy.GracePeriodSeconds != nil {
+		helper.GracePeriodSeconds = *policy.GracePeriodSeconds
+		// Wait for the pods to terminate, rather than failing the drain while
+		// they are still within their grace period.
+		if grace := time.Duration(*policy.GracePeriodSeconds) * time.Second; grace > helper.Timeout {
+			helper.Timeout = grace
+		}
+	}
+	if policy.Action == drainPolicyActionDelete || (policy.ForceAfterTimeout && elapsed > policy.timeout) {
+		helper.DisableEviction = true
+	}
+	return &helper
+}
+
+// runNodeDrain drains the node like drain.RunNodeDrain, applying the drain
+// policies to the pods they match. The pods matched by no policy are drained
+// first, then those of each policy in order. It returns the names of the
+// policies whose pods still block the drain past their timeout.
+func runNodeDrain(drainer *drain.Helper, nodeName string, policies []drainPolicy, elapsed time.Duration) ([]string, error) {
+	if len(policies) == 0 {
+		return nil, drain.RunNodeDrain(drainer, nodeName)
+	}
+
+	list, errs := drainer.GetPodsForDeletion(nodeName)
+	if errs != nil {
+		return nil, kubeErrs.NewAggregate(errs)
+	}
+	if warnings := list.Warnings(); warnings != "" {
+		fmt.Fprintf(drainer.ErrOut, "WARNING: %s\n", warnings)
+	}
+
+	// Pods matched by the policy at index i are in groups[i+1].
+	groups := make([][]corev1.Pod, len(policies)+1)
+	for _, pod := range list.Pods() {
+		i := matchDrainPolicy(policies, &pod)
+		groups[i+1] = append(groups[i+1], pod)
+	}
+
+	var timedOut []string
+	errs = nil
+	if len(groups[0]) > 0 {
+		if err := drainer.DeleteOrEvictPods(groups[0]); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	for i := range policies {
+		policy := &policies[i]
+		if len(groups[i+1]) == 0 {
+			continue
+		}
+		if err := drainPolicyHelper(drainer, policy, elapsed).DeleteOrEvictPods(groups[i+1]); err != nil {
+			errs = append(errs, err)
+			if policy.timeout > 0 && elapsed > policy.timeout && !policy.ForceAfterTimeout {
+				timedOut = append(timedOut, policy.Name)
+			}
+		}
+	}
+	return timedOut, kubeErrs.NewAggregate(errs)
+}
@@ -0,0 +1,176 @@
+package drain
+
+import (
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	opv1 "github.com/openshift/api/operator/v1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/kubectl/pkg/drain"
+
+	ctrlcommon "github.com/openshift/machine-config-operator/pkg/controller/common"
+)
+
+func newTestMachineConfiguration(policies string) *opv1.MachineConfiguration {
+	return &opv1.MachineConfiguration{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        ctrlcommon.MCOOperatorKnobsObjectName,
+			Annotations: map[string]string{ctrlcommon.DrainPoliciesAnnotationKey: policies},
+		},
+	}
+}
+
+func TestGetDrainPolicies(t *testing.T) {
+	tests := []struct {
+		name      string
+		mcop      *opv1.MachineConfiguration
+		expected  []string
+		expectErr bool
+	}{{
+		name: "no MachineConfiguration",
+	}, {
+		name: "no annotation",
+		mcop: &opv1.MachineConfiguration{ObjectMeta: metav1.ObjectMeta{Name: ctrlcommon.MCOOperatorKnobsObjectName}},
+	}, {
+		name: "valid policies",
+		mcop: newTestMachineConfiguration(`[
+			{"name": "databases", "namespaces": ["db"], "gracePeriodSeconds": 600, "timeout": "30m", "forceAfterTimeout": true},
+			{"name": "batch", "podSelector": {"matchLabels": {"app": "batch"}}, "action": "Delete"},
+			{"name": "monitoring", "namespaces": ["monitoring"], "podSelector": {"matchExpressions": [{"key": "app", "operator": "In", "values": ["agent"]}]}, "action": "Skip"}
+		]`),
+		expected: []string{"databases", "batch", "monitoring"},
+	}, {
+		name:      "invalid JSON",
+		mcop:      newTestMachineConfiguration(`{"name": "databases"}`),
+		expectErr: true,
+	}, {
+		name:      "missing name",
+		mcop:      newTestMachineConfiguration(`[{"namespaces": ["db"]}]`),
+		expectErr: true,
+	}, {
+		name:      "matches everything",
+		mcop:      newTestMachineConfiguration(`[{"name": "all"}]`),
+		expectErr: true,
+	}, {
+		name:      "invalid action",
+		mcop:      newTestMachineConfiguration(`[{"name": "databases", "namespaces": ["db"], "action": "Ignore"}]`),
+		expectErr: true,
+	}, {
+		name:      "negative grace period",
+		mcop:      newTestMachineConfiguration(`[{"name": "databases", "namespaces": ["db"], "gracePeriodSeconds": -1}]`),
+		expectErr: true,
+	}, {
+		name:      "invalid timeout",
+		mcop:      newTestMachineConfiguration(`[{"name": "databases", "namespaces": ["db"], "timeout": "soon"}]`),
+		expectErr: true,
+	}, {
+		name:      "force without timeout",
+		mcop:      newTestMachineConfiguration(`[{"name": "databases", "namespaces": ["db"], "forceAfterTimeout": true}]`),
+		expectErr: true,
+	}, {
+		name:      "invalid selector",
+		mcop:      newTestMachineConfiguration(`[{"name": "databases", "podSelector": {"matchExpressions": [{"key": "app", "operator": "Near"}]}}]`),
+		expectErr: true,
+	}, {
+		name: "duplicate name",
+		mcop: newTestMachineConfiguration(`[
+			{"name": "databases", "namespaces": ["db"]},
+			{"name": "databases", "namespaces": ["postgres"]}
+		]`),
+		expectErr: true,
+	}}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			policies, err := getDrainPolicies(test.mcop)
+			if test.expectErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			var names []string
+			for _, policy := range policies {
+				names = append(names, policy.Name)
+				assert.NotEmpty(t, policy.Action)
+			}
+			assert.Equal(t, test.expected, names)
+		})
+	}
+}
+
+func TestDrainPolicyHelper(t *testing.T) {
+	policies, err := getDrainPolicies(newTestMachineConfiguration(`[
+		{"name": "databases", "namespaces": ["db"], "gracePeriodSeconds": 600, "timeout": "30m", "forceAfterTimeout": true},
+		{"name": "batch", "podSelector": {"matchLabels": {"app": "batch"}}, "action": "Delete"}
+	]`))
+	require.NoError(t, err)
+
+	drainer := &drain.Helper{GracePeriodSeconds: -1, Timeout: 90 * time.Second}
+
+	helper := drainPolicyHelper(drainer, &policies[0], time.Minute)
+	assert.Equal(t, 600, helper.GracePeriodSeconds)
+	assert.Equal(t, 600*time.Second, helper.Timeout)
+	assert.False(t, helper.DisableEviction)
+	assert.True(t, drainPolicyHelper(drainer, &policies[0], time.Hour).DisableEviction)
+
+	helper = drainPolicyHelper(drainer, &policies[1], time.Minute)
+	assert.Equal(t, -1, helper.GracePeriodSeconds)
+	assert.Equal(t, 90*time.Second, helper.Timeout)
+	assert.True(t, helper.DisableEviction)
+
+	// The helper of the node is left untouched.
+	assert.Equal(t, &drain.Helper{GracePeriodSeconds: -1, Timeout: 90 * time.Second}, drainer)
+}
+
+func TestRunNodeDrainWithPolicies(t *testing.T) {
+	newPod := func(namespace, name string, labels map[string]string) *corev1.Pod {
+		return &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: name, Labels: labels},
+			Spec:       corev1.PodSpec{NodeName: "node-0"},
+		}
+	}
+	client := fake.NewSimpleClientset(
+		newPod("app", "web-0", map[string]string{"app": "web"}),
+		newPod("app", "batch-0", map[string]string{"app": "batch"}),
+		newPod("monitoring", "agent-0", map[string]string{"app": "agent"}),
+		newPod("monitoring", "prometheus-0", map[string]string{"app": "prometheus"}),
+	)
+	// The fake clientset does not support evictions, so the pods are deleted.
+	client.Resources = []*metav1.APIResourceList{{GroupVersion: "v1", APIResources: []metav1.APIResource{{Name: "pods", Kind: "Pod"}}}}
+
+	policies, err := getDrainPolicies(newTestMachineConfiguration(`[
+		{"name": "batch", "podSelector": {"matchLabels": {"app": "batch"}}, "action": "Delete", "gracePeriodSeconds": 0},
+		{"name": "agents", "namespaces": ["monitoring"], "podSelector": {"matchLabels": {"app": "agent"}}, "action": "Skip"}
+	]`))
+	require.NoError(t, err)
+
+	drainer := &drain.Helper{
+		Ctx:                 context.TODO(),
+		Client:              client,
+		Force:               true,
+		GracePeriodSeconds:  -1,
+		Timeout:             10 * time.Second,
+		IgnoreAllDaemonSets: true,
+		AdditionalFilters:   []drain.PodFilter{skipDrainPolicyFilter(policies)},
+		Out:                 io.Discard,
+		ErrOut:              io.Discard,
+	}
+
+	timedOut, err := runNodeDrain(drainer, "node-0", policies, time.Minute)
+	require.NoError(t, err)
+	assert.Empty(t, timedOut)
+
+	pods, err := client.CoreV1().Pods("").List(context.TODO(), metav1.ListOptions{})
+	require.NoError(t, err)
+	var remaining []string
+	for _, pod := range pods.Items {
+		remaining = append(remaining, pod.Namespace+"/"+pod.Name)
+	}
+	assert.Equal(t, []string{"monitoring/agent-0"}, remaining)
+}
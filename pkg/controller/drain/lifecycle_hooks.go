@@ -0,0 +1,269 @@
+package drain
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"strings"
+	"time"
+
+	mcfgv1 "github.com/openshift/api/machineconfiguration/v1"
+	"github.com/openshift/api/machineconfiguration/v1alpha1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/klog/v2"
+
+	ctrlcommon "github.com/openshift/machine-config-operator/pkg/controller/common"
+	"github.com/openshift/machine-config-operator/pkg/helpers"
+	"github.com/openshift/machine-config-operator/pkg/upgrademonitor"
+)
+
+const (
+	// lifecycleHookRequeueDelay is how often a node waiting for a running hook is synced.
+	lifecycleHookRequeueDelay = 15 * time.Second
+
+	// lifecycleHookFailedRequeueDelay is how often a node blocked by a failed hook is synced.
+	lifecycleHookFailedRequeueDelay = 2 * time.Minute
+
+	// lifecycleHookJobTTL is how long finished hook Jobs are kept.
+	lifecycleHookJobTTL = int32(24 * 60 * 60)
+)
+
+// MachineConfigNode conditions recording the results of lifecycle hooks.
+const (
+	machineConfigNodePreDrainHooks   v1alpha1.StateProgress = "PreDrainHooks"
+	machineConfigNodePostUpdateHooks v1alpha1.StateProgress = "PostUpdateHooks"
+)
+
+// lifecycleHookResult is the state of the Job of a lifecycle hook.
+type lifecycleHookResult int
+
+const (
+	lifecycleHookRunning lifecycleHookResult = iota
+	lifecycleHookSucceeded
+	lifecycleHookFailed
+)
+
+// lifecycleHookJobName returns the name of the Job of a hook for an update of
+// a node. The desired drainer state identifies the update, so each update of
+// the node launches new Jobs while resyncs of the same update find them.
+func lifecycleHookJobName(hook *ctrlcommon.LifecycleHook, nodeName, desiredState string) string {
+	hash := sha256.Sum256([]byte(strings.Join([]string{string(hook.Stage), nodeName, desiredState}, "/")))
+	suffix := fmt.Sprintf("-%x", hash[:5])
+	// Pods of the Job are labelled with its name, which must fit in a label value.
+	prefix := "mco-" + hook.Name
+	if maxLen := validation.LabelValueMaxLength - len(suffix); len(prefix) > maxLen {
+		prefix = strings.TrimRight(prefix[:maxLen], "-")
+	}
+	return prefix + suffix
+}
+
+// newLifecycleHookJob returns the Job of a hook for an update of a node. It
+// runs in the MCO namespace as the lifecycle hook service account, whatever
+// the template says.
+func newLifecycleHookJob(hook *ctrlcommon.LifecycleHook, node *corev1.Node, desiredState string) *batchv1.Job {
+	job := &batchv1.Job{
+		ObjectMeta: *hook.Template.ObjectMeta.DeepCopy(),
+		Spec:       *hook.Template.Spec.DeepCopy(),
+	}
+	job.Name = lifecycleHookJobName(hook, node.Name, desiredState)
+	job.GenerateName = ""
+	job.Namespace = ctrlcommon.MCONamespace
+	if job.Labels == nil {
+		job.Labels = map[string]string{}
+	}
+	job.Labels[ctrlcommon.LifecycleHookNameLabelKey] = hook.Name
+	if len(validation.IsValidLabelValue(node.Name)) == 0 {
+		job.Labels[ctrlcommon.LifecycleHookNodeLabelKey] = node.Name
+	}
+
+	// The Job must not outlive the hook timeout, and is cleaned up eventually.
+	deadline := int64(hook.GetTimeout().Seconds())
+	if job.Spec.ActiveDeadlineSeconds == nil || *job.Spec.ActiveDeadlineSeconds > deadline {
+		job.Spec.ActiveDeadlineSeconds = &deadline
+	}
+	if job.Spec.TTLSecondsAfterFinished == nil || *job.Spec.TTLSecondsAfterFinished > lifecycleHookJobTTL {
+		ttl := lifecycleHookJobTTL
+		job.Spec.TTLSecondsAfterFinished = &ttl
+	}
+
+	podSpec := &job.Spec.Template.Spec
+	podSpec.ServiceAccountName = ctrlcommon.LifecycleHookServiceAccountName
+	podSpec.DeprecatedServiceAccount = ""
+	if hook.PinToNode {
+		podSpec.NodeName = node.Name
+	}
+	// The desired drainer state is "<verb>-<desired config>".
+	_, desiredConfig, _ := strings.Cut(desiredState, "-")
+	env := []corev1.EnvVar{
+		{Name: "MCO_NODE_NAME", Value: node.Name},
+		{Name: "MCO_HOOK_STAGE", Value: string(hook.Stage)},
+		{Name: "MCO_DESIRED_CONFIG", Value: desiredConfig},
+	}
+	for i := range podSpec.InitContainers {
+		podSpec.InitContainers[i].Env = append(podSpec.InitContainers[i].Env, env...)
+	}
+	for i := range podSpec.Containers {
+		podSpec.Containers[i].Env = append(podSpec.Containers[i].Env, env...)
+	}
+	return job
+}
+
+// isLifecycleHookJobFinished returns whether a Job has completed or failed.
+func isLifecycleHookJobFinished(job *batchv1.Job) bool {
+	for _, cond := range job.Status.Conditions {
+		if cond.Status == corev1.ConditionTrue && (cond.Type == batchv1.JobComplete || cond.Type == batchv1.JobFailed) {
+			return true
+		}
+	}
+	return false
+}
+
+// deleteFinishedLifecycleHookJobs deletes the finished Jobs of a hook for
+// previous updates of a node, so they do not pile up until their TTL when a
+// node is updated repeatedly.
+func (ctrl *Controller) deleteFinishedLifecycleHookJobs(hook *ctrlcommon.LifecycleHook, node *corev1.Node, current string) {
+	if len(validation.IsValidLabelValue(node.Name)) > 0 {
+		// The Jobs of the node are not labelled, their TTL cleans them up.
+		return
+	}
+	selector := labels.SelectorFromSet(labels.Set{
+		ctrlcommon.LifecycleHookNameLabelKey: hook.Name,
+		ctrlcommon.LifecycleHookNodeLabelKey: node.Name,
+	})
+	jobs, err := ctrl.kubeClient.BatchV1().Jobs(ctrlcommon.MCONamespace).List(context.TODO(), metav1.ListOptions{LabelSelector: selector.String()})
+	if err != nil {
+		klog.Warningf("node %s: could not list Jobs of lifecycle hook %s: %v", node.Name, hook.Name, err)
+		return
+	}
+	propagation := metav1.DeletePropagationBackground
+	for i := range jobs.Items {
+		job := &jobs.Items[i]
+		if job.Name == current || !isLifecycleHookJobFinished(job) {
+			continue
+		}
+		if err := ctrl.kubeClient.BatchV1().Jobs(job.Namespace).Delete(context.TODO(), job.Name, metav1.DeleteOptions{PropagationPolicy: &propagation}); err != nil && !apierrors.IsNotFound(err) {
+			klog.Warningf("node %s: could not delete finished Job %s of lifecycle hook %s: %v", node.Name, job.Name, hook.Name, err)
+		}
+	}
+}
+
+// getLifecycleHookResult returns the state of the Job of a hook, along with a
+// message describing why it failed, if it did.
+func getLifecycleHookResult(hook *ctrlcommon.LifecycleHook, job *batchv1.Job, now time.Time) (lifecycleHookResult, string) {
+	for _, cond := range job.Status.Conditions {
+		if cond.Status != corev1.ConditionTrue {
+			continue
+		}
+		switch cond.Type {
+		case batchv1.JobComplete:
+			return lifecycleHookSucceeded, ""
+		case batchv1.JobFailed:
+			return lifecycleHookFailed, fmt.Sprintf("Job %s/%s failed: %s: %s", job.Namespace, job.Name, cond.Reason, cond.Message)
+		}
+	}
+	// The Job controller enforces the deadline, but a Job whose pods cannot be
+	// created would never report it.
+	if timeout := hook.GetTimeout(); now.Sub(job.CreationTimestamp.Time) > timeout {
+		return lifecycleHookFailed, fmt.Sprintf("Job %s/%s did not complete within %s", job.Namespace, job.Name, timeout)
+	}
+	return lifecycleHookRunning, ""
+}
+
+// runLifecycleHooks launches the Jobs of the lifecycle hooks of the given
+// stage for the update of a node one after the other, and returns whether all
+// of them are done. The node is requeued while they are not.
+func (ctrl *Controller) runLifecycleHooks(node *corev1.Node, stage ctrlcommon.LifecycleHookStage, desiredState string) (bool, error) {
+	pool, err := helpers.GetPrimaryPoolForNode(ctrl.mcpLister, node)
+	if err != nil {
+		return false, err
+	}
+	if pool == nil {
+		return true, nil
+	}
+	hooks, err := ctrlcommon.GetLifecycleHooks(pool, stage)
+	if err != nil {
+		ctrl.eventRecorder.Eventf(node, corev1.EventTypeWarning, "InvalidLifecycleHooks", "Not updating node: %v", err)
+		return false, err
+	}
+	if len(hooks) == 0 {
+		return true, nil
+	}
+
+	results := []string{}
+	for i := range hooks {
+		hook := &hooks[i]
+		name := lifecycleHookJobName(hook, node.Name, desiredState)
+
+		job, err := ctrl.kubeClient.BatchV1().Jobs(ctrlcommon.MCONamespace).Get(context.TODO(), name, metav1.GetOptions{})
+		if apierrors.IsNotFound(err) {
+			ctrl.deleteFinishedLifecycleHookJobs(hook, node, name)
+			job, err = ctrl.kubeClient.BatchV1().Jobs(ctrlcommon.MCONamespace).Create(context.TODO(), newLifecycleHookJob(hook, node, desiredState), metav1.CreateOptions{})
+			if err != nil {
+				return false, fmt.Errorf("could not launch %s lifecycle hook %s: %w", stage, hook.Name, err)
+			}
+			ctrl.logNode(node, "launched %s lifecycle hook %s as Job %s/%s", stage, hook.Name, job.Namespace, job.Name)
+			ctrl.eventRecorder.Eventf(node, corev1.EventTypeNormal, "LifecycleHookStarted", "Launched %s lifecycle hook %s as Job %s/%s", stage, hook.Name, job.Namespace, job.Name)
+		}
+		if err != nil {
+			return false, err
+		}
+
+		result, message := getLifecycleHookResult(hook, job, time.Now())
+		switch result {
+		case lifecycleHookSucceeded:
+			results = append(results, fmt.Sprintf("%s succeeded", hook.Name))
+			continue
+		case lifecycleHookRunning:
+			results = append(results, fmt.Sprintf("%s running", hook.Name))
+			ctrl.recordLifecycleHooks(node, pool, stage, metav1.ConditionUnknown, results)
+			ctrl.enqueueAfter(node, lifecycleHookRequeueDelay)
+			return false, nil
+		}
+
+		if hook.FailurePolicy == ctrlcommon.LifecycleHookFailurePolicyIgnore {
+			klog.Warningf("node %s: ignoring failed %s lifecycle hook %s: %s", node.Name, stage, hook.Name, message)
+			ctrl.eventRecorder.Eventf(node, corev1.EventTypeWarning, "LifecycleHookFailed", "Ignoring failed %s lifecycle hook %s: %s", stage, hook.Name, message)
+			results = append(results, fmt.Sprintf("%s failed and was ignored: %s", hook.Name, message))
+			continue
+		}
+
+		ctrl.logNode(node, "%s lifecycle hook %s failed, delete the Job to retry it: %s", stage, hook.Name, message)
+		ctrl.eventRecorder.Eventf(node, corev1.EventTypeWarning, "LifecycleHookFailed", "%s lifecycle hook %s failed: %s", stage, hook.Name, message)
+		results = append(results, fmt.Sprintf("%s failed: %s", hook.Name, message))
+		ctrl.recordLifecycleHooks(node, pool, stage, metav1.ConditionFalse, results)
+		ctrl.enqueueAfter(node, lifecycleHookFailedRequeueDelay)
+		return false, nil
+	}
+
+	ctrl.recordLifecycleHooks(node, pool, stage, metav1.ConditionTrue, results)
+	return true, nil
+}
+
+// recordLifecycleHooks records the results of the lifecycle hooks of a stage
+// on the MachineConfigNode of the node. They have their own condition, so that
+// nodes resynced during a drain do not overwrite the drain progress.
+func (ctrl *Controller) recordLifecycleHooks(node *corev1.Node, pool *mcfgv1.MachineConfigPool, stage ctrlcommon.LifecycleHookStage, status metav1.ConditionStatus, results []string) {
+	state := machineConfigNodePreDrainHooks
+	if stage == ctrlcommon.LifecycleHookStagePostUpdate {
+		state = machineConfigNodePostUpdateHooks
+	}
+
+	err := upgrademonitor.GenerateAndApplyMachineConfigNodes(
+		&upgrademonitor.Condition{State: state, Reason: string(state), Message: strings.Join(results, "; ")},
+		nil,
+		status,
+		metav1.ConditionFalse,
+		node,
+		ctrl.client,
+		ctrl.featureGatesAccessor,
+		pool.Name,
+	)
+	if err != nil {
+		klog.Errorf("Error making MCN for %s lifecycle hooks: %v", stage, err)
+	}
+}
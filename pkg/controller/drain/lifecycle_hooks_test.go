@@ -0,0 +1,167 @@
+package drain
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/client-go/kubernetes/fake"
+
+	ctrlcommon "github.com/openshift/machine-config-operator/pkg/controller/common"
+)
+
+func newTestLifecycleHook(name string) *ctrlcommon.LifecycleHook {
+	return &ctrlcommon.LifecycleHook{
+		Name:          name,
+		Stage:         ctrlcommon.LifecycleHookStagePreDrain,
+		Timeout:       "10m",
+		FailurePolicy: ctrlcommon.LifecycleHookFailurePolicyFail,
+		Template: batchv1.JobTemplateSpec{
+			ObjectMeta: metav1.ObjectMeta{Labels: map[string]string{"app": "evacuate"}},
+			Spec: batchv1.JobSpec{
+				Template: corev1.PodTemplateSpec{
+					Spec: corev1.PodSpec{
+						Containers:    []corev1.Container{{Name: "hook", Image: "quay.io/example/hook"}},
+						RestartPolicy: corev1.RestartPolicyNever,
+					},
+				},
+			},
+		},
+	}
+}
+
+func TestLifecycleHookJobName(t *testing.T) {
+	hook := newTestLifecycleHook("evacuate")
+
+	name := lifecycleHookJobName(hook, "node-0", "drain-rendered-worker-1")
+	assert.True(t, strings.HasPrefix(name, "mco-evacuate-"))
+	assert.Equal(t, name, lifecycleHookJobName(hook, "node-0", "drain-rendered-worker-1"))
+	assert.NotEqual(t, name, lifecycleHookJobName(hook, "node-1", "drain-rendered-worker-1"))
+	assert.NotEqual(t, name, lifecycleHookJobName(hook, "node-0", "drain-rendered-worker-2"))
+
+	long := lifecycleHookJobName(newTestLifecycleHook(strings.Repeat("a", 63)), "node-0", "drain-rendered-worker-1")
+	assert.Empty(t, validation.IsValidLabelValue(long))
+	assert.Empty(t, validation.IsDNS1123Label(long))
+}
+
+func TestNewLifecycleHookJob(t *testing.T) {
+	node := &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node-0"}}
+
+	hook := newTestLifecycleHook("evacuate")
+	job := newLifecycleHookJob(hook, node, "drain-rendered-worker-1")
+
+	assert.Equal(t, ctrlcommon.MCONamespace, job.Namespace)
+	assert.Equal(t, ctrlcommon.LifecycleHookServiceAccountName, job.Spec.Template.Spec.ServiceAccountName)
+	assert.Equal(t, map[string]string{
+		"app":                                "evacuate",
+		ctrlcommon.LifecycleHookNameLabelKey: "evacuate",
+		ctrlcommon.LifecycleHookNodeLabelKey: "node-0",
+	}, job.Labels)
+	assert.Equal(t, int64(600), *job.Spec.ActiveDeadlineSeconds)
+	assert.Equal(t, lifecycleHookJobTTL, *job.Spec.TTLSecondsAfterFinished)
+	assert.Empty(t, job.Spec.Template.Spec.NodeName)
+	assert.Equal(t, []corev1.EnvVar{
+		{Name: "MCO_NODE_NAME", Value: "node-0"},
+		{Name: "MCO_HOOK_STAGE", Value: "PreDrain"},
+		{Name: "MCO_DESIRED_CONFIG", Value: "rendered-worker-1"},
+	}, job.Spec.Template.Spec.Containers[0].Env)
+	// The template of the hook is left untouched.
+	assert.Nil(t, hook.Template.Spec.Template.Spec.Containers[0].Env)
+
+	hook.PinToNode = true
+	assert.Equal(t, "node-0", newLifecycleHookJob(hook, node, "drain-rendered-worker-1").Spec.Template.Spec.NodeName)
+
+	// Finished Jobs are not kept longer than the default.
+	ttl := 7 * lifecycleHookJobTTL
+	hook.Template.Spec.TTLSecondsAfterFinished = &ttl
+	assert.Equal(t, lifecycleHookJobTTL, *newLifecycleHookJob(hook, node, "drain-rendered-worker-1").Spec.TTLSecondsAfterFinished)
+}
+
+func TestDeleteFinishedLifecycleHookJobs(t *testing.T) {
+	node := &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node-0"}}
+	hook := newTestLifecycleHook("evacuate")
+
+	newJob := func(desiredState string, conditions ...batchv1.JobCondition) *batchv1.Job {
+		job := newLifecycleHookJob(hook, node, desiredState)
+		job.Status.Conditions = conditions
+		return job
+	}
+	complete := batchv1.JobCondition{Type: batchv1.JobComplete, Status: corev1.ConditionTrue}
+	failed := batchv1.JobCondition{Type: batchv1.JobFailed, Status: corev1.ConditionTrue}
+	other := newLifecycleHookJob(newTestLifecycleHook("notify"), node, "drain-rendered-worker-1")
+	other.Status.Conditions = []batchv1.JobCondition{complete}
+
+	ctrl := &Controller{kubeClient: fake.NewSimpleClientset(
+		newJob("drain-rendered-worker-1", complete),
+		newJob("drain-rendered-worker-2", failed),
+		newJob("drain-rendered-worker-3"),
+		newJob("drain-rendered-worker-4", complete),
+		other,
+	)}
+	ctrl.deleteFinishedLifecycleHookJobs(hook, node, lifecycleHookJobName(hook, node.Name, "drain-rendered-worker-4"))
+
+	jobs, err := ctrl.kubeClient.BatchV1().Jobs(ctrlcommon.MCONamespace).List(context.TODO(), metav1.ListOptions{})
+	require.NoError(t, err)
+	names := []string{}
+	for _, job := range jobs.Items {
+		names = append(names, job.Name)
+	}
+	// Running Jobs, the Job of the current update and the Jobs of other hooks are kept.
+	assert.ElementsMatch(t, []string{
+		lifecycleHookJobName(hook, node.Name, "drain-rendered-worker-3"),
+		lifecycleHookJobName(hook, node.Name, "drain-rendered-worker-4"),
+		other.Name,
+	}, names)
+}
+
+func TestGetLifecycleHookResult(t *testing.T) {
+	now := time.Now()
+	hook := newTestLifecycleHook("evacuate")
+
+	newJob := func(age time.Duration, conditions ...batchv1.JobCondition) *batchv1.Job {
+		return &batchv1.Job{
+			ObjectMeta: metav1.ObjectMeta{Name: "mco-evacuate", Namespace: ctrlcommon.MCONamespace, CreationTimestamp: metav1.NewTime(now.Add(-age))},
+			Status:     batchv1.JobStatus{Conditions: conditions},
+		}
+	}
+
+	tests := []struct {
+		name            string
+		job             *batchv1.Job
+		expected        lifecycleHookResult
+		expectedMessage string
+	}{{
+		name:     "running",
+		job:      newJob(time.Minute),
+		expected: lifecycleHookRunning,
+	}, {
+		name:     "complete",
+		job:      newJob(time.Minute, batchv1.JobCondition{Type: batchv1.JobComplete, Status: corev1.ConditionTrue}),
+		expected: lifecycleHookSucceeded,
+	}, {
+		name:            "failed",
+		job:             newJob(time.Minute, batchv1.JobCondition{Type: batchv1.JobFailed, Status: corev1.ConditionTrue, Reason: "BackoffLimitExceeded", Message: "Job has reached the specified backoff limit"}),
+		expected:        lifecycleHookFailed,
+		expectedMessage: "Job openshift-machine-config-operator/mco-evacuate failed: BackoffLimitExceeded: Job has reached the specified backoff limit",
+	}, {
+		name:            "timed out",
+		job:             newJob(time.Hour),
+		expected:        lifecycleHookFailed,
+		expectedMessage: "Job openshift-machine-config-operator/mco-evacuate did not complete within 10m0s",
+	}}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			result, message := getLifecycleHookResult(hook, test.job, now)
+			assert.Equal(t, test.expected, result)
+			assert.Equal(t, test.expectedMessage, message)
+		})
+	}
+}
@@ -0,0 +1,268 @@
+package node
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	mcfgv1 "github.com/openshift/api/machineconfiguration/v1"
+	mcfgv1alpha1 "github.com/openshift/api/machineconfiguration/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/sets"
+
+	"github.com/openshift/machine-config-operator/pkg/apihelpers"
+	ctrlcommon "github.com/openshift/machine-config-operator/pkg/controller/common"
+	daemonconsts "github.com/openshift/machine-config-operator/pkg/daemon/constants"
+)
+
+// Reasons of the CanaryRollout pool condition.
+const (
+	canaryReasonInvalidConfig = "InvalidCanaryConfig"
+	canaryReasonUpdating      = "CanariesUpdating"
+	canaryReasonFailed        = "CanariesFailed"
+	canaryReasonSoaking       = "CanariesSoaking"
+	canaryReasonPassed        = "CanariesPassed"
+
+	// defaultCanarySoakPeriod is used when a pool opts in to canary rollouts
+	// without setting CanarySoakPeriodAnnotationKey.
+	defaultCanarySoakPeriod = 10 * time.Minute
+)
+
+type canaryConfig struct {
+	// selector chooses the canary nodes; nil selects all nodes of the pool.
+	selector labels.Selector
+	// count limits the number of canary nodes; zero means no limit.
+	count      int
+	soakPeriod time.Duration
+}
+
+// getCanaryConfig reads the canary rollout settings of a pool. It returns nil
+// if the pool has not opted in to canary rollouts.
+func getCanaryConfig(pool *mcfgv1.MachineConfigPool) (*canaryConfig, error) {
+	selectorVal, hasSelector := pool.Annotations[ctrlcommon.CanaryNodeSelectorAnnotationKey]
+	countVal, hasCount := pool.Annotations[ctrlcommon.CanaryNodeCountAnnotationKey]
+	if !hasSelector && !hasCount {
+		return nil, nil
+	}
+
+	cfg := &canaryConfig{soakPeriod: defaultCanarySoakPeriod}
+
+	if hasSelector {
+		selector, err := labels.Parse(selectorVal)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s annotation %q: %w", ctrlcommon.CanaryNodeSelectorAnnotationKey, selectorVal, err)
+		}
+		cfg.selector = selector
+	}
+
+	if hasCount {
+		count, err := strconv.Atoi(countVal)
+		if err != nil || count < 1 {
+			return nil, fmt.Errorf("invalid %s annotation %q: must be a positive integer", ctrlcommon.CanaryNodeCountAnnotationKey, countVal)
+		}
+		cfg.count = count
+	}
+
+	if soakVal, ok := pool.Annotations[ctrlcommon.CanarySoakPeriodAnnotationKey]; ok {
+		soak, err := time.ParseDuration(soakVal)
+		if err != nil || soak < 0 {
+			return nil, fmt.Errorf("invalid %s annotation %q: must be a non-negative duration", ctrlcommon.CanarySoakPeriodAnnotationKey, soakVal)
+		}
+		cfg.soakPeriod = soak
+	}
+
+	return cfg, nil
+}
+
+// selectCanaryNodes returns the canary nodes out of the nodes of a pool. When
+// limited by count, nodes are picked in the same zone and age order as update
+// candidates, so the selection is stable across syncs.
+func selectCanaryNodes(nodes []*corev1.Node, cfg *canaryConfig) []*corev1.Node {
+	canaries := []*corev1.Node{}
+	for _, node := range nodes {
+		if cfg.selector == nil || cfg.selector.Matches(labels.Set(node.Labels)) {
+			canaries = append(canaries, node)
+		}
+	}
+
+	canaries = sortNodeList(canaries)
+	if cfg.count > 0 && len(canaries) > cfg.count {
+		canaries = canaries[:cfg.count]
+	}
+	return canaries
+}
+
+// canaryRollout is the state of a canary rollout of the pool's desired config.
+type canaryRollout struct {
+	canaries sets.Set[string]
+	reason   string
+	message  string
+	// requeueAfter is the remaining soak period, if the canaries are soaking.
+	requeueAfter time.Duration
+}
+
+// holdsBack returns true if the update of non-canary nodes must wait.
+func (r *canaryRollout) holdsBack() bool {
+	return r.reason != canaryReasonPassed
+}
+
+// filterCandidates restricts the update candidates to the canary nodes while
+// the canaries have not passed, and to no nodes at all if they failed.
+func (r *canaryRollout) filterCandidates(candidates []*corev1.Node) []*corev1.Node {
+	if !r.holdsBack() {
+		return candidates
+	}
+	if r.reason == canaryReasonFailed || r.reason == canaryReasonInvalidConfig {
+		return nil
+	}
+
+	filtered := []*corev1.Node{}
+	for _, node := range candidates {
+		if r.canaries.Has(node.Name) {
+			filtered = append(filtered, node)
+		}
+	}
+	return filtered
+}
+
+// evaluateCanaryRollout determines the state of the canary rollout for the
+// pool's desired config. It returns nil if the pool has not opted in to canary
+// rollouts. The state is derived from the nodes and the previous CanaryRollout
+// condition of the pool, which records when the soak period started.
+func evaluateCanaryRollout(pool *mcfgv1.MachineConfigPool, nodes []*corev1.Node, mcns []*mcfgv1alpha1.MachineConfigNode, layered bool, mosc *mcfgv1.MachineOSConfig, mosb *mcfgv1.MachineOSBuild, now time.Time) *canaryRollout {
+	cfg, err := getCanaryConfig(pool)
+	if err != nil {
+		return &canaryRollout{
+			canaries: sets.New[string](),
+			reason:   canaryReasonInvalidConfig,
+			message:  fmt.Sprintf("Rollout is halted: %v", err),
+		}
+	}
+	if cfg == nil {
+		return nil
+	}
+
+	canaries := selectCanaryNodes(nodes, cfg)
+	rollout := &canaryRollout{canaries: sets.New[string](getNamesFromNodes(canaries)...)}
+	target := getPoolUpdateLine(pool, mosc, layered)
+	previous := apihelpers.GetMachineConfigPoolCondition(pool.Status, ctrlcommon.MachineConfigPoolCanaryRollout)
+
+	// Once the canaries passed, the rest of the pool proceeds even if a canary
+	// node becomes unavailable later on.
+	rollout.reason = canaryReasonPassed
+	rollout.message = fmt.Sprintf("Canary nodes passed for %s", target)
+	if len(canaries) == 0 {
+		rollout.message = fmt.Sprintf("No canary nodes selected for %s", target)
+		return rollout
+	}
+	if previous != nil && previous.Reason == rollout.reason && previous.Message == rollout.message {
+		return rollout
+	}
+	// Nothing is held back if the whole pool is already updated.
+	if len(getUpdatedMachines(pool, nodes, mosc, mosb, layered)) == len(nodes) {
+		return rollout
+	}
+
+	mcnsByName := map[string]*mcfgv1alpha1.MachineConfigNode{}
+	for _, mcn := range mcns {
+		mcnsByName[mcn.Name] = mcn
+	}
+
+	failures := []string{}
+	for _, node := range canaries {
+		if !isNodeTargetingPool(node, pool, layered, mosc, mosb) {
+			continue
+		}
+		if failure := getCanaryFailure(node, mcnsByName[node.Name]); failure != "" {
+			failures = append(failures, failure)
+		}
+	}
+	if len(failures) > 0 {
+		rollout.reason = canaryReasonFailed
+		rollout.message = fmt.Sprintf("Rollout of %s is halted as canary nodes failed: %s", target, strings.Join(failures, ", "))
+		return rollout
+	}
+
+	ready := getReadyMachines(pool, canaries, mosc, mosb, layered)
+	if len(ready) < len(canaries) {
+		rollout.reason = canaryReasonUpdating
+		rollout.message = fmt.Sprintf("Updating canary nodes to %s (%d of %d done)", target, len(ready), len(canaries))
+		return rollout
+	}
+
+	if cfg.soakPeriod == 0 {
+		return rollout
+	}
+
+	soakMessage := fmt.Sprintf("Canary nodes are updated to %s; soaking for %s", target, cfg.soakPeriod)
+	soakEnd := now.Add(cfg.soakPeriod)
+	if previous != nil && previous.Reason == canaryReasonSoaking && previous.Message == soakMessage {
+		soakEnd = previous.LastTransitionTime.Add(cfg.soakPeriod)
+	}
+	if now.Before(soakEnd) {
+		rollout.reason = canaryReasonSoaking
+		rollout.message = soakMessage
+		rollout.requeueAfter = soakEnd.Sub(now)
+	}
+
+	return rollout
+}
+
+// isNodeTargetingPool returns true if the node's desired state matches the
+// pool's desired config, or build for layered pools.
+func isNodeTargetingPool(node *corev1.Node, pool *mcfgv1.MachineConfigPool, layered bool, mosc *mcfgv1.MachineOSConfig, mosb *mcfgv1.MachineOSBuild) bool {
+	lns := ctrlcommon.NewLayeredNodeState(node)
+	if layered && mosb != nil {
+		return lns.IsDesiredEqualToBuild(mosc, mosb)
+	}
+	return lns.IsDesiredEqualToPool(pool, layered)
+}
+
+// getCanaryFailure returns why a canary node failed to update, or an empty
+// string if it did not.
+func getCanaryFailure(node *corev1.Node, mcn *mcfgv1alpha1.MachineConfigNode) string {
+	if isNodeMCDFailing(node) {
+		return fmt.Sprintf("node %s is reporting %s: %q", node.Name, node.Annotations[daemonconsts.MachineConfigDaemonStateAnnotationKey], node.Annotations[daemonconsts.MachineConfigDaemonReasonAnnotationKey])
+	}
+
+	if mcn == nil {
+		return ""
+	}
+	for _, cond := range mcn.Status.Conditions {
+		if cond.Status != metav1.ConditionTrue {
+			continue
+		}
+		switch mcfgv1alpha1.StateProgress(cond.Type) {
+		case mcfgv1alpha1.MachineConfigNodeNodeDegraded, mcfgv1alpha1.MachineConfigNodePinnedImageSetsDegraded:
+			return fmt.Sprintf("node %s is reporting %s: %q", node.Name, cond.Type, cond.Message)
+		}
+	}
+	return ""
+}
+
+// setCanaryRolloutCondition records the canary rollout state on the pool
+// status. Unlike SetMachineConfigPoolCondition, the transition time is reset
+// whenever the reason or message change, as it marks the start of the soak period.
+func setCanaryRolloutCondition(status *mcfgv1.MachineConfigPoolStatus, rollout *canaryRollout) {
+	if rollout == nil {
+		apihelpers.RemoveMachineConfigPoolCondition(status, ctrlcommon.MachineConfigPoolCanaryRollout)
+		return
+	}
+
+	condStatus := corev1.ConditionFalse
+	if rollout.holdsBack() {
+		condStatus = corev1.ConditionTrue
+	}
+
+	current := apihelpers.GetMachineConfigPoolCondition(*status, ctrlcommon.MachineConfigPoolCanaryRollout)
+	if current != nil && current.Status == condStatus && current.Reason == rollout.reason && current.Message == rollout.message {
+		return
+	}
+
+	apihelpers.RemoveMachineConfigPoolCondition(status, ctrlcommon.MachineConfigPoolCanaryRollout)
+	cond := apihelpers.NewMachineConfigPoolCondition(ctrlcommon.MachineConfigPoolCanaryRollout, condStatus, rollout.reason, rollout.message)
+	apihelpers.SetMachineConfigPoolCondition(status, *cond)
+}
@@ -0,0 +1,276 @@
+package node
+
+import (
+	"testing"
+	"time"
+
+	mcfgv1 "github.com/openshift/api/machineconfiguration/v1"
+	mcfgalphav1 "github.com/openshift/api/machineconfiguration/v1alpha1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/openshift/machine-config-operator/pkg/apihelpers"
+	ctrlcommon "github.com/openshift/machine-config-operator/pkg/controller/common"
+	daemonconsts "github.com/openshift/machine-config-operator/pkg/daemon/constants"
+	"github.com/openshift/machine-config-operator/test/helpers"
+)
+
+func TestGetCanaryConfig(t *testing.T) {
+	tests := []struct {
+		name        string
+		annotations map[string]string
+		expectNil   bool
+		expectErr   bool
+		count       int
+		soakPeriod  time.Duration
+	}{{
+		name:      "not opted in",
+		expectNil: true,
+	}, {
+		name:        "count only",
+		annotations: map[string]string{ctrlcommon.CanaryNodeCountAnnotationKey: "2"},
+		count:       2,
+		soakPeriod:  defaultCanarySoakPeriod,
+	}, {
+		name: "selector and soak period",
+		annotations: map[string]string{
+			ctrlcommon.CanaryNodeSelectorAnnotationKey: "canary=true",
+			ctrlcommon.CanarySoakPeriodAnnotationKey:   "1h",
+		},
+		soakPeriod: time.Hour,
+	}, {
+		name:        "invalid count",
+		annotations: map[string]string{ctrlcommon.CanaryNodeCountAnnotationKey: "0"},
+		expectErr:   true,
+	}, {
+		name:        "invalid selector",
+		annotations: map[string]string{ctrlcommon.CanaryNodeSelectorAnnotationKey: "canary in (true"},
+		expectErr:   true,
+	}, {
+		name: "invalid soak period",
+		annotations: map[string]string{
+			ctrlcommon.CanaryNodeCountAnnotationKey:  "1",
+			ctrlcommon.CanarySoakPeriodAnnotationKey: "ten minutes",
+		},
+		expectErr: true,
+	}}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			pool := helpers.NewMachineConfigPool("worker", nil, helpers.WorkerSelector, machineConfigV1)
+			pool.Annotations = test.annotations
+
+			cfg, err := getCanaryConfig(pool)
+			if test.expectErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			if test.expectNil {
+				assert.Nil(t, cfg)
+				return
+			}
+			require.NotNil(t, cfg)
+			assert.Equal(t, test.count, cfg.count)
+			assert.Equal(t, test.soakPeriod, cfg.soakPeriod)
+		})
+	}
+}
+
+func TestSelectCanaryNodes(t *testing.T) {
+	nodes := []*corev1.Node{
+		newNodeWithLabels("node-0", map[string]string{zoneLabel: "zone-b"}),
+		newNodeWithLabels("node-1", map[string]string{zoneLabel: "zone-a", "canary": "true"}),
+		newNodeWithLabels("node-2", map[string]string{zoneLabel: "zone-c", "canary": "true"}),
+	}
+
+	pool := helpers.NewMachineConfigPool("worker", nil, helpers.WorkerSelector, machineConfigV1)
+	pool.Annotations = map[string]string{ctrlcommon.CanaryNodeCountAnnotationKey: "2"}
+	cfg, err := getCanaryConfig(pool)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"node-1", "node-0"}, getNamesFromNodes(selectCanaryNodes(nodes, cfg)))
+
+	pool.Annotations[ctrlcommon.CanaryNodeSelectorAnnotationKey] = "canary=true"
+	cfg, err = getCanaryConfig(pool)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"node-1", "node-2"}, getNamesFromNodes(selectCanaryNodes(nodes, cfg)))
+
+	pool.Annotations[ctrlcommon.CanaryNodeCountAnnotationKey] = "1"
+	cfg, err = getCanaryConfig(pool)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"node-1"}, getNamesFromNodes(selectCanaryNodes(nodes, cfg)))
+}
+
+func TestEvaluateCanaryRollout(t *testing.T) {
+	now := time.Now()
+	canaryLabels := map[string]string{"canary": "true"}
+	soakingMessage := "Canary nodes are updated to MachineConfig " + machineConfigV1 + "; soaking for 10m0s"
+
+	canaryPool := func(conditions ...mcfgv1.MachineConfigPoolCondition) *mcfgv1.MachineConfigPool {
+		pool := helpers.NewMachineConfigPool("worker", nil, helpers.WorkerSelector, machineConfigV1)
+		pool.Annotations = map[string]string{ctrlcommon.CanaryNodeSelectorAnnotationKey: "canary=true"}
+		pool.Status.Conditions = append(pool.Status.Conditions, conditions...)
+		return pool
+	}
+	soakingSince := func(start time.Time) mcfgv1.MachineConfigPoolCondition {
+		return mcfgv1.MachineConfigPoolCondition{
+			Type:               ctrlcommon.MachineConfigPoolCanaryRollout,
+			Status:             corev1.ConditionTrue,
+			Reason:             canaryReasonSoaking,
+			Message:            soakingMessage,
+			LastTransitionTime: metav1.NewTime(start),
+		}
+	}
+
+	tests := []struct {
+		name           string
+		pool           *mcfgv1.MachineConfigPool
+		nodes          []*corev1.Node
+		mcns           []*mcfgalphav1.MachineConfigNode
+		expectNil      bool
+		expectReason   string
+		expectRequeue  time.Duration
+		expectFiltered []string
+	}{{
+		name: "not opted in",
+		pool: helpers.NewMachineConfigPool("worker", nil, helpers.WorkerSelector, machineConfigV1),
+		nodes: []*corev1.Node{
+			newNodeWithReady("node-0", machineConfigV0, machineConfigV0, corev1.ConditionTrue),
+		},
+		expectNil: true,
+	}, {
+		name: "invalid config halts the rollout",
+		pool: func() *mcfgv1.MachineConfigPool {
+			pool := canaryPool()
+			pool.Annotations[ctrlcommon.CanaryNodeCountAnnotationKey] = "none"
+			return pool
+		}(),
+		nodes: []*corev1.Node{
+			newNodeWithReady("node-0", machineConfigV0, machineConfigV0, corev1.ConditionTrue),
+		},
+		expectReason:   canaryReasonInvalidConfig,
+		expectFiltered: []string{},
+	}, {
+		name: "canaries are updated first",
+		pool: canaryPool(),
+		nodes: []*corev1.Node{
+			newNodeWithLabel("node-0", machineConfigV0, machineConfigV0, canaryLabels),
+			newNodeWithReady("node-1", machineConfigV0, machineConfigV0, corev1.ConditionTrue),
+		},
+		expectReason:   canaryReasonUpdating,
+		expectFiltered: []string{"node-0"},
+	}, {
+		name: "failing canary halts the rollout",
+		pool: canaryPool(),
+		nodes: []*corev1.Node{
+			helpers.NewNodeBuilder("node-0").WithConfigs(machineConfigV0, machineConfigV1).WithLabels(canaryLabels).WithMCDState(daemonconsts.MachineConfigDaemonStateDegraded).Node(),
+			newNodeWithReady("node-1", machineConfigV0, machineConfigV0, corev1.ConditionTrue),
+		},
+		expectReason:   canaryReasonFailed,
+		expectFiltered: []string{},
+	}, {
+		name: "degraded MachineConfigNode halts the rollout",
+		pool: canaryPool(),
+		nodes: []*corev1.Node{
+			newNodeWithLabel("node-0", machineConfigV0, machineConfigV1, canaryLabels),
+			newNodeWithReady("node-1", machineConfigV0, machineConfigV0, corev1.ConditionTrue),
+		},
+		mcns: []*mcfgalphav1.MachineConfigNode{{
+			ObjectMeta: metav1.ObjectMeta{Name: "node-0"},
+			Status: mcfgalphav1.MachineConfigNodeStatus{
+				Conditions: []metav1.Condition{{
+					Type:   string(mcfgalphav1.MachineConfigNodeNodeDegraded),
+					Status: metav1.ConditionTrue,
+				}},
+			},
+		}},
+		expectReason:   canaryReasonFailed,
+		expectFiltered: []string{},
+	}, {
+		name: "updated canaries start soaking",
+		pool: canaryPool(),
+		nodes: []*corev1.Node{
+			newNodeWithLabel("node-0", machineConfigV1, machineConfigV1, canaryLabels),
+			newNodeWithReady("node-1", machineConfigV0, machineConfigV0, corev1.ConditionTrue),
+		},
+		expectReason:   canaryReasonSoaking,
+		expectRequeue:  defaultCanarySoakPeriod,
+		expectFiltered: []string{"node-0"},
+	}, {
+		name: "canaries keep soaking",
+		pool: canaryPool(soakingSince(now.Add(-4 * time.Minute))),
+		nodes: []*corev1.Node{
+			newNodeWithLabel("node-0", machineConfigV1, machineConfigV1, canaryLabels),
+			newNodeWithReady("node-1", machineConfigV0, machineConfigV0, corev1.ConditionTrue),
+		},
+		expectReason:   canaryReasonSoaking,
+		expectRequeue:  6 * time.Minute,
+		expectFiltered: []string{"node-0"},
+	}, {
+		name: "canaries passed after soaking",
+		pool: canaryPool(soakingSince(now.Add(-11 * time.Minute))),
+		nodes: []*corev1.Node{
+			newNodeWithLabel("node-0", machineConfigV1, machineConfigV1, canaryLabels),
+			newNodeWithReady("node-1", machineConfigV0, machineConfigV0, corev1.ConditionTrue),
+		},
+		expectReason:   canaryReasonPassed,
+		expectFiltered: []string{"node-0", "node-1"},
+	}, {
+		name: "passed canaries are not re-evaluated",
+		pool: canaryPool(mcfgv1.MachineConfigPoolCondition{
+			Type:    ctrlcommon.MachineConfigPoolCanaryRollout,
+			Status:  corev1.ConditionFalse,
+			Reason:  canaryReasonPassed,
+			Message: "Canary nodes passed for MachineConfig " + machineConfigV1,
+		}),
+		nodes: []*corev1.Node{
+			newNodeWithReady("node-0", machineConfigV1, machineConfigV1, corev1.ConditionFalse),
+			newNodeWithReady("node-1", machineConfigV0, machineConfigV0, corev1.ConditionTrue),
+		},
+		expectReason:   canaryReasonPassed,
+		expectFiltered: []string{"node-0", "node-1"},
+	}}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			rollout := evaluateCanaryRollout(test.pool, test.nodes, test.mcns, false, nil, nil, now)
+			if test.expectNil {
+				assert.Nil(t, rollout)
+				return
+			}
+			require.NotNil(t, rollout)
+			assert.Equal(t, test.expectReason, rollout.reason, rollout.message)
+			assert.Equal(t, test.expectRequeue, rollout.requeueAfter)
+			assert.ElementsMatch(t, test.expectFiltered, getNamesFromNodes(rollout.filterCandidates(test.nodes)))
+		})
+	}
+}
+
+func TestSetCanaryRolloutCondition(t *testing.T) {
+	status := &mcfgv1.MachineConfigPoolStatus{}
+
+	updating := &canaryRollout{reason: canaryReasonUpdating, message: "updating"}
+	setCanaryRolloutCondition(status, updating)
+	cond := apihelpers.GetMachineConfigPoolCondition(*status, ctrlcommon.MachineConfigPoolCanaryRollout)
+	require.NotNil(t, cond)
+	assert.Equal(t, corev1.ConditionTrue, cond.Status)
+
+	// The transition time marks the start of the soak period, so it must be
+	// reset even though the status does not change.
+	cond.LastTransitionTime = metav1.NewTime(time.Now().Add(-time.Hour))
+	setCanaryRolloutCondition(status, &canaryRollout{reason: canaryReasonSoaking, message: "soaking"})
+	cond = apihelpers.GetMachineConfigPoolCondition(*status, ctrlcommon.MachineConfigPoolCanaryRollout)
+	require.NotNil(t, cond)
+	assert.Equal(t, canaryReasonSoaking, cond.Reason)
+	assert.WithinDuration(t, time.Now(), cond.LastTransitionTime.Time, time.Minute)
+
+	setCanaryRolloutCondition(status, &canaryRollout{reason: canaryReasonPassed, message: "passed"})
+	cond = apihelpers.GetMachineConfigPoolCondition(*status, ctrlcommon.MachineConfigPoolCanaryRollout)
+	require.NotNil(t, cond)
+	assert.Equal(t, corev1.ConditionFalse, cond.Status)
+
+	setCanaryRolloutCondition(status, nil)
+	assert.Nil(t, apihelpers.GetMachineConfigPoolCondition(*status, ctrlcommon.MachineConfigPoolCanaryRollout))
+}
@@ -0,0 +1,282 @@
+package node
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	mcfgv1 "github.com/openshift/api/machineconfiguration/v1"
+	opv1 "github.com/openshift/api/operator/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/labels"
+	intstrutil "k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/klog/v2"
+
+	"github.com/openshift/machine-config-operator/pkg/apihelpers"
+	ctrlcommon "github.com/openshift/machine-config-operator/pkg/controller/common"
+	daemonconsts "github.com/openshift/machine-config-operator/pkg/daemon/constants"
+)
+
+// Reasons of the WaitingForDisruptionBudget pool condition.
+const (
+	disruptionBudgetReasonExhausted = "DisruptionBudgetExhausted"
+	disruptionBudgetReasonPriority  = "HigherPriorityPoolsUpdating"
+	disruptionBudgetReasonAvailable = "DisruptionBudgetAvailable"
+
+	// disruptionBudgetRequeueDelay is how often a pool waiting for the cluster
+	// disruption budget is synced, as nodes of other pools finishing their
+	// update do not trigger a sync of the pool.
+	disruptionBudgetRequeueDelay = 30 * time.Second
+
+	// disruptionReservationTimeout bounds how long a node targeted at a new
+	// config counts against the budget until the node lister reflects it.
+	disruptionReservationTimeout = time.Minute
+)
+
+// clusterDisruptionBudget limits the number of nodes disrupted at once across
+// all pools. The node controller runs leader-elected and is the only writer of
+// the desired config of nodes, so an in-memory semaphore is enough; it only
+// has to account for nodes targeted by other workers which the node lister
+// does not reflect yet.
+type clusterDisruptionBudget struct {
+	mu sync.Mutex
+	// reserved holds the nodes recently targeted at a new config.
+	reserved map[string]time.Time
+	// waiting holds the WaitingForDisruptionBudget condition of each pool as of
+	// its last sync. Pools are absent if the budget is not limited.
+	waiting map[string]*mcfgv1.MachineConfigPoolCondition
+}
+
+// available returns how many more nodes may be disrupted, given the limit and
+// the disrupted nodes known to the node lister. It must be called with mu held.
+func (b *clusterDisruptionBudget) available(limit int, disrupted sets.Set[string], now time.Time) int {
+	for name, reservedAt := range b.reserved {
+		if disrupted.Has(name) || now.Sub(reservedAt) > disruptionReservationTimeout {
+			delete(b.reserved, name)
+		}
+	}
+	available := limit - disrupted.Len() - len(b.reserved)
+	if available < 0 {
+		return 0
+	}
+	return available
+}
+
+// reserve counts a node targeted at a new config against the budget until
+// the node lister reflects it. It must be called with mu held.
+func (b *clusterDisruptionBudget) reserve(nodeName string, now time.Time) {
+	if b.reserved == nil {
+		b.reserved = map[string]time.Time{}
+	}
+	b.reserved[nodeName] = now
+}
+
+func (b *clusterDisruptionBudget) setPoolCondition(poolName string, cond *mcfgv1.MachineConfigPoolCondition) {
+	if b.waiting == nil {
+		b.waiting = map[string]*mcfgv1.MachineConfigPoolCondition{}
+	}
+	if cond == nil {
+		delete(b.waiting, poolName)
+		return
+	}
+	b.waiting[poolName] = cond
+}
+
+func (b *clusterDisruptionBudget) getPoolCondition(poolName string) *mcfgv1.MachineConfigPoolCondition {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.waiting[poolName]
+}
+
+// getMaxDisruptedNodes returns the cluster-wide limit of disrupted nodes set on
+// the MachineConfiguration cluster object, or zero if there is no limit.
+func getMaxDisruptedNodes(mcop *opv1.MachineConfiguration, nodeCount int) (int, error) {
+	if mcop == nil {
+		return 0, nil
+	}
+	val, ok := mcop.Annotations[ctrlcommon.MaxDisruptedNodesAnnotationKey]
+	if !ok {
+		return 0, nil
+	}
+
+	intOrPercent := intstrutil.Parse(val)
+	limit, err := intstrutil.GetScaledValueFromIntOrPercent(&intOrPercent, nodeCount, false)
+	if err != nil || limit < 0 || (intOrPercent.Type == intstrutil.Int && limit == 0) {
+		return 0, fmt.Errorf("invalid %s annotation %q: must be a positive integer or percentage", ctrlcommon.MaxDisruptedNodesAnnotationKey, val)
+	}
+	// Like maxUnavailable, a percentage always allows at least one node.
+	if limit == 0 {
+		limit = 1
+	}
+	return limit, nil
+}
+
+// getDisruptedNodes returns the names of the nodes which are unavailable,
+// i.e. updating or not ready, regardless of their pool.
+func getDisruptedNodes(nodes []*corev1.Node) sets.Set[string] {
+	disrupted := sets.New[string]()
+	for _, node := range nodes {
+		if !isNodeManaged(node) {
+			continue
+		}
+		_, layered := node.Annotations[daemonconsts.DesiredImageAnnotationKey]
+		if isNodeUnavailable(node, layered) {
+			disrupted.Insert(node.Name)
+		}
+	}
+	return disrupted
+}
+
+// getUpdatePriority returns the update priority of a pool.
+func getUpdatePriority(pool *mcfgv1.MachineConfigPool) (int, error) {
+	val, ok := pool.Annotations[ctrlcommon.UpdatePriorityAnnotationKey]
+	if !ok {
+		return 0, nil
+	}
+	priority, err := strconv.Atoi(val)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s annotation %q: must be an integer", ctrlcommon.UpdatePriorityAnnotationKey, val)
+	}
+	return priority, nil
+}
+
+// getPoolUpdateHold returns why the update of a pool is held until an admin
+// intervenes, or an empty string if it is not. A pool rolled back
+// automatically is held by its degraded nodes.
+func getPoolUpdateHold(pool *mcfgv1.MachineConfigPool) string {
+	conditions := pool.Status.Conditions
+	if apihelpers.IsMachineConfigPoolConditionTrue(conditions, mcfgv1.MachineConfigPoolDegraded) ||
+		apihelpers.IsMachineConfigPoolConditionTrue(conditions, mcfgv1.MachineConfigPoolNodeDegraded) {
+		return "degraded"
+	}
+	if canary := apihelpers.GetMachineConfigPoolCondition(pool.Status, ctrlcommon.MachineConfigPoolCanaryRollout); canary != nil &&
+		canary.Status == corev1.ConditionTrue && (canary.Reason == canaryReasonFailed || canary.Reason == canaryReasonInvalidConfig) {
+		return "canary rollout halted"
+	}
+	if apihelpers.IsMachineConfigPoolConditionTrue(conditions, ctrlcommon.MachineConfigPoolWaitingForMaintenanceWindow) {
+		return "waiting for its maintenance window"
+	}
+	return ""
+}
+
+// getHigherPriorityUpdatingPools returns the unpaused pools with a higher update
+// priority than the given one which are still updating, sorted by name. Pools
+// whose update is held, e.g. because they are degraded, are skipped, as they
+// would block lower priority pools indefinitely.
+func getHigherPriorityUpdatingPools(pool *mcfgv1.MachineConfigPool, pools []*mcfgv1.MachineConfigPool) []*mcfgv1.MachineConfigPool {
+	// Invalid priorities are reported by the sync of their own pool.
+	priority, _ := getUpdatePriority(pool)
+
+	higher := []*mcfgv1.MachineConfigPool{}
+	for _, other := range pools {
+		if other.Name == pool.Name || other.Spec.Paused {
+			continue
+		}
+		otherPriority, _ := getUpdatePriority(other)
+		if otherPriority <= priority || !apihelpers.IsMachineConfigPoolConditionTrue(other.Status.Conditions, mcfgv1.MachineConfigPoolUpdating) {
+			continue
+		}
+		if hold := getPoolUpdateHold(other); hold != "" {
+			klog.V(4).Infof("Pool %s: not waiting for higher priority pool %s: %s", pool.Name, other.Name, hold)
+			continue
+		}
+		higher = append(higher, other)
+	}
+	sort.Slice(higher, func(i, j int) bool { return higher[i].Name < higher[j].Name })
+	return higher
+}
+
+// describeUpdatingPools lists the pools with their update progress.
+func describeUpdatingPools(pools []*mcfgv1.MachineConfigPool) string {
+	descriptions := make([]string, 0, len(pools))
+	for _, pool := range pools {
+		descriptions = append(descriptions, fmt.Sprintf("%s (%d of %d nodes updated)", pool.Name, pool.Status.UpdatedMachineCount, pool.Status.MachineCount))
+	}
+	return strings.Join(descriptions, ", ")
+}
+
+// limitByDisruptionBudget caps the capacity of a pool by the cluster-wide
+// disruption budget, if any, and records whether the pool is waiting for it.
+// It must be called with ctrl.disruptionBudget.mu held, and the budget must be
+// held until the returned capacity is used.
+func (ctrl *Controller) limitByDisruptionBudget(pool *mcfgv1.MachineConfigPool, candidates []*corev1.Node, capacity uint) (uint, error) {
+	budget := &ctrl.disruptionBudget
+
+	mcop, err := ctrl.mcopLister.Get(ctrlcommon.MCOOperatorKnobsObjectName)
+	if err != nil && !apierrors.IsNotFound(err) {
+		return 0, err
+	}
+	if apierrors.IsNotFound(err) {
+		mcop = nil
+	}
+
+	allNodes, err := ctrl.nodeLister.List(labels.Everything())
+	if err != nil {
+		return 0, err
+	}
+
+	limit, err := getMaxDisruptedNodes(mcop, len(allNodes))
+	if err != nil {
+		ctrl.reportInvalidConfig(pool, "InvalidMaxDisruptedNodes", "Ignoring cluster disruption budget: %v", err)
+	} else {
+		ctrl.invalidConfigs.Clear(pool, "InvalidMaxDisruptedNodes")
+	}
+	if limit == 0 {
+		budget.setPoolCondition(pool.Name, nil)
+		return capacity, nil
+	}
+
+	if _, err := getUpdatePriority(pool); err != nil {
+		ctrl.reportInvalidConfig(pool, "InvalidUpdatePriority", "Using the default update priority: %v", err)
+	} else {
+		ctrl.invalidConfigs.Clear(pool, "InvalidUpdatePriority")
+	}
+
+	available := budget.available(limit, getDisruptedNodes(allNodes), time.Now())
+	if len(candidates) == 0 {
+		budget.setPoolCondition(pool.Name, apihelpers.NewMachineConfigPoolCondition(ctrlcommon.MachineConfigPoolWaitingForDisruptionBudget, corev1.ConditionFalse, disruptionBudgetReasonAvailable, ""))
+		return capacity, nil
+	}
+
+	var reason, message string
+	pools, err := ctrl.mcpLister.List(labels.Everything())
+	if err != nil {
+		return 0, err
+	}
+	if higher := getHigherPriorityUpdatingPools(pool, pools); len(higher) > 0 {
+		capacity = 0
+		reason = disruptionBudgetReasonPriority
+		message = fmt.Sprintf("Waiting for pools with a higher update priority to finish updating: %s", describeUpdatingPools(higher))
+	} else if available == 0 {
+		capacity = 0
+		reason = disruptionBudgetReasonExhausted
+		message = fmt.Sprintf("Waiting for the cluster disruption budget: %d of %d nodes are already disrupted", limit-available, limit)
+	} else if uint(available) < capacity {
+		capacity = uint(available)
+	}
+
+	if capacity == 0 {
+		ctrl.logPool(pool, "Not updating %d candidate nodes: %s", len(candidates), message)
+		ctrl.enqueueAfter(pool, disruptionBudgetRequeueDelay)
+		budget.setPoolCondition(pool.Name, apihelpers.NewMachineConfigPoolCondition(ctrlcommon.MachineConfigPoolWaitingForDisruptionBudget, corev1.ConditionTrue, reason, message))
+		return 0, nil
+	}
+
+	budget.setPoolCondition(pool.Name, apihelpers.NewMachineConfigPoolCondition(ctrlcommon.MachineConfigPoolWaitingForDisruptionBudget, corev1.ConditionFalse, disruptionBudgetReasonAvailable, ""))
+	return capacity, nil
+}
+
+// setDisruptionBudgetCondition records the WaitingForDisruptionBudget condition
+// determined during the last sync of the pool on its status.
+func setDisruptionBudgetCondition(status *mcfgv1.MachineConfigPoolStatus, cond *mcfgv1.MachineConfigPoolCondition) {
+	if cond == nil {
+		apihelpers.RemoveMachineConfigPoolCondition(status, ctrlcommon.MachineConfigPoolWaitingForDisruptionBudget)
+		return
+	}
+	apihelpers.SetMachineConfigPoolCondition(status, *cond)
+}
@@ -0,0 +1,156 @@
+package node
+
+import (
+	"testing"
+	"time"
+
+	mcfgv1 "github.com/openshift/api/machineconfiguration/v1"
+	opv1 "github.com/openshift/api/operator/v1"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/sets"
+
+	"github.com/openshift/machine-config-operator/pkg/apihelpers"
+	ctrlcommon "github.com/openshift/machine-config-operator/pkg/controller/common"
+	daemonconsts "github.com/openshift/machine-config-operator/pkg/daemon/constants"
+	"github.com/openshift/machine-config-operator/test/helpers"
+)
+
+func TestGetMaxDisruptedNodes(t *testing.T) {
+	tests := []struct {
+		name        string
+		annotations map[string]string
+		nilMCOP     bool
+		expected    int
+		expectErr   bool
+	}{{
+		name:    "no MachineConfiguration",
+		nilMCOP: true,
+	}, {
+		name: "not set",
+	}, {
+		name:        "integer",
+		annotations: map[string]string{ctrlcommon.MaxDisruptedNodesAnnotationKey: "3"},
+		expected:    3,
+	}, {
+		name:        "percentage",
+		annotations: map[string]string{ctrlcommon.MaxDisruptedNodesAnnotationKey: "25%"},
+		expected:    5,
+	}, {
+		name:        "percentage rounds down to at least one node",
+		annotations: map[string]string{ctrlcommon.MaxDisruptedNodesAnnotationKey: "1%"},
+		expected:    1,
+	}, {
+		name:        "zero",
+		annotations: map[string]string{ctrlcommon.MaxDisruptedNodesAnnotationKey: "0"},
+		expectErr:   true,
+	}, {
+		name:        "negative",
+		annotations: map[string]string{ctrlcommon.MaxDisruptedNodesAnnotationKey: "-1"},
+		expectErr:   true,
+	}, {
+		name:        "garbage",
+		annotations: map[string]string{ctrlcommon.MaxDisruptedNodesAnnotationKey: "many"},
+		expectErr:   true,
+	}}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var mcop *opv1.MachineConfiguration
+			if !test.nilMCOP {
+				mcop = &opv1.MachineConfiguration{ObjectMeta: metav1.ObjectMeta{Name: ctrlcommon.MCOOperatorKnobsObjectName, Annotations: test.annotations}}
+			}
+
+			limit, err := getMaxDisruptedNodes(mcop, 20)
+			if test.expectErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, test.expected, limit)
+		})
+	}
+}
+
+func TestGetDisruptedNodes(t *testing.T) {
+	nodes := []*corev1.Node{
+		newNodeWithReadyAndDaemonState("node-0", machineConfigV0, machineConfigV0, corev1.ConditionTrue, daemonconsts.MachineConfigDaemonStateDone),
+		newNodeWithReadyAndDaemonState("node-1", machineConfigV0, machineConfigV1, corev1.ConditionTrue, daemonconsts.MachineConfigDaemonStateWorking),
+		newNodeWithReadyAndDaemonState("node-2", machineConfigV0, machineConfigV0, corev1.ConditionFalse, daemonconsts.MachineConfigDaemonStateDone),
+		newNodeWithReadyAndDaemonState("node-3", machineConfigV0, machineConfigV1, corev1.ConditionTrue, daemonconsts.MachineConfigDaemonStateDegraded),
+		// Unmanaged nodes are not counted.
+		newNodeWithReady("node-4", "", "", corev1.ConditionFalse),
+	}
+
+	assert.Equal(t, sets.New("node-1", "node-2"), getDisruptedNodes(nodes))
+}
+
+func TestClusterDisruptionBudgetAvailable(t *testing.T) {
+	now := time.Now()
+
+	b := clusterDisruptionBudget{}
+	assert.Equal(t, 2, b.available(3, sets.New("node-0"), now))
+
+	// A reserved node counts until the node lister shows it disrupted.
+	b.reserve("node-1", now)
+	assert.Equal(t, 1, b.available(3, sets.New("node-0"), now))
+	assert.Equal(t, 1, b.available(3, sets.New("node-0", "node-1"), now))
+	assert.Empty(t, b.reserved)
+
+	// Reservations expire if the node lister never shows the node disrupted.
+	b.reserve("node-2", now)
+	assert.Equal(t, 0, b.available(2, sets.New("node-0"), now))
+	assert.Equal(t, 1, b.available(2, sets.New("node-0"), now.Add(2*disruptionReservationTimeout)))
+
+	// The available budget never goes negative.
+	assert.Equal(t, 0, b.available(1, sets.New("node-0", "node-1"), now))
+}
+
+func TestGetHigherPriorityUpdatingPools(t *testing.T) {
+	newPool := func(name, priority string, updating, paused bool) *mcfgv1.MachineConfigPool {
+		pool := helpers.NewMachineConfigPool(name, nil, helpers.WorkerSelector, machineConfigV0)
+		if priority != "" {
+			pool.Annotations = map[string]string{ctrlcommon.UpdatePriorityAnnotationKey: priority}
+		}
+		pool.Spec.Paused = paused
+		if updating {
+			apihelpers.SetMachineConfigPoolCondition(&pool.Status, *apihelpers.NewMachineConfigPoolCondition(mcfgv1.MachineConfigPoolUpdating, corev1.ConditionTrue, "", ""))
+		}
+		return pool
+	}
+
+	withCondition := func(pool *mcfgv1.MachineConfigPool, condType mcfgv1.MachineConfigPoolConditionType, reason string) *mcfgv1.MachineConfigPool {
+		apihelpers.SetMachineConfigPoolCondition(&pool.Status, *apihelpers.NewMachineConfigPoolCondition(condType, corev1.ConditionTrue, reason, ""))
+		return pool
+	}
+	names := func(pools []*mcfgv1.MachineConfigPool) []string {
+		names := []string{}
+		for _, pool := range pools {
+			names = append(names, pool.Name)
+		}
+		return names
+	}
+
+	worker := newPool("worker", "", true, false)
+	pools := []*mcfgv1.MachineConfigPool{
+		worker,
+		newPool("infra", "10", true, false),
+		newPool("db", "5", true, false),
+		newPool("gpu", "10", false, false),
+		newPool("edge", "10", true, true),
+		newPool("batch", "-5", true, false),
+		withCondition(newPool("degraded", "10", true, false), mcfgv1.MachineConfigPoolNodeDegraded, ""),
+		withCondition(newPool("canary", "10", true, false), ctrlcommon.MachineConfigPoolCanaryRollout, canaryReasonFailed),
+		withCondition(newPool("soaking", "10", true, false), ctrlcommon.MachineConfigPoolCanaryRollout, canaryReasonSoaking),
+		withCondition(newPool("window", "10", true, false), ctrlcommon.MachineConfigPoolWaitingForMaintenanceWindow, maintenanceWindowReasonClosed),
+	}
+
+	assert.Equal(t, []string{"db", "infra", "soaking"}, names(getHigherPriorityUpdatingPools(worker, pools)))
+	assert.Equal(t, []string{}, names(getHigherPriorityUpdatingPools(pools[1], pools)))
+	assert.Equal(t, []string{"db", "infra", "soaking", "worker"}, names(getHigherPriorityUpdatingPools(pools[5], pools)))
+
+	pools[1].Status.MachineCount = 5
+	pools[1].Status.UpdatedMachineCount = 2
+	assert.Equal(t, "db (0 of 0 nodes updated), infra (2 of 5 nodes updated)", describeUpdatingPools([]*mcfgv1.MachineConfigPool{pools[2], pools[1]}))
+}
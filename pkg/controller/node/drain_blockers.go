@@ -0,0 +1,50 @@
+package node
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	mcfgv1 "github.com/openshift/api/machineconfiguration/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/klog/v2"
+
+	"github.com/openshift/machine-config-operator/pkg/apihelpers"
+	ctrlcommon "github.com/openshift/machine-config-operator/pkg/controller/common"
+)
+
+// setDrainBlockedCondition aggregates the drain blockers reported by the drain
+// controller on the nodes of the pool into the NodeDrainBlocked condition. The
+// condition is removed once no node of the pool is blocked.
+func setDrainBlockedCondition(status *mcfgv1.MachineConfigPoolStatus, nodes []*corev1.Node) {
+	blocked := []string{}
+	reasons := map[string]int{}
+	for _, node := range nodes {
+		report, err := ctrlcommon.ParseDrainBlockerReport(node.Annotations[ctrlcommon.DrainBlockersAnnotationKey])
+		if err != nil {
+			klog.Warningf("node %s: %v", node.Name, err)
+			continue
+		}
+		if report == nil {
+			continue
+		}
+		blocked = append(blocked, fmt.Sprintf("%s: %s", node.Name, report.Summary()))
+		reasons[report.Reason()]++
+	}
+
+	if len(blocked) == 0 {
+		apihelpers.RemoveMachineConfigPoolCondition(status, ctrlcommon.MachineConfigPoolNodeDrainBlocked)
+		return
+	}
+
+	sort.Strings(blocked)
+	reason := ""
+	for r, count := range reasons {
+		if count > reasons[reason] || (count == reasons[reason] && r < reason) {
+			reason = r
+		}
+	}
+	message := fmt.Sprintf("%d nodes cannot be drained. %s", len(blocked), strings.Join(blocked, ". "))
+	cond := apihelpers.NewMachineConfigPoolCondition(ctrlcommon.MachineConfigPoolNodeDrainBlocked, corev1.ConditionTrue, reason, message)
+	apihelpers.SetMachineConfigPoolCondition(status, *cond)
+}
@@ -0,0 +1,46 @@
+package node
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	mcfgv1 "github.com/openshift/api/machineconfiguration/v1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/openshift/machine-config-operator/pkg/apihelpers"
+	ctrlcommon "github.com/openshift/machine-config-operator/pkg/controller/common"
+)
+
+func TestSetDrainBlockedCondition(t *testing.T) {
+	since := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	report, err := json.Marshal(ctrlcommon.NewDrainBlockerReport(since, []ctrlcommon.DrainBlocker{
+		{Namespace: "db", Name: "postgres-0", Reason: ctrlcommon.DrainBlockerReasonPodDisruptionBudget, PodDisruptionBudget: "postgres"},
+	}))
+	require.NoError(t, err)
+
+	newNode := func(name, report string) *corev1.Node {
+		node := &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: name, Annotations: map[string]string{}}}
+		if report != "" {
+			node.Annotations[ctrlcommon.DrainBlockersAnnotationKey] = report
+		}
+		return node
+	}
+
+	status := &mcfgv1.MachineConfigPoolStatus{}
+	setDrainBlockedCondition(status, []*corev1.Node{newNode("node-0", ""), newNode("node-1", "")})
+	assert.Nil(t, apihelpers.GetMachineConfigPoolCondition(*status, ctrlcommon.MachineConfigPoolNodeDrainBlocked))
+
+	setDrainBlockedCondition(status, []*corev1.Node{newNode("node-0", ""), newNode("node-1", string(report)), newNode("node-2", "invalid")})
+	cond := apihelpers.GetMachineConfigPoolCondition(*status, ctrlcommon.MachineConfigPoolNodeDrainBlocked)
+	require.NotNil(t, cond)
+	assert.Equal(t, corev1.ConditionTrue, cond.Status)
+	assert.Equal(t, ctrlcommon.DrainBlockerReasonPodDisruptionBudget, cond.Reason)
+	assert.Equal(t, "1 nodes cannot be drained. node-1: Drain failing since 2024-05-01T10:00:00Z, 1 pods cannot be evicted: db/postgres-0: PodDisruptionBudget postgres", cond.Message)
+
+	setDrainBlockedCondition(status, []*corev1.Node{newNode("node-0", ""), newNode("node-1", "")})
+	assert.Nil(t, apihelpers.GetMachineConfigPoolCondition(*status, ctrlcommon.MachineConfigPoolNodeDrainBlocked))
+}
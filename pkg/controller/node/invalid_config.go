@@ -0,0 +1,11 @@
+package node
+
+import (
+	mcfgv1 "github.com/openshift/api/machineconfiguration/v1"
+)
+
+// reportInvalidConfig emits a Warning event for an invalid setting of a pool,
+// unless it was already reported.
+func (ctrl *Controller) reportInvalidConfig(pool *mcfgv1.MachineConfigPool, reason, messageFmt string, args ...interface{}) {
+	ctrl.invalidConfigs.Report(ctrl.eventRecorder, pool, reason, messageFmt, args...)
+}
@@ -0,0 +1,151 @@
+package node
+
+import (
+	"fmt"
+	"time"
+
+	mcfgv1 "github.com/openshift/api/machineconfiguration/v1"
+	"github.com/robfig/cron"
+	corev1 "k8s.io/api/core/v1"
+
+	"github.com/openshift/machine-config-operator/pkg/apihelpers"
+	ctrlcommon "github.com/openshift/machine-config-operator/pkg/controller/common"
+)
+
+// Reasons of the WaitingForMaintenanceWindow pool condition.
+const (
+	maintenanceWindowReasonInvalid = "InvalidMaintenanceWindow"
+	maintenanceWindowReasonOpen    = "MaintenanceWindowOpen"
+	maintenanceWindowReasonClosed  = "OutsideMaintenanceWindow"
+)
+
+type maintenanceWindowConfig struct {
+	schedule *cron.SpecSchedule
+	duration time.Duration
+	location *time.Location
+}
+
+// getMaintenanceWindowConfig reads the maintenance window settings of a pool.
+// It returns nil if the pool has no maintenance windows.
+func getMaintenanceWindowConfig(pool *mcfgv1.MachineConfigPool) (*maintenanceWindowConfig, error) {
+	scheduleVal, ok := pool.Annotations[ctrlcommon.MaintenanceWindowScheduleAnnotationKey]
+	if !ok {
+		return nil, nil
+	}
+
+	schedule, err := cron.ParseStandard(scheduleVal)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s annotation %q: %w", ctrlcommon.MaintenanceWindowScheduleAnnotationKey, scheduleVal, err)
+	}
+	// Descriptors like "@every 1h" are relative to the time they are evaluated
+	// at, so they cannot describe the start of a window.
+	spec, ok := schedule.(*cron.SpecSchedule)
+	if !ok {
+		return nil, fmt.Errorf("invalid %s annotation %q: must be a cron schedule", ctrlcommon.MaintenanceWindowScheduleAnnotationKey, scheduleVal)
+	}
+
+	durationVal := pool.Annotations[ctrlcommon.MaintenanceWindowDurationAnnotationKey]
+	duration, err := time.ParseDuration(durationVal)
+	if err != nil || duration <= 0 {
+		return nil, fmt.Errorf("invalid %s annotation %q: must be a positive duration", ctrlcommon.MaintenanceWindowDurationAnnotationKey, durationVal)
+	}
+
+	location := time.UTC
+	if tz, ok := pool.Annotations[ctrlcommon.MaintenanceWindowTimezoneAnnotationKey]; ok {
+		location, err = time.LoadLocation(tz)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s annotation %q: %w", ctrlcommon.MaintenanceWindowTimezoneAnnotationKey, tz, err)
+		}
+	}
+
+	return &maintenanceWindowConfig{schedule: spec, duration: duration, location: location}, nil
+}
+
+// maintenanceWindowState is the state of the maintenance windows of a pool at
+// a given time.
+type maintenanceWindowState struct {
+	open bool
+	// nextChange is the end of the current window if open, or the start of
+	// the next window otherwise. It is zero if there is no upcoming window.
+	nextChange time.Time
+	err        error
+}
+
+// evaluateMaintenanceWindow determines whether a maintenance window of the pool
+// is open at the given time. It returns nil if the pool has no maintenance
+// windows.
+func evaluateMaintenanceWindow(pool *mcfgv1.MachineConfigPool, now time.Time) *maintenanceWindowState {
+	cfg, err := getMaintenanceWindowConfig(pool)
+	if err != nil {
+		return &maintenanceWindowState{err: err}
+	}
+	if cfg == nil {
+		return nil
+	}
+
+	// The first window starting after now-duration is either open, or the
+	// next one to open.
+	now = now.In(cfg.location)
+	start := cfg.schedule.Next(now.Add(-cfg.duration))
+	if start.IsZero() {
+		return &maintenanceWindowState{}
+	}
+	if !start.After(now) {
+		return &maintenanceWindowState{open: true, nextChange: start.Add(cfg.duration)}
+	}
+	return &maintenanceWindowState{nextChange: start}
+}
+
+// requeueAfter returns how long until the window opens or closes.
+func (s *maintenanceWindowState) requeueAfter(now time.Time) time.Duration {
+	if s.nextChange.IsZero() {
+		return 0
+	}
+	return s.nextChange.Sub(now)
+}
+
+// setMaintenanceWindowCondition records on the pool status whether the update of
+// nodes, if any, is waiting for the next maintenance window.
+func setMaintenanceWindowCondition(status *mcfgv1.MachineConfigPoolStatus, state *maintenanceWindowState, pending bool) {
+	if state == nil {
+		apihelpers.RemoveMachineConfigPoolCondition(status, ctrlcommon.MachineConfigPoolWaitingForMaintenanceWindow)
+		return
+	}
+
+	var reason, message string
+	switch {
+	case state.err != nil:
+		reason = maintenanceWindowReasonInvalid
+		message = fmt.Sprintf("Not updating any nodes: %v", state.err)
+	case state.open:
+		reason = maintenanceWindowReasonOpen
+		message = fmt.Sprintf("Maintenance window is open until %s", state.nextChange.Format(time.RFC3339))
+	case state.nextChange.IsZero():
+		reason = maintenanceWindowReasonClosed
+		message = "The maintenance window schedule has no upcoming window"
+	case pending:
+		reason = maintenanceWindowReasonClosed
+		message = fmt.Sprintf("Waiting for maintenance window starting at %s", state.nextChange.Format(time.RFC3339))
+	default:
+		reason = maintenanceWindowReasonClosed
+		message = fmt.Sprintf("Next maintenance window starts at %s", state.nextChange.Format(time.RFC3339))
+	}
+
+	condStatus := corev1.ConditionFalse
+	if pending && !state.open {
+		condStatus = corev1.ConditionTrue
+	}
+	cond := apihelpers.NewMachineConfigPoolCondition(ctrlcommon.MachineConfigPoolWaitingForMaintenanceWindow, condStatus, reason, message)
+	apihelpers.SetMachineConfigPoolCondition(status, *cond)
+}
+
+// hasNodesToUpdate returns true if any node of the pool is not targeting the
+// pool's desired config yet.
+func hasNodesToUpdate(pool *mcfgv1.MachineConfigPool, nodes []*corev1.Node, layered bool, mosc *mcfgv1.MachineOSConfig, mosb *mcfgv1.MachineOSBuild) bool {
+	for _, node := range nodes {
+		if !isNodeTargetingPool(node, pool, layered, mosc, mosb) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,178 @@
+package node
+
+import (
+	"testing"
+	"time"
+
+	mcfgv1 "github.com/openshift/api/machineconfiguration/v1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+
+	"github.com/openshift/machine-config-operator/pkg/apihelpers"
+	ctrlcommon "github.com/openshift/machine-config-operator/pkg/controller/common"
+	"github.com/openshift/machine-config-operator/test/helpers"
+)
+
+func TestEvaluateMaintenanceWindow(t *testing.T) {
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	require.NoError(t, err)
+
+	// Saturday, 2024-06-01 01:30 in Berlin.
+	saturday := time.Date(2024, time.June, 1, 1, 30, 0, 0, berlin)
+
+	tests := []struct {
+		name         string
+		annotations  map[string]string
+		now          time.Time
+		expectNil    bool
+		expectErr    bool
+		expectOpen   bool
+		expectChange time.Time
+	}{{
+		name:      "no maintenance window",
+		now:       saturday,
+		expectNil: true,
+	}, {
+		name: "before the window",
+		annotations: map[string]string{
+			ctrlcommon.MaintenanceWindowScheduleAnnotationKey: "0 2 * * 6",
+			ctrlcommon.MaintenanceWindowDurationAnnotationKey: "4h",
+			ctrlcommon.MaintenanceWindowTimezoneAnnotationKey: "Europe/Berlin",
+		},
+		now:          saturday,
+		expectChange: time.Date(2024, time.June, 1, 2, 0, 0, 0, berlin),
+	}, {
+		name: "inside the window",
+		annotations: map[string]string{
+			ctrlcommon.MaintenanceWindowScheduleAnnotationKey: "0 2 * * 6",
+			ctrlcommon.MaintenanceWindowDurationAnnotationKey: "4h",
+			ctrlcommon.MaintenanceWindowTimezoneAnnotationKey: "Europe/Berlin",
+		},
+		now:          saturday.Add(3 * time.Hour),
+		expectOpen:   true,
+		expectChange: time.Date(2024, time.June, 1, 6, 0, 0, 0, berlin),
+	}, {
+		name: "after the window",
+		annotations: map[string]string{
+			ctrlcommon.MaintenanceWindowScheduleAnnotationKey: "0 2 * * 6",
+			ctrlcommon.MaintenanceWindowDurationAnnotationKey: "4h",
+			ctrlcommon.MaintenanceWindowTimezoneAnnotationKey: "Europe/Berlin",
+		},
+		now:          saturday.Add(5 * time.Hour),
+		expectChange: time.Date(2024, time.June, 8, 2, 0, 0, 0, berlin),
+	}, {
+		name: "schedule defaults to UTC",
+		annotations: map[string]string{
+			ctrlcommon.MaintenanceWindowScheduleAnnotationKey: "0 2 * * 6",
+			ctrlcommon.MaintenanceWindowDurationAnnotationKey: "1h",
+		},
+		// 01:30 in Berlin is 23:30 UTC on Friday.
+		now:          saturday,
+		expectChange: time.Date(2024, time.June, 1, 2, 0, 0, 0, time.UTC),
+	}, {
+		name: "missing duration",
+		annotations: map[string]string{
+			ctrlcommon.MaintenanceWindowScheduleAnnotationKey: "0 2 * * 6",
+		},
+		now:       saturday,
+		expectErr: true,
+	}, {
+		name: "invalid schedule",
+		annotations: map[string]string{
+			ctrlcommon.MaintenanceWindowScheduleAnnotationKey: "every saturday",
+			ctrlcommon.MaintenanceWindowDurationAnnotationKey: "4h",
+		},
+		now:       saturday,
+		expectErr: true,
+	}, {
+		name: "relative schedule",
+		annotations: map[string]string{
+			ctrlcommon.MaintenanceWindowScheduleAnnotationKey: "@every 1h",
+			ctrlcommon.MaintenanceWindowDurationAnnotationKey: "4h",
+		},
+		now:       saturday,
+		expectErr: true,
+	}, {
+		name: "invalid timezone",
+		annotations: map[string]string{
+			ctrlcommon.MaintenanceWindowScheduleAnnotationKey: "0 2 * * 6",
+			ctrlcommon.MaintenanceWindowDurationAnnotationKey: "4h",
+			ctrlcommon.MaintenanceWindowTimezoneAnnotationKey: "Mars/Olympus_Mons",
+		},
+		now:       saturday,
+		expectErr: true,
+	}}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			pool := helpers.NewMachineConfigPool("worker", nil, helpers.WorkerSelector, machineConfigV1)
+			pool.Annotations = test.annotations
+
+			state := evaluateMaintenanceWindow(pool, test.now)
+			if test.expectNil {
+				assert.Nil(t, state)
+				return
+			}
+			require.NotNil(t, state)
+			if test.expectErr {
+				assert.Error(t, state.err)
+				assert.False(t, state.open)
+				return
+			}
+			require.NoError(t, state.err)
+			assert.Equal(t, test.expectOpen, state.open)
+			assert.True(t, test.expectChange.Equal(state.nextChange), "expected %s, got %s", test.expectChange, state.nextChange)
+			assert.Equal(t, test.expectChange.Sub(test.now), state.requeueAfter(test.now))
+		})
+	}
+}
+
+func TestSetMaintenanceWindowCondition(t *testing.T) {
+	nextStart := time.Date(2024, time.June, 1, 2, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name          string
+		state         *maintenanceWindowState
+		pending       bool
+		expectStatus  corev1.ConditionStatus
+		expectReason  string
+		expectMessage string
+	}{{
+		name:          "waiting for window",
+		state:         &maintenanceWindowState{nextChange: nextStart},
+		pending:       true,
+		expectStatus:  corev1.ConditionTrue,
+		expectReason:  maintenanceWindowReasonClosed,
+		expectMessage: "Waiting for maintenance window starting at 2024-06-01T02:00:00Z",
+	}, {
+		name:          "nothing to update",
+		state:         &maintenanceWindowState{nextChange: nextStart},
+		expectStatus:  corev1.ConditionFalse,
+		expectReason:  maintenanceWindowReasonClosed,
+		expectMessage: "Next maintenance window starts at 2024-06-01T02:00:00Z",
+	}, {
+		name:          "window open",
+		state:         &maintenanceWindowState{open: true, nextChange: nextStart},
+		pending:       true,
+		expectStatus:  corev1.ConditionFalse,
+		expectReason:  maintenanceWindowReasonOpen,
+		expectMessage: "Maintenance window is open until 2024-06-01T02:00:00Z",
+	}}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			status := &mcfgv1.MachineConfigPoolStatus{}
+			setMaintenanceWindowCondition(status, test.state, test.pending)
+
+			cond := apihelpers.GetMachineConfigPoolCondition(*status, ctrlcommon.MachineConfigPoolWaitingForMaintenanceWindow)
+			require.NotNil(t, cond)
+			assert.Equal(t, test.expectStatus, cond.Status)
+			assert.Equal(t, test.expectReason, cond.Reason)
+			assert.Equal(t, test.expectMessage, cond.Message)
+
+			setMaintenanceWindowCondition(status, nil, test.pending)
+			assert.Nil(t, apihelpers.GetMachineConfigPoolCondition(*status, ctrlcommon.MachineConfigPoolWaitingForMaintenanceWindow))
+		})
+	}
+}
@@ -14,6 +14,7 @@ import (
 	configv1 "github.com/openshift/api/config/v1"
 	features "github.com/openshift/api/features"
 	mcfgv1 "github.com/openshift/api/machineconfiguration/v1"
+	opv1 "github.com/openshift/api/operator/v1"
 
 	cligoinformersv1 "github.com/openshift/client-go/config/informers/externalversions/config/v1"
 	cligolistersv1 "github.com/openshift/client-go/config/listers/config/v1"
@@ -22,6 +23,8 @@ import (
 	mcfginformersv1 "github.com/openshift/client-go/machineconfiguration/informers/externalversions/machineconfiguration/v1"
 
 	mcfglistersv1 "github.com/openshift/client-go/machineconfiguration/listers/machineconfiguration/v1"
+	mcopinformersv1 "github.com/openshift/client-go/operator/informers/externalversions/operator/v1"
+	mcoplistersv1 "github.com/openshift/client-go/operator/listers/operator/v1"
 	"github.com/openshift/library-go/pkg/operator/v1helpers"
 	"github.com/openshift/machine-config-operator/internal"
 	"github.com/openshift/machine-config-operator/pkg/apihelpers"
@@ -102,6 +105,14 @@ type Controller struct {
 	schedulerList         cligolistersv1.SchedulerLister
 	schedulerListerSynced cache.InformerSynced
 
+	mcopLister       mcoplistersv1.MachineConfigurationLister
+	mcopListerSynced cache.InformerSynced
+
+	// disruptionBudget limits the number of disrupted nodes across all pools.
+	disruptionBudget clusterDisruptionBudget
+	// invalidConfigs reports the invalid settings of pools once.
+	invalidConfigs ctrlcommon.InvalidConfigReporter
+
 	queue workqueue.TypedRateLimitingInterface[string]
 
 	fgAcessor featuregates.FeatureGateAccess
@@ -119,6 +130,7 @@ func New(
 	podInformer coreinformersv1.PodInformer,
 	moscInformer mcfginformersv1.MachineOSConfigInformer,
 	schedulerInformer cligoinformersv1.SchedulerInformer,
+	mcopInformer mcopinformersv1.MachineConfigurationInformer,
 	kubeClient clientset.Interface,
 	mcfgClient mcfgclientset.Interface,
 	fgAccessor featuregates.FeatureGateAccess,
@@ -131,6 +143,7 @@ func New(
 		nodeInformer,
 		podInformer,
 		schedulerInformer,
+		mcopInformer,
 		kubeClient,
 		mcfgClient,
 		defaultUpdateDelay,
@@ -146,6 +159,7 @@ func NewWithCustomUpdateDelay(
 	podInformer coreinformersv1.PodInformer,
 	moscInformer mcfginformersv1.MachineOSConfigInformer,
 	schedulerInformer cligoinformersv1.SchedulerInformer,
+	mcopInformer mcopinformersv1.MachineConfigurationInformer,
 	kubeClient clientset.Interface,
 	mcfgClient mcfgclientset.Interface,
 	updateDelay time.Duration,
@@ -159,6 +173,7 @@ func NewWithCustomUpdateDelay(
 		nodeInformer,
 		podInformer,
 		schedulerInformer,
+		mcopInformer,
 		kubeClient,
 		mcfgClient,
 		updateDelay,
@@ -175,6 +190,7 @@ func newController(
 	nodeInformer coreinformersv1.NodeInformer,
 	podInformer coreinformersv1.PodInformer,
 	schedulerInformer cligoinformersv1.SchedulerInformer,
+	mcopInformer mcopinformersv1.MachineConfigurationInformer,
 	kubeClient clientset.Interface,
 	mcfgClient mcfgclientset.Interface,
 	updateDelay time.Duration,
@@ -214,6 +230,10 @@ func newController(
 		UpdateFunc: ctrl.checkMasterNodesOnUpdate,
 		DeleteFunc: ctrl.checkMasterNodesOnDelete,
 	})
+	mcopInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    ctrl.addMachineConfiguration,
+		UpdateFunc: ctrl.updateMachineConfiguration,
+	})
 
 	ctrl.syncHandler = ctrl.syncMachineConfigPool
 	ctrl.enqueueMachineConfigPool = ctrl.enqueueDefault
@@ -232,6 +252,9 @@ func newController(
 	ctrl.schedulerList = schedulerInformer.Lister()
 	ctrl.schedulerListerSynced = schedulerInformer.Informer().HasSynced
 
+	ctrl.mcopLister = mcopInformer.Lister()
+	ctrl.mcopListerSynced = mcopInformer.Informer().HasSynced
+
 	return ctrl
 }
 
@@ -240,7 +263,7 @@ func (ctrl *Controller) Run(workers int, stopCh <-chan struct{}) {
 	defer utilruntime.HandleCrash()
 	defer ctrl.queue.ShutDown()
 
-	if !cache.WaitForCacheSync(stopCh, ctrl.ccListerSynced, ctrl.mcListerSynced, ctrl.mcpListerSynced, ctrl.nodeListerSynced, ctrl.schedulerListerSynced) {
+	if !cache.WaitForCacheSync(stopCh, ctrl.ccListerSynced, ctrl.mcListerSynced, ctrl.mcpListerSynced, ctrl.nodeListerSynced, ctrl.schedulerListerSynced, ctrl.mcopListerSynced) {
 		return
 	}
 
@@ -380,6 +403,41 @@ func (ctrl *Controller) makeMasterNodeSchedulable(node *corev1.Node) error {
 	return nil
 }
 
+func (ctrl *Controller) addMachineConfiguration(obj interface{}) {
+	mcop := obj.(*opv1.MachineConfiguration)
+	if mcop.Name != ctrlcommon.MCOOperatorKnobsObjectName {
+		return
+	}
+	klog.V(4).Infof("MachineConfiguration %s added", mcop.Name)
+	ctrl.enqueueAllMachineConfigPools()
+}
+
+func (ctrl *Controller) updateMachineConfiguration(old, cur interface{}) {
+	oldMcop := old.(*opv1.MachineConfiguration)
+	curMcop := cur.(*opv1.MachineConfiguration)
+	if curMcop.Name != ctrlcommon.MCOOperatorKnobsObjectName {
+		return
+	}
+
+	// Only the cluster disruption budget affects the node controller.
+	if oldMcop.Annotations[ctrlcommon.MaxDisruptedNodesAnnotationKey] == curMcop.Annotations[ctrlcommon.MaxDisruptedNodesAnnotationKey] {
+		return
+	}
+	klog.V(4).Infof("MachineConfiguration %s cluster disruption budget updated", curMcop.Name)
+	ctrl.enqueueAllMachineConfigPools()
+}
+
+func (ctrl *Controller) enqueueAllMachineConfigPools() {
+	pools, err := ctrl.mcpLister.List(labels.Everything())
+	if err != nil {
+		klog.Errorf("error listing machineconfigpools: %v", err)
+		return
+	}
+	for _, pool := range pools {
+		ctrl.enqueueMachineConfigPool(pool)
+	}
+}
+
 func (ctrl *Controller) addMachineOSConfig(obj interface{}) {
 	curMOSC := obj.(*mcfgv1.MachineOSConfig)
 	klog.V(4).Infof("Adding MachineOSConfig %s", curMOSC.Name)
@@ -696,6 +754,10 @@ func (ctrl *Controller) updateNode(old, cur interface{}) {
 			ctrl.logPoolNode(pool, curNode, "changed taints")
 			changed = true
 		}
+		if hasNodeAnnotationChanged(oldNode, curNode, ctrlcommon.DrainBlockersAnnotationKey) {
+			ctrl.logPoolNode(pool, curNode, "changed drain blockers")
+			changed = true
+		}
 	}
 
 	pools, err := ctrl.getPoolsForNode(curNode)
@@ -1076,6 +1138,16 @@ func (ctrl *Controller) syncMachineConfigPool(key string) error {
 		return err
 	}
 
+	if !layered {
+		rolledBack, err := ctrl.autoRollbackPool(pool, nodes)
+		if err != nil {
+			return err
+		}
+		if rolledBack != nil {
+			return ctrl.syncStatusOnly(rolledBack)
+		}
+	}
+
 	maxunavail, err := maxUnavailable(pool, nodes)
 	if err != nil {
 		if syncErr := ctrl.syncStatusOnly(pool); syncErr != nil {
@@ -1114,27 +1186,49 @@ func (ctrl *Controller) syncMachineConfigPool(key string) error {
 		}
 	}
 	candidates, capacity := getAllCandidateMachines(layered, mosc, mosb, pool, nodes, maxunavail)
-	if len(candidates) > 0 {
-		zones := make(map[string]bool)
-		for _, candidate := range candidates {
-			zone, ok := candidate.Labels[zoneLabel]
-			if ok {
-				zones[zone] = true
-			}
-		}
-		ctrl.logPool(pool, "%d candidate nodes in %d zones for update, capacity: %d", len(candidates), len(zones), capacity)
-		if err := ctrl.updateCandidateMachines(layered, mosc, mosb, pool, candidates, capacity); err != nil {
-			if syncErr := ctrl.syncStatusOnly(pool); syncErr != nil {
-				errs := kubeErrs.NewAggregate([]error{syncErr, err})
-				return fmt.Errorf("error setting annotations for pool %q, sync error: %w", pool.Name, errs)
-			}
-			return err
+	candidates = ctrl.filterMaintenanceWindowCandidates(pool, candidates)
+	candidates = ctrl.filterCanaryCandidates(pool, nodes, candidates, layered, mosc, mosb)
+	candidates = ctrl.filterTopologyCandidates(pool, nodes, candidates, layered, mosb)
+	if err := ctrl.updateCandidateMachinesWithinBudget(layered, mosc, mosb, pool, candidates, capacity); err != nil {
+		if syncErr := ctrl.syncStatusOnly(pool); syncErr != nil {
+			errs := kubeErrs.NewAggregate([]error{syncErr, err})
+			return fmt.Errorf("error setting annotations for pool %q, sync error: %w", pool.Name, errs)
 		}
-		ctrlcommon.UpdateStateMetric(ctrlcommon.MCCSubControllerState, "machine-config-controller-node", "Sync Machine Config Pool", pool.Name)
+		return err
 	}
 	return ctrl.syncStatusOnly(pool)
 }
 
+// updateCandidateMachinesWithinBudget updates the candidate machines, holding
+// the cluster disruption budget so that pools synced by other workers cannot
+// use the same budget.
+func (ctrl *Controller) updateCandidateMachinesWithinBudget(layered bool, mosc *mcfgv1.MachineOSConfig, mosb *mcfgv1.MachineOSBuild, pool *mcfgv1.MachineConfigPool, candidates []*corev1.Node, capacity uint) error {
+	ctrl.disruptionBudget.mu.Lock()
+	defer ctrl.disruptionBudget.mu.Unlock()
+
+	capacity, err := ctrl.limitByDisruptionBudget(pool, candidates, capacity)
+	if err != nil {
+		return err
+	}
+	if len(candidates) == 0 || capacity == 0 {
+		return nil
+	}
+
+	zones := make(map[string]bool)
+	for _, candidate := range candidates {
+		zone, ok := candidate.Labels[zoneLabel]
+		if ok {
+			zones[zone] = true
+		}
+	}
+	ctrl.logPool(pool, "%d candidate nodes in %d zones for update, capacity: %d", len(candidates), len(zones), capacity)
+	if err := ctrl.updateCandidateMachines(layered, mosc, mosb, pool, candidates, capacity); err != nil {
+		return err
+	}
+	ctrlcommon.UpdateStateMetric(ctrlcommon.MCCSubControllerState, "machine-config-controller-node", "Sync Machine Config Pool", pool.Name)
+	return nil
+}
+
 // checkIfNodeHasInProgressTaint checks if the given node has in progress taint
 func checkIfNodeHasInProgressTaint(node *corev1.Node) bool {
 	for _, taint := range node.Spec.Taints {
@@ -1317,6 +1411,88 @@ func getCandidateMachines(pool *mcfgv1.MachineConfigPool, config *mcfgv1.Machine
 	return nodes[:capacity]
 }
 
+// filterMaintenanceWindowCandidates drops all update candidates outside of the
+// pool's maintenance windows. Nodes already updating are left to finish.
+func (ctrl *Controller) filterMaintenanceWindowCandidates(pool *mcfgv1.MachineConfigPool, candidates []*corev1.Node) []*corev1.Node {
+	now := time.Now()
+	state := evaluateMaintenanceWindow(pool, now)
+	if state == nil || state.err == nil {
+		ctrl.invalidConfigs.Clear(pool, maintenanceWindowReasonInvalid)
+	}
+	if state == nil {
+		return candidates
+	}
+	if requeueAfter := state.requeueAfter(now); requeueAfter > 0 {
+		ctrl.enqueueAfter(pool, requeueAfter)
+	}
+	if len(candidates) == 0 || state.open {
+		return candidates
+	}
+
+	if state.err != nil {
+		ctrl.reportInvalidConfig(pool, maintenanceWindowReasonInvalid, "Not updating any nodes: %v", state.err)
+	} else if state.nextChange.IsZero() {
+		ctrl.logPool(pool, "Not updating %d candidate nodes: no upcoming maintenance window", len(candidates))
+	} else {
+		ctrl.logPool(pool, "Not updating %d candidate nodes: waiting for maintenance window starting at %s", len(candidates), state.nextChange.Format(time.RFC3339))
+	}
+	return nil
+}
+
+// filterCanaryCandidates restricts the update candidates according to the
+// canary rollout state of the pool, if it opted in to canary rollouts.
+func (ctrl *Controller) filterCanaryCandidates(pool *mcfgv1.MachineConfigPool, nodes, candidates []*corev1.Node, layered bool, mosc *mcfgv1.MachineOSConfig, mosb *mcfgv1.MachineOSBuild) []*corev1.Node {
+	cfg, err := getCanaryConfig(pool)
+	if err != nil {
+		ctrl.reportInvalidConfig(pool, canaryReasonInvalidConfig, "Not updating any nodes: %v", err)
+	} else {
+		ctrl.invalidConfigs.Clear(pool, canaryReasonInvalidConfig)
+		if cfg == nil {
+			return candidates
+		}
+	}
+
+	fg, err := ctrl.fgAcessor.CurrentFeatureGates()
+	if err != nil {
+		klog.Errorf("Could not get FG: %v", err)
+	}
+	mcns := ctrl.getMachineConfigNodes(fg, nodes)
+
+	rollout := evaluateCanaryRollout(pool, nodes, mcns, layered, mosc, mosb, time.Now())
+
+	previous := apihelpers.GetMachineConfigPoolCondition(pool.Status, ctrlcommon.MachineConfigPoolCanaryRollout)
+	if rollout.reason == canaryReasonFailed && (previous == nil || previous.Reason != canaryReasonFailed) {
+		ctrl.eventRecorder.Eventf(pool, corev1.EventTypeWarning, "CanaryRolloutHalted", rollout.message)
+	}
+	if rollout.requeueAfter > 0 {
+		ctrl.logPool(pool, "Canary nodes soaking, resuming rollout in %v", rollout.requeueAfter)
+		ctrl.enqueueAfter(pool, rollout.requeueAfter)
+	}
+	if rollout.holdsBack() {
+		klog.V(4).Infof("Pool %s: canary rollout holds back update of non-canary nodes: %s", pool.Name, rollout.message)
+	}
+
+	return rollout.filterCandidates(candidates)
+}
+
+// filterTopologyCandidates restricts the update candidates to a single
+// topology domain, if the pool opted in to spreading updates across domains.
+func (ctrl *Controller) filterTopologyCandidates(pool *mcfgv1.MachineConfigPool, nodes, candidates []*corev1.Node, layered bool, mosb *mcfgv1.MachineOSBuild) []*corev1.Node {
+	key, err := getUpdateTopologyKey(pool)
+	if err != nil {
+		ctrl.reportInvalidConfig(pool, "InvalidUpdateTopologyKey", "Not updating any nodes: %v", err)
+		return nil
+	}
+	ctrl.invalidConfigs.Clear(pool, "InvalidUpdateTopologyKey")
+	if key == "" || len(candidates) == 0 {
+		return candidates
+	}
+
+	filtered, msg := selectTopologyCandidates(key, candidates, getUnavailableMachines(nodes, pool, layered, mosb))
+	ctrl.logPool(pool, "%s", msg)
+	return filtered
+}
+
 // getOperatorPodNodeName fetches the name of the current node running the machine-config-operator pod
 func (ctrl *Controller) getOperatorNodeName() (string, error) {
 	// Create a selector object with  a filter on the machine-config-operator pod
@@ -1395,6 +1571,7 @@ func (ctrl *Controller) setDesiredAnnotations(layered bool, mosc *mcfgv1.Machine
 		if err := ctrl.updateCandidateNode(mosc, mosb, node.Name, pool); err != nil {
 			return fmt.Errorf("setting desired %s for node %s: %w", pool.Spec.Configuration.Name, node.Name, err)
 		}
+		ctrl.disruptionBudget.reserve(node.Name, time.Now())
 	}
 
 	if len(candidates) == 1 {
@@ -31,10 +31,13 @@ import (
 	"github.com/davecgh/go-spew/spew"
 	configv1 "github.com/openshift/api/config/v1"
 	mcfgv1 "github.com/openshift/api/machineconfiguration/v1"
+	opv1 "github.com/openshift/api/operator/v1"
 	fakeconfigv1client "github.com/openshift/client-go/config/clientset/versioned/fake"
 	configv1informer "github.com/openshift/client-go/config/informers/externalversions"
 	"github.com/openshift/client-go/machineconfiguration/clientset/versioned/fake"
 	informers "github.com/openshift/client-go/machineconfiguration/informers/externalversions"
+	fakeoperatorclient "github.com/openshift/client-go/operator/clientset/versioned/fake"
+	operatorinformer "github.com/openshift/client-go/operator/informers/externalversions"
 	"github.com/openshift/machine-config-operator/pkg/constants"
 	ctrlcommon "github.com/openshift/machine-config-operator/pkg/controller/common"
 	daemonconsts "github.com/openshift/machine-config-operator/pkg/daemon/constants"
@@ -64,6 +67,7 @@ type fixture struct {
 	client          *fake.Clientset
 	kubeclient      *k8sfake.Clientset
 	schedulerClient *fakeconfigv1client.Clientset
+	operatorClient  *fakeoperatorclient.Clientset
 
 	ccLister   []*mcfgv1.ControllerConfig
 	mcpLister  []*mcfgv1.MachineConfigPool
@@ -76,6 +80,8 @@ type fixture struct {
 	objects          []runtime.Object
 	schedulerObjects []runtime.Object
 	schedulerLister  []*configv1.Scheduler
+	operatorObjects  []runtime.Object
+	mcopLister       []*opv1.MachineConfiguration
 	fgAccess         featuregates.FeatureGateAccess
 }
 
@@ -99,23 +105,28 @@ func (f *fixture) newControllerWithStopChan(stopCh <-chan struct{}) *Controller
 	f.client = fake.NewSimpleClientset(f.objects...)
 	f.kubeclient = k8sfake.NewSimpleClientset(f.kubeobjects...)
 	f.schedulerClient = fakeconfigv1client.NewSimpleClientset(f.schedulerObjects...)
+	f.operatorClient = fakeoperatorclient.NewSimpleClientset(f.operatorObjects...)
 
 	i := informers.NewSharedInformerFactory(f.client, noResyncPeriodFunc())
 	k8sI := kubeinformers.NewSharedInformerFactory(f.kubeclient, noResyncPeriodFunc())
 	ci := configv1informer.NewSharedInformerFactory(f.schedulerClient, noResyncPeriodFunc())
+	oi := operatorinformer.NewSharedInformerFactory(f.operatorClient, noResyncPeriodFunc())
 	c := NewWithCustomUpdateDelay(i.Machineconfiguration().V1().ControllerConfigs(), i.Machineconfiguration().V1().MachineConfigs(), i.Machineconfiguration().V1().MachineConfigPools(), k8sI.Core().V1().Nodes(),
-		k8sI.Core().V1().Pods(), i.Machineconfiguration().V1().MachineOSConfigs(), ci.Config().V1().Schedulers(), f.kubeclient, f.client, time.Millisecond, f.fgAccess)
+		k8sI.Core().V1().Pods(), i.Machineconfiguration().V1().MachineOSConfigs(), ci.Config().V1().Schedulers(), oi.Operator().V1().MachineConfigurations(), f.kubeclient, f.client, time.Millisecond, f.fgAccess)
 
 	c.ccListerSynced = alwaysReady
 	c.mcpListerSynced = alwaysReady
 	c.nodeListerSynced = alwaysReady
 	c.schedulerListerSynced = alwaysReady
+	c.mcopListerSynced = alwaysReady
 	c.eventRecorder = &record.FakeRecorder{}
 
 	i.Start(stopCh)
 	i.WaitForCacheSync(stopCh)
 	k8sI.Start(stopCh)
 	k8sI.WaitForCacheSync(stopCh)
+	oi.Start(stopCh)
+	oi.WaitForCacheSync(stopCh)
 
 	for _, c := range f.ccLister {
 		i.Machineconfiguration().V1().ControllerConfigs().Informer().GetIndexer().Add(c)
@@ -130,6 +141,9 @@ func (f *fixture) newControllerWithStopChan(stopCh <-chan struct{}) *Controller
 	for _, c := range f.schedulerLister {
 		ci.Config().V1().Schedulers().Informer().GetIndexer().Add(c)
 	}
+	for _, m := range f.mcopLister {
+		oi.Operator().V1().MachineConfigurations().Informer().GetIndexer().Add(m)
+	}
 
 	return c
 
@@ -0,0 +1,91 @@
+package node
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+
+	mcfgv1 "github.com/openshift/api/machineconfiguration/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	ctrlcommon "github.com/openshift/machine-config-operator/pkg/controller/common"
+	daemonconsts "github.com/openshift/machine-config-operator/pkg/daemon/constants"
+)
+
+// getAutoRollbackThreshold returns the number of nodes which must degrade on
+// the pool's desired config to roll the pool back, or zero if the pool has not
+// opted in to automatic rollbacks.
+func getAutoRollbackThreshold(pool *mcfgv1.MachineConfigPool) (int, error) {
+	val, ok := pool.Annotations[ctrlcommon.AutoRollbackDegradedNodesAnnotationKey]
+	if !ok {
+		return 0, nil
+	}
+	threshold, err := strconv.Atoi(val)
+	if err != nil || threshold < 1 {
+		return 0, fmt.Errorf("invalid %s annotation %q: must be a positive integer", ctrlcommon.AutoRollbackDegradedNodesAnnotationKey, val)
+	}
+	return threshold, nil
+}
+
+// getAutoRollbackTarget returns the rendered MachineConfig the pool must be
+// rolled back to, along with the nodes which degraded on the pool's desired
+// config. It returns an empty string if no rollback is needed, which is also
+// the case if the pool is already pinned or has no previous config, i.e. it
+// never completed an update.
+func getAutoRollbackTarget(pool *mcfgv1.MachineConfigPool, nodes []*corev1.Node, threshold int) (string, []*corev1.Node) {
+	if pool.Annotations[ctrlcommon.PinnedRenderedConfigAnnotationKey] != "" {
+		return "", nil
+	}
+	previous := pool.Status.Configuration.Name
+	if previous == "" || previous == pool.Spec.Configuration.Name {
+		return "", nil
+	}
+
+	var degraded []*corev1.Node
+	for _, node := range nodes {
+		if node.Annotations[daemonconsts.DesiredMachineConfigAnnotationKey] == pool.Spec.Configuration.Name && isNodeMCDFailing(node) {
+			degraded = append(degraded, node)
+		}
+	}
+	if len(degraded) < threshold {
+		return "", nil
+	}
+	return previous, degraded
+}
+
+// autoRollbackPool pins the pool to its previous rendered MachineConfig if
+// enough nodes degraded on its desired config. The render controller then
+// retargets the pool, and the degraded nodes are moved back to the previous
+// config like any other node. It returns the updated pool if it was rolled back.
+func (ctrl *Controller) autoRollbackPool(pool *mcfgv1.MachineConfigPool, nodes []*corev1.Node) (*mcfgv1.MachineConfigPool, error) {
+	threshold, err := getAutoRollbackThreshold(pool)
+	if err != nil {
+		ctrl.reportInvalidConfig(pool, "InvalidAutoRollback", "Automatic rollback is disabled: %v", err)
+		return nil, nil
+	}
+	ctrl.invalidConfigs.Clear(pool, "InvalidAutoRollback")
+	if threshold == 0 {
+		return nil, nil
+	}
+
+	target, degraded := getAutoRollbackTarget(pool, nodes, threshold)
+	if target == "" {
+		return nil, nil
+	}
+
+	newPool := pool.DeepCopy()
+	if newPool.Annotations == nil {
+		newPool.Annotations = map[string]string{}
+	}
+	newPool.Annotations[ctrlcommon.PinnedRenderedConfigAnnotationKey] = target
+	updated, err := ctrl.client.MachineconfigurationV1().MachineConfigPools().Update(context.TODO(), newPool, metav1.UpdateOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("could not pin pool %s to %s: %w", pool.Name, target, err)
+	}
+
+	ctrl.logPool(pool, "Rolling back from %s to %s as nodes %v degraded", pool.Spec.Configuration.Name, target, getNamesFromNodes(degraded))
+	ctrl.eventRecorder.Eventf(pool, corev1.EventTypeWarning, "AutomaticRollback", "Rolling back from %s to %s as %d nodes degraded: %v",
+		pool.Spec.Configuration.Name, target, len(degraded), getNamesFromNodes(degraded))
+	return updated, nil
+}
@@ -0,0 +1,90 @@
+package node
+
+import (
+	"testing"
+
+	mcfgv1 "github.com/openshift/api/machineconfiguration/v1"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+
+	ctrlcommon "github.com/openshift/machine-config-operator/pkg/controller/common"
+	daemonconsts "github.com/openshift/machine-config-operator/pkg/daemon/constants"
+	"github.com/openshift/machine-config-operator/test/helpers"
+)
+
+func TestGetAutoRollbackTarget(t *testing.T) {
+	degradedNode := func(name string) *corev1.Node {
+		return helpers.NewNodeBuilder(name).WithConfigs(machineConfigV0, machineConfigV1).WithMCDState(daemonconsts.MachineConfigDaemonStateDegraded).Node()
+	}
+
+	tests := []struct {
+		name             string
+		annotations      map[string]string
+		statusConfig     string
+		nodes            []*corev1.Node
+		expectErr        bool
+		expectTarget     string
+		expectedDegraded []string
+	}{{
+		name:         "not opted in",
+		statusConfig: machineConfigV0,
+		nodes:        []*corev1.Node{degradedNode("node-0")},
+	}, {
+		name:         "invalid threshold",
+		annotations:  map[string]string{ctrlcommon.AutoRollbackDegradedNodesAnnotationKey: "-1"},
+		statusConfig: machineConfigV0,
+		expectErr:    true,
+	}, {
+		name:         "below threshold",
+		annotations:  map[string]string{ctrlcommon.AutoRollbackDegradedNodesAnnotationKey: "2"},
+		statusConfig: machineConfigV0,
+		nodes: []*corev1.Node{
+			degradedNode("node-0"),
+			newNodeWithDaemonState("node-1", machineConfigV0, machineConfigV1, daemonconsts.MachineConfigDaemonStateWorking),
+		},
+	}, {
+		name:             "threshold reached",
+		annotations:      map[string]string{ctrlcommon.AutoRollbackDegradedNodesAnnotationKey: "2"},
+		statusConfig:     machineConfigV0,
+		nodes:            []*corev1.Node{degradedNode("node-0"), degradedNode("node-1")},
+		expectTarget:     machineConfigV0,
+		expectedDegraded: []string{"node-0", "node-1"},
+	}, {
+		name: "already pinned",
+		annotations: map[string]string{
+			ctrlcommon.AutoRollbackDegradedNodesAnnotationKey: "1",
+			ctrlcommon.PinnedRenderedConfigAnnotationKey:      machineConfigV1,
+		},
+		statusConfig: machineConfigV0,
+		nodes:        []*corev1.Node{degradedNode("node-0")},
+	}, {
+		name:         "no previous config",
+		annotations:  map[string]string{ctrlcommon.AutoRollbackDegradedNodesAnnotationKey: "1"},
+		statusConfig: machineConfigV1,
+		nodes:        []*corev1.Node{degradedNode("node-0")},
+	}}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			pool := helpers.NewMachineConfigPool("worker", nil, helpers.WorkerSelector, machineConfigV1)
+			pool.Annotations = test.annotations
+			pool.Status.Configuration = mcfgv1.MachineConfigPoolStatusConfiguration{}
+			pool.Status.Configuration.Name = test.statusConfig
+
+			threshold, err := getAutoRollbackThreshold(pool)
+			if test.expectErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			if threshold == 0 {
+				assert.Empty(t, test.expectTarget)
+				return
+			}
+
+			target, degraded := getAutoRollbackTarget(pool, test.nodes, threshold)
+			assert.Equal(t, test.expectTarget, target)
+			assert.Equal(t, test.expectedDegraded, getNamesFromNodes(degraded))
+		})
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"strings"
+	"time"
 
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/equality"
@@ -32,27 +33,11 @@ func (ctrl *Controller) syncStatusOnly(pool *mcfgv1.MachineConfigPool) error {
 		return err
 	}
 
-	machineConfigStates := []*mcfgv1alpha1.MachineConfigNode{}
 	fg, err := ctrl.fgAcessor.CurrentFeatureGates()
-	list := fg.KnownFeatures()
-	mcnExists := false
-	for _, feature := range list {
-		if feature == features.FeatureGateMachineConfigNodes {
-			mcnExists = true
-		}
-	}
 	if err != nil {
 		klog.Errorf("Could not get FG: %v", err)
-	} else if mcnExists && fg.Enabled(features.FeatureGateMachineConfigNodes) {
-		for _, node := range nodes {
-			ms, err := ctrl.client.MachineconfigurationV1alpha1().MachineConfigNodes().Get(context.TODO(), node.Name, metav1.GetOptions{})
-			if err != nil {
-				klog.Errorf("Could not find our MachineConfigNode for node. %s: %v", node.Name, err)
-				continue
-			}
-			machineConfigStates = append(machineConfigStates, ms)
-		}
 	}
+	machineConfigStates := ctrl.getMachineConfigNodes(fg, nodes)
 
 	mosc, mosb, l, err := ctrl.getConfigAndBuildAndLayeredStatus(pool)
 	if err != nil {
@@ -80,6 +65,35 @@ func (ctrl *Controller) syncStatusOnly(pool *mcfgv1.MachineConfigPool) error {
 	return err
 }
 
+// getMachineConfigNodes returns the MachineConfigNodes of the given nodes if
+// the MachineConfigNodes feature is enabled.
+func (ctrl *Controller) getMachineConfigNodes(fg featuregates.FeatureGate, nodes []*corev1.Node) []*mcfgv1alpha1.MachineConfigNode {
+	machineConfigStates := []*mcfgv1alpha1.MachineConfigNode{}
+	if fg == nil {
+		return machineConfigStates
+	}
+
+	mcnExists := false
+	for _, feature := range fg.KnownFeatures() {
+		if feature == features.FeatureGateMachineConfigNodes {
+			mcnExists = true
+		}
+	}
+	if !mcnExists || !fg.Enabled(features.FeatureGateMachineConfigNodes) {
+		return machineConfigStates
+	}
+
+	for _, node := range nodes {
+		ms, err := ctrl.client.MachineconfigurationV1alpha1().MachineConfigNodes().Get(context.TODO(), node.Name, metav1.GetOptions{})
+		if err != nil {
+			klog.Errorf("Could not find our MachineConfigNode for node. %s: %v", node.Name, err)
+			continue
+		}
+		machineConfigStates = append(machineConfigStates, ms)
+	}
+	return machineConfigStates
+}
+
 //nolint:gocyclo,gosec
 func (ctrl *Controller) calculateStatus(fg featuregates.FeatureGate, mcs []*mcfgv1alpha1.MachineConfigNode, cconfig *mcfgv1.ControllerConfig, pool *mcfgv1.MachineConfigPool, nodes []*corev1.Node, mosc *mcfgv1.MachineOSConfig, mosb *mcfgv1.MachineOSBuild) mcfgv1.MachineConfigPoolStatus {
 	certExpirys := []mcfgv1.CertExpiry{}
@@ -252,6 +266,12 @@ func (ctrl *Controller) calculateStatus(fg featuregates.FeatureGate, mcs []*mcfg
 	conditions := pool.Status.Conditions
 	status.Conditions = append(status.Conditions, conditions...)
 
+	now := time.Now()
+	setCanaryRolloutCondition(&status, evaluateCanaryRollout(pool, nodes, mcs, l, mosc, mosb, now))
+	setMaintenanceWindowCondition(&status, evaluateMaintenanceWindow(pool, now), hasNodesToUpdate(pool, nodes, l, mosc, mosb))
+	setDisruptionBudgetCondition(&status, ctrl.disruptionBudget.getPoolCondition(pool.Name))
+	setDrainBlockedCondition(&status, nodes)
+
 	allUpdated := updatedMachineCount == machineCount &&
 		readyMachineCount == machineCount &&
 		unavailableMachineCount == 0
@@ -0,0 +1,102 @@
+package node
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	mcfgv1 "github.com/openshift/api/machineconfiguration/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/apimachinery/pkg/util/validation"
+
+	ctrlcommon "github.com/openshift/machine-config-operator/pkg/controller/common"
+)
+
+// getUpdateTopologyKey returns the node label key the updates of a pool are
+// spread by, or an empty string if the pool has not opted in.
+func getUpdateTopologyKey(pool *mcfgv1.MachineConfigPool) (string, error) {
+	key, ok := pool.Annotations[ctrlcommon.UpdateTopologyKeyAnnotationKey]
+	if !ok {
+		return "", nil
+	}
+	if errs := validation.IsQualifiedName(key); len(errs) > 0 {
+		return "", fmt.Errorf("invalid %s annotation %q: %s", ctrlcommon.UpdateTopologyKeyAnnotationKey, key, strings.Join(errs, "; "))
+	}
+	return key, nil
+}
+
+// topologyDomain is a failure domain of a pool, i.e. the nodes sharing a value
+// of the topology key. Nodes without the label form a domain of their own.
+type topologyDomain struct {
+	value   string
+	labeled bool
+}
+
+func (d topologyDomain) String() string {
+	if !d.labeled {
+		return "nodes without label"
+	}
+	return d.value
+}
+
+func getTopologyDomain(node *corev1.Node, key string) topologyDomain {
+	value, ok := node.Labels[key]
+	return topologyDomain{value: value, labeled: ok}
+}
+
+// sortTopologyDomains sorts domains by value; the domain of unlabeled nodes is last.
+func sortTopologyDomains(domains []topologyDomain) {
+	sort.Slice(domains, func(i, j int) bool {
+		if domains[i].labeled != domains[j].labeled {
+			return domains[i].labeled
+		}
+		return domains[i].value < domains[j].value
+	})
+}
+
+// selectTopologyCandidates restricts the update candidates to a single
+// topology domain, so that at most one domain of the pool is disrupted at a
+// time. A domain is disrupted if any of its nodes is unavailable, i.e. updating
+// or not ready. While a domain is disrupted only its nodes are updated, and no
+// nodes at all are updated while several domains are. Otherwise the first
+// domain with candidates is picked. It returns the candidates along with a
+// description of the selection for logging.
+func selectTopologyCandidates(key string, candidates, unavailable []*corev1.Node) ([]*corev1.Node, string) {
+	disrupted := sets.New[topologyDomain]()
+	for _, node := range unavailable {
+		disrupted.Insert(getTopologyDomain(node, key))
+	}
+
+	var domain topologyDomain
+	switch {
+	case disrupted.Len() > 1:
+		domains := disrupted.UnsortedList()
+		sortTopologyDomains(domains)
+		names := []string{}
+		for _, d := range domains {
+			names = append(names, d.String())
+		}
+		return nil, fmt.Sprintf("waiting for %s domains %s to become available", key, strings.Join(names, ", "))
+	case disrupted.Len() == 1:
+		domain = disrupted.UnsortedList()[0]
+	default:
+		domains := []topologyDomain{}
+		for _, node := range candidates {
+			domains = append(domains, getTopologyDomain(node, key))
+		}
+		if len(domains) == 0 {
+			return nil, ""
+		}
+		sortTopologyDomains(domains)
+		domain = domains[0]
+	}
+
+	filtered := []*corev1.Node{}
+	for _, node := range candidates {
+		if getTopologyDomain(node, key) == domain {
+			filtered = append(filtered, node)
+		}
+	}
+	return filtered, fmt.Sprintf("updating %s domain %s", key, domain)
+}
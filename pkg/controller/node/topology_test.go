@@ -0,0 +1,96 @@
+package node
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+
+	ctrlcommon "github.com/openshift/machine-config-operator/pkg/controller/common"
+	"github.com/openshift/machine-config-operator/test/helpers"
+)
+
+func TestGetUpdateTopologyKey(t *testing.T) {
+	pool := helpers.NewMachineConfigPool("worker", nil, helpers.WorkerSelector, machineConfigV1)
+
+	key, err := getUpdateTopologyKey(pool)
+	require.NoError(t, err)
+	assert.Empty(t, key)
+
+	pool.Annotations = map[string]string{ctrlcommon.UpdateTopologyKeyAnnotationKey: zoneLabel}
+	key, err = getUpdateTopologyKey(pool)
+	require.NoError(t, err)
+	assert.Equal(t, zoneLabel, key)
+
+	pool.Annotations[ctrlcommon.UpdateTopologyKeyAnnotationKey] = "not a/valid/key"
+	_, err = getUpdateTopologyKey(pool)
+	assert.Error(t, err)
+}
+
+func TestSelectTopologyCandidates(t *testing.T) {
+	const rackLabel = "example.com/rack"
+
+	inRack := func(name, rack string) *corev1.Node {
+		return newNodeWithLabels(name, map[string]string{rackLabel: rack})
+	}
+
+	tests := []struct {
+		name        string
+		candidates  []*corev1.Node
+		unavailable []*corev1.Node
+		expected    []string
+	}{{
+		name:     "no candidates",
+		expected: []string{},
+	}, {
+		name: "nothing disrupted picks the first domain",
+		candidates: []*corev1.Node{
+			inRack("node-0", "rack-b"),
+			newNodeWithLabels("node-1", nil),
+			inRack("node-2", "rack-a"),
+			inRack("node-3", "rack-a"),
+		},
+		expected: []string{"node-2", "node-3"},
+	}, {
+		name: "unlabeled nodes are updated last",
+		candidates: []*corev1.Node{
+			newNodeWithLabels("node-0", nil),
+			inRack("node-1", "rack-b"),
+		},
+		expected: []string{"node-1"},
+	}, {
+		name: "disrupted domain is finished first",
+		candidates: []*corev1.Node{
+			inRack("node-0", "rack-a"),
+			inRack("node-1", "rack-b"),
+		},
+		unavailable: []*corev1.Node{inRack("node-2", "rack-b")},
+		expected:    []string{"node-1"},
+	}, {
+		name: "disrupted domain without candidates blocks other domains",
+		candidates: []*corev1.Node{
+			inRack("node-0", "rack-a"),
+		},
+		unavailable: []*corev1.Node{inRack("node-1", "rack-b")},
+		expected:    []string{},
+	}, {
+		name: "several disrupted domains block all domains",
+		candidates: []*corev1.Node{
+			inRack("node-0", "rack-a"),
+			inRack("node-1", "rack-b"),
+		},
+		unavailable: []*corev1.Node{
+			inRack("node-2", "rack-a"),
+			newNodeWithLabels("node-3", nil),
+		},
+		expected: []string{},
+	}}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			filtered, _ := selectTopologyCandidates(rackLabel, test.candidates, test.unavailable)
+			assert.ElementsMatch(t, test.expected, getNamesFromNodes(filtered))
+		})
+	}
+}
@@ -2,17 +2,23 @@ package render
 
 import (
 	"context"
+	"encoding/json"
 	goerrs "errors"
 	"fmt"
 	"reflect"
 	"sort"
+	"strconv"
+	"strings"
 	"time"
 
 	mcfgv1 "github.com/openshift/api/machineconfiguration/v1"
+	opv1 "github.com/openshift/api/operator/v1"
 	mcfgclientset "github.com/openshift/client-go/machineconfiguration/clientset/versioned"
 	"github.com/openshift/client-go/machineconfiguration/clientset/versioned/scheme"
 	mcfginformersv1 "github.com/openshift/client-go/machineconfiguration/informers/externalversions/machineconfiguration/v1"
 	mcfglistersv1 "github.com/openshift/client-go/machineconfiguration/listers/machineconfiguration/v1"
+	mcopinformersv1 "github.com/openshift/client-go/operator/informers/externalversions/operator/v1"
+	mcoplistersv1 "github.com/openshift/client-go/operator/listers/operator/v1"
 	mcoResourceApply "github.com/openshift/machine-config-operator/lib/resourceapply"
 	"github.com/openshift/machine-config-operator/pkg/apihelpers"
 	ctrlcommon "github.com/openshift/machine-config-operator/pkg/controller/common"
@@ -24,9 +30,12 @@ import (
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/labels"
 	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/apimachinery/pkg/util/sets"
 	"k8s.io/apimachinery/pkg/util/wait"
+	coreinformersv1 "k8s.io/client-go/informers/core/v1"
 	clientset "k8s.io/client-go/kubernetes"
 	corev1client "k8s.io/client-go/kubernetes/typed/core/v1"
+	corelisterv1 "k8s.io/client-go/listers/core/v1"
 	"k8s.io/client-go/tools/cache"
 	"k8s.io/client-go/tools/record"
 	"k8s.io/client-go/util/workqueue"
@@ -76,7 +85,19 @@ type Controller struct {
 	mckLister       mcfglistersv1.KubeletConfigLister
 	mckListerSynced cache.InformerSynced
 
+	nodeLister       corelisterv1.NodeLister
+	nodeListerSynced cache.InformerSynced
+
+	mcopLister       mcoplistersv1.MachineConfigurationLister
+	mcopListerSynced cache.InformerSynced
+
+	secretLister       corelisterv1.SecretLister
+	secretListerSynced cache.InformerSynced
+
 	queue workqueue.TypedRateLimitingInterface[string]
+
+	// invalidConfigs reports the invalid settings of pools once.
+	invalidConfigs ctrlcommon.InvalidConfigReporter
 }
 
 // New returns a new render controller.
@@ -86,6 +107,9 @@ func New(
 	ccInformer mcfginformersv1.ControllerConfigInformer,
 	crcInformer mcfginformersv1.ContainerRuntimeConfigInformer,
 	mckInformer mcfginformersv1.KubeletConfigInformer,
+	nodeInformer coreinformersv1.NodeInformer,
+	mcopInformer mcopinformersv1.MachineConfigurationInformer,
+	secretInformer coreinformersv1.SecretInformer,
 	kubeClient clientset.Interface,
 	mcfgClient mcfgclientset.Interface,
 ) *Controller {
@@ -111,6 +135,15 @@ func New(
 		UpdateFunc: ctrl.updateMachineConfig,
 		DeleteFunc: ctrl.deleteMachineConfig,
 	})
+	mcopInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    ctrl.addMachineConfiguration,
+		UpdateFunc: ctrl.updateMachineConfiguration,
+	})
+	secretInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    ctrl.addSecret,
+		UpdateFunc: ctrl.updateSecret,
+		DeleteFunc: ctrl.deleteSecret,
+	})
 
 	ctrl.syncHandler = ctrl.syncMachineConfigPool
 	ctrl.enqueueMachineConfigPool = ctrl.enqueueDefault
@@ -125,6 +158,12 @@ func New(
 	ctrl.crcListerSynced = crcInformer.Informer().HasSynced
 	ctrl.mckLister = mckInformer.Lister()
 	ctrl.mckListerSynced = mckInformer.Informer().HasSynced
+	ctrl.nodeLister = nodeInformer.Lister()
+	ctrl.nodeListerSynced = nodeInformer.Informer().HasSynced
+	ctrl.mcopLister = mcopInformer.Lister()
+	ctrl.mcopListerSynced = mcopInformer.Informer().HasSynced
+	ctrl.secretLister = secretInformer.Lister()
+	ctrl.secretListerSynced = secretInformer.Informer().HasSynced
 
 	return ctrl
 }
@@ -134,7 +173,7 @@ func (ctrl *Controller) Run(workers int, stopCh <-chan struct{}) {
 	defer utilruntime.HandleCrash()
 	defer ctrl.queue.ShutDown()
 
-	if !cache.WaitForCacheSync(stopCh, ctrl.mcpListerSynced, ctrl.mcListerSynced, ctrl.ccListerSynced) {
+	if !cache.WaitForCacheSync(stopCh, ctrl.mcpListerSynced, ctrl.mcListerSynced, ctrl.ccListerSynced, ctrl.nodeListerSynced, ctrl.mcopListerSynced, ctrl.secretListerSynced) {
 		return
 	}
 
@@ -278,6 +317,41 @@ func (ctrl *Controller) deleteMachineConfig(obj interface{}) {
 	}
 }
 
+func (ctrl *Controller) addMachineConfiguration(obj interface{}) {
+	mcop := obj.(*opv1.MachineConfiguration)
+	if mcop.Name != ctrlcommon.MCOOperatorKnobsObjectName {
+		return
+	}
+	klog.V(4).Infof("MachineConfiguration %s added", mcop.Name)
+	ctrl.enqueueAllMachineConfigPools()
+}
+
+func (ctrl *Controller) updateMachineConfiguration(old, cur interface{}) {
+	oldMcop := old.(*opv1.MachineConfiguration)
+	curMcop := cur.(*opv1.MachineConfiguration)
+	if curMcop.Name != ctrlcommon.MCOOperatorKnobsObjectName {
+		return
+	}
+
+	// Only the node disruption policies affect the disruption preview of the pools.
+	if reflect.DeepEqual(oldMcop.Status.NodeDisruptionPolicyStatus, curMcop.Status.NodeDisruptionPolicyStatus) {
+		return
+	}
+	klog.V(4).Infof("MachineConfiguration %s node disruption policies updated", curMcop.Name)
+	ctrl.enqueueAllMachineConfigPools()
+}
+
+func (ctrl *Controller) enqueueAllMachineConfigPools() {
+	pools, err := ctrl.mcpLister.List(labels.Everything())
+	if err != nil {
+		klog.Errorf("error listing machineconfigpools: %v", err)
+		return
+	}
+	for _, p := range pools {
+		ctrl.enqueueMachineConfigPool(p)
+	}
+}
+
 func (ctrl *Controller) resolveControllerRef(controllerRef *metav1.OwnerReference) *mcfgv1.MachineConfigPool {
 	// We can't look up by UID, so look up by Name and then verify UID.
 	// Don't even try to look up by Name if it's the wrong Kind.
@@ -488,16 +562,192 @@ func (ctrl *Controller) syncFailingStatus(pool *mcfgv1.MachineConfigPool, err er
 	return err
 }
 
-// This function will eventually contain a sane garbage collection policy for rendered MachineConfigs;
-// see https://github.com/openshift/machine-config-operator/issues/301
-// It will probably involve making sure we're only GCing a config after all nodes don't have it
-// in either desired or current config.
-func (ctrl *Controller) garbageCollectRenderedConfigs(_ *mcfgv1.MachineConfigPool) error {
-	// Temporarily until https://github.com/openshift/machine-config-operator/pull/318
-	// which depends on the strategy for https://github.com/openshift/machine-config-operator/issues/301
+// garbageCollectRenderedConfigs deletes the rendered MachineConfigs owned by
+// the given pool which exceed the retention count set by the
+// RenderedConfigRetentionAnnotationKey annotation. Pools without the
+// annotation are left alone. A rendered MachineConfig is never deleted while
+// the pool, a node, a MachineConfigNode or a MachineOSBuild still refers to it.
+// See https://github.com/openshift/machine-config-operator/issues/301
+func (ctrl *Controller) garbageCollectRenderedConfigs(pool *mcfgv1.MachineConfigPool) error {
+	retention, enabled, err := getRenderedConfigRetention(pool)
+	if err != nil {
+		ctrl.invalidConfigs.Report(ctrl.eventRecorder, pool, "InvalidRenderedConfigRetention", "Skipping rendered config garbage collection: %v", err)
+		return nil
+	}
+	ctrl.invalidConfigs.Clear(pool, "InvalidRenderedConfigRetention")
+	if !enabled {
+		return nil
+	}
+
+	rendered, err := ctrl.getRenderedMachineConfigsForPool(pool)
+	if err != nil {
+		return fmt.Errorf("could not list rendered MachineConfigs for pool %s: %w", pool.Name, err)
+	}
+
+	inUse, err := ctrl.getRenderedMachineConfigsReferencedByNodes(pool)
+	if err != nil {
+		return err
+	}
+
+	candidates := getRenderedMachineConfigsToDelete(rendered, inUse, retention)
+	if len(candidates) == 0 {
+		return nil
+	}
+
+	// MachineConfigNodes and MachineOSBuilds are only consulted once we know
+	// there is something to delete, since they are not backed by a lister.
+	if err := ctrl.addRenderedMachineConfigsReferencedByMCO(inUse); err != nil {
+		return err
+	}
+
+	var errs []error
+	for _, mc := range candidates {
+		if inUse.Has(mc.Name) {
+			continue
+		}
+
+		err := ctrl.client.MachineconfigurationV1().MachineConfigs().Delete(context.TODO(), mc.Name, metav1.DeleteOptions{})
+		if err != nil && !apierrors.IsNotFound(err) {
+			errs = append(errs, fmt.Errorf("could not delete rendered MachineConfig %s: %w", mc.Name, err))
+			continue
+		}
+
+		klog.V(2).Infof("Pool %s: garbage collected rendered MachineConfig %s", pool.Name, mc.Name)
+		ctrl.eventRecorder.Eventf(pool, corev1.EventTypeNormal, "RenderedConfigGarbageCollected", "Deleted unused rendered MachineConfig %s (retention: %d)", mc.Name, retention)
+		ctrlcommon.MCCRenderedConfigsGarbageCollected.WithLabelValues(pool.Name).Inc()
+	}
+
+	return goerrs.Join(errs...)
+}
+
+// getRenderedConfigRetention returns the number of rendered MachineConfigs to
+// keep for the pool and whether garbage collection is enabled at all.
+func getRenderedConfigRetention(pool *mcfgv1.MachineConfigPool) (int, bool, error) {
+	val, ok := pool.Annotations[ctrlcommon.RenderedConfigRetentionAnnotationKey]
+	if !ok {
+		return 0, false, nil
+	}
+
+	retention, err := strconv.Atoi(val)
+	if err != nil {
+		return 0, false, fmt.Errorf("invalid value %q for annotation %s: %w", val, ctrlcommon.RenderedConfigRetentionAnnotationKey, err)
+	}
+
+	if retention < 1 {
+		return 0, false, fmt.Errorf("invalid value %q for annotation %s: must be at least 1", val, ctrlcommon.RenderedConfigRetentionAnnotationKey)
+	}
+
+	return retention, true, nil
+}
+
+// getRenderedMachineConfigsForPool returns the rendered MachineConfigs which
+// are controlled by the given pool.
+func (ctrl *Controller) getRenderedMachineConfigsForPool(pool *mcfgv1.MachineConfigPool) ([]*mcfgv1.MachineConfig, error) {
+	mcs, err := ctrl.mcLister.List(labels.Everything())
+	if err != nil {
+		return nil, err
+	}
+
+	var out []*mcfgv1.MachineConfig
+	for _, mc := range mcs {
+		controllerRef := metav1.GetControllerOf(mc)
+		if controllerRef == nil || controllerRef.Kind != controllerKind.Kind || controllerRef.UID != pool.UID {
+			continue
+		}
+
+		if !strings.HasPrefix(mc.Name, "rendered-") {
+			continue
+		}
+
+		out = append(out, mc)
+	}
+
+	return out, nil
+}
+
+// getRenderedMachineConfigsReferencedByNodes returns the names of the rendered
+// MachineConfigs which the pool targets or that any node is currently on or
+// moving to. All nodes are considered since a node may be changing pools.
+func (ctrl *Controller) getRenderedMachineConfigsReferencedByNodes(pool *mcfgv1.MachineConfigPool) (sets.Set[string], error) {
+	inUse := sets.New[string]()
+	inUse.Insert(pool.Spec.Configuration.Name, pool.Status.Configuration.Name, pool.Annotations[ctrlcommon.PinnedRenderedConfigAnnotationKey])
+
+	nodes, err := ctrl.nodeLister.List(labels.Everything())
+	if err != nil {
+		return nil, fmt.Errorf("could not list nodes: %w", err)
+	}
+
+	for _, node := range nodes {
+		for _, key := range []string{daemonconsts.CurrentMachineConfigAnnotationKey, daemonconsts.DesiredMachineConfigAnnotationKey} {
+			if name := node.Annotations[key]; name != "" {
+				inUse.Insert(name)
+			}
+		}
+	}
+
+	inUse.Delete("")
+	return inUse, nil
+}
+
+// addRenderedMachineConfigsReferencedByMCO adds the rendered MachineConfigs
+// referenced by any MachineConfigNode or MachineOSBuild to the given set.
+// Either API may be absent when its feature gate is disabled, in which case it
+// is skipped.
+func (ctrl *Controller) addRenderedMachineConfigsReferencedByMCO(inUse sets.Set[string]) error {
+	mcns, err := ctrl.client.MachineconfigurationV1alpha1().MachineConfigNodes().List(context.TODO(), metav1.ListOptions{})
+	if err != nil && !apierrors.IsNotFound(err) {
+		return fmt.Errorf("could not list MachineConfigNodes: %w", err)
+	}
+	if err == nil {
+		for _, mcn := range mcns.Items {
+			inUse.Insert(mcn.Spec.ConfigVersion.Desired, mcn.Status.ConfigVersion.Current, mcn.Status.ConfigVersion.Desired)
+		}
+	}
+
+	mosbs, err := ctrl.client.MachineconfigurationV1().MachineOSBuilds().List(context.TODO(), metav1.ListOptions{})
+	if err != nil && !apierrors.IsNotFound(err) {
+		return fmt.Errorf("could not list MachineOSBuilds: %w", err)
+	}
+	if err == nil {
+		for _, mosb := range mosbs.Items {
+			inUse.Insert(mosb.Spec.MachineConfig.Name)
+		}
+	}
+
+	inUse.Delete("")
 	return nil
 }
 
+// getRenderedMachineConfigsToDelete returns the rendered MachineConfigs which
+// fall outside of the retention count, newest first, skipping any which are in
+// use. In-use configs count towards the retention count.
+func getRenderedMachineConfigsToDelete(rendered []*mcfgv1.MachineConfig, inUse sets.Set[string], retention int) []*mcfgv1.MachineConfig {
+	sorted := make([]*mcfgv1.MachineConfig, len(rendered))
+	copy(sorted, rendered)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		iTime := sorted[i].CreationTimestamp.Time
+		jTime := sorted[j].CreationTimestamp.Time
+		if iTime.Equal(jTime) {
+			return sorted[i].Name < sorted[j].Name
+		}
+		return iTime.After(jTime)
+	})
+
+	if len(sorted) <= retention {
+		return nil
+	}
+
+	var out []*mcfgv1.MachineConfig
+	for _, mc := range sorted[retention:] {
+		if inUse.Has(mc.Name) {
+			continue
+		}
+		out = append(out, mc)
+	}
+
+	return out
+}
+
 func (ctrl *Controller) getRenderedMachineConfig(pool *mcfgv1.MachineConfigPool, configs []*mcfgv1.MachineConfig, cc *mcfgv1.ControllerConfig) (*mcfgv1.MachineConfig, error) {
 	// If we don't yet have a rendered MachineConfig on the pool, we cannot
 	// perform reconciliation. So we must solely generate the rendered
@@ -535,6 +785,11 @@ func (ctrl *Controller) syncGeneratedMachineConfig(pool *mcfgv1.MachineConfigPoo
 		return err
 	}
 
+	configs, err = pinSecretFileSources(configs, ctrl.secretLister.Secrets(ctrlcommon.FileSourcesNamespace).Get)
+	if err != nil {
+		return err
+	}
+
 	generated, err := ctrl.getRenderedMachineConfig(pool, configs, cc)
 	if err != nil {
 		return fmt.Errorf("could not generate rendered MachineConfig: %w", err)
@@ -565,29 +820,261 @@ func (ctrl *Controller) syncGeneratedMachineConfig(pool *mcfgv1.MachineConfigPoo
 		return err
 	}
 
+	// A pinned pool keeps targeting the pinned config; the generated one is
+	// still created so that unpinning moves the pool to the latest config.
+	target, err := ctrl.getPinnedRenderedConfig(pool)
+	if err != nil {
+		return err
+	}
+	if target == nil {
+		target = generated
+	}
+
 	newPool := pool.DeepCopy()
 	newPool.Spec.Configuration.Source = source
 
-	if pool.Spec.Configuration.Name == generated.Name {
-		_, _, err = mcoResourceApply.ApplyMachineConfig(ctrl.client.MachineconfigurationV1(), generated)
+	if pool.Spec.Configuration.Name == target.Name {
+		if target == generated {
+			_, _, err = mcoResourceApply.ApplyMachineConfig(ctrl.client.MachineconfigurationV1(), generated)
+			if err != nil {
+				return err
+			}
+		}
+		pool, err = ctrl.client.MachineconfigurationV1().MachineConfigPools().Update(context.TODO(), newPool, metav1.UpdateOptions{})
 		if err != nil {
 			return err
 		}
-		_, err = ctrl.client.MachineconfigurationV1().MachineConfigPools().Update(context.TODO(), newPool, metav1.UpdateOptions{})
-		return err
+		if err := ctrl.syncRenderedConfigStatus(pool, target, generated); err != nil {
+			return err
+		}
+		return ctrl.garbageCollectRenderedConfigs(pool)
 	}
 
-	newPool.Spec.Configuration.Name = generated.Name
+	newPool.Spec.Configuration.Name = target.Name
 	// TODO(walters) Use subresource or JSON patch, but the latter isn't supported by the unit test mocks
 	pool, err = ctrl.client.MachineconfigurationV1().MachineConfigPools().Update(context.TODO(), newPool, metav1.UpdateOptions{})
 	if err != nil {
 		return err
 	}
 	klog.V(2).Infof("Pool %s: now targeting: %s", pool.Name, pool.Spec.Configuration.Name)
+	if target != generated {
+		ctrl.eventRecorder.Eventf(pool, corev1.EventTypeNormal, "PinnedRenderedConfig", "Targeting pinned %s instead of %s", target.Name, generated.Name)
+	}
 	ctrlcommon.UpdateStateMetric(ctrlcommon.MCCSubControllerState, "machine-config-controller-render", "Sync Machine Config Pool with new MC", pool.Name)
+	if err := ctrl.syncRenderedConfigStatus(pool, target, generated); err != nil {
+		return err
+	}
 	return ctrl.garbageCollectRenderedConfigs(pool)
 }
 
+// getPinnedRenderedConfig returns the rendered MachineConfig the pool is pinned
+// to with the PinnedRenderedConfigAnnotationKey annotation, or nil if the pool
+// is not pinned. Only rendered MachineConfigs owned by the pool can be pinned.
+func (ctrl *Controller) getPinnedRenderedConfig(pool *mcfgv1.MachineConfigPool) (*mcfgv1.MachineConfig, error) {
+	name := pool.Annotations[ctrlcommon.PinnedRenderedConfigAnnotationKey]
+	if name == "" {
+		return nil, nil
+	}
+
+	mc, err := ctrl.mcLister.Get(name)
+	if apierrors.IsNotFound(err) {
+		return nil, fmt.Errorf("pinned rendered MachineConfig %s does not exist", name)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not get pinned rendered MachineConfig %s: %w", name, err)
+	}
+
+	owner := metav1.GetControllerOf(mc)
+	if owner == nil || owner.Kind != controllerKind.Kind || owner.Name != pool.Name {
+		return nil, fmt.Errorf("pinned MachineConfig %s is not a rendered MachineConfig of pool %s", name, pool.Name)
+	}
+	return mc, nil
+}
+
+// maxReportedOverrides is the number of overrides listed in the RenderConflicts
+// condition message; the full list is kept in the provenance annotation of the
+// rendered MachineConfig.
+const maxReportedOverrides = 10
+
+// syncRenderedConfigStatus updates the pool conditions which describe the
+// rendered MachineConfig the pool targets, writing the pool status only if
+// one of them changed. The target differs from the generated MachineConfig
+// if the pool is pinned.
+func (ctrl *Controller) syncRenderedConfigStatus(pool *mcfgv1.MachineConfigPool, target, generated *mcfgv1.MachineConfig) error {
+	newPool := pool.DeepCopy()
+
+	conflictsChanged, err := ctrl.setRenderConflictsCondition(newPool, target)
+	if err != nil {
+		return err
+	}
+
+	previewChanged, err := ctrl.setNodeDisruptionPreviewCondition(newPool, target)
+	if err != nil {
+		return err
+	}
+
+	pinnedChanged := setRenderedConfigPinnedCondition(newPool, target, generated)
+
+	if !conflictsChanged && !previewChanged && !pinnedChanged {
+		return nil
+	}
+
+	_, err = ctrl.client.MachineconfigurationV1().MachineConfigPools().UpdateStatus(context.TODO(), newPool, metav1.UpdateOptions{})
+	return err
+}
+
+// setRenderConflictsCondition reports the overrides recorded in the provenance
+// of the rendered MachineConfig through the RenderConflicts pool condition, and
+// emits an event whenever they change.
+func (ctrl *Controller) setRenderConflictsCondition(pool *mcfgv1.MachineConfigPool, generated *mcfgv1.MachineConfig) (bool, error) {
+	provenance, err := ctrlcommon.ParseMachineConfigProvenance(generated)
+	if err != nil {
+		return false, err
+	}
+
+	var overrides []ctrlcommon.MachineConfigOverrideInfo
+	if provenance != nil {
+		overrides = provenance.Overrides
+	}
+
+	current := apihelpers.GetMachineConfigPoolCondition(pool.Status, ctrlcommon.MachineConfigPoolRenderConflicts)
+
+	var cond *mcfgv1.MachineConfigPoolCondition
+	if len(overrides) == 0 {
+		if current == nil || current.Status == corev1.ConditionFalse {
+			return false, nil
+		}
+		cond = apihelpers.NewMachineConfigPoolCondition(ctrlcommon.MachineConfigPoolRenderConflicts, corev1.ConditionFalse, "", "")
+	} else {
+		message := getRenderConflictsMessage(generated.Name, overrides)
+		if current != nil && current.Status == corev1.ConditionTrue && current.Message == message {
+			return false, nil
+		}
+		cond = apihelpers.NewMachineConfigPoolCondition(ctrlcommon.MachineConfigPoolRenderConflicts, corev1.ConditionTrue, "MachineConfigOverrides", message)
+		ctrl.eventRecorder.Eventf(pool, corev1.EventTypeNormal, "RenderConflicts", "%s", message)
+	}
+
+	apihelpers.SetMachineConfigPoolCondition(&pool.Status, *cond)
+	return true, nil
+}
+
+// setNodeDisruptionPreviewCondition predicts the actions the nodes of the pool
+// will take when updating from the pool's current rendered MachineConfig to the
+// generated one, and reports them through the NodeDisruptionPreview pool
+// condition. The prediction uses the same diff and NodeDisruptionPolicy
+// evaluation as the MCD.
+func (ctrl *Controller) setNodeDisruptionPreviewCondition(pool *mcfgv1.MachineConfigPool, generated *mcfgv1.MachineConfig) (bool, error) {
+	current := apihelpers.GetMachineConfigPoolCondition(pool.Status, ctrlcommon.MachineConfigPoolNodeDisruptionPreview)
+
+	currentConfigName := pool.Status.Configuration.Name
+	if currentConfigName == "" || currentConfigName == generated.Name {
+		if current == nil || current.Status == corev1.ConditionFalse {
+			return false, nil
+		}
+		cond := apihelpers.NewMachineConfigPoolCondition(ctrlcommon.MachineConfigPoolNodeDisruptionPreview, corev1.ConditionFalse, "", "")
+		apihelpers.SetMachineConfigPoolCondition(&pool.Status, *cond)
+		return true, nil
+	}
+
+	currentConfig, err := ctrl.mcLister.Get(currentConfigName)
+	if apierrors.IsNotFound(err) {
+		klog.V(4).Infof("Pool %s: current MachineConfig %s not found, skipping disruption preview", pool.Name, currentConfigName)
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("could not get current MachineConfig %s for MachineConfigPool %s: %w", currentConfigName, pool.Name, err)
+	}
+
+	// The cluster's node disruption policies are merged into the status of
+	// MachineConfiguration/cluster by the operator; wait for that to happen.
+	mcop, err := ctrl.mcopLister.Get(ctrlcommon.MCOOperatorKnobsObjectName)
+	if apierrors.IsNotFound(err) {
+		klog.V(4).Infof("Pool %s: MachineConfiguration %s not found, skipping disruption preview", pool.Name, ctrlcommon.MCOOperatorKnobsObjectName)
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if mcop.Generation != mcop.Status.ObservedGeneration {
+		klog.V(4).Infof("Pool %s: NodeDisruptionPolicyStatus is not up to date, skipping disruption preview", pool.Name)
+		return false, nil
+	}
+
+	var reason, message string
+	if err := ctrlcommon.IsRenderedConfigReconcilable(currentConfig, generated); err != nil {
+		// The MCD refuses such an update, which is reported by the nodes.
+		reason = "Unreconcilable"
+		message = fmt.Sprintf("Updating from %s to %s cannot be done in place: %v", currentConfigName, generated.Name, err)
+	} else {
+		actions, err := ctrlcommon.CalculateNodeDisruptionActions(currentConfig, generated, mcop.Status.NodeDisruptionPolicyStatus.ClusterPolicies, false)
+		if err != nil {
+			return false, fmt.Errorf("could not calculate node disruption actions from %s to %s: %w", currentConfigName, generated.Name, err)
+		}
+		reason, message = getNodeDisruptionPreview(currentConfigName, generated.Name, actions)
+	}
+	if current != nil && current.Status == corev1.ConditionTrue && current.Reason == reason && current.Message == message {
+		return false, nil
+	}
+
+	cond := apihelpers.NewMachineConfigPoolCondition(ctrlcommon.MachineConfigPoolNodeDisruptionPreview, corev1.ConditionTrue, reason, message)
+	apihelpers.SetMachineConfigPoolCondition(&pool.Status, *cond)
+	return true, nil
+}
+
+// setRenderedConfigPinnedCondition reports through the RenderedConfigPinned
+// pool condition whether the pool targets a pinned rendered MachineConfig
+// rather than the generated one.
+func setRenderedConfigPinnedCondition(pool *mcfgv1.MachineConfigPool, target, generated *mcfgv1.MachineConfig) bool {
+	current := apihelpers.GetMachineConfigPoolCondition(pool.Status, ctrlcommon.MachineConfigPoolRenderedConfigPinned)
+
+	var cond *mcfgv1.MachineConfigPoolCondition
+	if pool.Annotations[ctrlcommon.PinnedRenderedConfigAnnotationKey] == "" {
+		if current == nil || current.Status == corev1.ConditionFalse {
+			return false
+		}
+		cond = apihelpers.NewMachineConfigPoolCondition(ctrlcommon.MachineConfigPoolRenderedConfigPinned, corev1.ConditionFalse, "", "")
+	} else {
+		message := fmt.Sprintf("Pool is pinned to %s; the latest rendered MachineConfig is %s", target.Name, generated.Name)
+		if current != nil && current.Status == corev1.ConditionTrue && current.Message == message {
+			return false
+		}
+		cond = apihelpers.NewMachineConfigPoolCondition(ctrlcommon.MachineConfigPoolRenderedConfigPinned, corev1.ConditionTrue, "Pinned", message)
+	}
+
+	apihelpers.SetMachineConfigPoolCondition(&pool.Status, *cond)
+	return true
+}
+
+func getNodeDisruptionPreview(currentConfigName, desiredConfigName string, actions []opv1.NodeDisruptionPolicyStatusAction) (string, string) {
+	reason := "RebootlessUpdate"
+	switch {
+	case apihelpers.CheckNodeDisruptionActionsForTargetActions(actions, opv1.RebootStatusAction):
+		reason = "RebootRequired"
+	case apihelpers.CheckNodeDisruptionActionsForTargetActions(actions, opv1.NoneStatusAction):
+		reason = "NoDisruption"
+	}
+
+	described := []string{}
+	for _, action := range actions {
+		described = append(described, ctrlcommon.NodeDisruptionActionToString(action))
+	}
+
+	return reason, fmt.Sprintf("Updating from %s to %s will: %s", currentConfigName, desiredConfigName, strings.Join(described, ", "))
+}
+
+func getRenderConflictsMessage(renderedName string, overrides []ctrlcommon.MachineConfigOverrideInfo) string {
+	reported := []string{}
+	for i, override := range overrides {
+		if i == maxReportedOverrides {
+			reported = append(reported, fmt.Sprintf("and %d more", len(overrides)-maxReportedOverrides))
+			break
+		}
+		reported = append(reported, override.String())
+	}
+
+	return fmt.Sprintf("%d entries in %s are written by more than one MachineConfig: %s", len(overrides), renderedName, strings.Join(reported, "; "))
+}
+
 // generateRenderedMachineConfig takes all MCs for a given pool and returns a single rendered MC. For ex master-XXXX or worker-XXXX
 func generateRenderedMachineConfig(pool *mcfgv1.MachineConfigPool, configs []*mcfgv1.MachineConfig, cconfig *mcfgv1.ControllerConfig) (*mcfgv1.MachineConfig, error) {
 	// Suppress rendered config generation until a corresponding new controller can roll out too.
@@ -639,6 +1126,16 @@ func generateRenderedMachineConfig(pool *mcfgv1.MachineConfigPool, configs []*mc
 	merged.Annotations[ctrlcommon.GeneratedByControllerVersionAnnotationKey] = version.Hash
 	merged.Annotations[ctrlcommon.ReleaseImageVersionAnnotationKey] = cconfig.Annotations[ctrlcommon.ReleaseImageVersionAnnotationKey]
 
+	provenance, err := ctrlcommon.CalculateMachineConfigProvenance(configs)
+	if err != nil {
+		return nil, err
+	}
+	rawProvenance, err := json.Marshal(provenance)
+	if err != nil {
+		return nil, err
+	}
+	merged.Annotations[ctrlcommon.MachineConfigProvenanceAnnotationKey] = string(rawProvenance)
+
 	// The operator needs to know the user overrode this, so it knows if it needs to skip the
 	// OSImageURL check during upgrade -- if the user took over managing OS upgrades this way,
 	// the operator shouldn't stop the rest of the upgrade from progressing/completing.
@@ -690,6 +1187,10 @@ func RunBootstrap(pools []*mcfgv1.MachineConfigPool, configs []*mcfgv1.MachineCo
 			return nil, nil, err
 		}
 
+		if err := validateBootstrapSecretSources(pcs); err != nil {
+			return nil, nil, err
+		}
+
 		generated, err := generateRenderedMachineConfig(pool, pcs, cconfig)
 		if err != nil {
 			return nil, nil, err
@@ -1,6 +1,7 @@
 package render
 
 import (
+	"context"
 	"fmt"
 	"reflect"
 	"testing"
@@ -19,14 +20,19 @@ import (
 	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/apimachinery/pkg/util/diff"
 	utilrand "k8s.io/apimachinery/pkg/util/rand"
+	kubeinformers "k8s.io/client-go/informers"
 	k8sfake "k8s.io/client-go/kubernetes/fake"
 	core "k8s.io/client-go/testing"
 	"k8s.io/client-go/tools/cache"
 	"k8s.io/client-go/tools/record"
 
 	mcfgv1 "github.com/openshift/api/machineconfiguration/v1"
+	opv1 "github.com/openshift/api/operator/v1"
 	"github.com/openshift/client-go/machineconfiguration/clientset/versioned/fake"
 	informers "github.com/openshift/client-go/machineconfiguration/informers/externalversions"
+	fakeoperatorclient "github.com/openshift/client-go/operator/clientset/versioned/fake"
+	operatorinformer "github.com/openshift/client-go/operator/informers/externalversions"
+	"github.com/openshift/machine-config-operator/pkg/apihelpers"
 	ctrlcommon "github.com/openshift/machine-config-operator/pkg/controller/common"
 	daemonconsts "github.com/openshift/machine-config-operator/pkg/daemon/constants"
 	"github.com/openshift/machine-config-operator/pkg/version"
@@ -41,7 +47,9 @@ var (
 type fixture struct {
 	t *testing.T
 
-	client *fake.Clientset
+	client         *fake.Clientset
+	kubeclient     *k8sfake.Clientset
+	operatorClient *fakeoperatorclient.Clientset
 
 	mcpLister []*mcfgv1.MachineConfigPool
 	mcLister  []*mcfgv1.MachineConfig
@@ -49,6 +57,12 @@ type fixture struct {
 	crcLister []*mcfgv1.ContainerRuntimeConfig
 	mckLister []*mcfgv1.KubeletConfig
 
+	kubeobjects []runtime.Object
+	nodeLister  []*corev1.Node
+
+	operatorObjects []runtime.Object
+	mcopLister      []*opv1.MachineConfiguration
+
 	actions []core.Action
 
 	objects []runtime.Object
@@ -64,22 +78,35 @@ func newFixture(t *testing.T) *fixture {
 func (f *fixture) newController() *Controller {
 	f.client = fake.NewSimpleClientset(f.objects...)
 
+	f.kubeclient = k8sfake.NewSimpleClientset(f.kubeobjects...)
+	f.operatorClient = fakeoperatorclient.NewSimpleClientset(f.operatorObjects...)
+
 	i := informers.NewSharedInformerFactory(f.client, noResyncPeriodFunc())
+	k8sI := kubeinformers.NewSharedInformerFactory(f.kubeclient, noResyncPeriodFunc())
+	oi := operatorinformer.NewSharedInformerFactory(f.operatorClient, noResyncPeriodFunc())
 
 	c := New(i.Machineconfiguration().V1().MachineConfigPools(), i.Machineconfiguration().V1().MachineConfigs(),
-		i.Machineconfiguration().V1().ControllerConfigs(), i.Machineconfiguration().V1().ContainerRuntimeConfigs(), i.Machineconfiguration().V1().KubeletConfigs(), k8sfake.NewSimpleClientset(), f.client)
+		i.Machineconfiguration().V1().ControllerConfigs(), i.Machineconfiguration().V1().ContainerRuntimeConfigs(), i.Machineconfiguration().V1().KubeletConfigs(),
+		k8sI.Core().V1().Nodes(), oi.Operator().V1().MachineConfigurations(), k8sI.Core().V1().Secrets(), f.kubeclient, f.client)
 
 	c.mcpListerSynced = alwaysReady
 	c.mcListerSynced = alwaysReady
 	c.ccListerSynced = alwaysReady
 	c.crcListerSynced = alwaysReady
 	c.mckListerSynced = alwaysReady
+	c.nodeListerSynced = alwaysReady
+	c.mcopListerSynced = alwaysReady
+	c.secretListerSynced = alwaysReady
 	c.eventRecorder = ctrlcommon.NamespacedEventRecorder(&record.FakeRecorder{})
 
 	stopCh := make(chan struct{})
 	defer close(stopCh)
 	i.Start(stopCh)
 	i.WaitForCacheSync(stopCh)
+	k8sI.Start(stopCh)
+	k8sI.WaitForCacheSync(stopCh)
+	oi.Start(stopCh)
+	oi.WaitForCacheSync(stopCh)
 
 	for _, c := range f.ccLister {
 		i.Machineconfiguration().V1().ControllerConfigs().Informer().GetIndexer().Add(c)
@@ -103,6 +130,14 @@ func (f *fixture) newController() *Controller {
 		i.Machineconfiguration().V1().KubeletConfigs().Informer().GetIndexer().Add(m)
 	}
 
+	for _, n := range f.nodeLister {
+		k8sI.Core().V1().Nodes().Informer().GetIndexer().Add(n)
+	}
+
+	for _, m := range f.mcopLister {
+		oi.Operator().V1().MachineConfigurations().Informer().GetIndexer().Add(m)
+	}
+
 	return c
 }
 
@@ -599,3 +634,500 @@ func TestGenerateMachineConfigValidation(t *testing.T) {
 	assert.Error(t, err)
 	assert.Nil(t, gmc)
 }
+
+func TestGarbageCollectRenderedConfigs(t *testing.T) {
+	newRendered := func(pool *mcfgv1.MachineConfigPool, name string, age time.Duration) *mcfgv1.MachineConfig {
+		mc := helpers.NewMachineConfig(name, nil, "dummy://", []ign3types.File{})
+		mc.CreationTimestamp = metav1.NewTime(time.Now().Add(-age))
+		mc.SetOwnerReferences([]metav1.OwnerReference{*metav1.NewControllerRef(pool, controllerKind)})
+		return mc
+	}
+
+	testCases := []struct {
+		name            string
+		annotations     map[string]string
+		nodes           []*corev1.Node
+		mosbs           []*mcfgv1.MachineOSBuild
+		expectedDeleted []string
+	}{
+		{
+			name:        "No retention annotation",
+			annotations: nil,
+		},
+		{
+			name:        "Invalid retention annotation",
+			annotations: map[string]string{ctrlcommon.RenderedConfigRetentionAnnotationKey: "zero"},
+		},
+		{
+			name:            "Deletes configs outside of retention",
+			annotations:     map[string]string{ctrlcommon.RenderedConfigRetentionAnnotationKey: "2"},
+			expectedDeleted: []string{"rendered-worker-3", "rendered-worker-4"},
+		},
+		{
+			name:        "Keeps configs referenced by nodes",
+			annotations: map[string]string{ctrlcommon.RenderedConfigRetentionAnnotationKey: "2"},
+			nodes: []*corev1.Node{
+				helpers.NewNodeBuilder("node-0").WithConfigs("rendered-worker-4", "rendered-worker-1").Node(),
+			},
+			expectedDeleted: []string{"rendered-worker-3"},
+		},
+		{
+			name:        "Keeps configs referenced by MachineOSBuilds",
+			annotations: map[string]string{ctrlcommon.RenderedConfigRetentionAnnotationKey: "1"},
+			mosbs: []*mcfgv1.MachineOSBuild{
+				{
+					ObjectMeta: metav1.ObjectMeta{Name: "mosb"},
+					Spec: mcfgv1.MachineOSBuildSpec{
+						MachineConfig: mcfgv1.MachineConfigReference{Name: "rendered-worker-2"},
+					},
+				},
+			},
+			expectedDeleted: []string{"rendered-worker-3", "rendered-worker-4"},
+		},
+		{
+			name: "Keeps pinned config",
+			annotations: map[string]string{
+				ctrlcommon.RenderedConfigRetentionAnnotationKey: "2",
+				ctrlcommon.PinnedRenderedConfigAnnotationKey:    "rendered-worker-4",
+			},
+			expectedDeleted: []string{"rendered-worker-3"},
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			f := newFixture(t)
+			mcp := helpers.NewMachineConfigPool("worker", helpers.WorkerSelector, nil, "rendered-worker-1")
+			mcp.UID = types.UID(utilrand.String(5))
+			mcp.Annotations = testCase.annotations
+
+			otherPool := helpers.NewMachineConfigPool("infra", helpers.WorkerSelector, nil, "rendered-infra-1")
+			otherPool.UID = types.UID(utilrand.String(5))
+
+			mcs := []*mcfgv1.MachineConfig{
+				newRendered(mcp, "rendered-worker-1", time.Hour),
+				newRendered(mcp, "rendered-worker-2", 2*time.Hour),
+				newRendered(mcp, "rendered-worker-3", 3*time.Hour),
+				newRendered(mcp, "rendered-worker-4", 4*time.Hour),
+				newRendered(otherPool, "rendered-infra-1", 5*time.Hour),
+				helpers.NewMachineConfig("00-worker", map[string]string{"node-role/worker": ""}, "dummy://", []ign3types.File{}),
+			}
+
+			f.mcpLister = append(f.mcpLister, mcp, otherPool)
+			f.objects = append(f.objects, mcp, otherPool)
+			f.mcLister = append(f.mcLister, mcs...)
+			for idx := range mcs {
+				f.objects = append(f.objects, mcs[idx])
+			}
+			for idx := range testCase.mosbs {
+				f.objects = append(f.objects, testCase.mosbs[idx])
+			}
+			f.nodeLister = append(f.nodeLister, testCase.nodes...)
+
+			c := f.newController()
+			require.NoError(t, c.garbageCollectRenderedConfigs(mcp))
+
+			deleted := []string{}
+			for _, action := range f.client.Actions() {
+				if deleteAction, ok := action.(core.DeleteAction); ok && action.Matches("delete", "machineconfigs") {
+					deleted = append(deleted, deleteAction.GetName())
+				}
+			}
+
+			if testCase.expectedDeleted == nil {
+				testCase.expectedDeleted = []string{}
+			}
+			assert.ElementsMatch(t, testCase.expectedDeleted, deleted)
+		})
+	}
+}
+
+func TestGarbageCollectRenderedConfigsReportsInvalidRetentionOnce(t *testing.T) {
+	f := newFixture(t)
+	mcp := helpers.NewMachineConfigPool("worker", helpers.WorkerSelector, nil, "rendered-worker-1")
+	mcp.Annotations = map[string]string{ctrlcommon.RenderedConfigRetentionAnnotationKey: "zero"}
+	f.mcpLister = append(f.mcpLister, mcp)
+	f.objects = append(f.objects, mcp)
+
+	c := f.newController()
+	recorder := record.NewFakeRecorder(10)
+	c.eventRecorder = recorder
+
+	for i := 0; i < 3; i++ {
+		require.NoError(t, c.garbageCollectRenderedConfigs(mcp))
+	}
+	assert.Len(t, recorder.Events, 1)
+
+	// A different invalid value is reported again.
+	mcp.Annotations[ctrlcommon.RenderedConfigRetentionAnnotationKey] = "-1"
+	require.NoError(t, c.garbageCollectRenderedConfigs(mcp))
+	require.NoError(t, c.garbageCollectRenderedConfigs(mcp))
+	assert.Len(t, recorder.Events, 2)
+}
+
+func TestSyncRenderConflictsStatus(t *testing.T) {
+	overrides := `{"overrides":[{"kind":"file","name":"/etc/foo","source":"50-user","overriddenBy":["60-user"]}]}`
+
+	testCases := []struct {
+		name              string
+		provenance        string
+		currentCondition  *mcfgv1.MachineConfigPoolCondition
+		expectedCondition *mcfgv1.MachineConfigPoolCondition
+	}{
+		{
+			name: "No overrides and no condition",
+		},
+		{
+			name:       "Overrides set the condition",
+			provenance: overrides,
+			expectedCondition: &mcfgv1.MachineConfigPoolCondition{
+				Type:    ctrlcommon.MachineConfigPoolRenderConflicts,
+				Status:  corev1.ConditionTrue,
+				Reason:  "MachineConfigOverrides",
+				Message: "1 entries in rendered-worker-1 are written by more than one MachineConfig: file /etc/foo from 50-user overridden by 60-user",
+			},
+		},
+		{
+			name:             "Resolved overrides clear the condition",
+			provenance:       `{}`,
+			currentCondition: apihelpers.NewMachineConfigPoolCondition(ctrlcommon.MachineConfigPoolRenderConflicts, corev1.ConditionTrue, "MachineConfigOverrides", "old"),
+			expectedCondition: &mcfgv1.MachineConfigPoolCondition{
+				Type:   ctrlcommon.MachineConfigPoolRenderConflicts,
+				Status: corev1.ConditionFalse,
+			},
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			f := newFixture(t)
+			mcp := helpers.NewMachineConfigPool("worker", helpers.WorkerSelector, nil, "rendered-worker-1")
+			if testCase.currentCondition != nil {
+				apihelpers.SetMachineConfigPoolCondition(&mcp.Status, *testCase.currentCondition)
+			}
+			f.objects = append(f.objects, mcp)
+
+			generated := helpers.NewMachineConfig("rendered-worker-1", nil, "dummy://", []ign3types.File{})
+			if testCase.provenance != "" {
+				generated.Annotations[ctrlcommon.MachineConfigProvenanceAnnotationKey] = testCase.provenance
+			}
+
+			c := f.newController()
+			require.NoError(t, c.syncRenderedConfigStatus(mcp, generated, generated))
+
+			updated, err := f.client.MachineconfigurationV1().MachineConfigPools().Get(context.TODO(), mcp.Name, metav1.GetOptions{})
+			require.NoError(t, err)
+
+			cond := apihelpers.GetMachineConfigPoolCondition(updated.Status, ctrlcommon.MachineConfigPoolRenderConflicts)
+			if testCase.expectedCondition == nil {
+				assert.Nil(t, cond)
+				return
+			}
+			require.NotNil(t, cond)
+			assert.Equal(t, testCase.expectedCondition.Status, cond.Status)
+			assert.Equal(t, testCase.expectedCondition.Reason, cond.Reason)
+			assert.Equal(t, testCase.expectedCondition.Message, cond.Message)
+		})
+	}
+}
+
+func TestSyncNodeDisruptionPreview(t *testing.T) {
+	clusterPolicies := apihelpers.MergeClusterPolicies(opv1.NodeDisruptionPolicyConfig{})
+
+	testCases := []struct {
+		name              string
+		currentConfig     string
+		files             []ign3types.File
+		kargs             []string
+		fips              bool
+		mcopOutdated      bool
+		currentCondition  *mcfgv1.MachineConfigPoolCondition
+		expectedCondition *mcfgv1.MachineConfigPoolCondition
+	}{
+		{
+			name:          "Pool is up to date",
+			currentConfig: "rendered-worker-2",
+		},
+		{
+			name:          "File with a reload policy",
+			currentConfig: "rendered-worker-1",
+			files:         []ign3types.File{helpers.CreateEncodedIgn3File("/etc/containers/policy.json", "{}", 0o644)},
+			expectedCondition: &mcfgv1.MachineConfigPoolCondition{
+				Status:  corev1.ConditionTrue,
+				Reason:  "RebootlessUpdate",
+				Message: "Updating from rendered-worker-1 to rendered-worker-2 will: Reload crio.service",
+			},
+		},
+		{
+			name:          "File without a policy",
+			currentConfig: "rendered-worker-1",
+			files:         []ign3types.File{helpers.CreateEncodedIgn3File("/etc/foo", "foo", 0o644)},
+			expectedCondition: &mcfgv1.MachineConfigPoolCondition{
+				Status:  corev1.ConditionTrue,
+				Reason:  "RebootRequired",
+				Message: "Updating from rendered-worker-1 to rendered-worker-2 will: Reboot",
+			},
+		},
+		{
+			name:          "Kernel arguments always reboot",
+			currentConfig: "rendered-worker-1",
+			kargs:         []string{"foo=bar"},
+			expectedCondition: &mcfgv1.MachineConfigPoolCondition{
+				Status:  corev1.ConditionTrue,
+				Reason:  "RebootRequired",
+				Message: "Updating from rendered-worker-1 to rendered-worker-2 will: Reboot",
+			},
+		},
+		{
+			name:          "FIPS cannot be changed in place",
+			currentConfig: "rendered-worker-1",
+			fips:          true,
+			expectedCondition: &mcfgv1.MachineConfigPoolCondition{
+				Status:  corev1.ConditionTrue,
+				Reason:  "Unreconcilable",
+				Message: "Updating from rendered-worker-1 to rendered-worker-2 cannot be done in place: new machineconfig \"rendered-worker-1\" is not reconcilable against \"rendered-worker-2\": detected change to FIPS flag; refusing to modify FIPS on a running cluster",
+			},
+		},
+		{
+			name:          "Node disruption policies not yet synced",
+			currentConfig: "rendered-worker-1",
+			files:         []ign3types.File{helpers.CreateEncodedIgn3File("/etc/foo", "foo", 0o644)},
+			mcopOutdated:  true,
+		},
+		{
+			name:             "Completed update clears the condition",
+			currentConfig:    "rendered-worker-2",
+			currentCondition: apihelpers.NewMachineConfigPoolCondition(ctrlcommon.MachineConfigPoolNodeDisruptionPreview, corev1.ConditionTrue, "RebootRequired", "old"),
+			expectedCondition: &mcfgv1.MachineConfigPoolCondition{
+				Status: corev1.ConditionFalse,
+			},
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			f := newFixture(t)
+			mcp := helpers.NewMachineConfigPool("worker", helpers.WorkerSelector, nil, "rendered-worker-2")
+			mcp.Status.Configuration.Name = testCase.currentConfig
+			if testCase.currentCondition != nil {
+				apihelpers.SetMachineConfigPoolCondition(&mcp.Status, *testCase.currentCondition)
+			}
+			f.objects = append(f.objects, mcp)
+
+			current := helpers.NewMachineConfig("rendered-worker-1", nil, "dummy://", []ign3types.File{})
+			generated := helpers.NewMachineConfigExtended("rendered-worker-2", nil, nil, testCase.files, nil, nil, nil, testCase.fips, testCase.kargs, "", "dummy://")
+			f.mcLister = append(f.mcLister, current, generated)
+
+			mcop := &opv1.MachineConfiguration{
+				ObjectMeta: metav1.ObjectMeta{Name: ctrlcommon.MCOOperatorKnobsObjectName, Generation: 1},
+				Status: opv1.MachineConfigurationStatus{
+					ObservedGeneration:         1,
+					NodeDisruptionPolicyStatus: opv1.NodeDisruptionPolicyStatus{ClusterPolicies: clusterPolicies},
+				},
+			}
+			if testCase.mcopOutdated {
+				mcop.Generation = 2
+			}
+			f.mcopLister = append(f.mcopLister, mcop)
+
+			c := f.newController()
+			require.NoError(t, c.syncRenderedConfigStatus(mcp, generated, generated))
+
+			updated, err := f.client.MachineconfigurationV1().MachineConfigPools().Get(context.TODO(), mcp.Name, metav1.GetOptions{})
+			require.NoError(t, err)
+
+			cond := apihelpers.GetMachineConfigPoolCondition(updated.Status, ctrlcommon.MachineConfigPoolNodeDisruptionPreview)
+			if testCase.expectedCondition == nil {
+				assert.Nil(t, cond)
+				return
+			}
+			require.NotNil(t, cond)
+			assert.Equal(t, testCase.expectedCondition.Status, cond.Status)
+			assert.Equal(t, testCase.expectedCondition.Reason, cond.Reason)
+			assert.Equal(t, testCase.expectedCondition.Message, cond.Message)
+		})
+	}
+}
+
+func TestPinnedRenderedConfig(t *testing.T) {
+	mcs := []*mcfgv1.MachineConfig{
+		helpers.NewMachineConfig("00-worker", map[string]string{"node-role/worker": ""}, "dummy://", []ign3types.File{}),
+	}
+	cc := newControllerConfig(ctrlcommon.ControllerConfigName)
+
+	newRendered := func(pool *mcfgv1.MachineConfigPool, name string) *mcfgv1.MachineConfig {
+		mc := helpers.NewMachineConfig(name, nil, "dummy://", []ign3types.File{})
+		mc.SetOwnerReferences([]metav1.OwnerReference{*metav1.NewControllerRef(pool, controllerKind)})
+		return mc
+	}
+
+	testCases := []struct {
+		name              string
+		pin               string
+		expectErr         bool
+		expectPinned      bool
+		expectedCondition corev1.ConditionStatus
+	}{
+		{
+			name: "Not pinned",
+		},
+		{
+			name:              "Pinned to an earlier config",
+			pin:               "rendered-worker-old",
+			expectPinned:      true,
+			expectedCondition: corev1.ConditionTrue,
+		},
+		{
+			name:      "Pinned to a missing config",
+			pin:       "rendered-worker-missing",
+			expectErr: true,
+		},
+		{
+			name:      "Pinned to a config of another pool",
+			pin:       "rendered-infra-old",
+			expectErr: true,
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			f := newFixture(t)
+			mcp := helpers.NewMachineConfigPool("worker", helpers.WorkerSelector, nil, "")
+			otherPool := helpers.NewMachineConfigPool("infra", helpers.WorkerSelector, nil, "")
+
+			generated, err := generateRenderedMachineConfig(mcp, mcs, cc)
+			require.NoError(t, err)
+			mcp.Spec.Configuration.Name = generated.Name
+			mcp.Status.Configuration.Name = generated.Name
+			if testCase.pin != "" {
+				mcp.Annotations = map[string]string{ctrlcommon.PinnedRenderedConfigAnnotationKey: testCase.pin}
+			}
+
+			rendered := []*mcfgv1.MachineConfig{generated, newRendered(mcp, "rendered-worker-old"), newRendered(otherPool, "rendered-infra-old")}
+			f.ccLister = append(f.ccLister, cc)
+			f.mcpLister = append(f.mcpLister, mcp)
+			f.objects = append(f.objects, mcp)
+			for _, mc := range append(rendered, mcs...) {
+				f.mcLister = append(f.mcLister, mc)
+				f.objects = append(f.objects, mc)
+			}
+
+			c := f.newController()
+			err = c.syncGeneratedMachineConfig(mcp, mcs)
+			if testCase.expectErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+
+			updated, err := f.client.MachineconfigurationV1().MachineConfigPools().Get(context.TODO(), mcp.Name, metav1.GetOptions{})
+			require.NoError(t, err)
+
+			cond := apihelpers.GetMachineConfigPoolCondition(updated.Status, ctrlcommon.MachineConfigPoolRenderedConfigPinned)
+			if !testCase.expectPinned {
+				assert.Equal(t, generated.Name, updated.Spec.Configuration.Name)
+				assert.Nil(t, cond)
+				return
+			}
+			assert.Equal(t, testCase.pin, updated.Spec.Configuration.Name)
+			require.NotNil(t, cond)
+			assert.Equal(t, testCase.expectedCondition, cond.Status)
+			assert.Equal(t, fmt.Sprintf("Pool is pinned to %s; the latest rendered MachineConfig is %s", testCase.pin, generated.Name), cond.Message)
+		})
+	}
+}
+
+func TestPinSecretFileSources(t *testing.T) {
+	mcp := helpers.NewMachineConfigPool("test-cluster-master", helpers.MasterSelector, nil, "")
+	source := ctrlcommon.InClusterSource{Resource: "secrets", Name: "tokens", Key: "token"}.String()
+	mcs := []*mcfgv1.MachineConfig{
+		helpers.NewMachineConfig("00-test-cluster-master", map[string]string{"node-role/master": ""}, "dummy-test-1", []ign3types.File{}),
+		helpers.NewMachineConfig("99-token", map[string]string{"node-role/master": ""}, "", []ign3types.File{{
+			Node:          ign3types.Node{Path: "/etc/token"},
+			FileEmbedded1: ign3types.FileEmbedded1{Contents: ign3types.Resource{Source: &source}},
+		}}),
+	}
+	cc := newControllerConfig(ctrlcommon.ControllerConfigName)
+	getSecret := func(token string) func(string) (*corev1.Secret, error) {
+		return func(name string) (*corev1.Secret, error) {
+			if name != "tokens" {
+				return nil, fmt.Errorf("secret %s not found", name)
+			}
+			return &corev1.Secret{Data: map[string][]byte{"token": []byte(token)}}, nil
+		}
+	}
+	render := func(token string) *mcfgv1.MachineConfig {
+		pinned, err := pinSecretFileSources(mcs, getSecret(token))
+		require.NoError(t, err)
+		// MachineConfigs without Secret sources are left as is.
+		assert.Same(t, mcs[0], pinned[0])
+		generated, err := generateRenderedMachineConfig(mcp, pinned, cc)
+		require.NoError(t, err)
+		return generated
+	}
+
+	// The rendered config only holds the reference and the hash.
+	generated := render("secret-token")
+	ignCfg, err := ctrlcommon.ParseAndConvertConfig(generated.Spec.Config.Raw)
+	require.NoError(t, err)
+	require.Len(t, ignCfg.Storage.Files, 1)
+	assert.Equal(t, source, *ignCfg.Storage.Files[0].Contents.Source)
+	assert.Equal(t, ctrlcommon.FileSourceHash([]byte("secret-token")), *ignCfg.Storage.Files[0].Contents.Verification.Hash)
+	assert.NotContains(t, string(generated.Spec.Config.Raw), "secret-token")
+
+	// The source MachineConfig is not modified.
+	userCfg, err := ctrlcommon.ParseAndConvertConfig(mcs[1].Spec.Config.Raw)
+	require.NoError(t, err)
+	assert.Nil(t, userCfg.Storage.Files[0].Contents.Verification.Hash)
+
+	// Rotating the Secret renders a new config.
+	assert.NotEqual(t, generated.Name, render("rotated-token").Name)
+	assert.Equal(t, generated.Name, render("secret-token").Name)
+
+	// Missing Secrets and keys fail the render.
+	_, err = pinSecretFileSources(mcs, func(string) (*corev1.Secret, error) { return nil, fmt.Errorf("not found") })
+	assert.Error(t, err)
+	_, err = pinSecretFileSources(mcs, func(string) (*corev1.Secret, error) { return &corev1.Secret{}, nil })
+	assert.Error(t, err)
+}
+
+func TestRunBootstrapRejectsSecretFileSources(t *testing.T) {
+	source := ctrlcommon.InClusterSource{Resource: "secrets", Name: "tokens", Key: "token"}.String()
+	mcs := []*mcfgv1.MachineConfig{
+		helpers.NewMachineConfig("00-test-cluster-master", map[string]string{"node-role/master": ""}, "dummy-test-1", []ign3types.File{}),
+		helpers.NewMachineConfig("99-token", map[string]string{"node-role/master": ""}, "", []ign3types.File{{
+			Node:          ign3types.Node{Path: "/etc/token"},
+			FileEmbedded1: ign3types.FileEmbedded1{Contents: ign3types.Resource{Source: &source}},
+		}}),
+	}
+	cc := newControllerConfig(ctrlcommon.ControllerConfigName)
+	pools := []*mcfgv1.MachineConfigPool{helpers.NewMachineConfigPool("master", helpers.MasterSelector, nil, "")}
+
+	_, _, err := RunBootstrap(pools, mcs, cc)
+	assert.ErrorContains(t, err, "MachineConfig 99-token references secrets [tokens]")
+
+	_, generated, err := RunBootstrap(pools, mcs[:1], cc)
+	require.NoError(t, err)
+	assert.Len(t, generated, 1)
+}
+
+func TestSecretUpdateEnqueuesPools(t *testing.T) {
+	f := newFixture(t)
+	mcp := helpers.NewMachineConfigPool("test-cluster-master", helpers.MasterSelector, nil, "")
+	source := ctrlcommon.InClusterSource{Resource: "secrets", Name: "tokens", Key: "token"}.String()
+	mc := helpers.NewMachineConfig("99-token", map[string]string{"node-role/master": ""}, "", []ign3types.File{{
+		Node:          ign3types.Node{Path: "/etc/token"},
+		FileEmbedded1: ign3types.FileEmbedded1{Contents: ign3types.Resource{Source: &source}},
+	}})
+	f.mcpLister = append(f.mcpLister, mcp)
+	f.mcLister = append(f.mcLister, mc)
+	c := f.newController()
+
+	enqueued := []string{}
+	c.enqueueMachineConfigPool = func(pool *mcfgv1.MachineConfigPool) { enqueued = append(enqueued, pool.Name) }
+
+	c.enqueuePoolsForSecret("other")
+	assert.Empty(t, enqueued)
+	c.enqueuePoolsForSecret("tokens")
+	assert.Equal(t, []string{mcp.Name}, enqueued)
+}
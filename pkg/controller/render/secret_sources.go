@@ -0,0 +1,183 @@
+package render
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+
+	mcfgv1 "github.com/openshift/api/machineconfiguration/v1"
+	ctrlcommon "github.com/openshift/machine-config-operator/pkg/controller/common"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/klog/v2"
+)
+
+// getSecretSources returns the names of the Secrets the files of a
+// MachineConfig reference.
+func getSecretSources(config *mcfgv1.MachineConfig) (sets.Set[string], error) {
+	names := sets.New[string]()
+	// Most MachineConfigs have no in-cluster sources, spare parsing them.
+	if !bytes.Contains(config.Spec.Config.Raw, []byte(ctrlcommon.InClusterSourceHost)) {
+		return names, nil
+	}
+	ignCfg, err := ctrlcommon.ParseAndConvertConfig(config.Spec.Config.Raw)
+	if err != nil {
+		return nil, fmt.Errorf("parsing Ignition config failed for MachineConfig %s: %w", config.Name, err)
+	}
+	for _, file := range ignCfg.Storage.Files {
+		if ref, _ := parseSecretSource(file.Contents.Source); ref != nil {
+			names.Insert(ref.Name)
+		}
+	}
+	return names, nil
+}
+
+func parseSecretSource(source *string) (*ctrlcommon.InClusterSource, error) {
+	if !ctrlcommon.IsRemoteFileSource(source) {
+		return nil, nil
+	}
+	ref, err := ctrlcommon.ParseInClusterSource(*source)
+	if err != nil || ref == nil || !ref.IsSecret() {
+		return nil, err
+	}
+	return ref, nil
+}
+
+// validateBootstrapSecretSources rejects MachineConfigs with Secret sources
+// in bootstrap. Their hash cannot be pinned, as the Secrets cannot be read
+// before the API server is up, and the daemon refuses files without one.
+func validateBootstrapSecretSources(configs []*mcfgv1.MachineConfig) error {
+	for _, config := range configs {
+		names, err := getSecretSources(config)
+		if err != nil {
+			return err
+		}
+		if names.Len() > 0 {
+			return fmt.Errorf("MachineConfig %s references secrets %v: secret file sources are not supported at install time", config.Name, sets.List(names))
+		}
+	}
+	return nil
+}
+
+// pinSecretFileSources returns the MachineConfigs with the hash of the
+// current contents of the Secrets their files reference set as the
+// verification hash of the files. The rendered MachineConfig only holds the
+// reference and the hash, and changes along with the contents of the Secret.
+// MachineConfigs without Secret sources are returned as is.
+func pinSecretFileSources(configs []*mcfgv1.MachineConfig, getSecret func(name string) (*corev1.Secret, error)) ([]*mcfgv1.MachineConfig, error) {
+	pinned := make([]*mcfgv1.MachineConfig, 0, len(configs))
+	for _, config := range configs {
+		names, err := getSecretSources(config)
+		if err != nil {
+			return nil, err
+		}
+		if names.Len() == 0 {
+			pinned = append(pinned, config)
+			continue
+		}
+
+		ignCfg, err := ctrlcommon.ParseAndConvertConfig(config.Spec.Config.Raw)
+		if err != nil {
+			return nil, fmt.Errorf("parsing Ignition config failed for MachineConfig %s: %w", config.Name, err)
+		}
+		for idx, file := range ignCfg.Storage.Files {
+			ref, err := parseSecretSource(file.Contents.Source)
+			if err != nil {
+				return nil, fmt.Errorf("invalid source for %s in MachineConfig %s: %w", file.Path, config.Name, err)
+			}
+			if ref == nil {
+				continue
+			}
+			secret, err := getSecret(ref.Name)
+			if err != nil {
+				return nil, fmt.Errorf("could not get secret %s for %s in MachineConfig %s: %w", ref.Name, file.Path, config.Name, err)
+			}
+			contents, err := ctrlcommon.SecretSourceData(secret, ref.Key)
+			if err != nil {
+				return nil, fmt.Errorf("invalid source for %s in MachineConfig %s: %w", file.Path, config.Name, err)
+			}
+			hash := ctrlcommon.FileSourceHash(contents)
+			ignCfg.Storage.Files[idx].Contents.Verification.Hash = &hash
+		}
+
+		raw, err := json.Marshal(ignCfg)
+		if err != nil {
+			return nil, err
+		}
+		config = config.DeepCopy()
+		config.Spec.Config.Raw = raw
+		pinned = append(pinned, config)
+	}
+	return pinned, nil
+}
+
+func (ctrl *Controller) addSecret(obj interface{}) {
+	secret := obj.(*corev1.Secret)
+	klog.V(4).Infof("Secret %s added", secret.Name)
+	ctrl.enqueuePoolsForSecret(secret.Name)
+}
+
+func (ctrl *Controller) updateSecret(old, cur interface{}) {
+	oldSecret := old.(*corev1.Secret)
+	curSecret := cur.(*corev1.Secret)
+	// Resyncs do not change the contents of the Secret.
+	if oldSecret.ResourceVersion == curSecret.ResourceVersion {
+		return
+	}
+	klog.V(4).Infof("Secret %s updated", curSecret.Name)
+	ctrl.enqueuePoolsForSecret(curSecret.Name)
+}
+
+func (ctrl *Controller) deleteSecret(obj interface{}) {
+	secret, ok := obj.(*corev1.Secret)
+	if !ok {
+		tombstone, ok := obj.(cache.DeletedFinalStateUnknown)
+		if !ok {
+			utilruntime.HandleError(fmt.Errorf("Couldn't get object from tombstone %#v", obj))
+			return
+		}
+		secret, ok = tombstone.Obj.(*corev1.Secret)
+		if !ok {
+			utilruntime.HandleError(fmt.Errorf("Tombstone contained object that is not a Secret %#v", obj))
+			return
+		}
+	}
+	klog.V(4).Infof("Secret %s deleted", secret.Name)
+	ctrl.enqueuePoolsForSecret(secret.Name)
+}
+
+// enqueuePoolsForSecret enqueues the pools of the MachineConfigs referencing
+// a Secret, so they are rendered again with its current contents.
+func (ctrl *Controller) enqueuePoolsForSecret(name string) {
+	mcs, err := ctrl.mcLister.List(labels.Everything())
+	if err != nil {
+		klog.Errorf("error listing machineconfigs: %v", err)
+		return
+	}
+	for _, mc := range mcs {
+		// Rendered MachineConfigs are not rendered again themselves.
+		if ref := metav1.GetControllerOf(mc); ref != nil && ref.Kind == controllerKind.Kind {
+			continue
+		}
+		names, err := getSecretSources(mc)
+		if err != nil {
+			klog.Errorf("error finding secrets for machineconfig %s: %v", mc.Name, err)
+			continue
+		}
+		if !names.Has(name) {
+			continue
+		}
+		pools, err := ctrl.getPoolsForMachineConfig(mc)
+		if err != nil {
+			klog.Errorf("error finding pools for machineconfig: %v", err)
+			continue
+		}
+		for _, p := range pools {
+			ctrl.enqueueMachineConfigPool(p)
+		}
+	}
+}
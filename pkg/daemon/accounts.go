@@ -0,0 +1,418 @@
+package daemon
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/user"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+
+	ign3types "github.com/coreos/ignition/v2/config/v3_5/types"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/klog/v2"
+
+	"github.com/openshift/machine-config-operator/pkg/daemon/constants"
+)
+
+// managedAccountsFilePath records the users and groups the MCD created, which
+// are the ones it deletes once they are dropped from the config. Accounts
+// which existed before, such as those shipped with the OS, are only modified,
+// and users among them are locked once dropped from the config.
+var managedAccountsFilePath = filepath.Join("/etc", "machine-config-daemon", "accounts.json")
+
+// managedAccounts are the users and groups created by the MCD, and the users
+// it locked.
+type managedAccounts struct {
+	Users  []string `json:"users"`
+	Groups []string `json:"groups"`
+	Locked []string `json:"locked,omitempty"`
+}
+
+// runAccountCommand, userExists, groupExists and lookupUserHomeDir can be
+// replaced by tests.
+var (
+	runAccountCommand = func(name string, args ...string) error {
+		if out, err := exec.Command(name, args...).CombinedOutput(); err != nil {
+			return fmt.Errorf("%s failed: %s: %w", name, strings.TrimSpace(string(out)), err)
+		}
+		return nil
+	}
+
+	userExists = func(name string) (bool, error) {
+		_, err := user.Lookup(name)
+		var uErr user.UnknownUserError
+		if errors.As(err, &uErr) {
+			return false, nil
+		}
+		return err == nil, err
+	}
+
+	groupExists = func(name string) (bool, error) {
+		_, err := user.LookupGroup(name)
+		var gErr user.UnknownGroupError
+		if errors.As(err, &gErr) {
+			return false, nil
+		}
+		return err == nil, err
+	}
+
+	lookupUserHomeDir = func(name string) (string, error) {
+		u, err := user.Lookup(name)
+		if err != nil {
+			return "", err
+		}
+		return u.HomeDir, nil
+	}
+)
+
+func loadManagedAccounts() (*managedAccounts, error) {
+	accounts := &managedAccounts{}
+	raw, err := os.ReadFile(managedAccountsFilePath)
+	if os.IsNotExist(err) {
+		return accounts, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not read managed accounts: %w", err)
+	}
+	if err := json.Unmarshal(raw, accounts); err != nil {
+		return nil, fmt.Errorf("could not parse managed accounts: %w", err)
+	}
+	return accounts, nil
+}
+
+func (a *managedAccounts) save() error {
+	raw, err := json.Marshal(a)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomically(managedAccountsFilePath, raw, defaultDirectoryPermissions, 0o600, -1, -1)
+}
+
+// useraddArgs returns the useradd arguments creating a user, as Ignition
+// creates it.
+func useraddArgs(u ign3types.PasswdUser) []string {
+	args := []string{"--root", "/"}
+	if u.UID != nil {
+		args = append(args, "--uid", strconv.Itoa(*u.UID))
+	}
+	if u.Gecos != nil {
+		args = append(args, "--comment", *u.Gecos)
+	}
+	if u.HomeDir != nil {
+		args = append(args, "--home-dir", *u.HomeDir)
+	}
+	if u.NoCreateHome != nil && *u.NoCreateHome {
+		args = append(args, "--no-create-home")
+	} else {
+		args = append(args, "--create-home")
+	}
+	if u.PrimaryGroup != nil {
+		args = append(args, "--gid", *u.PrimaryGroup)
+	}
+	if len(u.Groups) > 0 {
+		args = append(args, "--groups", joinGroups(u.Groups))
+	}
+	if u.NoUserGroup != nil && *u.NoUserGroup {
+		args = append(args, "--no-user-group")
+	}
+	if u.System != nil && *u.System {
+		args = append(args, "--system")
+	}
+	if u.NoLogInit != nil && *u.NoLogInit {
+		args = append(args, "--no-log-init")
+	}
+	if u.Shell != nil {
+		args = append(args, "--shell", *u.Shell)
+	}
+	return append(args, u.Name)
+}
+
+// usermodArgs returns the usermod arguments bringing an existing user in line
+// with the config, or nil if the config sets nothing usermod changes. As in
+// Ignition, the supplementary groups of the config replace the existing ones.
+func usermodArgs(u ign3types.PasswdUser) []string {
+	var args []string
+	if u.UID != nil {
+		args = append(args, "--uid", strconv.Itoa(*u.UID))
+	}
+	if u.Gecos != nil {
+		args = append(args, "--comment", *u.Gecos)
+	}
+	if u.HomeDir != nil {
+		args = append(args, "--home", *u.HomeDir)
+	}
+	if u.PrimaryGroup != nil {
+		args = append(args, "--gid", *u.PrimaryGroup)
+	}
+	if len(u.Groups) > 0 {
+		args = append(args, "--groups", joinGroups(u.Groups))
+	}
+	if u.Shell != nil {
+		args = append(args, "--shell", *u.Shell)
+	}
+	if len(args) == 0 {
+		return nil
+	}
+	return append(args, u.Name)
+}
+
+func joinGroups(groups []ign3types.Group) string {
+	names := make([]string, 0, len(groups))
+	for _, g := range groups {
+		names = append(names, string(g))
+	}
+	return strings.Join(names, ",")
+}
+
+// groupaddArgs returns the groupadd arguments creating a group.
+func groupaddArgs(g ign3types.PasswdGroup) []string {
+	args := []string{"--root", "/"}
+	if g.Gid != nil {
+		args = append(args, "--gid", strconv.Itoa(*g.Gid))
+	}
+	if g.PasswordHash != nil {
+		args = append(args, "--password", *g.PasswordHash)
+	}
+	if g.System != nil && *g.System {
+		args = append(args, "--system")
+	}
+	return append(args, g.Name)
+}
+
+// groupmodArgs returns the groupmod arguments bringing an existing group in
+// line with the config, or nil if there is nothing to change.
+func groupmodArgs(g ign3types.PasswdGroup) []string {
+	var args []string
+	if g.Gid != nil {
+		args = append(args, "--gid", strconv.Itoa(*g.Gid))
+	}
+	if g.PasswordHash != nil {
+		args = append(args, "--password", *g.PasswordHash)
+	}
+	if len(args) == 0 {
+		return nil
+	}
+	return append(args, g.Name)
+}
+
+// updateUsersAndGroups creates and modifies the users other than core and
+// the groups of the new config, and deletes those the MCD created which the
+// new config dropped. Password hashes and SSH keys are set afterwards by
+// SetPasswordHash and updateSSHKeys.
+//
+//nolint:gocyclo
+func (dn *Daemon) updateUsersAndGroups(newIgnConfig, oldIgnConfig ign3types.Config) error {
+	if dn.mock {
+		return nil
+	}
+	accounts, err := loadManagedAccounts()
+	if err != nil {
+		return err
+	}
+	managedUsers := sets.New(accounts.Users...)
+	managedGroups := sets.New(accounts.Groups...)
+	lockedUsers := sets.New(accounts.Locked...)
+	save := func() error {
+		accounts.Users = sets.List(managedUsers)
+		accounts.Groups = sets.List(managedGroups)
+		accounts.Locked = sets.List(lockedUsers)
+		return accounts.save()
+	}
+
+	// Groups first, so users can be added to them.
+	newGroups := sets.New[string]()
+	for _, g := range newIgnConfig.Passwd.Groups {
+		newGroups.Insert(g.Name)
+		exists, err := groupExists(g.Name)
+		if err != nil {
+			return fmt.Errorf("failed to check if group %s exists: %w", g.Name, err)
+		}
+		if exists {
+			if args := groupmodArgs(g); args != nil {
+				if err := runAccountCommand("groupmod", args...); err != nil {
+					return fmt.Errorf("failed to modify group %s: %w", g.Name, err)
+				}
+			}
+			continue
+		}
+		logSystem("Creating group %s", g.Name)
+		if err := runAccountCommand("groupadd", groupaddArgs(g)...); err != nil {
+			return fmt.Errorf("failed to create group %s: %w", g.Name, err)
+		}
+		managedGroups.Insert(g.Name)
+		if err := save(); err != nil {
+			return err
+		}
+	}
+
+	newUsers := sets.New[string]()
+	for _, u := range newIgnConfig.Passwd.Users {
+		newUsers.Insert(u.Name)
+		if u.Name == constants.CoreUserName {
+			continue
+		}
+		exists, err := userExists(u.Name)
+		if err != nil {
+			return fmt.Errorf("failed to check if user %s exists: %w", u.Name, err)
+		}
+		if exists {
+			if args := usermodArgs(u); args != nil {
+				if err := runAccountCommand("usermod", args...); err != nil {
+					return fmt.Errorf("failed to modify user %s: %w", u.Name, err)
+				}
+			}
+			// A user locked once dropped from the config is back: its
+			// password hash and SSH keys are set again afterwards.
+			if lockedUsers.Has(u.Name) {
+				logSystem("Unlocking user %s", u.Name)
+				if err := runAccountCommand("usermod", "--expiredate", "", u.Name); err != nil {
+					return fmt.Errorf("failed to unlock user %s: %w", u.Name, err)
+				}
+				lockedUsers.Delete(u.Name)
+				if err := save(); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+		logSystem("Creating user %s", u.Name)
+		if err := runAccountCommand("useradd", useraddArgs(u)...); err != nil {
+			return fmt.Errorf("failed to create user %s: %w", u.Name, err)
+		}
+		managedUsers.Insert(u.Name)
+		if err := save(); err != nil {
+			return err
+		}
+	}
+
+	// Users before groups, which may be their primary group.
+	for _, u := range oldIgnConfig.Passwd.Users {
+		if newUsers.Has(u.Name) || !managedUsers.Has(u.Name) {
+			continue
+		}
+		logSystem("Deleting user %s", u.Name)
+		if exists, err := userExists(u.Name); err != nil {
+			return fmt.Errorf("failed to check if user %s exists: %w", u.Name, err)
+		} else if exists {
+			// The home directory is kept: it may hold data, and it is
+			// reused if the user is created again.
+			if err := runAccountCommand("userdel", u.Name); err != nil {
+				return fmt.Errorf("failed to delete user %s: %w", u.Name, err)
+			}
+		}
+		managedUsers.Delete(u.Name)
+		if err := save(); err != nil {
+			return err
+		}
+	}
+	for _, g := range oldIgnConfig.Passwd.Groups {
+		if newGroups.Has(g.Name) || !managedGroups.Has(g.Name) {
+			continue
+		}
+		logSystem("Deleting group %s", g.Name)
+		if exists, err := groupExists(g.Name); err != nil {
+			return fmt.Errorf("failed to check if group %s exists: %w", g.Name, err)
+		} else if exists {
+			if err := runAccountCommand("groupdel", g.Name); err != nil {
+				return fmt.Errorf("failed to delete group %s: %w", g.Name, err)
+			}
+		}
+		managedGroups.Delete(g.Name)
+		if err := save(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// lockAbsentUser locks a user other than core which was dropped from the
+// config, and removes the SSH keys the MCD wrote for it, unless the MCD
+// created the user, in which case updateUsersAndGroups deletes it. Locking
+// rather than deleting leaves the files of accounts the MCD did not create
+// alone, while nobody can log in as the user anymore.
+func (dn *Daemon) lockAbsentUser(name string) error {
+	accounts, err := loadManagedAccounts()
+	if err != nil {
+		return err
+	}
+	if slices.Contains(accounts.Users, name) || slices.Contains(accounts.Locked, name) {
+		return nil
+	}
+	exists, err := userExists(name)
+	if err != nil {
+		return fmt.Errorf("failed to check if user %s exists: %w", name, err)
+	}
+	if !exists {
+		return nil
+	}
+
+	logSystem("Locking user %s dropped from the config", name)
+	// Expiring the account also denies logins with SSH keys.
+	if err := runAccountCommand("usermod", "--lock", "--expiredate", "1", name); err != nil {
+		return fmt.Errorf("failed to lock user %s: %w", name, err)
+	}
+	homeDir, err := lookupUserHomeDir(name)
+	if err != nil {
+		return fmt.Errorf("failed to look up user %s: %w", name, err)
+	}
+	if err := os.Remove(dn.getUserSSHKeyPath(homeDir)); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove SSH keys of user %s: %w", name, err)
+	}
+	accounts.Locked = append(accounts.Locked, name)
+	return accounts.save()
+}
+
+// getUserSSHKeyPath returns where the SSH keys of a user other than core are
+// written, in its home directory as for core.
+func (dn *Daemon) getUserSSHKeyPath(homeDir string) string {
+	if dn.useNewSSHKeyPath() {
+		return filepath.Join(homeDir, ".ssh", "authorized_keys.d", "ignition")
+	}
+	return filepath.Join(homeDir, ".ssh", "authorized_keys")
+}
+
+// updateUserSSHKeys writes the SSH keys of a user other than core, owned by
+// the user. The keys file is removed once the config has no keys left.
+func (dn *Daemon) updateUserSSHKeys(u ign3types.PasswdUser) error {
+	info, err := user.Lookup(u.Name)
+	if err != nil {
+		return fmt.Errorf("failed to look up user %s: %w", u.Name, err)
+	}
+	authKeyPath := dn.getUserSSHKeyPath(info.HomeDir)
+	if len(u.SSHAuthorizedKeys) == 0 {
+		if err := os.Remove(authKeyPath); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to remove SSH keys of user %s: %w", u.Name, err)
+		}
+		return nil
+	}
+	uid, err := strconv.Atoi(info.Uid)
+	if err != nil {
+		return err
+	}
+	gid, err := strconv.Atoi(info.Gid)
+	if err != nil {
+		return err
+	}
+
+	var keys string
+	for _, k := range u.SSHAuthorizedKeys {
+		keys = keys + string(k) + "\n"
+	}
+	klog.Infof("Writing SSH keys of user %s to %q", u.Name, authKeyPath)
+
+	// The directories are created as the user, so it owns them.
+	sshDir := filepath.Join(info.HomeDir, ".ssh")
+	for _, dir := range []string{sshDir, filepath.Dir(authKeyPath)} {
+		if _, err := os.Stat(dir); os.IsNotExist(err) {
+			if err := runAccountCommand("runuser", "-u", u.Name, "--", "mkdir", "-m", "0700", "-p", dir); err != nil {
+				return fmt.Errorf("failed to create %s: %w", dir, err)
+			}
+		}
+	}
+	return writeFileAtomically(authKeyPath, []byte(keys), os.FileMode(0o700), os.FileMode(0o600), uid, gid)
+}
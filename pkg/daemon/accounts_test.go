@@ -0,0 +1,159 @@
+package daemon
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	ign3types "github.com/coreos/ignition/v2/config/v3_5/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/openshift/machine-config-operator/test/helpers"
+)
+
+func TestAccountArgs(t *testing.T) {
+	u := ign3types.PasswdUser{
+		Name:         "breakglass",
+		UID:          helpers.IntToPtr(1500),
+		HomeDir:      helpers.StrToPtr("/var/home/breakglass"),
+		PrimaryGroup: helpers.StrToPtr("ops"),
+		Groups:       []ign3types.Group{"wheel", "adm"},
+		Shell:        helpers.StrToPtr("/bin/bash"),
+	}
+	assert.Equal(t, []string{"--root", "/", "--uid", "1500", "--home-dir", "/var/home/breakglass", "--create-home", "--gid", "ops", "--groups", "wheel,adm", "--shell", "/bin/bash", "breakglass"}, useraddArgs(u))
+	assert.Equal(t, []string{"--uid", "1500", "--home", "/var/home/breakglass", "--gid", "ops", "--groups", "wheel,adm", "--shell", "/bin/bash", "breakglass"}, usermodArgs(u))
+	assert.Nil(t, usermodArgs(ign3types.PasswdUser{Name: "breakglass"}))
+
+	g := ign3types.PasswdGroup{Name: "ops", Gid: helpers.IntToPtr(1600), System: helpers.BoolToPtr(true)}
+	assert.Equal(t, []string{"--root", "/", "--gid", "1600", "--system", "ops"}, groupaddArgs(g))
+	assert.Equal(t, []string{"--gid", "1600", "ops"}, groupmodArgs(g))
+	assert.Nil(t, groupmodArgs(ign3types.PasswdGroup{Name: "ops"}))
+}
+
+func TestUpdateUsersAndGroups(t *testing.T) {
+	origManagedAccountsFilePath := managedAccountsFilePath
+	origRunAccountCommand := runAccountCommand
+	origUserExists := userExists
+	origGroupExists := groupExists
+	t.Cleanup(func() {
+		managedAccountsFilePath = origManagedAccountsFilePath
+		runAccountCommand = origRunAccountCommand
+		userExists = origUserExists
+		groupExists = origGroupExists
+	})
+	managedAccountsFilePath = filepath.Join(t.TempDir(), "accounts.json")
+
+	// wheel and the automation user are shipped with the node.
+	users := map[string]bool{"automation": true}
+	groups := map[string]bool{"wheel": true}
+	var ran []string
+	runAccountCommand = func(name string, args ...string) error {
+		ran = append(ran, name+" "+strings.Join(args, " "))
+		account := args[len(args)-1]
+		switch name {
+		case "useradd":
+			users[account] = true
+		case "userdel":
+			delete(users, account)
+		case "groupadd":
+			groups[account] = true
+		case "groupdel":
+			delete(groups, account)
+		}
+		return nil
+	}
+	userExists = func(name string) (bool, error) { return users[name], nil }
+	groupExists = func(name string) (bool, error) { return groups[name], nil }
+
+	dn := &Daemon{}
+	oldIgn := ign3types.Config{}
+	newIgn := ign3types.Config{Passwd: ign3types.Passwd{
+		Users: []ign3types.PasswdUser{
+			{Name: "core", SSHAuthorizedKeys: []ign3types.SSHAuthorizedKey{"key"}},
+			{Name: "breakglass", Groups: []ign3types.Group{"ops"}},
+			{Name: "automation", Shell: helpers.StrToPtr("/bin/sh")},
+		},
+		Groups: []ign3types.PasswdGroup{{Name: "ops"}, {Name: "wheel"}},
+	}}
+	require.NoError(t, dn.updateUsersAndGroups(newIgn, oldIgn))
+	assert.Equal(t, []string{
+		"groupadd --root / ops",
+		"useradd --root / --create-home --groups ops breakglass",
+		"usermod --shell /bin/sh automation",
+	}, ran)
+
+	accounts, err := loadManagedAccounts()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"breakglass"}, accounts.Users)
+	assert.Equal(t, []string{"ops"}, accounts.Groups)
+
+	// Only the accounts created by the MCD are deleted, users first.
+	ran = nil
+	require.NoError(t, dn.updateUsersAndGroups(ign3types.Config{}, newIgn))
+	assert.Equal(t, []string{
+		"userdel breakglass",
+		"groupdel ops",
+	}, ran)
+	assert.True(t, users["automation"])
+	assert.True(t, groups["wheel"])
+
+	accounts, err = loadManagedAccounts()
+	require.NoError(t, err)
+	assert.Empty(t, accounts.Users)
+	assert.Empty(t, accounts.Groups)
+}
+
+func TestLockAbsentUser(t *testing.T) {
+	origManagedAccountsFilePath := managedAccountsFilePath
+	origRunAccountCommand := runAccountCommand
+	origUserExists := userExists
+	origGroupExists := groupExists
+	origLookupUserHomeDir := lookupUserHomeDir
+	t.Cleanup(func() {
+		managedAccountsFilePath = origManagedAccountsFilePath
+		runAccountCommand = origRunAccountCommand
+		userExists = origUserExists
+		groupExists = origGroupExists
+		lookupUserHomeDir = origLookupUserHomeDir
+	})
+	managedAccountsFilePath = filepath.Join(t.TempDir(), "accounts.json")
+
+	// The automation user is shipped with the node, breakglass was created
+	// by the MCD.
+	home := t.TempDir()
+	keys := filepath.Join(home, ".ssh", "authorized_keys")
+	require.NoError(t, os.MkdirAll(filepath.Dir(keys), 0o700))
+	require.NoError(t, os.WriteFile(keys, []byte("key\n"), 0o600))
+	require.NoError(t, (&managedAccounts{Users: []string{"breakglass"}}).save())
+
+	var ran []string
+	runAccountCommand = func(name string, args ...string) error {
+		ran = append(ran, name+" "+strings.Join(args, " "))
+		return nil
+	}
+	userExists = func(name string) (bool, error) { return name == "automation" || name == "breakglass", nil }
+	groupExists = func(string) (bool, error) { return false, nil }
+	lookupUserHomeDir = func(string) (string, error) { return home, nil }
+
+	dn := &Daemon{}
+	oldUsers := []ign3types.PasswdUser{{Name: "core"}, {Name: "automation"}, {Name: "breakglass"}}
+	require.NoError(t, dn.deconfigureAbsentUsers([]ign3types.PasswdUser{{Name: "core"}}, oldUsers))
+	require.NoError(t, dn.deconfigureAbsentUsers([]ign3types.PasswdUser{{Name: "core"}}, oldUsers))
+	assert.Equal(t, []string{"usermod --lock --expiredate 1 automation"}, ran)
+	assert.NoFileExists(t, keys)
+
+	accounts, err := loadManagedAccounts()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"automation"}, accounts.Locked)
+
+	// The user is unlocked once it is back in the config.
+	ran = nil
+	newIgn := ign3types.Config{Passwd: ign3types.Passwd{Users: []ign3types.PasswdUser{{Name: "automation"}}}}
+	require.NoError(t, dn.updateUsersAndGroups(newIgn, ign3types.Config{}))
+	assert.Equal(t, []string{"usermod --expiredate  automation"}, ran)
+	accounts, err = loadManagedAccounts()
+	require.NoError(t, err)
+	assert.Empty(t, accounts.Locked)
+}
@@ -6,6 +6,7 @@ import (
 	"os"
 	"path/filepath"
 	"sync"
+	"time"
 
 	ign2types "github.com/coreos/ignition/config/v2_2/types"
 	ign3types "github.com/coreos/ignition/v2/config/v3_5/types"
@@ -21,6 +22,11 @@ type configDriftErr struct {
 	error
 }
 
+// Unwrap allows matching the kind of drift, e.g. to apply the drift policy.
+func (e *configDriftErr) Unwrap() error {
+	return e.error
+}
+
 // Error type for file config drifts
 type fileConfigDriftErr struct {
 	error
@@ -31,6 +37,12 @@ type unitConfigDriftErr struct {
 	error
 }
 
+// Error type for config drifts of the host outside of files and units, e.g.
+// kernel arguments, layered packages or SSH keys
+type hostConfigDriftErr struct {
+	error
+}
+
 type ConfigDriftMonitor interface {
 	Start(ConfigDriftMonitorOpts) error
 	Done() <-chan struct{}
@@ -48,6 +60,14 @@ type ConfigDriftMonitorOpts struct {
 	SystemdPath string
 	// Channel to report unknown errors
 	ErrChan chan<- error
+	// Called periodically to check the full on-disk state of the
+	// MachineConfig, including what fsnotify cannot watch such as kernel
+	// arguments or layered packages. Defaults to validating the files and
+	// units only.
+	Rescan func(*mcfgv1.MachineConfig) error
+	// How often the full on-disk state is rescanned. Zero disables the
+	// periodic rescan.
+	RescanInterval time.Duration
 }
 
 // Holds the Config Drift Watcher and ensures we only have a single instance
@@ -157,6 +177,13 @@ func newConfigDriftWatcher(opts ConfigDriftMonitorOpts) (*configDriftWatcher, er
 		opts.SystemdPath = pathSystemd
 	}
 
+	if opts.Rescan == nil {
+		systemdPath := opts.SystemdPath
+		opts.Rescan = func(mc *mcfgv1.MachineConfig) error {
+			return validateOnDiskState(mc, systemdPath)
+		}
+	}
+
 	c := &configDriftWatcher{
 		ConfigDriftMonitorOpts: opts,
 		stopCh:                 make(chan struct{}),
@@ -224,8 +251,19 @@ func (c *configDriftWatcher) start() {
 
 	go func() {
 		defer c.wg.Done()
+
+		// A nil channel never fires, so without an interval there is no rescan.
+		var rescanCh <-chan time.Time
+		if c.RescanInterval > 0 {
+			ticker := time.NewTicker(c.RescanInterval)
+			defer ticker.Stop()
+			rescanCh = ticker.C
+		}
+
 		for {
 			select {
+			case <-rescanCh:
+				c.rescan()
 			case event := <-c.watcher.Events:
 				// Our watcher is reporting an event that we should look at.
 				if err := c.handleFileEvent(event); err != nil {
@@ -255,6 +293,15 @@ func (c *configDriftWatcher) stop() {
 	klog.Info("Config Drift Monitor has shut down")
 }
 
+// Checks the full on-disk state of the MachineConfig and reports any config
+// drift to the provided callback.
+func (c *configDriftWatcher) rescan() {
+	klog.V(4).Infof("Rescanning on-disk state of %s for config drift", c.MachineConfig.Name)
+	if err := c.Rescan(c.MachineConfig); err != nil {
+		c.OnDrift(&configDriftErr{err})
+	}
+}
+
 // Handles the filesystem event for any of the files we're watching and
 // filters any config drift errors to the provided callback.
 func (c *configDriftWatcher) handleFileEvent(event fsnotify.Event) error {
@@ -318,6 +365,20 @@ func getFilePathsFromIgn3Config(ignConfig ign3types.Config, systemdPath string)
 		}
 	}
 
+	// Directories and links are watched through their parent directories as
+	// well, which see their removal and mode and ownership changes.
+	for _, ignDir := range ignConfig.Storage.Directories {
+		if _, err := os.Stat(ignDir.Path); err == nil {
+			files.Insert(ignDir.Path)
+		}
+	}
+
+	for _, ignLink := range ignConfig.Storage.Links {
+		if _, err := os.Lstat(ignLink.Path); err == nil {
+			files.Insert(ignLink.Path)
+		}
+	}
+
 	// Get all the file paths for systemd dropins from the ignition config
 	for _, unit := range ignConfig.Systemd.Units {
 		unitPath := getIgn3SystemdUnitPath(systemdPath, unit)
@@ -11,6 +11,7 @@ import (
 
 	ign3types "github.com/coreos/ignition/v2/config/v3_5/types"
 	mcfgv1 "github.com/openshift/api/machineconfiguration/v1"
+	ctrlcommon "github.com/openshift/machine-config-operator/pkg/controller/common"
 	"github.com/openshift/machine-config-operator/test/helpers"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -527,3 +528,44 @@ func (tc configDriftMonitorTestCase) onDriftFunc(t *testing.T, err error) {
 		assert.ErrorAs(t, err, &uErr)
 	}
 }
+
+func TestConfigDriftMonitorRescan(t *testing.T) {
+	mc := helpers.CreateMachineConfigFromIgnitionWithMetadata(ctrlcommon.NewIgnConfig(), "rendered-worker-1", "worker")
+	errChan := make(chan error, 5)
+	driftChan := make(chan error, 1)
+	rescanErr := errors.New("unexpected layered packages")
+
+	cdm := NewConfigDriftMonitor()
+	go func() {
+		<-cdm.Done()
+	}()
+
+	require.NoError(t, cdm.Start(ConfigDriftMonitorOpts{
+		ErrChan:       errChan,
+		SystemdPath:   t.TempDir(),
+		MachineConfig: mc,
+		OnDrift: func(err error) {
+			select {
+			case driftChan <- err:
+			default:
+			}
+		},
+		Rescan: func(rescanned *mcfgv1.MachineConfig) error {
+			assert.Equal(t, mc.Name, rescanned.Name)
+			return rescanErr
+		},
+		RescanInterval: 10 * time.Millisecond,
+	}))
+
+	select {
+	case err := <-driftChan:
+		var cdErr *configDriftErr
+		assert.ErrorAs(t, err, &cdErr)
+		assert.ErrorIs(t, err, rescanErr)
+	case <-time.After(time.Second):
+		t.Errorf("expected the rescan to report drift")
+	}
+
+	cdm.Stop()
+	assert.Empty(t, errChan)
+}
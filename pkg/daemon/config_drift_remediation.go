@@ -0,0 +1,315 @@
+package daemon
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	ign3types "github.com/coreos/ignition/v2/config/v3_5/types"
+	features "github.com/openshift/api/features"
+	mcfgv1 "github.com/openshift/api/machineconfiguration/v1"
+	opv1 "github.com/openshift/api/operator/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog/v2"
+
+	ctrlcommon "github.com/openshift/machine-config-operator/pkg/controller/common"
+	"github.com/openshift/machine-config-operator/pkg/daemon/constants"
+	"github.com/openshift/machine-config-operator/pkg/helpers"
+)
+
+// configDriftPolicy is what the MCD does when the files or units on a node
+// drift from its current MachineConfig.
+type configDriftPolicy string
+
+const (
+	// configDriftPolicyDegrade marks the node Degraded. This is the default.
+	configDriftPolicyDegrade configDriftPolicy = "Degrade"
+	// configDriftPolicyRemediate rewrites the drifted files and units from
+	// the current MachineConfig and runs the node disruption actions a
+	// config change of them would run.
+	configDriftPolicyRemediate configDriftPolicy = "Remediate"
+	// configDriftPolicyReport only reports the drift with an event and a
+	// metric.
+	configDriftPolicyReport configDriftPolicy = "Report"
+)
+
+// getConfigDriftPolicy returns the config drift policy declared on a pool.
+func getConfigDriftPolicy(pool *mcfgv1.MachineConfigPool) (configDriftPolicy, error) {
+	if pool == nil {
+		return configDriftPolicyDegrade, nil
+	}
+	val, ok := pool.Annotations[ctrlcommon.ConfigDriftPolicyAnnotationKey]
+	if !ok {
+		return configDriftPolicyDegrade, nil
+	}
+	switch policy := configDriftPolicy(val); policy {
+	case configDriftPolicyDegrade, configDriftPolicyRemediate, configDriftPolicyReport:
+		return policy, nil
+	default:
+		return "", fmt.Errorf("invalid %s annotation %q on pool %s: must be %s, %s or %s", ctrlcommon.ConfigDriftPolicyAnnotationKey,
+			val, pool.Name, configDriftPolicyDegrade, configDriftPolicyRemediate, configDriftPolicyReport)
+	}
+}
+
+// getConfigDrift returns the files and units of the config which do not
+// match their on-disk state.
+func getConfigDrift(ignConfig ign3types.Config, systemdPath string) ([]ign3types.File, []ign3types.Unit) {
+	var files []ign3types.File
+	for _, f := range ignConfig.Storage.Files {
+		if err := checkV3Files([]ign3types.File{f}); err != nil {
+			files = append(files, f)
+		}
+	}
+	var units []ign3types.Unit
+	for _, u := range ignConfig.Systemd.Units {
+		if err := checkV3Unit(u, systemdPath); err != nil {
+			units = append(units, u)
+		}
+	}
+	return files, units
+}
+
+// getStorageDrift returns the directories and links of the config which do
+// not match their on-disk state.
+func getStorageDrift(ignConfig ign3types.Config) ([]ign3types.Directory, []ign3types.Link) {
+	var dirs []ign3types.Directory
+	for _, d := range ignConfig.Storage.Directories {
+		if err := checkV3Directories([]ign3types.Directory{d}); err != nil {
+			dirs = append(dirs, d)
+		}
+	}
+	var links []ign3types.Link
+	for _, l := range ignConfig.Storage.Links {
+		if err := checkV3Links([]ign3types.Link{l}); err != nil {
+			links = append(links, l)
+		}
+	}
+	return dirs, links
+}
+
+// getConfigDriftActions returns the node disruption actions of a config
+// change of the drifted paths and units. Without node disruption policies,
+// the legacy post config change actions are used instead.
+func getConfigDriftActions(filePaths []string, units []ign3types.Unit, policies *opv1.NodeDisruptionPolicyClusterStatus) []opv1.NodeDisruptionPolicyStatusAction {
+	unitNames := []string{}
+	for _, u := range units {
+		unitNames = append(unitNames, u.Name)
+	}
+
+	if policies != nil {
+		return ctrlcommon.CalculateNodeDisruptionActionsFromMCDiffs(false, filePaths, unitNames, *policies)
+	}
+
+	diff := &machineConfigDiff{MachineConfigDiff: ctrlcommon.MachineConfigDiff{Files: len(filePaths) > 0, Units: len(units) > 0}}
+	actions := []opv1.NodeDisruptionPolicyStatusAction{}
+	for _, action := range calculatePostConfigChangeActionsForDiff(diff, filePaths) {
+		switch action {
+		case postConfigChangeActionNone:
+			actions = append(actions, opv1.NodeDisruptionPolicyStatusAction{Type: opv1.NoneStatusAction})
+		case postConfigChangeActionReloadCrio:
+			actions = append(actions, opv1.NodeDisruptionPolicyStatusAction{Type: opv1.ReloadStatusAction, Reload: &opv1.ReloadService{ServiceName: constants.CRIOServiceName}})
+		case postConfigChangeActionRestartCrio:
+			actions = append(actions, opv1.NodeDisruptionPolicyStatusAction{Type: opv1.RestartStatusAction, Restart: &opv1.RestartService{ServiceName: constants.CRIOServiceName}})
+		default:
+			actions = append(actions, opv1.NodeDisruptionPolicyStatusAction{Type: opv1.RebootStatusAction})
+		}
+	}
+	return actions
+}
+
+// runDisruptiveConfigDriftActions runs the node disruption actions of a drift
+// remediation which drained the node, the same way an update runs them: the
+// node is rebooted, or uncordoned once the actions ran. It is a variable so
+// tests can replace it.
+var runDisruptiveConfigDriftActions func(dn *Daemon, actions []opv1.NodeDisruptionPolicyStatusAction, configName string) error
+
+func init() {
+	// Assigned here, as the actions of an update start the config drift
+	// monitor, which remediates drift.
+	runDisruptiveConfigDriftActions = (*Daemon).performPostConfigChangeNodeDisruptionAction
+}
+
+// runConfigDriftAction runs a non-disruptive node disruption action. It is a
+// variable so tests can replace it.
+var runConfigDriftAction = func(action opv1.NodeDisruptionPolicyStatusAction) error {
+	switch action.Type {
+	case opv1.RestartStatusAction:
+		return restartService(string(action.Restart.ServiceName))
+	case opv1.ReloadStatusAction:
+		return reloadService(string(action.Reload.ServiceName))
+	case opv1.SpecialStatusAction:
+		return reloadService(constants.CRIOServiceName)
+	case opv1.DaemonReloadStatusAction:
+		return reloadDaemon()
+	default:
+		return nil
+	}
+}
+
+// getNodeDisruptionPolicies returns the node disruption policies of the
+// cluster, or nil if the NodeDisruptionPolicy feature is disabled.
+func (dn *Daemon) getNodeDisruptionPolicies() (*opv1.NodeDisruptionPolicyClusterStatus, error) {
+	if dn.featureGatesAccessor == nil {
+		return nil, nil
+	}
+	fg, err := dn.featureGatesAccessor.CurrentFeatureGates()
+	if err != nil {
+		return nil, err
+	}
+	if !fg.Enabled(features.FeatureGateNodeDisruptionPolicy) {
+		return nil, nil
+	}
+	mcop, err := dn.mcopClient.OperatorV1().MachineConfigurations().Get(context.TODO(), ctrlcommon.MCOOperatorKnobsObjectName, metav1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+	return &mcop.Status.NodeDisruptionPolicyStatus.ClusterPolicies, nil
+}
+
+// remediateConfigDrift rewrites the drifted files, directories, links and
+// units of the current config and runs the node disruption actions of the
+// change. If the actions require it, the node is drained first and the
+// actions are run as for an update, which reboots or uncordons the node.
+func (dn *Daemon) remediateConfigDrift() error {
+	odc, err := dn.getCurrentConfigOnDisk()
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if odc == nil {
+		if odc, err = dn.getCurrentConfigFromNode(); err != nil {
+			return err
+		}
+	}
+	ignConfig, err := ctrlcommon.ParseAndConvertConfig(odc.currentConfig.Spec.Config.Raw)
+	if err != nil {
+		return err
+	}
+
+	files, units := getConfigDrift(ignConfig, pathSystemd)
+	dirs, links := getStorageDrift(ignConfig)
+	if len(files) == 0 && len(units) == 0 && len(dirs) == 0 && len(links) == 0 {
+		// The drift was fixed in the meantime.
+		return nil
+	}
+	filePaths := []string{}
+	for _, d := range dirs {
+		filePaths = append(filePaths, d.Path)
+	}
+	for _, f := range files {
+		filePaths = append(filePaths, f.Path)
+	}
+	for _, l := range links {
+		filePaths = append(filePaths, l.Path)
+	}
+	paths := append([]string{}, filePaths...)
+	for _, u := range units {
+		paths = append(paths, u.Name)
+	}
+
+	policies, err := dn.getNodeDisruptionPolicies()
+	if err != nil {
+		return fmt.Errorf("could not get node disruption policies to remediate config drift: %w", err)
+	}
+	actions := getConfigDriftActions(filePaths, units, policies)
+	// The on-disk contents of the drifted files are not known, so the drain
+	// decision is made as for a change of the current config to itself.
+	drain, err := isDrainRequiredForNodeDisruptionActions(actions, ignConfig, ignConfig, false)
+	if err != nil {
+		return fmt.Errorf("could not remediate config drift: %w", err)
+	}
+	if drain {
+		dn.catchIgnoreSIGTERM()
+		defer dn.CancelSIGTERM()
+		logSystem("Draining node to remediate config drift of %s", strings.Join(paths, ", "))
+		if err := dn.performDrain(); err != nil {
+			return fmt.Errorf("could not remediate config drift: %w", err)
+		}
+	}
+
+	if err := dn.writeDirectories(dirs); err != nil {
+		return fmt.Errorf("could not remediate config drift: %w", err)
+	}
+	if err := dn.writeFiles(files, false); err != nil {
+		return fmt.Errorf("could not remediate config drift: %w", err)
+	}
+	if err := dn.writeLinks(links); err != nil {
+		return fmt.Errorf("could not remediate config drift: %w", err)
+	}
+	if err := dn.writeUnits(units); err != nil {
+		return fmt.Errorf("could not remediate config drift: %w", err)
+	}
+	actionNames := []string{}
+	for _, action := range actions {
+		actionNames = append(actionNames, ctrlcommon.NodeDisruptionActionToString(action))
+	}
+
+	// Reported before the actions run, as the node may reboot.
+	mcdConfigDriftRemediations.Inc()
+	msg := fmt.Sprintf("Remediated config drift of %s from %s with actions: %s", strings.Join(paths, ", "), odc.currentConfig.Name, strings.Join(actionNames, ", "))
+	logSystem("%s", msg)
+	if dn.nodeWriter != nil {
+		dn.nodeWriter.Eventf(corev1.EventTypeNormal, "ConfigDriftRemediated", msg)
+	}
+
+	if drain {
+		if err := runDisruptiveConfigDriftActions(dn, actions, odc.currentConfig.Name); err != nil {
+			return fmt.Errorf("could not remediate config drift: %w", err)
+		}
+		return nil
+	}
+	for _, action := range actions {
+		if err := runConfigDriftAction(action); err != nil {
+			return fmt.Errorf("could not remediate config drift: %s failed: %w", ctrlcommon.NodeDisruptionActionToString(action), err)
+		}
+	}
+	return nil
+}
+
+// getConfigDriftPolicyForNode returns the config drift policy of the pool of
+// the node.
+func (dn *Daemon) getConfigDriftPolicyForNode() (configDriftPolicy, error) {
+	if dn.mcpLister == nil {
+		return configDriftPolicyDegrade, nil
+	}
+	pool, err := helpers.GetPrimaryPoolForNode(dn.mcpLister, dn.node)
+	if err != nil {
+		return configDriftPolicyDegrade, err
+	}
+	return getConfigDriftPolicy(pool)
+}
+
+// handleConfigDrift applies the config drift policy of the node's pool and
+// returns the drift if the node must degrade.
+func (dn *Daemon) handleConfigDrift(driftErr error) error {
+	policy, err := dn.getConfigDriftPolicyForNode()
+	if err != nil {
+		klog.Errorf("Could not get config drift policy, degrading: %v", err)
+		return driftErr
+	}
+
+	switch policy {
+	case configDriftPolicyReport:
+		klog.Warningf("Config drift reported only, as set by the pool: %v", driftErr)
+		return nil
+	case configDriftPolicyRemediate:
+		// Only files, directories, links and units can be rewritten from the
+		// config.
+		var hostErr *hostConfigDriftErr
+		if errors.As(driftErr, &hostErr) {
+			klog.Warningf("Config drift cannot be remediated, degrading: %v", driftErr)
+			return driftErr
+		}
+		if err := dn.remediateConfigDrift(); err != nil {
+			if dn.nodeWriter != nil {
+				dn.nodeWriter.Eventf(corev1.EventTypeWarning, "ConfigDriftRemediationFailed", err.Error())
+			}
+			return &configDriftErr{err}
+		}
+		return nil
+	default:
+		return driftErr
+	}
+}
@@ -0,0 +1,199 @@
+package daemon
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	ign3types "github.com/coreos/ignition/v2/config/v3_5/types"
+	apicfgv1 "github.com/openshift/api/config/v1"
+	features "github.com/openshift/api/features"
+	mcfgv1 "github.com/openshift/api/machineconfiguration/v1"
+	opv1 "github.com/openshift/api/operator/v1"
+	mcopfake "github.com/openshift/client-go/operator/clientset/versioned/fake"
+	"github.com/openshift/library-go/pkg/operator/configobserver/featuregates"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	ctrlcommon "github.com/openshift/machine-config-operator/pkg/controller/common"
+	"github.com/openshift/machine-config-operator/pkg/daemon/constants"
+	"github.com/openshift/machine-config-operator/test/helpers"
+)
+
+func TestGetConfigDriftPolicy(t *testing.T) {
+	tests := []struct {
+		name       string
+		annotation *string
+		expected   configDriftPolicy
+		expectErr  bool
+	}{{
+		name:     "default",
+		expected: configDriftPolicyDegrade,
+	}, {
+		name:       "remediate",
+		annotation: helpers.StrToPtr("Remediate"),
+		expected:   configDriftPolicyRemediate,
+	}, {
+		name:       "report",
+		annotation: helpers.StrToPtr("Report"),
+		expected:   configDriftPolicyReport,
+	}, {
+		name:       "invalid",
+		annotation: helpers.StrToPtr("remediate"),
+		expectErr:  true,
+	}}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			pool := &mcfgv1.MachineConfigPool{ObjectMeta: metav1.ObjectMeta{Name: "worker", Annotations: map[string]string{}}}
+			if test.annotation != nil {
+				pool.Annotations[ctrlcommon.ConfigDriftPolicyAnnotationKey] = *test.annotation
+			}
+			policy, err := getConfigDriftPolicy(pool)
+			if test.expectErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, test.expected, policy)
+		})
+	}
+}
+
+func TestGetConfigDriftActions(t *testing.T) {
+	file := func(path string) []string {
+		return []string{path}
+	}
+	units := []ign3types.Unit{{Name: "foo.service"}}
+	policies := &opv1.NodeDisruptionPolicyClusterStatus{
+		Files: []opv1.NodeDisruptionPolicyStatusFile{{
+			Path:    "/etc/ssh/sshd_config",
+			Actions: []opv1.NodeDisruptionPolicyStatusAction{{Type: opv1.RestartStatusAction, Restart: &opv1.RestartService{ServiceName: "sshd.service"}}},
+		}},
+	}
+
+	tests := []struct {
+		name       string
+		files      []string
+		units      []ign3types.Unit
+		policies   *opv1.NodeDisruptionPolicyClusterStatus
+		expected   []opv1.NodeDisruptionPolicyStatusAction
+		disruptive bool
+	}{{
+		name:     "legacy none",
+		files:    file("/var/lib/kubelet/config.json"),
+		expected: []opv1.NodeDisruptionPolicyStatusAction{{Type: opv1.NoneStatusAction}},
+	}, {
+		name:     "legacy reload crio",
+		files:    file(constants.ContainerRegistryPolicyPath),
+		expected: []opv1.NodeDisruptionPolicyStatusAction{{Type: opv1.ReloadStatusAction, Reload: &opv1.ReloadService{ServiceName: constants.CRIOServiceName}}},
+	}, {
+		name:       "legacy unit",
+		units:      units,
+		expected:   []opv1.NodeDisruptionPolicyStatusAction{{Type: opv1.RebootStatusAction}},
+		disruptive: true,
+	}, {
+		name:     "node disruption policy",
+		files:    file("/etc/ssh/sshd_config"),
+		policies: policies,
+		expected: []opv1.NodeDisruptionPolicyStatusAction{{Type: opv1.RestartStatusAction, Restart: &opv1.RestartService{ServiceName: "sshd.service"}}},
+	}, {
+		name:       "no node disruption policy",
+		files:      file("/etc/logrotate.conf"),
+		policies:   policies,
+		expected:   []opv1.NodeDisruptionPolicyStatusAction{{Type: opv1.RebootStatusAction}},
+		disruptive: true,
+	}}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			actions := getConfigDriftActions(test.files, test.units, test.policies)
+			assert.Equal(t, test.expected, actions)
+			drain, err := isDrainRequiredForNodeDisruptionActions(actions, ctrlcommon.NewIgnConfig(), ctrlcommon.NewIgnConfig(), false)
+			require.NoError(t, err)
+			assert.Equal(t, test.disruptive, drain)
+		})
+	}
+}
+
+func TestRemediateConfigDrift(t *testing.T) {
+	dir := t.TempDir()
+	remediated := filepath.Join(dir, "remediated")
+	kept := filepath.Join(dir, "kept")
+	require.NoError(t, os.WriteFile(remediated, []byte("drifted\n"), defaultFilePermissions))
+	require.NoError(t, os.WriteFile(kept, []byte("kept\n"), defaultFilePermissions))
+
+	ignCfg := ctrlcommon.NewIgnConfig()
+	ignCfg.Storage.Files = []ign3types.File{
+		ctrlcommon.NewIgnFile(remediated, "expected\n"),
+		ctrlcommon.NewIgnFile(kept, "kept\n"),
+	}
+	mc := helpers.CreateMachineConfigFromIgnitionWithMetadata(ignCfg, "rendered-worker-1", "worker")
+	currentConfigPath := filepath.Join(dir, "currentconfig")
+	raw, err := json.Marshal(mc)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(currentConfigPath, raw, 0o644))
+
+	files, units := getConfigDrift(ignCfg, pathSystemd)
+	require.Len(t, files, 1)
+	assert.Equal(t, remediated, files[0].Path)
+	assert.Empty(t, units)
+
+	var ran, ranDisruptive []opv1.NodeDisruptionPolicyStatusAction
+	origRunConfigDriftAction := runConfigDriftAction
+	origRunDisruptiveConfigDriftActions := runDisruptiveConfigDriftActions
+	t.Cleanup(func() {
+		runConfigDriftAction = origRunConfigDriftAction
+		runDisruptiveConfigDriftActions = origRunDisruptiveConfigDriftActions
+	})
+	runConfigDriftAction = func(action opv1.NodeDisruptionPolicyStatusAction) error {
+		ran = append(ran, action)
+		return nil
+	}
+	runDisruptiveConfigDriftActions = func(_ *Daemon, actions []opv1.NodeDisruptionPolicyStatusAction, configName string) error {
+		assert.Equal(t, "rendered-worker-1", configName)
+		ranDisruptive = append(ranDisruptive, actions...)
+		return nil
+	}
+
+	restart := opv1.NodeDisruptionPolicyStatusAction{Type: opv1.RestartStatusAction, Restart: &opv1.RestartService{ServiceName: "foo.service"}}
+	mcop := &opv1.MachineConfiguration{
+		ObjectMeta: metav1.ObjectMeta{Name: ctrlcommon.MCOOperatorKnobsObjectName},
+		Status: opv1.MachineConfigurationStatus{NodeDisruptionPolicyStatus: opv1.NodeDisruptionPolicyStatus{
+			ClusterPolicies: opv1.NodeDisruptionPolicyClusterStatus{
+				Files: []opv1.NodeDisruptionPolicyStatusFile{{Path: remediated, Actions: []opv1.NodeDisruptionPolicyStatusAction{restart}}},
+			},
+		}},
+	}
+	dn := &Daemon{
+		currentConfigPath: currentConfigPath,
+		currentImagePath:  filepath.Join(dir, "currentimage"),
+		mcopClient:        mcopfake.NewSimpleClientset(mcop),
+	}
+
+	// Without node disruption policies, the drifted file requires a reboot,
+	// which is run as for an update.
+	require.NoError(t, dn.remediateConfigDrift())
+	contents, err := os.ReadFile(remediated)
+	require.NoError(t, err)
+	assert.Equal(t, "expected\n", string(contents))
+	assert.Empty(t, ran)
+	assert.Equal(t, []opv1.NodeDisruptionPolicyStatusAction{{Type: opv1.RebootStatusAction}}, ranDisruptive)
+
+	ranDisruptive = nil
+	require.NoError(t, os.WriteFile(remediated, []byte("drifted\n"), defaultFilePermissions))
+	dn.featureGatesAccessor = featuregates.NewHardcodedFeatureGateAccess([]apicfgv1.FeatureGateName{features.FeatureGateNodeDisruptionPolicy}, nil)
+	require.NoError(t, dn.remediateConfigDrift())
+	contents, err = os.ReadFile(remediated)
+	require.NoError(t, err)
+	assert.Equal(t, "expected\n", string(contents))
+	assert.Equal(t, []opv1.NodeDisruptionPolicyStatusAction{restart}, ran)
+	assert.Empty(t, ranDisruptive)
+
+	// Once remediated, there is nothing left to do.
+	ran = nil
+	require.NoError(t, dn.remediateConfigDrift())
+	assert.Empty(t, ran)
+}
@@ -13,6 +13,7 @@ import (
 	"os"
 	"os/exec"
 	"os/signal"
+	"os/user"
 	"path/filepath"
 	"slices"
 	"strings"
@@ -25,6 +26,7 @@ import (
 	"github.com/openshift/library-go/pkg/operator/configobserver/featuregates"
 
 	ign3types "github.com/coreos/ignition/v2/config/v3_5/types"
+	rpmostreeclient "github.com/coreos/rpmostree-client-go/pkg/client"
 	"github.com/google/go-cmp/cmp"
 	"github.com/google/renameio"
 	"golang.org/x/time/rate"
@@ -146,9 +148,29 @@ type Daemon struct {
 	booting bool
 	// rebootQueued is true when the node is waiting for graceful shutdown
 	rebootQueued bool
-
-	currentConfigPath string
-	currentImagePath  string
+	// statusLock guards the writes of node and rebootQueued, and the cached
+	// OS status, which the status listener reads from its own goroutine.
+	statusLock sync.Mutex
+	// osStatus is the cached rpm-ostree status of the node, or osStatusErr
+	// the error querying it, once osStatusQueried is set.
+	osStatus        *rpmostreeclient.Status
+	osStatusErr     error
+	osStatusQueried bool
+
+	currentConfigPath  string
+	currentImagePath   string
+	fileTransactionDir string
+	// openFileTransactions holds the directories of the file transactions
+	// of the updates this process is running, which must not be recovered.
+	openFileTransactions map[string]bool
+
+	// updateRecorder records the plan and step timings of the last update
+	// for the node status.
+	updateRecorder *updateRecorder
+
+	// configDriftStatus is the last config drift, for the node status.
+	configDriftStatus     ConfigDriftStatus
+	configDriftStatusLock sync.Mutex
 
 	// Config Drift Monitor
 	configDriftMonitor ConfigDriftMonitor
@@ -193,11 +215,27 @@ const (
 	// against annotation changes.
 	currentImagePath = "/etc/machine-config-daemon/currentimage"
 
+	// fileTransactionDir is where we journal the files and units an update is
+	// about to change, so they can be restored if the update is interrupted.
+	fileTransactionDir = "/etc/machine-config-daemon/transaction"
+
+	// lastUpdatePath is where we record the plan and step timings of the last
+	// update, for the node status.
+	lastUpdatePath = "/etc/machine-config-daemon/lastupdate"
+
+	// updateHistoryPath is where we keep the history of the updates of the
+	// node.
+	updateHistoryPath = "/etc/machine-config-daemon/updatehistory"
+
 	// originalContainerBin is the path at which we've stashed the MCD container's /usr/bin
 	// in the host namespace.  We use this for executing any extra binaries we have in our
 	// container image.
 	originalContainerBin = "/run/machine-config-daemon-bin"
 
+	// configDriftRescanInterval is how often the Config Drift Monitor checks
+	// the full on-disk state, including what fsnotify cannot watch.
+	configDriftRescanInterval = 30 * time.Minute
+
 	kubeletHealthzPollingInterval = 30 * time.Second
 	kubeletHealthzTimeout         = 30 * time.Second
 
@@ -353,6 +391,8 @@ func New(
 		exitCh:                 exitCh,
 		currentConfigPath:      currentConfigPath,
 		currentImagePath:       currentImagePath,
+		fileTransactionDir:     fileTransactionDir,
+		updateRecorder:         newUpdateRecorder(lastUpdatePath, updateHistoryPath),
 		configDriftMonitor:     NewConfigDriftMonitor(),
 		osImageMux:             &sync.Mutex{},
 	}, nil
@@ -438,7 +478,7 @@ func (dn *Daemon) HypershiftConnect(
 	if err != nil {
 		klog.Fatalf("Cannot fetch node object: %v", err)
 	}
-	dn.node = node
+	dn.setNode(node)
 
 	nodeInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
 		AddFunc:    dn.handleNodeEvent,
@@ -746,7 +786,7 @@ func (dn *Daemon) syncNode(key string) error {
 	node = node.DeepCopy()
 
 	if dn.node == nil {
-		dn.node = node
+		dn.setNode(node)
 		if err := dn.initializeNode(); err != nil {
 			return err
 		}
@@ -762,7 +802,7 @@ func (dn *Daemon) syncNode(key string) error {
 		if oldReason != newReason {
 			klog.Infof("Transitioned from degraded/unreconcilable reason %v -> %v", oldReason, newReason)
 		}
-		dn.node = node
+		dn.setNode(node)
 	}
 
 	// Sync our OS image pull secrets here. This will account for any changes to
@@ -855,6 +895,11 @@ func (dn *Daemon) syncNode(key string) error {
 			return err
 		}
 
+		// Check that the update can be applied before draining the node for it.
+		if err := dn.runUpdatePreflightChecks(ufc.currentConfig, ufc.desiredConfig, ufc.currentImage, ufc.desiredImage); err != nil {
+			return err
+		}
+
 		if err := dn.triggerUpdate(ufc.currentConfig, ufc.desiredConfig, ufc.currentImage, ufc.desiredImage); err != nil {
 			// if MC was not found, let user know where they can find more info on this.
 			maybeReportOnMissingMC(err)
@@ -949,7 +994,17 @@ func (dn *Daemon) runPreflightConfigDriftCheck() error {
 
 	start := time.Now()
 
-	if err := dn.validateOnDiskStateOrImage(currentOnDisk.currentConfig, currentOnDisk.currentImage); err != nil {
+	err = dn.validateOnDiskStateOrImage(currentOnDisk.currentConfig, currentOnDisk.currentImage)
+	// Config drift is handled as set by the pool, e.g. remediated before the
+	// update starts.
+	var fileErr *fileConfigDriftErr
+	var unitErr *unitConfigDriftErr
+	var hostErr *hostConfigDriftErr
+	if errors.As(err, &fileErr) || errors.As(err, &unitErr) || errors.As(err, &hostErr) {
+		mcdConfigDrift.SetToCurrentTime()
+		err = dn.handleConfigDrift(err)
+	}
+	if err != nil {
 		dn.nodeWriter.Eventf(corev1.EventTypeWarning, "PreflightConfigDriftCheckFailed", err.Error())
 		klog.Errorf("Preflight config drift check failed: %v", err)
 		return &configDriftErr{err}
@@ -1466,10 +1521,13 @@ func (dn *Daemon) kubeletRebootstrap(ctx context.Context) error {
 // Called whenever the on-disk config has drifted from the current machineconfig.
 func (dn *Daemon) onConfigDrift(err error) {
 	mcdConfigDrift.SetToCurrentTime()
+	dn.recordConfigDrift(err)
 	dn.nodeWriter.Eventf(corev1.EventTypeWarning, "ConfigDriftDetected", err.Error())
 	klog.Error(err)
-	if err := dn.updateErrorState(err); err != nil {
-		klog.Errorf("Could not update annotation: %v", err)
+	if err := dn.handleConfigDrift(err); err != nil {
+		if err := dn.updateErrorState(err); err != nil {
+			klog.Errorf("Could not update annotation: %v", err)
+		}
 	}
 }
 
@@ -1517,6 +1575,10 @@ func (dn *Daemon) startConfigDriftMonitor() {
 		SystemdPath:   pathSystemd,
 		ErrChan:       dn.exitCh,
 		MachineConfig: odc.currentConfig,
+		Rescan: func(mc *mcfgv1.MachineConfig) error {
+			return dn.validateOnDiskStateOrImage(mc, odc.currentImage)
+		},
+		RescanInterval: configDriftRescanInterval,
 	}
 
 	if err := dn.configDriftMonitor.Start(opts); err != nil {
@@ -2162,7 +2224,13 @@ func (dn *Daemon) checkStateOnFirstRun() error {
 		return err
 	}
 	// Update our cached copy
-	dn.node = node
+	dn.setNode(node)
+
+	// Finish the file writes of an update we were interrupted in, before
+	// looking at the state of the node.
+	if err := dn.recoverFileTransaction(); err != nil {
+		return err
+	}
 
 	state, err := dn.getStateAndConfigs()
 	if err != nil {
@@ -2338,11 +2406,22 @@ func (dn *Daemon) updateConfigAndState(state *stateAndConfigs) (bool, bool, erro
 		if err != nil {
 			klog.Errorf("Error making MCN for Resumed true: %v", err)
 		}
+
+		// Only gate nodes coming out of an update; a restart of the MCD on a
+		// node which is already Done must not degrade it.
+		if !state.bootstrapping && state.state != constants.MachineConfigDaemonStateDone {
+			if err := dn.runHealthGates(state.currentConfig.GetName()); err != nil {
+				UpdateStateMetric(mcdUpdateState, "", err.Error())
+				return missingODC, inDesiredConfig, err
+			}
+		}
+
 		klog.Infof("Completing update to target %s", state.getCurrentName())
 		if err := dn.completeUpdate(state.currentConfig.GetName()); err != nil {
 			UpdateStateMetric(mcdUpdateState, "", err.Error())
 			return missingODC, inDesiredConfig, err
 		}
+		dn.finishUpdateRecord(state.currentConfig.GetName(), nil)
 
 		// We update the node annotation, and pop an event saying we're done.
 		if dn.nodeWriter != nil {
@@ -2538,7 +2617,10 @@ func (dn *Daemon) completeUpdate(desiredConfigName string) error {
 
 	ctx := context.TODO()
 
-	if err := wait.PollUntilContextTimeout(ctx, 10*time.Second, 10*time.Minute, false, func(ctx context.Context) (bool, error) {
+	// The controller runs the post-update lifecycle hooks of the pool first.
+	uncordonTimeout := 10*time.Minute + dn.getLifecycleHooksTimeout(ctrlcommon.LifecycleHookStagePostUpdate)
+
+	if err := wait.PollUntilContextTimeout(ctx, 10*time.Second, uncordonTimeout, false, func(ctx context.Context) (bool, error) {
 		node, err := dn.kubeClient.CoreV1().Nodes().Get(ctx, dn.name, metav1.GetOptions{})
 		if err != nil {
 			klog.Warningf("Failed to get node: %v", err)
@@ -2550,7 +2632,7 @@ func (dn *Daemon) completeUpdate(desiredConfigName string) error {
 		return true, nil
 	}); err != nil {
 		if wait.Interrupted(err) {
-			failMsg := fmt.Sprintf("failed to uncordon node: %s after 10 minutes. Please see machine-config-controller logs for more information", dn.node.Name)
+			failMsg := fmt.Sprintf("failed to uncordon node: %s after %v. Please see machine-config-controller logs for more information", dn.node.Name, uncordonTimeout)
 			dn.nodeWriter.Eventf(corev1.EventTypeWarning, "FailedToUncordon", failMsg)
 			return errors.New(failMsg)
 		}
@@ -2660,11 +2742,84 @@ func (dn *Daemon) validateOnDiskStateImpl(currentConfig *mcfgv1.MachineConfig, i
 	if dn.os.IsCoreOSVariant() {
 		coreOSDaemon := CoreOSDaemon{dn}
 		if err := coreOSDaemon.validateKernelArguments(currentConfig); err != nil {
+			return &hostConfigDriftErr{err}
+		}
+		// Layered images carry their packages in the image itself.
+		if imageToCheck == currentConfig.Spec.OSImageURL {
+			if err := coreOSDaemon.validateLayeredPackages(currentConfig); err != nil {
+				return &hostConfigDriftErr{err}
+			}
+		}
+	}
+
+	if err := validateOnDiskState(currentConfig, pathSystemd); err != nil {
+		return err
+	}
+
+	if err := dn.validateUnitsAndUsers(currentConfig); err != nil {
+		return &hostConfigDriftErr{err}
+	}
+	return nil
+}
+
+// validateLayeredPackages checks that the packages layered on the booted
+// deployment match the extensions and kernel type of the config.
+func (dn *CoreOSDaemon) validateLayeredPackages(currentConfig *mcfgv1.MachineConfig) error {
+	if dn.NodeUpdaterClient == nil {
+		return nil
+	}
+	// Extensions and kernel types are only applied on these systems.
+	if !dn.os.IsEL() && !dn.os.IsFCOS() {
+		return nil
+	}
+	booted, _, err := dn.NodeUpdaterClient.GetBootedAndStagedDeployment()
+	if err != nil {
+		return err
+	}
+	if dn.os.IsEL() {
+		if err := checkKernelType(currentConfig, booted.RequestedPackages); err != nil {
 			return err
 		}
 	}
+	extra, err := checkLayeredPackages(currentConfig, booted.RequestedPackages, dn.os.IsEL())
+	if err != nil {
+		return err
+	}
+	if len(extra) > 0 {
+		klog.Warningf("Packages not requested by the config are layered on the booted deployment: %v", extra)
+	}
+	return nil
+}
+
+// validateUnitsAndUsers checks the enablement of the units and the SSH keys
+// and password hashes of the users of the config.
+func (dn *Daemon) validateUnitsAndUsers(currentConfig *mcfgv1.MachineConfig) error {
+	if dn.mock {
+		return nil
+	}
+	ignConfig, err := ctrlcommon.ParseAndConvertConfig(currentConfig.Spec.Config.Raw)
+	if err != nil {
+		return fmt.Errorf("failed to parse Ignition for validation: %w", err)
+	}
+	if err := checkUnitsEnablement(ignConfig.Systemd.Units); err != nil {
+		return err
+	}
+	if err := checkUsersAndGroups(ignConfig, dn.getUserSSHKeyPath); err != nil {
+		return err
+	}
 
-	return validateOnDiskState(currentConfig, pathSystemd)
+	// As in updateSSHKeys, the core user is only configured if it exists.
+	if _, err := user.Lookup(constants.CoreUserName); err != nil {
+		return nil
+	}
+	authKeyPath := constants.RHCOS8SSHKeyPath
+	if dn.useNewSSHKeyPath() {
+		authKeyPath = constants.RHCOS9SSHKeyPath
+	}
+	if err := checkSSHKeys(ignConfig.Passwd.Users, authKeyPath); err != nil {
+		return err
+	}
+	return checkPasswordHashes(ignConfig.Passwd.Users, shadowFilePath)
 }
 
 // validateOnDiskState compares the on-disk state against what a configuration
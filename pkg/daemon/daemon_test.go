@@ -354,20 +354,20 @@ func TestSetRunningKargs(t *testing.T) {
 	diff, err = newMachineConfigDiff(oldConfig, newConfig)
 	assert.Nil(t, err)
 	assert.False(t, diff.isEmpty())
-	assert.True(t, diff.kargs)
+	assert.True(t, diff.Kargs)
 
 	newConfig.Spec.KernelArguments = []string{"systemd.unified_cgroup_hierarchy=0", "systemd.legacy_systemd_cgroup_controller=1", "systemd.unified_cgroup_hierarchy=0"}
 	diff, err = newMachineConfigDiff(oldConfig, newConfig)
 	assert.Nil(t, err)
 	assert.False(t, diff.isEmpty())
-	assert.True(t, diff.kargs)
+	assert.True(t, diff.Kargs)
 
 	cmdline = "BOOT_IMAGE=(hd0,gpt3)/ostree/rhcos-c3b004db4/vmlinuz-5.14.0-284.23.1.el9_2.x86_64 systemd.unified_cgroup_hierarchy=0 systemd.unified_cgroup_hierarchy=0 systemd.legacy_systemd_cgroup_controller=1"
 	_ = setRunningKargsWithCmdline(oldConfig, newConfig.Spec.KernelArguments, []byte(cmdline))
 	diff, err = newMachineConfigDiff(oldConfig, newConfig)
 	assert.Nil(t, err)
 	assert.False(t, diff.isEmpty())
-	assert.True(t, diff.kargs)
+	assert.True(t, diff.Kargs)
 
 	cmdline = "BOOT_IMAGE=(hd0,gpt3)/ostree/rhcos-c3b004db4/vmlinuz-5.14.0-284.23.1.el9_2.x86_64 systemd.unified_cgroup_hierarchy=0 systemd.legacy_systemd_cgroup_controller=1 systemd.unified_cgroup_hierarchy=0"
 	_ = setRunningKargsWithCmdline(oldConfig, newConfig.Spec.KernelArguments, []byte(cmdline))
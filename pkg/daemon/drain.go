@@ -98,7 +98,10 @@ func (dn *Daemon) performDrain() error {
 
 	ctx := context.TODO()
 
-	if err := wait.PollUntilContextTimeout(ctx, 10*time.Second, 1*time.Hour, false, func(ctx context.Context) (bool, error) {
+	// The controller runs the pre-drain lifecycle hooks of the pool first.
+	drainTimeout := 1*time.Hour + dn.getLifecycleHooksTimeout(ctrlcommon.LifecycleHookStagePreDrain)
+
+	if err := wait.PollUntilContextTimeout(ctx, 10*time.Second, drainTimeout, false, func(ctx context.Context) (bool, error) {
 		node, err := dn.kubeClient.CoreV1().Nodes().Get(ctx, dn.name, metav1.GetOptions{})
 		if err != nil {
 			klog.Warningf("Failed to get node: %v", err)
@@ -110,7 +113,7 @@ func (dn *Daemon) performDrain() error {
 		return true, nil
 	}); err != nil {
 		if wait.Interrupted(err) {
-			failMsg := fmt.Sprintf("failed to drain node: %s after 1 hour. Please see machine-config-controller logs for more information", dn.node.Name)
+			failMsg := fmt.Sprintf("failed to drain node: %s after %v. Please see machine-config-controller logs for more information", dn.node.Name, drainTimeout)
 			dn.nodeWriter.Eventf(corev1.EventTypeWarning, "FailedToDrain", failMsg)
 
 			return errors.New(failMsg)
@@ -127,6 +130,24 @@ func (dn *Daemon) performDrain() error {
 	return nil
 }
 
+// getLifecycleHooksTimeout returns how long the lifecycle hooks of the given
+// stage of the node's pool may delay the controller acting on a drain or
+// uncordon request.
+func (dn *Daemon) getLifecycleHooksTimeout(stage ctrlcommon.LifecycleHookStage) time.Duration {
+	pool, err := helpers.GetPrimaryPoolForNode(dn.mcpLister, dn.node)
+	if err != nil || pool == nil {
+		return 0
+	}
+	timeout, err := ctrlcommon.GetLifecycleHooksTimeout(pool, stage)
+	if err != nil {
+		// The controller reports invalid hooks; it does not drain the node
+		// until they are fixed.
+		klog.Warningf("Could not get lifecycle hooks: %v", err)
+		return 0
+	}
+	return timeout
+}
+
 // isDrainRequiredForNodeDisruptionActions determines whether node drain is required or not to apply config changes for this set of NodeDisruptionActions
 func isDrainRequiredForNodeDisruptionActions(actions []opv1.NodeDisruptionPolicyStatusAction, oldIgnConfig, newIgnConfig ign3types.Config, overrideImageRegistryDrain bool) (bool, error) {
 	klog.Infof("Checking drain required for node disruption actions")
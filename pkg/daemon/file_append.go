@@ -0,0 +1,244 @@
+package daemon
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	ign3types "github.com/coreos/ignition/v2/config/v3_5/types"
+	"k8s.io/klog/v2"
+
+	ctrlcommon "github.com/openshift/machine-config-operator/pkg/controller/common"
+)
+
+// appendParentDirPath holds the state of the files the MCD appends to without
+// owning their contents: the original file and what was appended to it.
+var appendParentDirPath = filepath.Join("/etc", "machine-config-daemon", "append")
+
+func appendBaseFileName(fpath string) string {
+	return filepath.Join(appendParentDirPath, fpath+".mcdappendbase")
+}
+
+func appendRecordFileName(fpath string) string {
+	return filepath.Join(appendParentDirPath, fpath+".mcdappend")
+}
+
+// appendRecord tracks a file the MCD appends fragments to. The file is
+// rebuilt from its original contents, kept as the base, and the fragments of
+// the config on every update, so fragments dropped from the config go away.
+type appendRecord struct {
+	// Existed is whether the file existed before the MCD appended to it. If
+	// not, there is no base and the file is removed with its fragments.
+	Existed bool `json:"existed"`
+	// Fragments are the SHA-256 digests of the fragments last appended.
+	Fragments []string `json:"fragments"`
+}
+
+// isAppendOnlyFile returns whether a file of the config only appends to the
+// file on disk. Files which also set their contents own the whole file and
+// are handled like any other file.
+func isAppendOnlyFile(file ign3types.File) bool {
+	return len(file.Append) > 0 && file.Contents.Source == nil
+}
+
+// decodeAppendFragments returns the concatenated fragments appended to a file
+// and their digests.
+func decodeAppendFragments(file ign3types.File) ([]byte, []string, error) {
+	var fragments []byte
+	digests := []string{}
+	for _, fragment := range file.Append {
+		contents, err := ctrlcommon.DecodeIgnitionFileContents(fragment.Source, fragment.Compression)
+		if err != nil {
+			return nil, nil, fmt.Errorf("could not decode append fragment of file %q: %w", file.Path, err)
+		}
+		fragments = append(fragments, contents...)
+		digests = append(digests, fmt.Sprintf("sha256:%x", sha256.Sum256(contents)))
+	}
+	return fragments, digests, nil
+}
+
+// loadAppendRecord returns the append record of a file, or nil if the MCD
+// does not append to it.
+func loadAppendRecord(fpath string) (*appendRecord, error) {
+	raw, err := os.ReadFile(appendRecordFileName(fpath))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not read append record of %q: %w", fpath, err)
+	}
+	record := &appendRecord{}
+	if err := json.Unmarshal(raw, record); err != nil {
+		return nil, fmt.Errorf("could not parse append record of %q: %w", fpath, err)
+	}
+	return record, nil
+}
+
+func writeAppendRecord(fpath string, record *appendRecord) error {
+	raw, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomically(appendRecordFileName(fpath), raw, defaultDirectoryPermissions, 0o600, -1, -1)
+}
+
+// captureAppendBase saves the original contents of a file the MCD starts
+// appending to. If a MachineConfig owned the whole file until now, its orig
+// backup or noorig stamp is the original. Otherwise the file on disk is, less
+// the fragments if Ignition already appended them when provisioning the node.
+func captureAppendBase(fpath string, fragments []byte) (*appendRecord, error) {
+	record := &appendRecord{Fragments: []string{}}
+	var (
+		base []byte
+		mode os.FileMode
+	)
+	if _, err := os.Stat(noOrigFileStampName(fpath)); err == nil {
+		klog.Infof("Taking over noorig stamp of %q for append", fpath)
+	} else if fi, err := os.Stat(origFileName(fpath)); err == nil {
+		if base, err = os.ReadFile(origFileName(fpath)); err != nil {
+			return nil, fmt.Errorf("could not read orig file of %q: %w", fpath, err)
+		}
+		record.Existed, mode = true, fi.Mode().Perm()
+	} else if fi, err := os.Stat(fpath); err == nil {
+		if base, err = os.ReadFile(fpath); err != nil {
+			return nil, fmt.Errorf("could not read %q: %w", fpath, err)
+		}
+		if len(fragments) > 0 && bytes.HasSuffix(base, fragments) {
+			klog.Infof("File %q already ends with its append fragments, leaving them out of its base", fpath)
+			base = base[:len(base)-len(fragments)]
+		}
+		record.Existed, mode = true, fi.Mode().Perm()
+	} else if !os.IsNotExist(err) {
+		return nil, fmt.Errorf("could not stat %q: %w", fpath, err)
+	}
+
+	if record.Existed {
+		if err := writeFileAtomically(appendBaseFileName(fpath), base, defaultDirectoryPermissions, mode, -1, -1); err != nil {
+			return nil, fmt.Errorf("could not save base of %q: %w", fpath, err)
+		}
+	}
+	if err := writeAppendRecord(fpath, record); err != nil {
+		return nil, fmt.Errorf("could not write append record of %q: %w", fpath, err)
+	}
+	for _, stale := range []string{origFileName(fpath), noOrigFileStampName(fpath)} {
+		if err := os.Remove(stale); err != nil && !os.IsNotExist(err) {
+			return nil, fmt.Errorf("could not remove %q: %w", stale, err)
+		}
+	}
+	return record, nil
+}
+
+// getAppendBase returns the original contents and mode of a file the MCD
+// appends to, or nil contents if the file did not exist.
+func getAppendBase(fpath string, record *appendRecord) ([]byte, os.FileMode, error) {
+	if !record.Existed {
+		return nil, defaultFilePermissions, nil
+	}
+	fi, err := os.Stat(appendBaseFileName(fpath))
+	if err != nil {
+		return nil, 0, fmt.Errorf("could not stat base of %q: %w", fpath, err)
+	}
+	base, err := os.ReadFile(appendBaseFileName(fpath))
+	if err != nil {
+		return nil, 0, fmt.Errorf("could not read base of %q: %w", fpath, err)
+	}
+	return base, fi.Mode().Perm(), nil
+}
+
+// writeAppendedFile rebuilds a file the config only appends to from its
+// original contents and the fragments of the config.
+func writeAppendedFile(file ign3types.File) error {
+	fragments, digests, err := decodeAppendFragments(file)
+	if err != nil {
+		return err
+	}
+	uid, gid, err := getFileOwnership(file)
+	if err != nil {
+		return fmt.Errorf("failed to retrieve file ownership for file %q: %w", file.Path, err)
+	}
+
+	record, err := loadAppendRecord(file.Path)
+	if err != nil {
+		return err
+	}
+	if record == nil {
+		if record, err = captureAppendBase(file.Path, fragments); err != nil {
+			return err
+		}
+	}
+	base, mode, err := getAppendBase(file.Path, record)
+	if err != nil {
+		return err
+	}
+	// As in Ignition, appending keeps the mode of the file unless set.
+	if file.Mode != nil {
+		mode = os.FileMode(*file.Mode) //nolint:gosec
+	}
+
+	if err := writeFileAtomically(file.Path, append(base, fragments...), defaultDirectoryPermissions, mode, uid, gid); err != nil {
+		return err
+	}
+	record.Fragments = digests
+	return writeAppendRecord(file.Path, record)
+}
+
+// restoreAppendBase puts back the original contents of a file the MCD no
+// longer appends to, or removes the file if it did not exist, and forgets
+// about it. It returns false if the MCD did not append to the file.
+func restoreAppendBase(fpath string) (bool, error) {
+	record, err := loadAppendRecord(fpath)
+	if err != nil || record == nil {
+		return false, err
+	}
+	if record.Existed {
+		if err := restorePathFrom(appendBaseFileName(fpath), fpath); err != nil {
+			return true, err
+		}
+		klog.V(2).Infof("Restored file %q without its append fragments", fpath)
+	} else {
+		if err := os.Remove(fpath); err != nil && !os.IsNotExist(err) {
+			return true, fmt.Errorf("unable to delete %s: %w", fpath, err)
+		}
+		klog.V(2).Infof("Removed appended file %q", fpath)
+	}
+	if err := os.Remove(appendRecordFileName(fpath)); err != nil {
+		return true, fmt.Errorf("deleting append record %q: %w", appendRecordFileName(fpath), err)
+	}
+	return true, nil
+}
+
+// checkAppendedFile validates that a file the config only appends to is its
+// original contents followed by the fragments of the config. Without an
+// append record, the fragments were appended by Ignition when provisioning
+// the node and only the end of the file can be checked.
+func checkAppendedFile(file ign3types.File) error {
+	fragments, _, err := decodeAppendFragments(file)
+	if err != nil {
+		return err
+	}
+	record, err := loadAppendRecord(file.Path)
+	if err != nil {
+		return err
+	}
+	if record == nil {
+		contents, err := os.ReadFile(file.Path)
+		if err != nil {
+			return fmt.Errorf("could not read file %q: %w", file.Path, err)
+		}
+		if !bytes.HasSuffix(contents, fragments) {
+			return fmt.Errorf("content mismatch for file %q: append fragments missing", file.Path)
+		}
+		return nil
+	}
+	base, mode, err := getAppendBase(file.Path, record)
+	if err != nil {
+		return err
+	}
+	if file.Mode != nil {
+		mode = os.FileMode(*file.Mode) //nolint:gosec
+	}
+	return checkFileContentsAndMode(file.Path, append(base, fragments...), mode)
+}
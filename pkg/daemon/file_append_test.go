@@ -0,0 +1,142 @@
+package daemon
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	ign3types "github.com/coreos/ignition/v2/config/v3_5/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vincent-petithory/dataurl"
+)
+
+func appendFile(path string, fragments ...string) ign3types.File {
+	uid, gid := os.Getuid(), os.Getgid()
+	file := ign3types.File{Node: ign3types.Node{
+		Path:  path,
+		User:  ign3types.NodeUser{ID: &uid},
+		Group: ign3types.NodeGroup{ID: &gid},
+	}}
+	for _, fragment := range fragments {
+		source := dataurl.EncodeBytes([]byte(fragment))
+		file.Append = append(file.Append, ign3types.Resource{Source: &source})
+	}
+	return file
+}
+
+func TestAppendToExistingFile(t *testing.T) {
+	testDir, cleanup := setupTempDirWithEtc(t)
+	defer cleanup()
+
+	path := filepath.Join(testDir, "etc", "hosts")
+	require.NoError(t, os.WriteFile(path, []byte("127.0.0.1 localhost\n"), 0o640))
+	require.NoError(t, os.Chmod(path, 0o640))
+
+	// Fragments are appended to the original file, keeping its mode.
+	file := appendFile(path, "10.0.0.1 foo\n", "10.0.0.2 bar\n")
+	require.NoError(t, writeFiles([]ign3types.File{file}, false))
+	contents, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, "127.0.0.1 localhost\n10.0.0.1 foo\n10.0.0.2 bar\n", string(contents))
+	fi, err := os.Stat(path)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0o640), fi.Mode())
+	assert.NoError(t, checkV3Files([]ign3types.File{file}))
+
+	record, err := loadAppendRecord(path)
+	require.NoError(t, err)
+	assert.True(t, record.Existed)
+	assert.Len(t, record.Fragments, 2)
+
+	// A dropped fragment is removed, and writing is idempotent.
+	file = appendFile(path, "10.0.0.2 bar\n")
+	require.NoError(t, writeFiles([]ign3types.File{file}, false))
+	require.NoError(t, writeFiles([]ign3types.File{file}, false))
+	contents, err = os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, "127.0.0.1 localhost\n10.0.0.2 bar\n", string(contents))
+	assert.NoError(t, checkV3Files([]ign3types.File{file}))
+
+	// Local changes are drift.
+	require.NoError(t, os.WriteFile(path, []byte("127.0.0.1 localhost\n"), 0o640))
+	assert.Error(t, checkV3Files([]ign3types.File{file}))
+
+	// Without fragments, the original file is restored.
+	restored, err := restoreAppendBase(path)
+	require.NoError(t, err)
+	assert.True(t, restored)
+	contents, err = os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, "127.0.0.1 localhost\n", string(contents))
+	record, err = loadAppendRecord(path)
+	require.NoError(t, err)
+	assert.Nil(t, record)
+}
+
+func TestAppendToMissingFile(t *testing.T) {
+	testDir, cleanup := setupTempDirWithEtc(t)
+	defer cleanup()
+
+	path := filepath.Join(testDir, "etc", "new")
+	file := appendFile(path, "foo\n")
+	require.NoError(t, writeFiles([]ign3types.File{file}, false))
+	contents, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, "foo\n", string(contents))
+
+	restored, err := restoreAppendBase(path)
+	require.NoError(t, err)
+	assert.True(t, restored)
+	assert.NoFileExists(t, path)
+
+	restored, err = restoreAppendBase(path)
+	require.NoError(t, err)
+	assert.False(t, restored)
+}
+
+func TestAppendAfterIgnition(t *testing.T) {
+	testDir, cleanup := setupTempDirWithEtc(t)
+	defer cleanup()
+
+	// Ignition appended the fragment when provisioning the node.
+	path := filepath.Join(testDir, "etc", "pam")
+	require.NoError(t, os.WriteFile(path, []byte("vendor\nfoo\n"), 0o644))
+	file := appendFile(path, "foo\n")
+	assert.NoError(t, checkV3Files([]ign3types.File{file}))
+
+	file = appendFile(path, "bar\n")
+	assert.Error(t, checkV3Files([]ign3types.File{file}))
+
+	// The fragment is not appended twice.
+	file = appendFile(path, "foo\n")
+	require.NoError(t, writeFiles([]ign3types.File{file}, false))
+	contents, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, "vendor\nfoo\n", string(contents))
+	base, err := os.ReadFile(appendBaseFileName(path))
+	require.NoError(t, err)
+	assert.Equal(t, "vendor\n", string(base))
+}
+
+func TestAppendWithContents(t *testing.T) {
+	testDir, cleanup := setupTempDirWithEtc(t)
+	defer cleanup()
+
+	path := filepath.Join(testDir, "etc", "owned")
+	require.NoError(t, os.WriteFile(path, []byte("vendor\n"), 0o644))
+	file := appendFile(path, "bar\n")
+	require.NoError(t, writeFiles([]ign3types.File{file}, false))
+
+	// Setting the contents takes over the whole file.
+	source := dataurl.EncodeBytes([]byte("foo\n"))
+	file.Contents.Source = &source
+	require.NoError(t, writeFiles([]ign3types.File{file}, false))
+	contents, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, "foo\nbar\n", string(contents))
+	assert.NoError(t, checkV3Files([]ign3types.File{file}))
+	record, err := loadAppendRecord(path)
+	require.NoError(t, err)
+	assert.Nil(t, record)
+}
@@ -0,0 +1,161 @@
+package daemon
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"time"
+
+	ign3types "github.com/coreos/ignition/v2/config/v3_5/types"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog/v2"
+
+	ctrlcommon "github.com/openshift/machine-config-operator/pkg/controller/common"
+)
+
+const (
+	// fileSourceFetchTimeout bounds fetching a remote file source.
+	fileSourceFetchTimeout = 2 * time.Minute
+
+	// maxFileSourceBytes bounds the size of a remote file source, which is
+	// held in memory to be verified before it is written.
+	maxFileSourceBytes = 1 << 30
+)
+
+// hostCABundlePath is the CA bundle of the host, which includes the
+// additional trust bundle of the cluster. It can be replaced by tests.
+var hostCABundlePath = "/etc/pki/tls/certs/ca-bundle.crt"
+
+// newFileSourceHTTPClient returns the client fetching https file sources with
+// the proxy and CA trust of the node.
+func newFileSourceHTTPClient() (*http.Client, error) {
+	pool, err := x509.SystemCertPool()
+	if err != nil {
+		klog.Warningf("Could not load system cert pool: %v", err)
+		pool = x509.NewCertPool()
+	}
+	if bundle, err := os.ReadFile(hostCABundlePath); err == nil {
+		pool.AppendCertsFromPEM(bundle)
+	} else if !os.IsNotExist(err) {
+		return nil, fmt.Errorf("could not read CA bundle %s: %w", hostCABundlePath, err)
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = http.ProxyFromEnvironment
+	transport.TLSClientConfig = &tls.Config{RootCAs: pool, MinVersion: tls.VersionTLS12}
+	return &http.Client{Transport: transport, Timeout: fileSourceFetchTimeout}, nil
+}
+
+// fetchHTTPSFileSource fetches the contents of an https file source.
+func fetchHTTPSFileSource(client *http.Client, contents ign3types.Resource) ([]byte, error) {
+	req, err := http.NewRequest(http.MethodGet, *contents.Source, nil)
+	if err != nil {
+		return nil, err
+	}
+	for _, header := range contents.HTTPHeaders {
+		if header.Value != nil {
+			req.Header.Set(header.Name, *header.Value)
+		}
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxFileSourceBytes+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > maxFileSourceBytes {
+		return nil, fmt.Errorf("contents exceed %d bytes", maxFileSourceBytes)
+	}
+	return data, nil
+}
+
+// fetchInClusterFileSource reads the contents of an in-cluster file source
+// with the credentials of the MCD.
+func (dn *Daemon) fetchInClusterFileSource(ref *ctrlcommon.InClusterSource) ([]byte, error) {
+	if dn.kubeClient == nil {
+		return nil, fmt.Errorf("no cluster access to read %s", ref.Name)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), fileSourceFetchTimeout)
+	defer cancel()
+	if ref.IsSecret() {
+		secret, err := dn.kubeClient.CoreV1().Secrets(ref.Namespace()).Get(ctx, ref.Name, metav1.GetOptions{})
+		if err != nil {
+			return nil, err
+		}
+		return ctrlcommon.SecretSourceData(secret, ref.Key)
+	}
+	cm, err := dn.kubeClient.CoreV1().ConfigMaps(ref.Namespace()).Get(ctx, ref.Name, metav1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+	return ctrlcommon.ConfigMapSourceData(cm, ref.Key)
+}
+
+// resolveFileSources returns the files with the contents of their remote
+// sources fetched, verified against their hash and inlined, so they are
+// written as any other file. All sources are resolved before any file is
+// written, so an unreachable source leaves the files untouched.
+func (dn *Daemon) resolveFileSources(files []ign3types.File) ([]ign3types.File, error) {
+	var client *http.Client
+	resolved := make([]ign3types.File, 0, len(files))
+	for _, file := range files {
+		if !ctrlcommon.IsRemoteFileSource(file.Contents.Source) {
+			resolved = append(resolved, file)
+			continue
+		}
+		ref, err := ctrlcommon.ParseInClusterSource(*file.Contents.Source)
+		if err != nil {
+			return nil, fmt.Errorf("could not resolve source of file %q: %w", file.Path, err)
+		}
+		var contents []byte
+		if ref != nil {
+			klog.Infof("Reading contents of file %q from %s %s", file.Path, ref.Resource, ref.Name)
+			contents, err = dn.fetchInClusterFileSource(ref)
+		} else {
+			if client == nil {
+				if client, err = newFileSourceHTTPClient(); err != nil {
+					return nil, err
+				}
+			}
+			klog.Infof("Fetching contents of file %q from %s", file.Path, *file.Contents.Source)
+			contents, err = fetchHTTPSFileSource(client, file.Contents)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("could not fetch contents of file %q: %w", file.Path, err)
+		}
+		if err := ctrlcommon.VerifyFileSourceHash(file.Contents.Verification, contents); err != nil {
+			return nil, fmt.Errorf("could not verify contents of file %q: %w", file.Path, err)
+		}
+		resolved = append(resolved, ctrlcommon.InlineFileContents(file, contents))
+	}
+	return resolved, nil
+}
+
+// checkFileHashAndMode validates a file with a remote source against the hash
+// of its contents and its mode, without fetching the source.
+func checkFileHashAndMode(file ign3types.File, mode os.FileMode) error {
+	fi, err := os.Lstat(file.Path)
+	if err != nil {
+		return fmt.Errorf("could not stat file %q: %w", file.Path, err)
+	}
+	if fi.Mode() != mode {
+		return fmt.Errorf("mode mismatch for file: %q; expected: %[2]v/%[2]d/%#[2]o; received: %[3]v/%[3]d/%#[3]o", file.Path, mode, fi.Mode())
+	}
+	contents, err := os.ReadFile(file.Path)
+	if err != nil {
+		return fmt.Errorf("could not read file %q: %w", file.Path, err)
+	}
+	if err := ctrlcommon.VerifyFileSourceHash(file.Contents.Verification, contents); err != nil {
+		return fmt.Errorf("content mismatch for file %q: %w", file.Path, err)
+	}
+	return nil
+}
@@ -0,0 +1,102 @@
+package daemon
+
+import (
+	"crypto/sha256"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	ign3types "github.com/coreos/ignition/v2/config/v3_5/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	k8sfake "k8s.io/client-go/kubernetes/fake"
+
+	ctrlcommon "github.com/openshift/machine-config-operator/pkg/controller/common"
+	"github.com/openshift/machine-config-operator/test/helpers"
+)
+
+func remoteFile(path, source string, contents []byte) ign3types.File {
+	uid, gid := os.Getuid(), os.Getgid()
+	return ign3types.File{
+		Node: ign3types.Node{
+			Path:  path,
+			User:  ign3types.NodeUser{ID: &uid},
+			Group: ign3types.NodeGroup{ID: &gid},
+		},
+		FileEmbedded1: ign3types.FileEmbedded1{
+			Mode: helpers.IntToPtr(0o644),
+			Contents: ign3types.Resource{
+				Source:       helpers.StrToPtr(source),
+				Verification: ign3types.Verification{Hash: helpers.StrToPtr(fmt.Sprintf("sha256-%x", sha256.Sum256(contents)))},
+			},
+		},
+	}
+}
+
+func TestWriteFilesFromRemoteSources(t *testing.T) {
+	testDir, cleanup := setupTempDirWithEtc(t)
+	defer cleanup()
+
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer token" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		fmt.Fprint(w, "remote contents\n")
+	}))
+	defer server.Close()
+
+	// The server is trusted through the CA bundle of the host.
+	bundle := filepath.Join(testDir, "ca-bundle.crt")
+	require.NoError(t, os.WriteFile(bundle, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw}), 0o644))
+	origHostCABundlePath := hostCABundlePath
+	hostCABundlePath = bundle
+	t.Cleanup(func() { hostCABundlePath = origHostCABundlePath })
+
+	cmSource := ctrlcommon.InClusterSource{Resource: "configmaps", Name: "bundles", Key: "ca.pem"}
+	secretSource := ctrlcommon.InClusterSource{Resource: "secrets", Name: "tokens", Key: "token"}
+	dn := &Daemon{kubeClient: k8sfake.NewSimpleClientset(&corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: "bundles", Namespace: ctrlcommon.MCONamespace},
+		Data:       map[string]string{"ca.pem": "configmap contents\n"},
+	}, &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "tokens", Namespace: ctrlcommon.FileSourcesNamespace},
+		Data:       map[string][]byte{"token": []byte("secret contents\n")},
+	})}
+
+	httpsFile := remoteFile(filepath.Join(testDir, "etc", "remote"), server.URL+"/remote", []byte("remote contents\n"))
+	httpsFile.Contents.HTTPHeaders = ign3types.HTTPHeaders{{Name: "Authorization", Value: helpers.StrToPtr("Bearer token")}}
+	cmFile := remoteFile(filepath.Join(testDir, "etc", "ca.pem"), cmSource.String(), []byte("configmap contents\n"))
+	secretFile := remoteFile(filepath.Join(testDir, "etc", "token"), secretSource.String(), []byte("secret contents\n"))
+
+	require.NoError(t, dn.writeFiles([]ign3types.File{httpsFile, cmFile, secretFile}, false))
+	for path, expected := range map[string]string{httpsFile.Path: "remote contents\n", cmFile.Path: "configmap contents\n", secretFile.Path: "secret contents\n"} {
+		contents, err := os.ReadFile(path)
+		require.NoError(t, err)
+		assert.Equal(t, expected, string(contents))
+	}
+	// The files are validated against their hash.
+	assert.NoError(t, checkV3Files([]ign3types.File{httpsFile, cmFile, secretFile}))
+	require.NoError(t, os.WriteFile(cmFile.Path, []byte("drifted\n"), 0o644))
+	assert.ErrorContains(t, checkV3Files([]ign3types.File{cmFile}), "content mismatch")
+
+	// Contents which do not match their hash are not written, nor are any
+	// other files.
+	mismatched := remoteFile(filepath.Join(testDir, "etc", "mismatched"), cmSource.String(), []byte("other contents\n"))
+	other := newTestIgnitionFile(1)
+	other.Path = filepath.Join(testDir, "etc", "other")
+	assert.ErrorContains(t, dn.writeFiles([]ign3types.File{other, mismatched}, false), "hash mismatch")
+	assert.NoFileExists(t, mismatched.Path)
+	assert.NoFileExists(t, other.Path)
+
+	// Unreachable sources fail the write.
+	missing := remoteFile(filepath.Join(testDir, "etc", "missing"), ctrlcommon.InClusterSource{Resource: "configmaps", Name: "missing", Key: "key"}.String(), nil)
+	assert.ErrorContains(t, dn.writeFiles([]ign3types.File{missing}, false), "could not fetch")
+	unauthorized := remoteFile(filepath.Join(testDir, "etc", "unauthorized"), server.URL+"/remote", []byte("remote contents\n"))
+	assert.ErrorContains(t, dn.writeFiles([]ign3types.File{unauthorized}, false), "403")
+}
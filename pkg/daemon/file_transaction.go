@@ -0,0 +1,555 @@
+package daemon
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+
+	ign3types "github.com/coreos/ignition/v2/config/v3_5/types"
+	"github.com/google/renameio"
+	kubeErrs "k8s.io/apimachinery/pkg/util/errors"
+	"k8s.io/klog/v2"
+)
+
+// fileTransactionJournal is the name of the journal in the transaction
+// directory. It is written once all backups are on disk and removed first when
+// the transaction ends, so its presence means the transaction is open.
+const fileTransactionJournal = "journal.json"
+
+// Each update keeps its transaction in its own directory under the daemon's
+// transaction directory, named after its depth and config: an update which
+// completes without a reboot can start the next update while its own
+// transaction is still open.
+
+// fileTransactionJournalData is the on-disk journal of a file transaction.
+type fileTransactionJournalData struct {
+	// Config is the name of the MachineConfig the transaction applies.
+	Config string `json:"config"`
+	// Depth is the number of transactions which were open in the same
+	// process when this one was opened.
+	Depth int `json:"depth,omitempty"`
+	// Paths lists the state of the touched paths before the transaction.
+	Paths []fileTransactionPath `json:"paths"`
+	// Units lists the enablement of the touched systemd units before the
+	// transaction, as reported by systemctl is-enabled.
+	Units []fileTransactionUnit `json:"units,omitempty"`
+}
+
+type fileTransactionPath struct {
+	Path   string `json:"path"`
+	Exists bool   `json:"exists"`
+	// Backup is the name of the copy of the file in the transaction
+	// directory, if it is a regular file.
+	Backup string      `json:"backup,omitempty"`
+	Link   string      `json:"link,omitempty"`
+	Mode   os.FileMode `json:"mode,omitempty"`
+	UID    int         `json:"uid,omitempty"`
+	GID    int         `json:"gid,omitempty"`
+}
+
+type fileTransactionUnit struct {
+	Name  string `json:"name"`
+	State string `json:"state"`
+}
+
+// fileTransaction snapshots the files and units an update is about to change,
+// so they can be restored exactly if the update fails, including across an
+// MCD restart.
+type fileTransaction struct {
+	dir     string
+	journal fileTransactionJournalData
+}
+
+// systemctlDaemonReload reloads the systemd units. It is a variable so tests
+// do not need systemd.
+var systemctlDaemonReload = func() error {
+	return runCmdSync("systemctl", "daemon-reload")
+}
+
+// systemctlIsEnabled returns the enablement state of a systemd unit. It is a
+// variable so tests do not need systemd.
+var systemctlIsEnabled = func(unit string) string {
+	out, _ := exec.Command("systemctl", "is-enabled", unit).CombinedOutput()
+	return strings.TrimSpace(string(out))
+}
+
+// setUnitEnablement enables or disables a systemd unit. It is a variable so
+// tests do not need systemd.
+var setUnitEnablement = func(unit string, enabled bool) error {
+	verb := "disable"
+	if enabled {
+		verb = "enable"
+	}
+	if out, err := exec.Command("systemctl", verb, unit).CombinedOutput(); err != nil {
+		return fmt.Errorf("could not %s unit %s: %s: %w", verb, unit, string(out), err)
+	}
+	return nil
+}
+
+// beginFileTransaction snapshots the given paths and units into the
+// transaction directory and opens the transaction. It fails if a transaction
+// is already open.
+func beginFileTransaction(dir, config string, depth int, paths, units []string) (*fileTransaction, error) {
+	if _, err := os.Stat(filepath.Join(dir, fileTransactionJournal)); err == nil {
+		return nil, fmt.Errorf("a file transaction is already open in %s", dir)
+	}
+	// Leftovers of a transaction which was not fully opened or cleaned up.
+	if err := os.RemoveAll(dir); err != nil {
+		return nil, fmt.Errorf("could not clean up file transaction directory: %w", err)
+	}
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, fmt.Errorf("could not create file transaction directory: %w", err)
+	}
+
+	tx := &fileTransaction{dir: dir, journal: fileTransactionJournalData{Config: config, Depth: depth}}
+	seen := map[string]bool{}
+	for _, path := range paths {
+		if seen[path] {
+			continue
+		}
+		seen[path] = true
+		entry, err := tx.snapshotPath(path)
+		if err != nil {
+			return nil, err
+		}
+		if entry != nil {
+			tx.journal.Paths = append(tx.journal.Paths, *entry)
+		}
+	}
+	seen = map[string]bool{}
+	for _, unit := range units {
+		if seen[unit] {
+			continue
+		}
+		seen[unit] = true
+		tx.journal.Units = append(tx.journal.Units, fileTransactionUnit{Name: unit, State: systemctlIsEnabled(unit)})
+	}
+
+	raw, err := json.Marshal(tx.journal)
+	if err != nil {
+		return nil, err
+	}
+	if err := writeFileAtomically(filepath.Join(dir, fileTransactionJournal), raw, 0o700, 0o600, -1, -1); err != nil {
+		return nil, fmt.Errorf("could not write file transaction journal: %w", err)
+	}
+	klog.Infof("Opened file transaction for %s with %d paths and %d units", config, len(tx.journal.Paths), len(tx.journal.Units))
+	return tx, nil
+}
+
+// snapshotPath records the state of a path and backs up its contents. It
+// returns nil for directories, which are not part of transactions.
+func (tx *fileTransaction) snapshotPath(path string) (*fileTransactionPath, error) {
+	entry := &fileTransactionPath{Path: path}
+	info, err := os.Lstat(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return entry, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not snapshot %s: %w", path, err)
+	}
+
+	entry.Exists = true
+	entry.Mode = info.Mode() & (fs.ModePerm | fs.ModeSetuid | fs.ModeSetgid | fs.ModeSticky)
+	if stat, ok := info.Sys().(*syscall.Stat_t); ok {
+		entry.UID = int(stat.Uid)
+		entry.GID = int(stat.Gid)
+	}
+
+	switch {
+	case info.Mode()&fs.ModeSymlink != 0:
+		if entry.Link, err = os.Readlink(path); err != nil {
+			return nil, fmt.Errorf("could not snapshot %s: %w", path, err)
+		}
+	case info.Mode().IsRegular():
+		contents, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("could not snapshot %s: %w", path, err)
+		}
+		entry.Backup = strconv.Itoa(len(tx.journal.Paths))
+		if err := writeFileAtomically(filepath.Join(tx.dir, entry.Backup), contents, 0o700, 0o600, -1, -1); err != nil {
+			return nil, fmt.Errorf("could not back up %s: %w", path, err)
+		}
+	case info.IsDir():
+		return nil, nil
+	default:
+		klog.Warningf("Not including %s in file transaction: not a regular file or symlink", path)
+		return nil, nil
+	}
+	return entry, nil
+}
+
+// commit ends the transaction, keeping the changes.
+func (tx *fileTransaction) commit() error {
+	if err := tx.close(); err != nil {
+		return err
+	}
+	klog.Infof("Committed file transaction for %s", tx.journal.Config)
+	return nil
+}
+
+// rollback restores the snapshotted paths and units and ends the transaction.
+// Restoring is idempotent, so an interrupted rollback can be run again.
+func (tx *fileTransaction) rollback() error {
+	var errs []error
+	// Paths which did not exist are removed once the others are restored,
+	// deepest first, so the directories the update created are empty by the
+	// time they are removed.
+	created := []string{}
+	for i := len(tx.journal.Paths) - 1; i >= 0; i-- {
+		entry := &tx.journal.Paths[i]
+		if !entry.Exists {
+			created = append(created, entry.Path)
+			continue
+		}
+		if err := tx.restorePath(entry); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	sort.SliceStable(created, func(i, j int) bool {
+		return strings.Count(created[i], "/") > strings.Count(created[j], "/")
+	})
+	for _, path := range created {
+		if err := removeCreatedPath(path); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	// The restored unit files and dropins are only picked up by systemd once
+	// it is reloaded.
+	if len(tx.journal.Units) > 0 {
+		if err := systemctlDaemonReload(); err != nil {
+			errs = append(errs, fmt.Errorf("could not reload systemd units: %w", err))
+		}
+	}
+	for _, unit := range tx.journal.Units {
+		var err error
+		switch unit.State {
+		case "enabled":
+			err = setUnitEnablement(unit.Name, true)
+		case "disabled":
+			err = setUnitEnablement(unit.Name, false)
+		}
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if len(errs) > 0 {
+		// Keep the journal so the rollback is retried on the next attempt.
+		return fmt.Errorf("could not roll back file transaction for %s: %w", tx.journal.Config, kubeErrs.NewAggregate(errs))
+	}
+
+	if err := tx.close(); err != nil {
+		return err
+	}
+	klog.Infof("Rolled back file transaction for %s", tx.journal.Config)
+	return nil
+}
+
+// removeCreatedPath removes a path which did not exist before the
+// transaction. Directories holding files the transaction does not cover are
+// left in place.
+func removeCreatedPath(path string) error {
+	err := os.Remove(path)
+	switch {
+	case err == nil, errors.Is(err, fs.ErrNotExist):
+		return nil
+	case errors.Is(err, syscall.ENOTEMPTY), errors.Is(err, syscall.EEXIST):
+		klog.Warningf("Not removing directory %s: it holds files which are not part of the file transaction", path)
+		return nil
+	default:
+		return fmt.Errorf("could not remove %s: %w", path, err)
+	}
+}
+
+func (tx *fileTransaction) restorePath(entry *fileTransactionPath) error {
+	if entry.Link != "" {
+		if err := os.MkdirAll(filepath.Dir(entry.Path), defaultDirectoryPermissions); err != nil {
+			return fmt.Errorf("could not restore %s: %w", entry.Path, err)
+		}
+		if err := renameio.Symlink(entry.Link, entry.Path); err != nil {
+			return fmt.Errorf("could not restore %s: %w", entry.Path, err)
+		}
+		return nil
+	}
+
+	contents, err := os.ReadFile(filepath.Join(tx.dir, entry.Backup))
+	if err != nil {
+		return fmt.Errorf("could not read backup of %s: %w", entry.Path, err)
+	}
+	if err := writeFileAtomically(entry.Path, contents, defaultDirectoryPermissions, entry.Mode, entry.UID, entry.GID); err != nil {
+		return fmt.Errorf("could not restore %s: %w", entry.Path, err)
+	}
+	return nil
+}
+
+// close removes the journal, then the rest of the transaction directory.
+func (tx *fileTransaction) close() error {
+	if err := os.Remove(filepath.Join(tx.dir, fileTransactionJournal)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("could not remove file transaction journal: %w", err)
+	}
+	if err := os.RemoveAll(tx.dir); err != nil {
+		klog.Warningf("Could not clean up file transaction directory: %v", err)
+	}
+	return nil
+}
+
+// loadFileTransaction returns the open transaction in the directory, if any.
+func loadFileTransaction(dir string) (*fileTransaction, error) {
+	raw, err := os.ReadFile(filepath.Join(dir, fileTransactionJournal))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	tx := &fileTransaction{dir: dir}
+	if err := json.Unmarshal(raw, &tx.journal); err != nil {
+		return nil, fmt.Errorf("invalid file transaction journal: %w", err)
+	}
+	return tx, nil
+}
+
+// recoverFileTransactions ends the transactions left open under dir by an MCD
+// which exited during an update, except those in open, which this process
+// still holds. If an update went as far as recording its config as the
+// current config on disk, the update is complete, apart from the reboot or
+// service reloads which follow it, so its transaction is committed, along
+// with those of the updates it was nested in, which completed before it
+// started. The transactions of the updates nested in it did not complete and
+// are rolled back first, newest first, as are all transactions if no config
+// was recorded; the update is then run again from the current config.
+func recoverFileTransactions(dir, currentConfig string, open map[string]bool) error {
+	entries, err := os.ReadDir(dir)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	txs := []*fileTransaction{}
+	for _, entry := range entries {
+		txDir := filepath.Join(dir, entry.Name())
+		if !entry.IsDir() || open[txDir] {
+			continue
+		}
+		tx, err := loadFileTransaction(txDir)
+		if err != nil {
+			return err
+		}
+		if tx == nil {
+			// Leftovers of a transaction which was not fully opened or
+			// cleaned up.
+			if err := os.RemoveAll(txDir); err != nil {
+				klog.Warningf("Could not clean up file transaction directory: %v", err)
+			}
+			continue
+		}
+		txs = append(txs, tx)
+	}
+	sort.Slice(txs, func(i, j int) bool {
+		return txs[i].journal.Depth > txs[j].journal.Depth
+	})
+
+	completed := false
+	for _, tx := range txs {
+		if tx.journal.Config == currentConfig {
+			completed = true
+		}
+		if completed {
+			logSystem("Committing file transaction for %s interrupted after the update completed", tx.journal.Config)
+			err = tx.commit()
+		} else {
+			logSystem("Rolling back file transaction for %s interrupted during the update", tx.journal.Config)
+			err = tx.rollback()
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// recoverFileTransaction ends the file transactions of interrupted updates,
+// if any, leaving those of the updates this process is running.
+func (dn *Daemon) recoverFileTransaction() error {
+	currentConfig := ""
+	if odc, err := dn.getCurrentConfigOnDisk(); err == nil {
+		currentConfig = odc.currentConfig.GetName()
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("could not read current config on disk: %w", err)
+	}
+	if err := recoverFileTransactions(dn.fileTransactionDir, currentConfig, dn.openFileTransactions); err != nil {
+		return fmt.Errorf("could not recover interrupted file transaction: %w", err)
+	}
+	return nil
+}
+
+// beginUpdateFilesTransaction opens a file transaction covering everything
+// updateFiles may change when going from the old to the new config.
+func (dn *Daemon) beginUpdateFilesTransaction(config string, oldIgnConfig, newIgnConfig ign3types.Config) (*fileTransaction, error) {
+	// End the transaction of a previous update which failed to commit or to
+	// roll back. A transaction which failed to commit is only committed if
+	// its config was recorded on disk, so an update which completed is not
+	// rolled back and an update which did not is. The transactions of the
+	// updates this one is nested in are left open.
+	if err := dn.recoverFileTransaction(); err != nil {
+		return nil, err
+	}
+
+	depth := len(dn.openFileTransactions)
+	dir := filepath.Join(dn.fileTransactionDir, fmt.Sprintf("%d-%s", depth, config))
+	paths, units := getUpdateFilesTransactionScope(oldIgnConfig, newIgnConfig)
+	tx, err := beginFileTransaction(dir, config, depth, paths, units)
+	if err != nil {
+		return nil, err
+	}
+	if dn.openFileTransactions == nil {
+		dn.openFileTransactions = map[string]bool{}
+	}
+	dn.openFileTransactions[tx.dir] = true
+	return tx, nil
+}
+
+// endUpdateFilesTransaction commits the transaction if the update succeeded,
+// and rolls it back otherwise. It returns the error of the update.
+func (dn *Daemon) endUpdateFilesTransaction(tx *fileTransaction, updateErr error) error {
+	// A transaction which fails to end is recovered by the next update.
+	delete(dn.openFileTransactions, tx.dir)
+	if updateErr == nil {
+		if err := tx.commit(); err != nil {
+			// The changes are in place; the transaction is committed when
+			// the next update begins or on the next start, once the config
+			// is recorded on disk.
+			klog.Warningf("Could not commit file transaction: %v", err)
+		}
+		return nil
+	}
+	if err := tx.rollback(); err != nil {
+		errs := kubeErrs.NewAggregate([]error{err, updateErr})
+		return fmt.Errorf("error rolling back files writes: %w", errs)
+	}
+	return updateErr
+}
+
+// getUpdateFilesTransactionScope returns the paths and units updateFiles may
+// change when going from the old to the new config: the directories, files,
+// links, units and dropins which differ between the configs with their orig
+// and noorig backups, and the enablement of the units which differ.
+func getUpdateFilesTransactionScope(oldIgnConfig, newIgnConfig ign3types.Config) ([]string, []string) {
+	paths := []string{}
+	units := []string{}
+	addPath := func(path string) {
+		paths = append(paths, path, origFileName(path), noOrigFileStampName(path))
+	}
+
+	// Directories which already existed are not snapshotted, the ones
+	// created by the update are removed on rollback once emptied.
+	oldDirs := map[string]ign3types.Directory{}
+	for _, d := range oldIgnConfig.Storage.Directories {
+		oldDirs[d.Path] = d
+	}
+	dirs := []string{}
+	for _, d := range newIgnConfig.Storage.Directories {
+		if old, ok := oldDirs[d.Path]; !ok || !reflect.DeepEqual(old, d) {
+			dirs = append(dirs, d.Path)
+		}
+		delete(oldDirs, d.Path)
+	}
+	for path := range oldDirs {
+		dirs = append(dirs, path)
+	}
+	sort.Strings(dirs)
+	for _, dir := range dirs {
+		addPath(dir)
+	}
+
+	oldFiles := map[string]ign3types.File{}
+	for _, f := range oldIgnConfig.Storage.Files {
+		oldFiles[f.Path] = f
+	}
+	addFile := func(path string) {
+		addPath(path)
+		paths = append(paths, appendBaseFileName(path), appendRecordFileName(path))
+	}
+	for _, f := range newIgnConfig.Storage.Files {
+		if old, ok := oldFiles[f.Path]; !ok || !reflect.DeepEqual(old, f) {
+			addFile(f.Path)
+		}
+		delete(oldFiles, f.Path)
+	}
+	for _, f := range oldIgnConfig.Storage.Files {
+		if _, ok := oldFiles[f.Path]; ok {
+			addFile(f.Path)
+		}
+	}
+
+	oldLinks := map[string]ign3types.Link{}
+	for _, l := range oldIgnConfig.Storage.Links {
+		oldLinks[l.Path] = l
+	}
+	for _, l := range newIgnConfig.Storage.Links {
+		if old, ok := oldLinks[l.Path]; !ok || !reflect.DeepEqual(old, l) {
+			addPath(l.Path)
+		}
+		delete(oldLinks, l.Path)
+	}
+	for _, l := range oldIgnConfig.Storage.Links {
+		if _, ok := oldLinks[l.Path]; ok {
+			addPath(l.Path)
+		}
+	}
+
+	oldUnits := map[string]ign3types.Unit{}
+	for _, u := range oldIgnConfig.Systemd.Units {
+		oldUnits[u.Name] = u
+	}
+	addUnit := func(u ign3types.Unit, old *ign3types.Unit) {
+		oldDropins := map[string]ign3types.Dropin{}
+		if old != nil {
+			for _, d := range old.Dropins {
+				oldDropins[d.Name] = d
+			}
+		}
+		for _, d := range u.Dropins {
+			if oldDropin, ok := oldDropins[d.Name]; !ok || !reflect.DeepEqual(oldDropin, d) {
+				addPath(filepath.Join(pathSystemd, u.Name+".d", d.Name))
+			}
+			delete(oldDropins, d.Name)
+		}
+		for name := range oldDropins {
+			addPath(filepath.Join(pathSystemd, u.Name+".d", name))
+		}
+		// A unit is masked by replacing its file with a link to /dev/null.
+		if old == nil || !reflect.DeepEqual(old.Contents, u.Contents) || !reflect.DeepEqual(old.Mask, u.Mask) {
+			addPath(filepath.Join(pathSystemd, u.Name))
+		}
+		if old == nil || !reflect.DeepEqual(old.Enabled, u.Enabled) || !reflect.DeepEqual(old.Mask, u.Mask) {
+			units = append(units, u.Name)
+		}
+	}
+	for _, u := range newIgnConfig.Systemd.Units {
+		old, ok := oldUnits[u.Name]
+		switch {
+		case !ok:
+			addUnit(u, nil)
+		case !reflect.DeepEqual(old, u):
+			addUnit(u, &old)
+		}
+		delete(oldUnits, u.Name)
+	}
+	for _, u := range oldIgnConfig.Systemd.Units {
+		if _, ok := oldUnits[u.Name]; ok {
+			addUnit(u, nil)
+		}
+	}
+	return paths, units
+}
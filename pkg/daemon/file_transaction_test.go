@@ -0,0 +1,327 @@
+package daemon
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	ign3types "github.com/coreos/ignition/v2/config/v3_5/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	ctrlcommon "github.com/openshift/machine-config-operator/pkg/controller/common"
+	"github.com/openshift/machine-config-operator/test/helpers"
+)
+
+type fileTransactionTestFixture struct {
+	txDir    string
+	modified string
+	removed  string
+	created  string
+	dir      string
+	link     string
+	units    map[string]bool
+	reloaded bool
+}
+
+// newFileTransactionTestFixture opens a transaction over a file which is then
+// modified, one which is removed, one which is created in a directory which
+// is created too, an existing directory and a symlink which is replaced, and
+// returns the paths.
+func newFileTransactionTestFixture(t *testing.T) *fileTransactionTestFixture {
+	root := t.TempDir()
+	f := &fileTransactionTestFixture{
+		txDir:    filepath.Join(root, "transaction", "0-rendered-worker-2"),
+		modified: filepath.Join(root, "etc", "modified.conf"),
+		removed:  filepath.Join(root, "etc", "removed.conf"),
+		created:  filepath.Join(root, "etc", "created", "nested", "created.conf"),
+		dir:      filepath.Join(root, "etc", "created"),
+		link:     filepath.Join(root, "etc", "masked.service"),
+		units:    map[string]bool{},
+	}
+
+	require.NoError(t, os.MkdirAll(filepath.Join(root, "etc"), 0o755))
+	require.NoError(t, os.WriteFile(f.modified, []byte("old"), 0o640))
+	require.NoError(t, os.WriteFile(f.removed, []byte("removed"), 0o600))
+	require.NoError(t, os.Symlink("/dev/null", f.link))
+
+	origIsEnabled, origSetEnablement, origDaemonReload := systemctlIsEnabled, setUnitEnablement, systemctlDaemonReload
+	systemctlIsEnabled = func(unit string) string {
+		return map[string]string{"enabled.service": "enabled", "disabled.service": "disabled"}[unit]
+	}
+	setUnitEnablement = func(unit string, enabled bool) error {
+		// Units are enabled from the restored unit files.
+		assert.True(t, f.reloaded, "units must be reloaded before their enablement is restored")
+		f.units[unit] = enabled
+		return nil
+	}
+	systemctlDaemonReload = func() error {
+		f.reloaded = true
+		return nil
+	}
+	t.Cleanup(func() {
+		systemctlIsEnabled, setUnitEnablement, systemctlDaemonReload = origIsEnabled, origSetEnablement, origDaemonReload
+	})
+
+	// Directories are listed before their contents, as the update lists them.
+	tx, err := beginFileTransaction(f.txDir, "rendered-worker-2", 0,
+		[]string{filepath.Join(root, "etc"), f.dir, filepath.Dir(f.created), f.modified, f.removed, f.created, f.link, f.modified},
+		[]string{"enabled.service", "disabled.service", "static.service"})
+	require.NoError(t, err)
+	assert.Len(t, tx.journal.Paths, 6, "existing directories are not snapshotted")
+
+	_, err = beginFileTransaction(f.txDir, "rendered-worker-2", 0, nil, nil)
+	assert.Error(t, err, "a second transaction must not be opened")
+
+	require.NoError(t, writeFileAtomically(f.modified, []byte("new"), 0o755, 0o644, -1, -1))
+	require.NoError(t, os.Remove(f.removed))
+	require.NoError(t, os.MkdirAll(filepath.Dir(f.created), 0o755))
+	require.NoError(t, os.WriteFile(f.created, []byte("created"), 0o644))
+	require.NoError(t, os.Remove(f.link))
+	require.NoError(t, os.WriteFile(f.link, []byte("[Unit]"), 0o644))
+	return f
+}
+
+func (f *fileTransactionTestFixture) assertRolledBack(t *testing.T) {
+	contents, err := os.ReadFile(f.modified)
+	require.NoError(t, err)
+	assert.Equal(t, "old", string(contents))
+	info, err := os.Stat(f.modified)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0o640), info.Mode().Perm())
+
+	contents, err = os.ReadFile(f.removed)
+	require.NoError(t, err)
+	assert.Equal(t, "removed", string(contents))
+
+	assert.NoFileExists(t, f.created)
+	assert.NoDirExists(t, f.dir)
+	assert.DirExists(t, filepath.Dir(f.modified))
+
+	target, err := os.Readlink(f.link)
+	require.NoError(t, err)
+	assert.Equal(t, "/dev/null", target)
+
+	assert.True(t, f.reloaded)
+	assert.Equal(t, map[string]bool{"enabled.service": true, "disabled.service": false}, f.units)
+	assert.NoDirExists(t, f.txDir)
+}
+
+func (f *fileTransactionTestFixture) assertCommitted(t *testing.T) {
+	contents, err := os.ReadFile(f.modified)
+	require.NoError(t, err)
+	assert.Equal(t, "new", string(contents))
+	assert.NoFileExists(t, f.removed)
+	assert.FileExists(t, f.created)
+	assert.False(t, f.reloaded)
+	assert.Empty(t, f.units)
+	assert.NoDirExists(t, f.txDir)
+}
+
+func TestFileTransactionRollback(t *testing.T) {
+	f := newFileTransactionTestFixture(t)
+
+	tx, err := loadFileTransaction(f.txDir)
+	require.NoError(t, err)
+	require.NoError(t, tx.rollback())
+	f.assertRolledBack(t)
+}
+
+func TestFileTransactionCommit(t *testing.T) {
+	f := newFileTransactionTestFixture(t)
+
+	tx, err := loadFileTransaction(f.txDir)
+	require.NoError(t, err)
+	require.NoError(t, tx.commit())
+	f.assertCommitted(t)
+
+	tx, err = loadFileTransaction(f.txDir)
+	assert.NoError(t, err)
+	assert.Nil(t, tx)
+}
+
+func TestRecoverFileTransactions(t *testing.T) {
+	t.Run("interrupted during the update", func(t *testing.T) {
+		f := newFileTransactionTestFixture(t)
+		require.NoError(t, recoverFileTransactions(filepath.Dir(f.txDir), "rendered-worker-1", nil))
+		f.assertRolledBack(t)
+	})
+
+	t.Run("interrupted after the update", func(t *testing.T) {
+		f := newFileTransactionTestFixture(t)
+		require.NoError(t, recoverFileTransactions(filepath.Dir(f.txDir), "rendered-worker-2", nil))
+		f.assertCommitted(t)
+	})
+
+	t.Run("open in this process", func(t *testing.T) {
+		f := newFileTransactionTestFixture(t)
+		require.NoError(t, recoverFileTransactions(filepath.Dir(f.txDir), "rendered-worker-2", map[string]bool{f.txDir: true}))
+		tx, err := loadFileTransaction(f.txDir)
+		require.NoError(t, err)
+		assert.NotNil(t, tx)
+	})
+
+	t.Run("no transaction", func(t *testing.T) {
+		assert.NoError(t, recoverFileTransactions(filepath.Join(t.TempDir(), "transaction"), "rendered-worker-1", nil))
+	})
+}
+
+func TestBeginUpdateFilesTransactionEndsLeftoverTransaction(t *testing.T) {
+	newDaemon := func(t *testing.T, f *fileTransactionTestFixture, currentConfig string) *Daemon {
+		dir := t.TempDir()
+		mc := helpers.CreateMachineConfigFromIgnitionWithMetadata(ctrlcommon.NewIgnConfig(), currentConfig, "worker")
+		raw, err := json.Marshal(mc)
+		require.NoError(t, err)
+		currentConfigPath := filepath.Join(dir, "currentconfig")
+		require.NoError(t, os.WriteFile(currentConfigPath, raw, 0o644))
+		return &Daemon{
+			currentConfigPath:  currentConfigPath,
+			currentImagePath:   filepath.Join(dir, "currentimage"),
+			fileTransactionDir: filepath.Dir(f.txDir),
+		}
+	}
+
+	// A transaction which failed to commit after its config was recorded on
+	// disk is committed rather than rolled back.
+	t.Run("failed to commit", func(t *testing.T) {
+		f := newFileTransactionTestFixture(t)
+		tx, err := newDaemon(t, f, "rendered-worker-2").beginUpdateFilesTransaction("rendered-worker-3", ign3types.Config{}, ign3types.Config{})
+		require.NoError(t, err)
+		require.NoError(t, tx.commit())
+		f.assertCommitted(t)
+	})
+
+	t.Run("failed to roll back", func(t *testing.T) {
+		f := newFileTransactionTestFixture(t)
+		tx, err := newDaemon(t, f, "rendered-worker-1").beginUpdateFilesTransaction("rendered-worker-2", ign3types.Config{}, ign3types.Config{})
+		require.NoError(t, err)
+		require.NoError(t, tx.commit())
+		f.assertRolledBack(t)
+	})
+}
+
+// nestedUpdateTestFixture runs the file transactions of an update from
+// rendered-worker-1 to rendered-worker-2 which completes without a reboot and
+// starts an update to rendered-worker-3 while its transaction is open.
+type nestedUpdateTestFixture struct {
+	dn    *Daemon
+	path  string
+	outer *fileTransaction
+	inner *fileTransaction
+}
+
+func newNestedUpdateTestFixture(t *testing.T) *nestedUpdateTestFixture {
+	root := t.TempDir()
+	f := &nestedUpdateTestFixture{
+		dn: &Daemon{
+			currentConfigPath:  filepath.Join(root, "currentconfig"),
+			currentImagePath:   filepath.Join(root, "currentimage"),
+			fileTransactionDir: filepath.Join(root, "transaction"),
+		},
+		path: filepath.Join(root, "etc", "foo.conf"),
+	}
+	require.NoError(t, os.MkdirAll(filepath.Dir(f.path), 0o755))
+	require.NoError(t, os.WriteFile(f.path, []byte("1"), 0o644))
+
+	ignConfig := func(contents string) ign3types.Config {
+		ignCfg := ctrlcommon.NewIgnConfig()
+		ignCfg.Storage.Files = append(ignCfg.Storage.Files, ctrlcommon.NewIgnFile(f.path, contents))
+		return ignCfg
+	}
+	update := func(config, contents string, oldIgnConfig, newIgnConfig ign3types.Config) *fileTransaction {
+		tx, err := f.dn.beginUpdateFilesTransaction(config, oldIgnConfig, newIgnConfig)
+		require.NoError(t, err)
+		require.NoError(t, os.WriteFile(f.path, []byte(contents), 0o644))
+		mc := helpers.CreateMachineConfigFromIgnitionWithMetadata(newIgnConfig, config, "worker")
+		require.NoError(t, f.dn.storeCurrentConfigOnDisk(&onDiskConfig{currentConfig: mc}))
+		return tx
+	}
+
+	f.outer = update("rendered-worker-2", "2", ignConfig("1"), ignConfig("2"))
+	f.inner = update("rendered-worker-3", "3", ignConfig("2"), ignConfig("3"))
+
+	// Opening the nested transaction does not end the outer one.
+	tx, err := loadFileTransaction(f.outer.dir)
+	require.NoError(t, err)
+	require.NotNil(t, tx)
+	return f
+}
+
+func (f *nestedUpdateTestFixture) assertContents(t *testing.T, expected string) {
+	t.Helper()
+	contents, err := os.ReadFile(f.path)
+	require.NoError(t, err)
+	assert.Equal(t, expected, string(contents))
+}
+
+func TestNestedUpdateFilesTransactions(t *testing.T) {
+	t.Run("nested update succeeds", func(t *testing.T) {
+		f := newNestedUpdateTestFixture(t)
+		require.NoError(t, f.dn.endUpdateFilesTransaction(f.inner, nil))
+		require.NoError(t, f.dn.endUpdateFilesTransaction(f.outer, nil))
+		f.assertContents(t, "3")
+		assert.Empty(t, f.dn.openFileTransactions)
+	})
+
+	t.Run("nested update fails", func(t *testing.T) {
+		f := newNestedUpdateTestFixture(t)
+		updateErr := errors.New("update failed")
+		assert.ErrorIs(t, f.dn.endUpdateFilesTransaction(f.inner, updateErr), updateErr)
+		f.assertContents(t, "2")
+		assert.ErrorIs(t, f.dn.endUpdateFilesTransaction(f.outer, updateErr), updateErr)
+		f.assertContents(t, "1")
+	})
+
+	// The nested update is rolled back and the outer one, which recorded
+	// its config before the nested one started, is committed.
+	t.Run("interrupted during the nested update", func(t *testing.T) {
+		f := newNestedUpdateTestFixture(t)
+		require.NoError(t, recoverFileTransactions(f.dn.fileTransactionDir, "rendered-worker-2", nil))
+		f.assertContents(t, "2")
+		assert.NoDirExists(t, f.outer.dir)
+		assert.NoDirExists(t, f.inner.dir)
+	})
+
+	t.Run("interrupted after the nested update", func(t *testing.T) {
+		f := newNestedUpdateTestFixture(t)
+		require.NoError(t, recoverFileTransactions(f.dn.fileTransactionDir, "rendered-worker-3", nil))
+		f.assertContents(t, "3")
+		assert.NoDirExists(t, f.outer.dir)
+		assert.NoDirExists(t, f.inner.dir)
+	})
+}
+
+func TestGetUpdateFilesTransactionScope(t *testing.T) {
+	oldIgnConfig := ctrlcommon.NewIgnConfig()
+	oldIgnConfig.Storage.Files = []ign3types.File{
+		ctrlcommon.NewIgnFile("/etc/unchanged", "a"),
+		ctrlcommon.NewIgnFile("/etc/changed", "a"),
+		ctrlcommon.NewIgnFile("/etc/removed", "a"),
+	}
+	oldIgnConfig.Systemd.Units = []ign3types.Unit{
+		{Name: "unchanged.service", Contents: helpers.StrToPtr("[Unit]")},
+		{Name: "dropin.service", Contents: helpers.StrToPtr("[Unit]"), Dropins: []ign3types.Dropin{{Name: "10-a.conf"}, {Name: "20-b.conf"}}},
+	}
+	newIgnConfig := ctrlcommon.NewIgnConfig()
+	newIgnConfig.Storage.Files = []ign3types.File{
+		ctrlcommon.NewIgnFile("/etc/unchanged", "a"),
+		ctrlcommon.NewIgnFile("/etc/changed", "b"),
+		ctrlcommon.NewIgnFile("/etc/added", "a"),
+	}
+	newIgnConfig.Systemd.Units = []ign3types.Unit{
+		{Name: "unchanged.service", Contents: helpers.StrToPtr("[Unit]")},
+		{Name: "dropin.service", Contents: helpers.StrToPtr("[Unit]"), Dropins: []ign3types.Dropin{{Name: "10-a.conf"}, {Name: "20-b.conf", Contents: helpers.StrToPtr("[Service]")}}},
+	}
+
+	paths, units := getUpdateFilesTransactionScope(oldIgnConfig, newIgnConfig)
+	expected := []string{}
+	for _, path := range []string{"/etc/changed", "/etc/added", "/etc/removed"} {
+		expected = append(expected, path, origFileName(path), noOrigFileStampName(path), appendBaseFileName(path), appendRecordFileName(path))
+	}
+	dropin := filepath.Join(pathSystemd, "dropin.service.d", "20-b.conf")
+	expected = append(expected, dropin, origFileName(dropin), noOrigFileStampName(dropin))
+	assert.ElementsMatch(t, expected, paths)
+	assert.Empty(t, units)
+}
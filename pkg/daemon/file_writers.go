@@ -125,10 +125,7 @@ func createOrigFile(fromPath, fpath string) error {
 		// create a noorig file that tells the MCD that the file wasn't present on disk before MCD
 		// took over so it can just remove it when deleting stale data, as opposed as restoring a file
 		// that was shipped _with_ the underlying OS (e.g. a default chrony config).
-		if makeErr := os.MkdirAll(filepath.Dir(noOrigFileStampName(fpath)), 0o755); makeErr != nil {
-			return fmt.Errorf("creating no orig parent dir: %w", makeErr)
-		}
-		return writeFileAtomicallyWithDefaults(noOrigFileStampName(fpath), nil)
+		return createNoOrigFileStamp(fpath)
 	}
 
 	// https://bugzilla.redhat.com/show_bug.cgi?id=1970959
@@ -151,6 +148,15 @@ func createOrigFile(fromPath, fpath string) error {
 	return nil
 }
 
+// createNoOrigFileStamp records that the path did not exist before the MCD
+// wrote it.
+func createNoOrigFileStamp(fpath string) error {
+	if makeErr := os.MkdirAll(filepath.Dir(noOrigFileStampName(fpath)), 0o755); makeErr != nil {
+		return fmt.Errorf("creating no orig parent dir: %w", makeErr)
+	}
+	return writeFileAtomicallyWithDefaults(noOrigFileStampName(fpath), nil)
+}
+
 func writeFileAtomicallyWithDefaults(fpath string, b []byte) error {
 	return writeFileAtomically(fpath, b, defaultDirectoryPermissions, defaultFilePermissions, -1, -1)
 }
@@ -233,16 +239,26 @@ func writeFiles(files []ign3types.File, skipCertificateWrite bool) error {
 		}
 		klog.Infof("Writing file %q", file.Path)
 
-		// We don't support appends in the file section, so instead of waiting to fail validation,
-		// let's explicitly fail here.
-		if len(file.Append) > 0 {
-			return fmt.Errorf("found an append section when writing files. Append is not supported")
+		if isAppendOnlyFile(file) {
+			if err := writeAppendedFile(file); err != nil {
+				return err
+			}
+			continue
+		}
+		// The config now owns the whole file, so stop appending to it.
+		if _, err := restoreAppendBase(file.Path); err != nil {
+			return err
 		}
 
 		decodedContents, err := ctrlcommon.DecodeIgnitionFileContents(file.Contents.Source, file.Contents.Compression)
 		if err != nil {
 			return fmt.Errorf("could not decode file %q: %w", file.Path, err)
 		}
+		fragments, _, err := decodeAppendFragments(file)
+		if err != nil {
+			return err
+		}
+		decodedContents = append(decodedContents, fragments...)
 
 		mode := defaultFilePermissions
 		if file.Mode != nil {
@@ -264,6 +280,115 @@ func writeFiles(files []ign3types.File, skipCertificateWrite bool) error {
 	return nil
 }
 
+// writeDirectories creates the given directories and sets their mode and
+// ownership. Directories the MCD creates are stamped as such, so they can be
+// removed once dropped from the config.
+func writeDirectories(dirs []ign3types.Directory) error {
+	for _, dir := range dirs {
+		klog.Infof("Writing directory %q", dir.Path)
+
+		mode := getDirectoryMode(dir)
+		uid, gid, err := getNodeOwnership(dir.Node)
+		if err != nil {
+			return fmt.Errorf("failed to retrieve ownership for directory %q: %w", dir.Path, err)
+		}
+
+		fi, err := os.Lstat(dir.Path)
+		switch {
+		case os.IsNotExist(err):
+			if err := createNoOrigFileStamp(dir.Path); err != nil {
+				return err
+			}
+			if err := os.MkdirAll(dir.Path, mode); err != nil {
+				return fmt.Errorf("failed to create directory %q: %w", dir.Path, err)
+			}
+		case err != nil:
+			return fmt.Errorf("could not stat directory %q: %w", dir.Path, err)
+		case !fi.IsDir():
+			return fmt.Errorf("cannot create directory %q: path exists and is not a directory", dir.Path)
+		}
+
+		// MkdirAll is subject to the umask, and the directory may have existed.
+		if err := os.Chmod(dir.Path, mode); err != nil {
+			return fmt.Errorf("failed to set mode of directory %q: %w", dir.Path, err)
+		}
+		if err := os.Lchown(dir.Path, uid, gid); err != nil {
+			return fmt.Errorf("failed to set ownership of directory %q: %w", dir.Path, err)
+		}
+	}
+	return nil
+}
+
+// getDirectoryMode returns the mode of a directory in the config. Unlike for
+// files, the setuid, setgid and sticky bits are commonly set on directories,
+// so they are mapped to their os.FileMode counterparts.
+func getDirectoryMode(dir ign3types.Directory) os.FileMode {
+	if dir.Mode == nil {
+		return defaultDirectoryPermissions
+	}
+	mode := os.FileMode(*dir.Mode).Perm() //nolint:gosec
+	if *dir.Mode&0o4000 != 0 {
+		mode |= os.ModeSetuid
+	}
+	if *dir.Mode&0o2000 != 0 {
+		mode |= os.ModeSetgid
+	}
+	if *dir.Mode&0o1000 != 0 {
+		mode |= os.ModeSticky
+	}
+	return mode
+}
+
+// writeLinks creates the given symbolic and hard links, replacing whatever is
+// at their path. As for files, the original file is backed up so it can be
+// restored once the link is dropped from the config.
+func writeLinks(links []ign3types.Link) error {
+	for _, link := range links {
+		if link.Target == nil || *link.Target == "" {
+			return fmt.Errorf("link %q has no target", link.Path)
+		}
+		target := *link.Target
+		hard := link.Hard != nil && *link.Hard
+		klog.Infof("Writing link %q to %q", link.Path, target)
+
+		uid, gid, err := getNodeOwnership(link.Node)
+		if err != nil {
+			return fmt.Errorf("failed to retrieve ownership for link %q: %w", link.Path, err)
+		}
+
+		fi, err := os.Lstat(link.Path)
+		if err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("could not stat link %q: %w", link.Path, err)
+		}
+		if err == nil && fi.IsDir() {
+			return fmt.Errorf("cannot create link %q: path exists and is a directory", link.Path)
+		}
+		if err := createOrigFile(link.Path, link.Path); err != nil {
+			return err
+		}
+		if err := os.MkdirAll(filepath.Dir(link.Path), defaultDirectoryPermissions); err != nil {
+			return fmt.Errorf("failed to create parent directory of link %q: %w", link.Path, err)
+		}
+
+		if hard {
+			if err := os.Remove(link.Path); err != nil && !os.IsNotExist(err) {
+				return fmt.Errorf("failed to replace %q with a hard link: %w", link.Path, err)
+			}
+			if err := os.Link(target, link.Path); err != nil {
+				return fmt.Errorf("failed to create hard link %q: %w", link.Path, err)
+			}
+			continue
+		}
+		if err := renameio.Symlink(target, link.Path); err != nil {
+			return fmt.Errorf("failed to create symbolic link %q: %w", link.Path, err)
+		}
+		if err := os.Lchown(link.Path, uid, gid); err != nil {
+			return fmt.Errorf("failed to set ownership of link %q: %w", link.Path, err)
+		}
+	}
+	return nil
+}
+
 // writeUnit writes a systemd unit and its dropins to disk
 func writeUnit(u ign3types.Unit, systemdRoot string, isCoreOSVariant bool) error {
 	if err := writeDropins(u, systemdRoot, isCoreOSVariant); err != nil {
@@ -361,23 +486,27 @@ func lookupGID(group string) (int, error) {
 	return gid, nil
 }
 
-// This is essentially ResolveNodeUidAndGid() from Ignition; XXX should dedupe
 func getFileOwnership(file ign3types.File) (int, int, error) {
+	return getNodeOwnership(file.Node)
+}
+
+// This is essentially ResolveNodeUidAndGid() from Ignition; XXX should dedupe
+func getNodeOwnership(node ign3types.Node) (int, int, error) {
 	uid, gid := 0, 0 // default to root
 	var err error    // create default error var
-	if file.User.ID != nil {
-		uid = *file.User.ID
-	} else if file.User.Name != nil && *file.User.Name != "" {
-		uid, err = lookupUID(*file.User.Name)
+	if node.User.ID != nil {
+		uid = *node.User.ID
+	} else if node.User.Name != nil && *node.User.Name != "" {
+		uid, err = lookupUID(*node.User.Name)
 		if err != nil {
 			return uid, gid, err
 		}
 	}
 
-	if file.Group.ID != nil {
-		gid = *file.Group.ID
-	} else if file.Group.Name != nil && *file.Group.Name != "" {
-		gid, err = lookupGID(*file.Group.Name)
+	if node.Group.ID != nil {
+		gid = *node.Group.ID
+	} else if node.Group.Name != nil && *node.Group.Name != "" {
+		gid, err = lookupGID(*node.Group.Name)
 		if err != nil {
 			return uid, gid, err
 		}
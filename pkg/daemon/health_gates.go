@@ -0,0 +1,289 @@
+package daemon
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os/exec"
+	"strings"
+	"time"
+
+	mcfgv1 "github.com/openshift/api/machineconfiguration/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/klog/v2"
+
+	ctrlcommon "github.com/openshift/machine-config-operator/pkg/controller/common"
+	"github.com/openshift/machine-config-operator/pkg/helpers"
+)
+
+const (
+	// defaultHealthGateTimeout is how long a health gate may take to pass if
+	// it does not set its own timeout.
+	defaultHealthGateTimeout = 5 * time.Minute
+
+	// healthGateProbeTimeout bounds a single HTTP request or command of a
+	// health gate.
+	healthGateProbeTimeout = 30 * time.Second
+
+	// healthGateOutputLimit is the number of characters of the probe output
+	// kept in the degraded reason of the node.
+	healthGateOutputLimit = 512
+)
+
+// healthGatePollInterval is how often a failing health gate is retried until
+// its timeout. It is a variable so tests can shorten it.
+var healthGatePollInterval = 10 * time.Second
+
+// healthGate is a user-defined check which must pass after a node is updated
+// before the node is uncordoned and marked Done. Exactly one of HTTP, Command
+// and Pods must be set.
+type healthGate struct {
+	// Name identifies the gate in events and errors.
+	Name string `json:"name"`
+	// Timeout is how long the gate is retried before the node degrades, as a
+	// duration string. Defaults to 5m.
+	Timeout string `json:"timeout,omitempty"`
+
+	HTTP    *httpHealthGate `json:"http,omitempty"`
+	Command []string        `json:"command,omitempty"`
+	Pods    *podsHealthGate `json:"pods,omitempty"`
+}
+
+// httpHealthGate passes once a GET request to URL returns ExpectedStatus.
+type httpHealthGate struct {
+	URL string `json:"url"`
+	// ExpectedStatus defaults to 200.
+	ExpectedStatus int `json:"expectedStatus,omitempty"`
+}
+
+// podsHealthGate passes once MinReady pods matching Selector in Namespace are
+// Ready on the node. The node is still cordoned while health gates run, so
+// the pods must tolerate the unschedulable taint, e.g. DaemonSet pods.
+type podsHealthGate struct {
+	Namespace string `json:"namespace"`
+	Selector  string `json:"selector"`
+	// MinReady defaults to 1.
+	MinReady int `json:"minReady,omitempty"`
+}
+
+// getHealthGates returns the validated health gates declared on a pool.
+func getHealthGates(pool *mcfgv1.MachineConfigPool) ([]healthGate, error) {
+	val, ok := pool.Annotations[ctrlcommon.HealthGatesAnnotationKey]
+	if !ok {
+		return nil, nil
+	}
+
+	var gates []healthGate
+	if err := json.Unmarshal([]byte(val), &gates); err != nil {
+		return nil, fmt.Errorf("invalid %s annotation on pool %s: %w", ctrlcommon.HealthGatesAnnotationKey, pool.Name, err)
+	}
+	for i := range gates {
+		if err := gates[i].validate(); err != nil {
+			return nil, fmt.Errorf("invalid %s annotation on pool %s: health gate %d: %w", ctrlcommon.HealthGatesAnnotationKey, pool.Name, i, err)
+		}
+	}
+	return gates, nil
+}
+
+func (g *healthGate) validate() error {
+	if g.Name == "" {
+		return fmt.Errorf("name is required")
+	}
+	if _, err := g.timeout(); err != nil {
+		return err
+	}
+
+	set := 0
+	if g.HTTP != nil {
+		set++
+		if g.HTTP.URL == "" {
+			return fmt.Errorf("%s: http.url is required", g.Name)
+		}
+		if g.HTTP.ExpectedStatus == 0 {
+			g.HTTP.ExpectedStatus = http.StatusOK
+		}
+	}
+	if len(g.Command) > 0 {
+		set++
+	}
+	if g.Pods != nil {
+		set++
+		if g.Pods.Namespace == "" {
+			return fmt.Errorf("%s: pods.namespace is required", g.Name)
+		}
+		if _, err := labels.Parse(g.Pods.Selector); err != nil {
+			return fmt.Errorf("%s: invalid pods.selector: %w", g.Name, err)
+		}
+		if g.Pods.MinReady < 0 {
+			return fmt.Errorf("%s: pods.minReady must not be negative", g.Name)
+		}
+		if g.Pods.MinReady == 0 {
+			g.Pods.MinReady = 1
+		}
+	}
+	if set != 1 {
+		return fmt.Errorf("%s: exactly one of http, command and pods must be set", g.Name)
+	}
+	return nil
+}
+
+func (g *healthGate) timeout() (time.Duration, error) {
+	if g.Timeout == "" {
+		return defaultHealthGateTimeout, nil
+	}
+	timeout, err := time.ParseDuration(g.Timeout)
+	if err != nil || timeout <= 0 {
+		return 0, fmt.Errorf("%s: invalid timeout %q", g.Name, g.Timeout)
+	}
+	return timeout, nil
+}
+
+// probe runs the gate once, returning an error with the probe output if it
+// does not pass.
+func (g *healthGate) probe(ctx context.Context, kubeClient kubernetes.Interface, nodeName string) error {
+	ctx, cancel := context.WithTimeout(ctx, healthGateProbeTimeout)
+	defer cancel()
+
+	switch {
+	case g.HTTP != nil:
+		return probeHTTP(ctx, g.HTTP)
+	case len(g.Command) > 0:
+		return probeCommand(ctx, g.Command)
+	default:
+		return probePods(ctx, g.Pods, kubeClient, nodeName)
+	}
+}
+
+func probeHTTP(ctx context.Context, gate *httpHealthGate) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, gate.URL, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, healthGateOutputLimit))
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != gate.ExpectedStatus {
+		return fmt.Errorf("GET %s returned %d, expected %d: %s", gate.URL, resp.StatusCode, gate.ExpectedStatus, strings.TrimSpace(string(body)))
+	}
+	return nil
+}
+
+func probeCommand(ctx context.Context, command []string) error {
+	out, err := exec.CommandContext(ctx, command[0], command[1:]...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%s: %w: %s", strings.Join(command, " "), err, truncate(strings.TrimSpace(string(out)), healthGateOutputLimit))
+	}
+	return nil
+}
+
+func probePods(ctx context.Context, gate *podsHealthGate, kubeClient kubernetes.Interface, nodeName string) error {
+	if kubeClient == nil {
+		return fmt.Errorf("pods health gates require a cluster")
+	}
+	pods, err := kubeClient.CoreV1().Pods(gate.Namespace).List(ctx, metav1.ListOptions{
+		LabelSelector: gate.Selector,
+		FieldSelector: fields.OneTermEqualSelector("spec.nodeName", nodeName).String(),
+	})
+	if err != nil {
+		return err
+	}
+
+	total := 0
+	notReady := []string{}
+	for i := range pods.Items {
+		pod := &pods.Items[i]
+		if pod.Spec.NodeName != nodeName {
+			continue
+		}
+		total++
+		if !isPodReady(pod) {
+			notReady = append(notReady, pod.Name)
+		}
+	}
+	ready := total - len(notReady)
+	if ready < gate.MinReady {
+		return fmt.Errorf("%d of %d pods matching %q in namespace %s are ready, expected at least %d; not ready: %v",
+			ready, total, gate.Selector, gate.Namespace, gate.MinReady, notReady)
+	}
+	return nil
+}
+
+func isPodReady(pod *corev1.Pod) bool {
+	for _, cond := range pod.Status.Conditions {
+		if cond.Type == corev1.PodReady {
+			return cond.Status == corev1.ConditionTrue
+		}
+	}
+	return false
+}
+
+// waitForHealthGate retries the gate until it passes or times out, returning
+// the output of the last failed probe in the latter case. Probes are not cut
+// short by the gate timeout so that the last one reports its own failure.
+func waitForHealthGate(ctx context.Context, gate *healthGate, kubeClient kubernetes.Interface, nodeName string) error {
+	// Already validated by getHealthGates.
+	timeout, _ := gate.timeout()
+	deadline := time.Now().Add(timeout)
+
+	for {
+		err := gate.probe(ctx, kubeClient, nodeName)
+		if err == nil {
+			return nil
+		}
+		if !time.Now().Add(healthGatePollInterval).Before(deadline) {
+			return fmt.Errorf("health gate %s did not pass within %s: %w", gate.Name, timeout, err)
+		}
+		klog.Infof("Health gate %s has not passed yet: %v", gate.Name, err)
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("health gate %s did not pass: %w", gate.Name, ctx.Err())
+		case <-time.After(healthGatePollInterval):
+		}
+	}
+}
+
+// runHealthGates runs the health gates of the node's pool after an update,
+// before the node is uncordoned and marked Done. A failing gate is returned
+// as an error so the node degrades and the rollout of the pool stops.
+func (dn *Daemon) runHealthGates(configName string) error {
+	pool, err := helpers.GetPrimaryPoolForNode(dn.mcpLister, dn.node)
+	if err != nil {
+		return err
+	}
+	if pool == nil {
+		return nil
+	}
+	gates, err := getHealthGates(pool)
+	if err != nil {
+		return err
+	}
+
+	for i := range gates {
+		gate := &gates[i]
+		logSystem("Running health gate %s for config %s", gate.Name, configName)
+		if err := waitForHealthGate(context.TODO(), gate, dn.kubeClient, dn.name); err != nil {
+			if dn.nodeWriter != nil {
+				dn.nodeWriter.Eventf(corev1.EventTypeWarning, "HealthGateFailed", err.Error())
+			}
+			return fmt.Errorf("failed health gates of pool %s: %w", pool.Name, err)
+		}
+	}
+	if len(gates) > 0 && dn.nodeWriter != nil {
+		dn.nodeWriter.Eventf(corev1.EventTypeNormal, "HealthGatesPassed", "Passed %d health gates for config %s", len(gates), configName)
+	}
+	return nil
+}
@@ -0,0 +1,152 @@
+package daemon
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	k8sfake "k8s.io/client-go/kubernetes/fake"
+
+	ctrlcommon "github.com/openshift/machine-config-operator/pkg/controller/common"
+	"github.com/openshift/machine-config-operator/test/helpers"
+)
+
+func TestGetHealthGates(t *testing.T) {
+	tests := []struct {
+		name       string
+		annotation string
+		expected   []healthGate
+		expectErr  bool
+	}{{
+		name: "no health gates",
+	}, {
+		name:       "defaults",
+		annotation: `[{"name": "router", "http": {"url": "http://localhost:1936/healthz"}}, {"name": "dns", "pods": {"namespace": "openshift-dns", "selector": "app=dns"}}]`,
+		expected: []healthGate{
+			{Name: "router", HTTP: &httpHealthGate{URL: "http://localhost:1936/healthz", ExpectedStatus: http.StatusOK}},
+			{Name: "dns", Pods: &podsHealthGate{Namespace: "openshift-dns", Selector: "app=dns", MinReady: 1}},
+		},
+	}, {
+		name:       "command with timeout",
+		annotation: `[{"name": "check", "timeout": "1m", "command": ["/usr/local/bin/check", "--quick"]}]`,
+		expected:   []healthGate{{Name: "check", Timeout: "1m", Command: []string{"/usr/local/bin/check", "--quick"}}},
+	}, {
+		name:       "invalid JSON",
+		annotation: `{"name": "check"}`,
+		expectErr:  true,
+	}, {
+		name:       "missing name",
+		annotation: `[{"command": ["true"]}]`,
+		expectErr:  true,
+	}, {
+		name:       "no probe",
+		annotation: `[{"name": "check"}]`,
+		expectErr:  true,
+	}, {
+		name:       "several probes",
+		annotation: `[{"name": "check", "command": ["true"], "http": {"url": "http://localhost"}}]`,
+		expectErr:  true,
+	}, {
+		name:       "invalid timeout",
+		annotation: `[{"name": "check", "timeout": "soon", "command": ["true"]}]`,
+		expectErr:  true,
+	}, {
+		name:       "invalid selector",
+		annotation: `[{"name": "check", "pods": {"namespace": "default", "selector": "app in ("}}]`,
+		expectErr:  true,
+	}}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			pool := helpers.NewMachineConfigPool("worker", nil, helpers.WorkerSelector, "rendered-worker-1")
+			if test.annotation != "" {
+				pool.Annotations = map[string]string{ctrlcommon.HealthGatesAnnotationKey: test.annotation}
+			}
+
+			gates, err := getHealthGates(pool)
+			if test.expectErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, test.expected, gates)
+		})
+	}
+}
+
+func TestWaitForHealthGate(t *testing.T) {
+	oldInterval := healthGatePollInterval
+	healthGatePollInterval = 10 * time.Millisecond
+	t.Cleanup(func() { healthGatePollInterval = oldInterval })
+
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		requests++
+		if requests < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			w.Write([]byte("starting"))
+			return
+		}
+		w.Write([]byte("ok"))
+	}))
+	t.Cleanup(server.Close)
+
+	newPod := func(name, node string, ready corev1.ConditionStatus) *corev1.Pod {
+		return &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "openshift-dns", Labels: map[string]string{"app": "dns"}},
+			Spec:       corev1.PodSpec{NodeName: node},
+			Status:     corev1.PodStatus{Conditions: []corev1.PodCondition{{Type: corev1.PodReady, Status: ready}}},
+		}
+	}
+	kubeClient := k8sfake.NewSimpleClientset(
+		newPod("dns-ready", "node-0", corev1.ConditionTrue),
+		newPod("dns-starting", "node-0", corev1.ConditionFalse),
+		newPod("dns-other-node", "node-1", corev1.ConditionTrue),
+	)
+
+	tests := []struct {
+		name        string
+		gate        healthGate
+		expectedErr string
+	}{{
+		name: "HTTP passes after retries",
+		gate: healthGate{Name: "http", Timeout: "5s", HTTP: &httpHealthGate{URL: server.URL, ExpectedStatus: http.StatusOK}},
+	}, {
+		name:        "HTTP unexpected status",
+		gate:        healthGate{Name: "http", Timeout: "50ms", HTTP: &httpHealthGate{URL: server.URL, ExpectedStatus: http.StatusNoContent}},
+		expectedErr: "returned 200, expected 204: ok",
+	}, {
+		name: "command passes",
+		gate: healthGate{Name: "command", Timeout: "1s", Command: []string{"true"}},
+	}, {
+		name:        "command fails with its output",
+		gate:        healthGate{Name: "command", Timeout: "50ms", Command: []string{"sh", "-c", "echo database unreachable; exit 3"}},
+		expectedErr: "exit status 3: database unreachable",
+	}, {
+		name: "enough pods ready on the node",
+		gate: healthGate{Name: "pods", Timeout: "1s", Pods: &podsHealthGate{Namespace: "openshift-dns", Selector: "app=dns", MinReady: 1}},
+	}, {
+		name:        "not enough pods ready on the node",
+		gate:        healthGate{Name: "pods", Timeout: "50ms", Pods: &podsHealthGate{Namespace: "openshift-dns", Selector: "app=dns", MinReady: 2}},
+		expectedErr: "1 of 2 pods matching \"app=dns\" in namespace openshift-dns are ready, expected at least 2; not ready: [dns-starting]",
+	}}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := waitForHealthGate(context.TODO(), &test.gate, kubeClient, "node-0")
+			if test.expectedErr == "" {
+				require.NoError(t, err)
+				return
+			}
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), "health gate "+test.gate.Name+" did not pass within")
+			assert.Contains(t, err.Error(), test.expectedErr)
+		})
+	}
+}
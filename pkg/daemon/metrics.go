@@ -57,6 +57,14 @@ var (
 			Name: "mcd_config_drift",
 			Help: "timestamp for config drift",
 		})
+
+	// mcdConfigDriftRemediations counts the config drift remediations
+	mcdConfigDriftRemediations = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "mcd_config_drift_remediations_total",
+			Help: "Total number of config drift remediations.",
+		})
+
 	// mcdMissingMC tracks the missing machine config error
 	mcdMissingMC = prometheus.NewGaugeVec(
 		prometheus.GaugeOpts{
@@ -92,6 +100,7 @@ func RegisterMCDMetrics() error {
 		mcdRebootErr,
 		mcdUpdateState,
 		mcdConfigDrift,
+		mcdConfigDriftRemediations,
 		unsupportedPackages,
 	})
 
@@ -0,0 +1,445 @@
+package daemon
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	rpmostreeclient "github.com/coreos/rpmostree-client-go/pkg/client"
+	mcfgv1 "github.com/openshift/api/machineconfiguration/v1"
+	opv1 "github.com/openshift/api/operator/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/klog/v2"
+
+	ctrlcommon "github.com/openshift/machine-config-operator/pkg/controller/common"
+	"github.com/openshift/machine-config-operator/pkg/daemon/constants"
+)
+
+// queryOSStatus queries the deployments of the node. It is a variable so
+// tests can replace it.
+var queryOSStatus = func(client *RpmOstreeClient) (*rpmostreeclient.Status, error) {
+	return client.Peel().QueryStatus()
+}
+
+const (
+	// updateStepDrain drains the node.
+	updateStepDrain = "Drain"
+	// updateStepFiles writes the files and units, SSH keys and password
+	// hashes.
+	updateStepFiles = "Files"
+	// updateStepOS applies the OS image, kernel arguments, extensions and
+	// kernel type.
+	updateStepOS = "OS"
+	// updateStepPostConfigChangeActions runs the post config change actions,
+	// e.g. restarting services.
+	updateStepPostConfigChangeActions = "PostConfigChangeActions"
+	// updateStepReboot lasts from the reboot until the update completes on
+	// the next boot.
+	updateStepReboot = "Reboot"
+)
+
+// NodeStatus is the status of the node as seen by the MCD. It is served on
+// the local status endpoint of the MCD, for node-local tooling and debugging
+// sessions which cannot reach the API server.
+type NodeStatus struct {
+	Node          string `json:"node"`
+	State         string `json:"state,omitempty"`
+	CurrentConfig string `json:"currentConfig,omitempty"`
+	DesiredConfig string `json:"desiredConfig,omitempty"`
+	CurrentImage  string `json:"currentImage,omitempty"`
+	DesiredImage  string `json:"desiredImage,omitempty"`
+	// OnDiskConfig is the config the MCD last wrote to disk, which is the
+	// truth if the node annotations disagree.
+	OnDiskConfig string `json:"onDiskConfig,omitempty"`
+
+	BootedDeployment   *NodeStatusDeployment `json:"bootedDeployment,omitempty"`
+	StagedDeployment   *NodeStatusDeployment `json:"stagedDeployment,omitempty"`
+	RollbackDeployment *NodeStatusDeployment `json:"rollbackDeployment,omitempty"`
+	// DeploymentsError is set if the deployments could not be queried.
+	DeploymentsError string `json:"deploymentsError,omitempty"`
+
+	LastUpdate  *UpdateRecord     `json:"lastUpdate,omitempty"`
+	ConfigDrift ConfigDriftStatus `json:"configDrift"`
+	// PendingReboot lists the reasons the node is waiting for a reboot, if
+	// any.
+	PendingReboot []string `json:"pendingReboot,omitempty"`
+}
+
+// NodeStatusDeployment is an OS deployment of the node.
+type NodeStatusDeployment struct {
+	ID                string   `json:"id"`
+	Checksum          string   `json:"checksum"`
+	Version           string   `json:"version,omitempty"`
+	Image             string   `json:"image,omitempty"`
+	RequestedPackages []string `json:"requestedPackages,omitempty"`
+}
+
+// ConfigDriftStatus is the state of the Config Drift Monitor.
+type ConfigDriftStatus struct {
+	Running      bool       `json:"running"`
+	LastDetected *time.Time `json:"lastDetected,omitempty"`
+	LastError    string     `json:"lastError,omitempty"`
+}
+
+// UpdateRecord is the plan of the last MachineConfig update of the node and
+// the timings of its steps. It is kept on disk so it survives the reboot of
+// the update.
+type UpdateRecord struct {
+	Plan *UpdatePlan `json:"plan"`
+	// OldImage and NewImage are the OS images of the update; the layered
+	// images of on-cluster layering or the OS images of the configs.
+	OldImage string       `json:"oldImage,omitempty"`
+	NewImage string       `json:"newImage,omitempty"`
+	Start    time.Time    `json:"start"`
+	End      *time.Time   `json:"end,omitempty"`
+	Error    string       `json:"error,omitempty"`
+	Steps    []UpdateStep `json:"steps"`
+	// BootID is the boot the update started in.
+	BootID string `json:"bootID,omitempty"`
+}
+
+// UpdateStep is a step of an update. A step lasts until the next one starts.
+type UpdateStep struct {
+	Name  string     `json:"name"`
+	Start time.Time  `json:"start"`
+	End   *time.Time `json:"end,omitempty"`
+	Error string     `json:"error,omitempty"`
+}
+
+// updateRecorder keeps the record of the last update in memory and on disk,
+// and adds the updates it finishes to the update history.
+type updateRecorder struct {
+	mu          sync.Mutex
+	path        string
+	historyPath string
+	record      *UpdateRecord
+	// now is a variable so tests can replace it.
+	now func() time.Time
+}
+
+func newUpdateRecorder(path, historyPath string) *updateRecorder {
+	r := &updateRecorder{path: path, historyPath: historyPath, now: time.Now}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			klog.Warningf("Could not read last update record: %v", err)
+		}
+		return r
+	}
+	record := &UpdateRecord{}
+	if err := json.Unmarshal(data, record); err != nil {
+		klog.Warningf("Could not parse last update record %s: %v", path, err)
+		return r
+	}
+	r.record = record
+	return r
+}
+
+// persist writes the record to disk. The record is only informational, so
+// errors are logged and not returned.
+func (r *updateRecorder) persist() {
+	data, err := json.Marshal(r.record)
+	if err != nil {
+		klog.Warningf("Could not marshal last update record: %v", err)
+		return
+	}
+	if err := writeFileAtomicallyWithDefaults(r.path, data); err != nil {
+		klog.Warningf("Could not write last update record: %v", err)
+	}
+}
+
+// begin starts the record of an update, replacing the previous one.
+func (r *updateRecorder) begin(plan *UpdatePlan, oldImage, newImage, bootID string) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.record = &UpdateRecord{Plan: plan, OldImage: oldImage, NewImage: newImage, Start: r.now(), BootID: bootID, Steps: []UpdateStep{}}
+	r.persist()
+}
+
+// endStep ends the running step of the record, if any.
+func (r *updateRecorder) endStep(end time.Time, err error) {
+	steps := r.record.Steps
+	if len(steps) == 0 || steps[len(steps)-1].End != nil {
+		return
+	}
+	steps[len(steps)-1].End = &end
+	if err != nil {
+		steps[len(steps)-1].Error = err.Error()
+	}
+}
+
+// startStep ends the running step of the update and starts the next one.
+func (r *updateRecorder) startStep(name string) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.record == nil || r.record.End != nil {
+		return
+	}
+	now := r.now()
+	r.endStep(now, nil)
+	r.record.Steps = append(r.record.Steps, UpdateStep{Name: name, Start: now})
+	r.persist()
+}
+
+// finish ends the update to the given config with the error it failed with,
+// if any, and adds it to the update history. It returns the update history,
+// or nil if there was no such update to finish. Records of other updates are
+// left untouched.
+func (r *updateRecorder) finish(configName string, err error) []UpdateHistoryEntry {
+	if r == nil {
+		return nil
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.record == nil || r.record.End != nil || r.record.Plan == nil || r.record.Plan.NewConfig != configName {
+		return nil
+	}
+	now := r.now()
+	r.endStep(now, err)
+	r.record.End = &now
+	if err != nil {
+		r.record.Error = err.Error()
+	}
+	r.persist()
+
+	history, histErr := appendUpdateHistory(r.historyPath, newUpdateHistoryEntry(r.record), updateHistoryLimit)
+	if histErr != nil {
+		klog.Warningf("Could not add update to %s to the update history: %v", configName, histErr)
+		return nil
+	}
+	return history
+}
+
+// get returns a copy of the record.
+func (r *updateRecorder) get() *UpdateRecord {
+	if r == nil {
+		return nil
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.record == nil {
+		return nil
+	}
+	record := *r.record
+	record.Steps = append([]UpdateStep{}, r.record.Steps...)
+	return &record
+}
+
+// awaitingReboot returns whether the update rebooted the node, or is about to,
+// and has not yet been completed from a new boot.
+func (r *UpdateRecord) awaitingReboot(bootID string) bool {
+	if r == nil || r.End != nil || len(r.Steps) == 0 {
+		return false
+	}
+	return r.Steps[len(r.Steps)-1].Name == updateStepReboot && r.BootID == bootID
+}
+
+// beginUpdateRecord records the plan of an update from the actions and drain
+// the update computed.
+func (dn *Daemon) beginUpdateRecord(oldConfig, newConfig *mcfgv1.MachineConfig, oldImage, newImage string, drain bool, nodeDisruptionActions []opv1.NodeDisruptionPolicyStatusAction, actions []string) {
+	if dn.updateRecorder == nil {
+		return
+	}
+	// The actions are already known, so skip computing them again.
+	plan, err := PlanUpdate(oldConfig, newConfig, UpdatePlanOptions{Force: true})
+	if err != nil {
+		klog.Warningf("Could not record update plan: %v", err)
+		return
+	}
+	plan.DrainRequired = drain
+	plan.Actions = []string{}
+	for _, action := range nodeDisruptionActions {
+		plan.Actions = append(plan.Actions, ctrlcommon.NodeDisruptionActionToString(action))
+	}
+	plan.Actions = append(plan.Actions, actions...)
+	dn.updateRecorder.begin(plan, oldImage, newImage, dn.bootID)
+}
+
+// finishUpdateRecord ends the record of the update to the given config and
+// reports the update history on the MachineConfigNode.
+func (dn *Daemon) finishUpdateRecord(configName string, err error) {
+	history := dn.updateRecorder.finish(configName, err)
+	dn.refreshOSStatus()
+	if history == nil {
+		return
+	}
+	dn.reportUpdateHistory(history)
+}
+
+// recordConfigDrift records a config drift for the node status.
+func (dn *Daemon) recordConfigDrift(err error) {
+	dn.configDriftStatusLock.Lock()
+	defer dn.configDriftStatusLock.Unlock()
+	now := time.Now()
+	dn.configDriftStatus.LastDetected = &now
+	dn.configDriftStatus.LastError = err.Error()
+}
+
+// getDeploymentsStatus returns the booted, staged and rollback deployments of
+// an rpm-ostree status. rpm-ostree also reports the deployments of bootc
+// systems, as both are based on OSTree.
+func getDeploymentsStatus(status *rpmostreeclient.Status) (booted, staged, rollback *NodeStatusDeployment) {
+	bootedSeen := false
+	for i := range status.Deployments {
+		d := &status.Deployments[i]
+		deployment := &NodeStatusDeployment{
+			ID:                d.ID,
+			Checksum:          d.Checksum,
+			Version:           d.Version,
+			Image:             d.ContainerImageReference,
+			RequestedPackages: d.RequestedPackages,
+		}
+		switch {
+		case d.Booted:
+			booted = deployment
+			bootedSeen = true
+		case d.Staged:
+			staged = deployment
+		case bootedSeen && rollback == nil:
+			// The rollback is the deployment right after the booted one.
+			rollback = deployment
+		}
+	}
+	return booted, staged, rollback
+}
+
+// setNode replaces the cached node. The cached node is only written by the
+// sync goroutine, which reads it without the lock.
+func (dn *Daemon) setNode(node *corev1.Node) {
+	dn.statusLock.Lock()
+	defer dn.statusLock.Unlock()
+	dn.node = node
+}
+
+// refreshOSStatus queries the deployments of the node and caches them for the
+// node status, so the status endpoint does not query rpm-ostree on each
+// request. It is called when the status is first served and whenever an
+// update may have changed the deployments.
+func (dn *Daemon) refreshOSStatus() {
+	if dn.NodeUpdaterClient == nil {
+		return
+	}
+	status, err := queryOSStatus(dn.NodeUpdaterClient)
+	if err != nil {
+		klog.Warningf("Could not query the OS status for the node status: %v", err)
+	}
+	dn.statusLock.Lock()
+	defer dn.statusLock.Unlock()
+	dn.osStatus = status
+	dn.osStatusErr = err
+	dn.osStatusQueried = true
+}
+
+// getNodeStatus returns the status of the node. It only uses the cached node
+// and the local state of the host, so it works without the API server.
+func (dn *Daemon) getNodeStatus() *NodeStatus {
+	dn.statusLock.Lock()
+	node := dn.node
+	rebootQueued := dn.rebootQueued
+	osStatusQueried := dn.osStatusQueried
+	dn.statusLock.Unlock()
+
+	if !osStatusQueried {
+		dn.refreshOSStatus()
+	}
+	dn.statusLock.Lock()
+	osStatus, osStatusErr := dn.osStatus, dn.osStatusErr
+	dn.statusLock.Unlock()
+
+	status := &NodeStatus{Node: dn.name}
+	if node != nil {
+		annos := node.Annotations
+		status.State = annos[constants.MachineConfigDaemonStateAnnotationKey]
+		status.CurrentConfig = annos[constants.CurrentMachineConfigAnnotationKey]
+		status.DesiredConfig = annos[constants.DesiredMachineConfigAnnotationKey]
+		status.CurrentImage = annos[constants.CurrentImageAnnotationKey]
+		status.DesiredImage = annos[constants.DesiredImageAnnotationKey]
+	}
+	if odc, err := dn.getCurrentConfigOnDisk(); err == nil {
+		status.OnDiskConfig = odc.currentConfig.GetName()
+	}
+
+	if osStatusErr != nil {
+		status.DeploymentsError = osStatusErr.Error()
+	} else if osStatus != nil {
+		status.BootedDeployment, status.StagedDeployment, status.RollbackDeployment = getDeploymentsStatus(osStatus)
+	}
+
+	status.LastUpdate = dn.updateRecorder.get()
+
+	dn.configDriftStatusLock.Lock()
+	status.ConfigDrift = dn.configDriftStatus
+	dn.configDriftStatusLock.Unlock()
+	if dn.configDriftMonitor != nil {
+		status.ConfigDrift.Running = dn.configDriftMonitor.IsRunning()
+	}
+
+	if rebootQueued {
+		status.PendingReboot = append(status.PendingReboot, "a reboot is queued and waiting for the graceful shutdown of the node")
+	}
+	if status.LastUpdate.awaitingReboot(dn.bootID) {
+		status.PendingReboot = append(status.PendingReboot, fmt.Sprintf("the update to %s requires a reboot", status.LastUpdate.Plan.NewConfig))
+	}
+	if status.StagedDeployment != nil {
+		status.PendingReboot = append(status.PendingReboot, fmt.Sprintf("deployment %s is staged for the next boot", status.StagedDeployment.Checksum))
+	}
+	return status
+}
+
+// RunStatusListener serves the status of the node on the given address until
+// stopCh is closed.
+func (dn *Daemon) RunStatusListener(address string, stopCh <-chan struct{}) {
+	klog.Infof("Starting status listener on %s", address)
+	mux := http.NewServeMux()
+	mux.Handle("/status", &statusHandler{dn: dn})
+	s := http.Server{
+		Addr:              address,
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	go func() {
+		if err := s.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			klog.Errorf("status listener exited with error: %v", err)
+		}
+	}()
+	<-stopCh
+	if err := s.Shutdown(context.Background()); err != nil && err != http.ErrServerClosed {
+		klog.Errorf("error stopping status listener: %v", err)
+	}
+}
+
+// statusHandler serves the status of the node as JSON.
+type statusHandler struct {
+	dn *Daemon
+}
+
+func (h *statusHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	data, err := json.MarshalIndent(h.dn.getNodeStatus(), "", "  ")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if r.Method == http.MethodGet {
+		if _, err := w.Write(append(data, '\n')); err != nil {
+			klog.V(4).Infof("Could not write node status: %v", err)
+		}
+	}
+}
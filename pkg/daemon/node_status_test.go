@@ -0,0 +1,219 @@
+package daemon
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	rpmostreeclient "github.com/coreos/rpmostree-client-go/pkg/client"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/openshift/machine-config-operator/pkg/daemon/constants"
+)
+
+func TestUpdateRecorder(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "lastupdate")
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	clock := start
+	newRecorder := func() *updateRecorder {
+		r := newUpdateRecorder(path, filepath.Join(filepath.Dir(path), "updatehistory"))
+		r.now = func() time.Time {
+			clock = clock.Add(time.Minute)
+			return clock
+		}
+		return r
+	}
+
+	r := newRecorder()
+	assert.Nil(t, r.get())
+
+	r.begin(&UpdatePlan{OldConfig: "rendered-worker-1", NewConfig: "rendered-worker-2"}, "", "", "boot1")
+	r.startStep(updateStepDrain)
+	r.startStep(updateStepFiles)
+	r.startStep(updateStepReboot)
+
+	// The record survives the reboot.
+	r = newRecorder()
+	record := r.get()
+	require.NotNil(t, record)
+	require.Len(t, record.Steps, 3)
+	assert.Equal(t, updateStepDrain, record.Steps[0].Name)
+	assert.Equal(t, time.Minute, record.Steps[0].End.Sub(record.Steps[0].Start))
+	assert.Nil(t, record.Steps[2].End)
+	assert.True(t, record.awaitingReboot("boot1"))
+	assert.False(t, record.awaitingReboot("boot2"))
+
+	// Other updates don't finish the record.
+	r.finish("rendered-worker-3", nil)
+	assert.Nil(t, r.get().End)
+
+	r.finish("rendered-worker-2", nil)
+	record = r.get()
+	require.NotNil(t, record.End)
+	require.NotNil(t, record.Steps[2].End)
+	assert.Empty(t, record.Error)
+	assert.False(t, record.awaitingReboot("boot1"))
+
+	// Steps of a finished update are ignored.
+	r.startStep(updateStepOS)
+	assert.Len(t, r.get().Steps, 3)
+
+	r.begin(&UpdatePlan{NewConfig: "rendered-worker-3"}, "", "", "boot2")
+	r.startStep(updateStepFiles)
+	r.finish("rendered-worker-3", errors.New("write failed"))
+	record = r.get()
+	assert.Equal(t, "write failed", record.Error)
+	assert.Equal(t, "write failed", record.Steps[0].Error)
+}
+
+func TestGetDeploymentsStatus(t *testing.T) {
+	booted, staged, rollback := getDeploymentsStatus(&rpmostreeclient.Status{Deployments: []rpmostreeclient.Deployment{
+		{ID: "staged", Checksum: "c", Staged: true},
+		{ID: "booted", Checksum: "b", Booted: true, ContainerImageReference: "ostree-unverified-registry:registry/os@sha256:b"},
+		{ID: "rollback", Checksum: "a"},
+		{ID: "older", Checksum: "0"},
+	}})
+	require.NotNil(t, booted)
+	assert.Equal(t, "booted", booted.ID)
+	assert.Equal(t, "ostree-unverified-registry:registry/os@sha256:b", booted.Image)
+	require.NotNil(t, staged)
+	assert.Equal(t, "staged", staged.ID)
+	require.NotNil(t, rollback)
+	assert.Equal(t, "rollback", rollback.ID)
+
+	booted, staged, rollback = getDeploymentsStatus(&rpmostreeclient.Status{Deployments: []rpmostreeclient.Deployment{{ID: "booted", Booted: true}}})
+	assert.NotNil(t, booted)
+	assert.Nil(t, staged)
+	assert.Nil(t, rollback)
+}
+
+func TestGetNodeStatusCachesOSStatus(t *testing.T) {
+	queries := 0
+	deployments := []rpmostreeclient.Deployment{{ID: "booted", Checksum: "a", Booted: true}}
+	origQueryOSStatus := queryOSStatus
+	t.Cleanup(func() { queryOSStatus = origQueryOSStatus })
+	queryOSStatus = func(*RpmOstreeClient) (*rpmostreeclient.Status, error) {
+		queries++
+		return &rpmostreeclient.Status{Deployments: deployments}, nil
+	}
+
+	dir := t.TempDir()
+	dn := &Daemon{
+		name:              "node",
+		currentConfigPath: filepath.Join(dir, "currentconfig"),
+		currentImagePath:  filepath.Join(dir, "currentimage"),
+		NodeUpdaterClient: &RpmOstreeClient{},
+		updateRecorder:    newUpdateRecorder(filepath.Join(dir, "lastupdate"), filepath.Join(dir, "updatehistory")),
+	}
+
+	// The status is queried on the first request only.
+	for i := 0; i < 3; i++ {
+		status := dn.getNodeStatus()
+		require.NotNil(t, status.BootedDeployment)
+		assert.Nil(t, status.StagedDeployment)
+	}
+	assert.Equal(t, 1, queries)
+
+	// An update staging a deployment refreshes the status.
+	deployments = append(deployments, rpmostreeclient.Deployment{ID: "staged", Checksum: "b", Staged: true})
+	dn.updateRecorder.begin(&UpdatePlan{OldConfig: "rendered-worker-1", NewConfig: "rendered-worker-2"}, "", "", "boot1")
+	dn.finishUpdateRecord("rendered-worker-2", nil)
+	assert.Equal(t, 2, queries)
+	status := dn.getNodeStatus()
+	require.NotNil(t, status.StagedDeployment)
+	assert.Equal(t, "staged", status.StagedDeployment.ID)
+	assert.Equal(t, []string{"deployment b is staged for the next boot"}, status.PendingReboot)
+	assert.Equal(t, 2, queries)
+
+	// Errors are cached as well.
+	queryOSStatus = func(*RpmOstreeClient) (*rpmostreeclient.Status, error) {
+		queries++
+		return nil, errors.New("rpm-ostree failed")
+	}
+	dn.refreshOSStatus()
+	status = dn.getNodeStatus()
+	assert.Equal(t, "rpm-ostree failed", status.DeploymentsError)
+	assert.Nil(t, status.BootedDeployment)
+	assert.Equal(t, 3, queries)
+}
+
+func TestStatusHandler(t *testing.T) {
+	dir := t.TempDir()
+	dn := &Daemon{
+		name:   "node",
+		bootID: "boot1",
+		node: &corev1.Node{ObjectMeta: metav1.ObjectMeta{
+			Name: "node",
+			Annotations: map[string]string{
+				constants.MachineConfigDaemonStateAnnotationKey: constants.MachineConfigDaemonStateWorking,
+				constants.CurrentMachineConfigAnnotationKey:     "rendered-worker-1",
+				constants.DesiredMachineConfigAnnotationKey:     "rendered-worker-2",
+			},
+		}},
+		currentConfigPath:  filepath.Join(dir, "currentconfig"),
+		currentImagePath:   filepath.Join(dir, "currentimage"),
+		updateRecorder:     newUpdateRecorder(filepath.Join(dir, "lastupdate"), filepath.Join(dir, "updatehistory")),
+		configDriftMonitor: NewConfigDriftMonitor(),
+	}
+	dn.updateRecorder.begin(&UpdatePlan{OldConfig: "rendered-worker-1", NewConfig: "rendered-worker-2"}, "", "", "boot1")
+	dn.updateRecorder.startStep(updateStepReboot)
+	dn.recordConfigDrift(errors.New("content mismatch for file \"/etc/foo\""))
+
+	handler := &statusHandler{dn: dn}
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/status", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "application/json", rec.Header().Get("Content-Type"))
+
+	status := &NodeStatus{}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), status))
+	assert.Equal(t, "node", status.Node)
+	assert.Equal(t, constants.MachineConfigDaemonStateWorking, status.State)
+	assert.Equal(t, "rendered-worker-1", status.CurrentConfig)
+	assert.Equal(t, "rendered-worker-2", status.DesiredConfig)
+	require.NotNil(t, status.LastUpdate)
+	assert.Equal(t, "rendered-worker-2", status.LastUpdate.Plan.NewConfig)
+	assert.False(t, status.ConfigDrift.Running)
+	assert.NotNil(t, status.ConfigDrift.LastDetected)
+	assert.Equal(t, "content mismatch for file \"/etc/foo\"", status.ConfigDrift.LastError)
+	assert.Equal(t, []string{"the update to rendered-worker-2 requires a reboot"}, status.PendingReboot)
+
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/status", nil))
+	assert.Equal(t, http.StatusMethodNotAllowed, rec.Code)
+}
+
+func TestGetNodeStatusConcurrentWithSync(t *testing.T) {
+	dir := t.TempDir()
+	dn := &Daemon{
+		name:              "node",
+		currentConfigPath: filepath.Join(dir, "currentconfig"),
+		currentImagePath:  filepath.Join(dir, "currentimage"),
+	}
+
+	// Run with -race: the status listener reads the node while the sync
+	// goroutine replaces it.
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 100; i++ {
+			dn.setNode(&corev1.Node{ObjectMeta: metav1.ObjectMeta{
+				Name:        "node",
+				Annotations: map[string]string{constants.CurrentMachineConfigAnnotationKey: "rendered-worker-1"},
+			}})
+		}
+	}()
+	for i := 0; i < 100; i++ {
+		dn.getNodeStatus()
+	}
+	<-done
+
+	assert.Equal(t, "rendered-worker-1", dn.getNodeStatus().CurrentConfig)
+}
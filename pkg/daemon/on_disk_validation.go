@@ -4,13 +4,18 @@ import (
 	"bytes"
 	"fmt"
 	"os"
+	"os/user"
 	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
 
 	ign2types "github.com/coreos/ignition/config/v2_2/types"
 	ign3types "github.com/coreos/ignition/v2/config/v3_5/types"
 	"github.com/google/go-cmp/cmp"
 	mcfgv1 "github.com/openshift/api/machineconfiguration/v1"
 	ctrlcommon "github.com/openshift/machine-config-operator/pkg/controller/common"
+	"github.com/openshift/machine-config-operator/pkg/daemon/constants"
 	"k8s.io/apimachinery/pkg/util/sets"
 	"k8s.io/klog/v2"
 )
@@ -30,6 +35,12 @@ func validateOnDiskState(currentConfig *mcfgv1.MachineConfig, systemdPath string
 		if err := checkV3Files(ignconfigi.(ign3types.Config).Storage.Files); err != nil {
 			return &fileConfigDriftErr{err}
 		}
+		if err := checkV3Directories(ignconfigi.(ign3types.Config).Storage.Directories); err != nil {
+			return &fileConfigDriftErr{err}
+		}
+		if err := checkV3Links(ignconfigi.(ign3types.Config).Storage.Links); err != nil {
+			return &fileConfigDriftErr{err}
+		}
 		if err := checkV3Units(ignconfigi.(ign3types.Config).Systemd.Units, systemdPath); err != nil {
 			return &unitConfigDriftErr{err}
 		}
@@ -185,19 +196,105 @@ func checkV3Files(files []ign3types.File) error {
 			klog.V(4).Infof("Skipping file %s during checkV3Files", f.Path)
 			continue
 		}
-		if len(f.Append) > 0 {
-			return fmt.Errorf("found an append section when checking files. Append is not supported")
-		}
 		mode := defaultFilePermissions
 		if f.Mode != nil {
 			mode = os.FileMode(*f.Mode) //nolint:gosec
 		}
-		contents, err := ctrlcommon.DecodeIgnitionFileContents(f.Contents.Source, f.Contents.Compression)
+		switch {
+		case isAppendOnlyFile(f):
+			if err := checkAppendedFile(f); err != nil {
+				return err
+			}
+		case ctrlcommon.IsRemoteFileSource(f.Contents.Source):
+			// Remote sources are checked against their hash rather than
+			// fetched again.
+			if err := checkFileHashAndMode(f, mode); err != nil {
+				return err
+			}
+		default:
+			contents, err := ctrlcommon.DecodeIgnitionFileContents(f.Contents.Source, f.Contents.Compression)
+			if err != nil {
+				return fmt.Errorf("couldn't decode file %q: %w", f.Path, err)
+			}
+			fragments, _, err := decodeAppendFragments(f)
+			if err != nil {
+				return err
+			}
+			if err := checkFileContentsAndMode(f.Path, append(contents, fragments...), mode); err != nil {
+				return err
+			}
+		}
+		// Only root can change the ownership of files, as the MCD does.
+		if os.Geteuid() == 0 {
+			if err := checkFileOwnership(f); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// checkV3Directories validates that all the directories in the target config
+// exist with the expected mode and ownership.
+func checkV3Directories(dirs []ign3types.Directory) error {
+	for _, d := range dirs {
+		fi, err := os.Lstat(d.Path)
 		if err != nil {
-			return fmt.Errorf("couldn't decode file %q: %w", f.Path, err)
+			return fmt.Errorf("could not stat directory %q: %w", d.Path, err)
 		}
-		if err := checkFileContentsAndMode(f.Path, contents, mode); err != nil {
-			return err
+		if !fi.IsDir() {
+			return fmt.Errorf("expected %q to be a directory, found %v", d.Path, fi.Mode().Type())
+		}
+		mode := getDirectoryMode(d)
+		if got := fi.Mode() &^ os.ModeDir; got != mode {
+			return fmt.Errorf("mode mismatch for directory: %q; expected: %[2]v/%[2]d/%#[2]o; received: %[3]v/%[3]d/%#[3]o", d.Path, mode, got)
+		}
+		// Only root can change the ownership of directories, as the MCD does.
+		if os.Geteuid() == 0 {
+			if err := checkNodeOwnership(d.Node, fi); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// checkV3Links validates that all the links in the target config exist and
+// point at their target.
+func checkV3Links(links []ign3types.Link) error {
+	for _, l := range links {
+		if l.Target == nil {
+			continue
+		}
+		if l.Hard != nil && *l.Hard {
+			fi, err := os.Lstat(l.Path)
+			if err != nil {
+				return fmt.Errorf("could not stat hard link %q: %w", l.Path, err)
+			}
+			target, err := os.Lstat(*l.Target)
+			if err != nil {
+				return fmt.Errorf("could not stat target of hard link %q: %w", l.Path, err)
+			}
+			if !os.SameFile(fi, target) {
+				return fmt.Errorf("hard link %q does not link to %q", l.Path, *l.Target)
+			}
+			continue
+		}
+		target, err := os.Readlink(l.Path)
+		if err != nil {
+			return fmt.Errorf("could not read symbolic link %q: %w", l.Path, err)
+		}
+		if target != *l.Target {
+			return fmt.Errorf("target mismatch for symbolic link: %q; expected: %q; received: %q", l.Path, *l.Target, target)
+		}
+		if os.Geteuid() == 0 {
+			fi, err := os.Lstat(l.Path)
+			if err != nil {
+				return fmt.Errorf("could not stat symbolic link %q: %w", l.Path, err)
+			}
+			if err := checkNodeOwnership(l.Node, fi); err != nil {
+				return err
+			}
 		}
 	}
 	return nil
@@ -282,3 +379,201 @@ func getSystemdPath(systemdPath string) string {
 
 	return systemdPath
 }
+
+// checkFileOwnership validates that the file is owned by the user and group
+// the config specifies, root by default.
+func checkFileOwnership(f ign3types.File) error {
+	fi, err := os.Lstat(f.Path)
+	if err != nil {
+		return fmt.Errorf("could not stat file %q: %w", f.Path, err)
+	}
+	return checkNodeOwnership(f.Node, fi)
+}
+
+// checkNodeOwnership validates that the path of a file, directory or link
+// with the given info is owned by the user and group the config specifies.
+func checkNodeOwnership(node ign3types.Node, fi os.FileInfo) error {
+	uid, gid, err := getNodeOwnership(node)
+	if err != nil {
+		return fmt.Errorf("could not get ownership of %q: %w", node.Path, err)
+	}
+	stat, ok := fi.Sys().(*syscall.Stat_t)
+	if !ok {
+		return nil
+	}
+	// As with chown, an ID of -1 leaves the ownership unchanged.
+	if (uid >= 0 && int(stat.Uid) != uid) || (gid >= 0 && int(stat.Gid) != gid) {
+		return fmt.Errorf("ownership mismatch for file: %q; expected: %d:%d; received: %d:%d", node.Path, uid, gid, stat.Uid, stat.Gid)
+	}
+	return nil
+}
+
+// checkUnitsEnablement validates that the units which the config enables or
+// disables are still enabled or disabled.
+func checkUnitsEnablement(units []ign3types.Unit) error {
+	for _, unit := range units {
+		if unit.Enabled == nil || (unit.Mask != nil && *unit.Mask) {
+			continue
+		}
+		state := systemctlIsEnabled(unit.Name)
+		switch {
+		case *unit.Enabled && state == "disabled":
+			return fmt.Errorf("state validation: unit %q is disabled, expected enabled", unit.Name)
+		case !*unit.Enabled && (state == "enabled" || state == "enabled-runtime"):
+			return fmt.Errorf("state validation: unit %q is %s, expected disabled", unit.Name, state)
+		}
+	}
+	return nil
+}
+
+// checkSSHKeys validates that the authorized keys file contains the SSH keys
+// of the core user in the config, as written by updateSSHKeys.
+func checkSSHKeys(users []ign3types.PasswdUser, authKeyPath string) error {
+	var expected string
+	for _, u := range users {
+		if u.Name != constants.CoreUserName {
+			continue
+		}
+		for _, k := range u.SSHAuthorizedKeys {
+			expected = expected + string(k) + "\n"
+		}
+	}
+	contents, err := os.ReadFile(authKeyPath)
+	if os.IsNotExist(err) && expected == "" {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("could not read SSH keys %q: %w", authKeyPath, err)
+	}
+	if string(contents) != expected {
+		return fmt.Errorf("content mismatch for SSH keys %q", authKeyPath)
+	}
+	return nil
+}
+
+// checkUsersAndGroups validates that the groups and the users other than core
+// in the config exist, and that the users have their SSH keys, as written by
+// updateUsersAndGroups and updateSSHKeys.
+func checkUsersAndGroups(ignConfig ign3types.Config, getSSHKeyPath func(homeDir string) string) error {
+	for _, g := range ignConfig.Passwd.Groups {
+		if _, err := user.LookupGroup(g.Name); err != nil {
+			return fmt.Errorf("state validation: could not find group %q: %w", g.Name, err)
+		}
+	}
+	for _, u := range ignConfig.Passwd.Users {
+		if u.Name == constants.CoreUserName {
+			continue
+		}
+		info, err := user.Lookup(u.Name)
+		if err != nil {
+			return fmt.Errorf("state validation: could not find user %q: %w", u.Name, err)
+		}
+		if u.UID != nil && info.Uid != strconv.Itoa(*u.UID) {
+			return fmt.Errorf("state validation: uid mismatch for user %q; expected: %d; received: %s", u.Name, *u.UID, info.Uid)
+		}
+		if len(u.SSHAuthorizedKeys) == 0 {
+			continue
+		}
+		var expected string
+		for _, k := range u.SSHAuthorizedKeys {
+			expected = expected + string(k) + "\n"
+		}
+		authKeyPath := getSSHKeyPath(info.HomeDir)
+		contents, err := os.ReadFile(authKeyPath)
+		if err != nil {
+			return fmt.Errorf("could not read SSH keys of user %q: %w", u.Name, err)
+		}
+		if string(contents) != expected {
+			return fmt.Errorf("content mismatch for SSH keys %q", authKeyPath)
+		}
+	}
+	return nil
+}
+
+// shadowFilePath is where the password hashes of the users are stored.
+const shadowFilePath = "/etc/shadow"
+
+// checkPasswordHashes validates that the users with a password hash in the
+// config have it in the shadow file.
+func checkPasswordHashes(users []ign3types.PasswdUser, shadowPath string) error {
+	expected := map[string]string{}
+	for _, u := range users {
+		if u.PasswordHash != nil && *u.PasswordHash != "" {
+			expected[u.Name] = *u.PasswordHash
+		}
+	}
+	if len(expected) == 0 {
+		return nil
+	}
+	contents, err := os.ReadFile(shadowPath)
+	if err != nil {
+		return fmt.Errorf("could not read %q: %w", shadowPath, err)
+	}
+	for _, line := range strings.Split(string(contents), "\n") {
+		fields := strings.Split(line, ":")
+		if len(fields) < 2 {
+			continue
+		}
+		if hash, ok := expected[fields[0]]; ok {
+			if fields[1] != hash {
+				return fmt.Errorf("state validation: password hash mismatch for user %q", fields[0])
+			}
+			delete(expected, fields[0])
+		}
+	}
+	if len(expected) > 0 {
+		return fmt.Errorf("state validation: users %v not found in %q", sets.List(sets.KeySet(expected)), shadowPath)
+	}
+	return nil
+}
+
+// isKernelTypePackage returns whether a layered package is part of a kernel
+// type switch rather than an extension.
+func isKernelTypePackage(pkg string) bool {
+	return strings.HasPrefix(pkg, "kernel-rt-") || strings.HasPrefix(pkg, "kernel-64k-")
+}
+
+// checkKernelType validates that the packages layered on the booted
+// deployment match the kernel type of the config.
+func checkKernelType(currentConfig *mcfgv1.MachineConfig, requestedPackages []string) error {
+	found := ctrlcommon.KernelTypeDefault
+	for _, pkg := range requestedPackages {
+		switch {
+		case strings.HasPrefix(pkg, "kernel-rt-"):
+			found = ctrlcommon.KernelTypeRealtime
+		case strings.HasPrefix(pkg, "kernel-64k-"):
+			found = ctrlcommon.KernelType64kPages
+		}
+	}
+	if expected := ctrlcommon.CanonicalizeKernelType(currentConfig.Spec.KernelType); found != expected {
+		return fmt.Errorf("state validation: kernel type mismatch; expected: %s; received: %s", expected, found)
+	}
+	return nil
+}
+
+// checkLayeredPackages validates that the packages of the extensions of the
+// config are layered on the booted deployment, and returns the other layered
+// packages. Those are not drift: they may have been layered by an
+// administrator, and the mcd_local_unsupported_packages metric counts them.
+// Extensions map to packages on RHCOS, and are packages themselves on FCOS.
+func checkLayeredPackages(currentConfig *mcfgv1.MachineConfig, requestedPackages []string, extensionPackages bool) ([]string, error) {
+	expected := sets.New[string]()
+	supported := ctrlcommon.SupportedExtensions()
+	for _, ext := range currentConfig.Spec.Extensions {
+		if extensionPackages {
+			expected.Insert(supported[ext]...)
+		} else {
+			expected.Insert(ext)
+		}
+	}
+	found := sets.New[string]()
+	for _, pkg := range requestedPackages {
+		if !isKernelTypePackage(pkg) {
+			found.Insert(pkg)
+		}
+	}
+	if missing := expected.Difference(found); missing.Len() > 0 {
+		return nil, fmt.Errorf("state validation: missing extension packages: %v", sets.List(missing))
+	}
+	return sets.List(found.Difference(expected)), nil
+}
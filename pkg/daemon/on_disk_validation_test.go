@@ -0,0 +1,196 @@
+package daemon
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	ign3types "github.com/coreos/ignition/v2/config/v3_5/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	ctrlcommon "github.com/openshift/machine-config-operator/pkg/controller/common"
+	"github.com/openshift/machine-config-operator/test/helpers"
+)
+
+func TestCheckUnitsEnablement(t *testing.T) {
+	states := map[string]string{
+		"enabled.service":  "enabled",
+		"disabled.service": "disabled",
+		"runtime.service":  "enabled-runtime",
+	}
+	origIsEnabled := systemctlIsEnabled
+	t.Cleanup(func() { systemctlIsEnabled = origIsEnabled })
+	systemctlIsEnabled = func(unit string) string {
+		return states[unit]
+	}
+
+	testCases := []struct {
+		name      string
+		units     []ign3types.Unit
+		expectErr bool
+	}{
+		{
+			name: "matching enablement",
+			units: []ign3types.Unit{
+				{Name: "enabled.service", Enabled: helpers.BoolToPtr(true)},
+				{Name: "disabled.service", Enabled: helpers.BoolToPtr(false)},
+				{Name: "unmanaged.service"},
+			},
+		},
+		{
+			name:      "disabled unit expected enabled",
+			units:     []ign3types.Unit{{Name: "disabled.service", Enabled: helpers.BoolToPtr(true)}},
+			expectErr: true,
+		},
+		{
+			name:      "runtime enabled unit expected disabled",
+			units:     []ign3types.Unit{{Name: "runtime.service", Enabled: helpers.BoolToPtr(false)}},
+			expectErr: true,
+		},
+		{
+			name:  "masked unit",
+			units: []ign3types.Unit{{Name: "enabled.service", Enabled: helpers.BoolToPtr(false), Mask: helpers.BoolToPtr(true)}},
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			err := checkUnitsEnablement(testCase.units)
+			if testCase.expectErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestCheckSSHKeys(t *testing.T) {
+	authKeyPath := filepath.Join(t.TempDir(), "authorized_keys")
+	users := []ign3types.PasswdUser{{Name: "core", SSHAuthorizedKeys: []ign3types.SSHAuthorizedKey{"key1", "key2"}}}
+
+	// Without keys, a missing file is expected.
+	assert.NoError(t, checkSSHKeys([]ign3types.PasswdUser{{Name: "core"}}, authKeyPath))
+	assert.Error(t, checkSSHKeys(users, authKeyPath))
+
+	require.NoError(t, os.WriteFile(authKeyPath, []byte("key1\nkey2\n"), 0o600))
+	assert.NoError(t, checkSSHKeys(users, authKeyPath))
+
+	require.NoError(t, os.WriteFile(authKeyPath, []byte("key1\nkey2\nadded\n"), 0o600))
+	assert.Error(t, checkSSHKeys(users, authKeyPath))
+}
+
+func TestCheckPasswordHashes(t *testing.T) {
+	shadowPath := filepath.Join(t.TempDir(), "shadow")
+	require.NoError(t, os.WriteFile(shadowPath, []byte("root:!locked::0:99999:7:::\ncore:$6$hash:19000:0:99999:7:::\n"), 0o600))
+
+	assert.NoError(t, checkPasswordHashes([]ign3types.PasswdUser{{Name: "core", PasswordHash: helpers.StrToPtr("$6$hash")}}, shadowPath))
+	assert.NoError(t, checkPasswordHashes([]ign3types.PasswdUser{{Name: "core"}}, shadowPath))
+	assert.Error(t, checkPasswordHashes([]ign3types.PasswdUser{{Name: "core", PasswordHash: helpers.StrToPtr("$6$other")}}, shadowPath))
+	assert.Error(t, checkPasswordHashes([]ign3types.PasswdUser{{Name: "missing", PasswordHash: helpers.StrToPtr("$6$hash")}}, shadowPath))
+}
+
+func TestCheckKernelType(t *testing.T) {
+	mc := helpers.CreateMachineConfigFromIgnitionWithMetadata(ctrlcommon.NewIgnConfig(), "rendered-worker-1", "worker")
+
+	assert.NoError(t, checkKernelType(mc, []string{"usbguard"}))
+	assert.Error(t, checkKernelType(mc, []string{"kernel-rt-core"}))
+
+	mc.Spec.KernelType = ctrlcommon.KernelTypeRealtime
+	assert.NoError(t, checkKernelType(mc, []string{"kernel-rt-core", "kernel-rt-modules"}))
+	assert.Error(t, checkKernelType(mc, nil))
+
+	mc.Spec.KernelType = ctrlcommon.KernelType64kPages
+	assert.NoError(t, checkKernelType(mc, []string{"kernel-64k-core"}))
+}
+
+func TestCheckLayeredPackages(t *testing.T) {
+	mc := helpers.CreateMachineConfigFromIgnitionWithMetadata(ctrlcommon.NewIgnConfig(), "rendered-worker-1", "worker")
+	mc.Spec.Extensions = []string{"usbguard"}
+
+	testCases := []struct {
+		name              string
+		requestedPackages []string
+		extensionPackages bool
+		expectedExtra     []string
+		expectErr         bool
+	}{
+		{
+			name:              "extension packages",
+			requestedPackages: ctrlcommon.SupportedExtensions()["usbguard"],
+			extensionPackages: true,
+		},
+		{
+			name:              "extensions are packages",
+			requestedPackages: []string{"usbguard"},
+		},
+		{
+			name:              "kernel type packages are ignored",
+			requestedPackages: []string{"usbguard", "kernel-rt-core"},
+		},
+		{
+			name:      "missing extension",
+			expectErr: true,
+		},
+		{
+			name:              "other packages are not drift",
+			requestedPackages: []string{"usbguard", "htop"},
+			expectedExtra:     []string{"htop"},
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			extra, err := checkLayeredPackages(mc, testCase.requestedPackages, testCase.extensionPackages)
+			if testCase.expectErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+				assert.ElementsMatch(t, testCase.expectedExtra, extra)
+			}
+		})
+	}
+}
+
+func TestCheckFileOwnership(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "file")
+	require.NoError(t, os.WriteFile(path, []byte("contents"), 0o644))
+
+	file := ctrlcommon.NewIgnFile(path, "contents")
+	file.User.ID = helpers.IntToPtr(os.Getuid())
+	file.Group.ID = helpers.IntToPtr(os.Getgid())
+	assert.NoError(t, checkFileOwnership(file))
+
+	file.User.ID = helpers.IntToPtr(os.Getuid() + 1)
+	assert.Error(t, checkFileOwnership(file))
+
+	// An ID of -1 leaves the ownership unchanged.
+	file.User.ID = helpers.IntToPtr(-1)
+	file.Group.ID = helpers.IntToPtr(-1)
+	assert.NoError(t, checkFileOwnership(file))
+}
+
+func TestCheckV3DirectoriesAndLinks(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "dir")
+	require.NoError(t, os.Mkdir(dir, 0o700))
+	require.NoError(t, os.Chmod(dir, 0o700))
+	target := filepath.Join(filepath.Dir(dir), "target")
+	require.NoError(t, os.WriteFile(target, nil, 0o644))
+	link := filepath.Join(filepath.Dir(dir), "link")
+	require.NoError(t, os.Symlink(target, link))
+
+	dirs := []ign3types.Directory{{Node: ign3types.Node{Path: dir}, DirectoryEmbedded1: ign3types.DirectoryEmbedded1{Mode: helpers.IntToPtr(0o700)}}}
+	assert.NoError(t, checkV3Directories(dirs))
+	dirs[0].Mode = helpers.IntToPtr(0o755)
+	assert.Error(t, checkV3Directories(dirs))
+	assert.Error(t, checkV3Directories([]ign3types.Directory{{Node: ign3types.Node{Path: target}}}))
+
+	links := []ign3types.Link{{Node: ign3types.Node{Path: link}, LinkEmbedded1: ign3types.LinkEmbedded1{Target: &target}}}
+	assert.NoError(t, checkV3Links(links))
+	links[0].Target = &dir
+	assert.Error(t, checkV3Links(links))
+	links[0].Target = &target
+	links[0].Hard = helpers.BoolToPtr(true)
+	assert.Error(t, checkV3Links(links))
+}
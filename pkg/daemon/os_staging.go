@@ -0,0 +1,89 @@
+package daemon
+
+import (
+	"fmt"
+	"strconv"
+
+	rpmostreeclient "github.com/coreos/rpmostree-client-go/pkg/client"
+	mcfgv1 "github.com/openshift/api/machineconfiguration/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/klog/v2"
+
+	ctrlcommon "github.com/openshift/machine-config-operator/pkg/controller/common"
+	"github.com/openshift/machine-config-operator/pkg/helpers"
+)
+
+// getStageOSBeforeDrain returns whether a pool asks for the new OS deployment
+// to be staged before its nodes are drained.
+func getStageOSBeforeDrain(pool *mcfgv1.MachineConfigPool) (bool, error) {
+	if pool == nil {
+		return false, nil
+	}
+	val, ok := pool.Annotations[ctrlcommon.StageOSBeforeDrainAnnotationKey]
+	if !ok {
+		return false, nil
+	}
+	stage, err := strconv.ParseBool(val)
+	if err != nil {
+		return false, fmt.Errorf("invalid %s annotation %q on pool %s: %w", ctrlcommon.StageOSBeforeDrainAnnotationKey, val, pool.Name, err)
+	}
+	return stage, nil
+}
+
+// shouldStageOSBeforeDrain returns whether the OS changes of updates which
+// drain the node are applied before the drain. The OS changes are only staged
+// by rpm-ostree and take effect on the reboot following the drain.
+func (dn *Daemon) shouldStageOSBeforeDrain() bool {
+	if !dn.os.IsCoreOSVariant() || dn.mcpLister == nil {
+		return false
+	}
+	pool, err := helpers.GetPrimaryPoolForNode(dn.mcpLister, dn.node)
+	if err != nil {
+		klog.Errorf("Could not get pool to check for OS staging, staging the OS after the drain: %v", err)
+		return false
+	}
+	stage, err := getStageOSBeforeDrain(pool)
+	if err != nil {
+		klog.Errorf("%v, staging the OS after the drain", err)
+		return false
+	}
+	return stage
+}
+
+// isStagedDeploymentSuperseded returns whether a staged deployment was
+// staged for another OS image than the desired one.
+func isStagedDeploymentSuperseded(staged *rpmostreeclient.Deployment, desiredImage string) bool {
+	if staged == nil {
+		return false
+	}
+	if staged.ContainerImageReference == "" {
+		return true
+	}
+	ref, err := staged.RequireContainerImage()
+	if err != nil {
+		return true
+	}
+	return ref.Imgref.Image != desiredImage
+}
+
+// removeSupersededStagedDeployment removes the deployment left staged by an
+// earlier update staging its OS before the drain, if it was staged for
+// another OS image than the desired one. Otherwise, it would be booted by the
+// next reboot.
+func (dn *CoreOSDaemon) removeSupersededStagedDeployment(desiredImage string) error {
+	_, staged, err := dn.NodeUpdaterClient.GetBootedAndStagedDeployment()
+	if err != nil {
+		return fmt.Errorf("could not get staged deployment: %w", err)
+	}
+	if !isStagedDeploymentSuperseded(staged, desiredImage) {
+		return nil
+	}
+	logSystem("Removing staged deployment %s superseded by OS image %s", staged.ID, desiredImage)
+	if err := removePendingDeployment(); err != nil {
+		return fmt.Errorf("failed to remove superseded staged deployment: %w", err)
+	}
+	if dn.nodeWriter != nil {
+		dn.nodeWriter.Eventf(corev1.EventTypeNormal, "OSStagedDeploymentRemoved", "Removed staged deployment %s superseded by OS image %s", staged.ID, desiredImage)
+	}
+	return nil
+}
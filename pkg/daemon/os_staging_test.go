@@ -0,0 +1,42 @@
+package daemon
+
+import (
+	"testing"
+
+	rpmostreeclient "github.com/coreos/rpmostree-client-go/pkg/client"
+	mcfgv1 "github.com/openshift/api/machineconfiguration/v1"
+	"github.com/stretchr/testify/assert"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	ctrlcommon "github.com/openshift/machine-config-operator/pkg/controller/common"
+)
+
+func TestGetStageOSBeforeDrain(t *testing.T) {
+	pool := func(annotations map[string]string) *mcfgv1.MachineConfigPool {
+		return &mcfgv1.MachineConfigPool{ObjectMeta: metav1.ObjectMeta{Name: "worker", Annotations: annotations}}
+	}
+
+	stage, err := getStageOSBeforeDrain(nil)
+	assert.NoError(t, err)
+	assert.False(t, stage)
+
+	stage, err = getStageOSBeforeDrain(pool(nil))
+	assert.NoError(t, err)
+	assert.False(t, stage)
+
+	stage, err = getStageOSBeforeDrain(pool(map[string]string{ctrlcommon.StageOSBeforeDrainAnnotationKey: "true"}))
+	assert.NoError(t, err)
+	assert.True(t, stage)
+
+	_, err = getStageOSBeforeDrain(pool(map[string]string{ctrlcommon.StageOSBeforeDrainAnnotationKey: "sometimes"}))
+	assert.Error(t, err)
+}
+
+func TestIsStagedDeploymentSuperseded(t *testing.T) {
+	desired := "registry/os@sha256:2"
+
+	assert.False(t, isStagedDeploymentSuperseded(nil, desired))
+	assert.False(t, isStagedDeploymentSuperseded(&rpmostreeclient.Deployment{ContainerImageReference: "ostree-unverified-registry:" + desired}, desired))
+	assert.True(t, isStagedDeploymentSuperseded(&rpmostreeclient.Deployment{ContainerImageReference: "ostree-unverified-registry:registry/os@sha256:1"}, desired))
+	assert.True(t, isStagedDeploymentSuperseded(&rpmostreeclient.Deployment{}, desired))
+}
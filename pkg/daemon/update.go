@@ -12,6 +12,7 @@ import (
 	"path/filepath"
 	"reflect"
 	goruntime "runtime"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
@@ -37,7 +38,6 @@ import (
 
 	opv1 "github.com/openshift/api/operator/v1"
 
-	"github.com/openshift/machine-config-operator/pkg/apihelpers"
 	ctrlcommon "github.com/openshift/machine-config-operator/pkg/controller/common"
 	"github.com/openshift/machine-config-operator/pkg/daemon/constants"
 	pivottypes "github.com/openshift/machine-config-operator/pkg/daemon/pivot/types"
@@ -156,6 +156,17 @@ func (dn *Daemon) executeReloadServiceNodeDisruptionAction(serviceName string, r
 // In the end uncordon node to schedule workload.
 // If at any point an error occurs, we reboot the node so that node has correct configuration.
 func (dn *Daemon) performPostConfigChangeNodeDisruptionAction(postConfigChangeActions []opv1.NodeDisruptionPolicyStatusAction, configName string) error {
+	rebootless, err := dn.runPostConfigChangeNodeDisruptionActions(postConfigChangeActions, configName)
+	if err != nil || !rebootless {
+		return err
+	}
+	return dn.finishRebootlessUpdate()
+}
+
+// runPostConfigChangeNodeDisruptionActions runs the actions of the cluster's
+// Node disruption policies and returns true if they were applied without a
+// reboot, in which case the update is finished by finishRebootlessUpdate.
+func (dn *Daemon) runPostConfigChangeNodeDisruptionActions(postConfigChangeActions []opv1.NodeDisruptionPolicyStatusAction, configName string) (bool, error) {
 	for _, action := range postConfigChangeActions {
 
 		// Drain is already completed at this stage and essentially a no-op for this loop, so no need to log that.
@@ -168,7 +179,7 @@ func (dn *Daemon) performPostConfigChangeNodeDisruptionAction(postConfigChangeAc
 		// Get MCP associated with node
 		pool, err := helpers.GetPrimaryPoolNameForMCN(dn.mcpLister, dn.node)
 		if err != nil {
-			return err
+			return false, err
 		}
 
 		switch action.Type {
@@ -187,7 +198,7 @@ func (dn *Daemon) performPostConfigChangeNodeDisruptionAction(postConfigChangeAc
 				klog.Errorf("Error making MCN for rebooting: %v", err)
 			}
 			logSystem("Rebooting node")
-			return dn.reboot(fmt.Sprintf("Node will reboot into config %s", configName))
+			return false, dn.reboot(fmt.Sprintf("Node will reboot into config %s", configName))
 
 		case opv1.NoneStatusAction:
 			if dn.nodeWriter != nil {
@@ -224,13 +235,13 @@ func (dn *Daemon) performPostConfigChangeNodeDisruptionAction(postConfigChangeAc
 						if dn.nodeWriter != nil {
 							dn.nodeWriter.Eventf(corev1.EventTypeWarning, "FailedServiceRestart", fmt.Sprintf("Restarting %s service failed. Error: %v", serviceName, err))
 						}
-						return fmt.Errorf("error running %s: %s: %w", constants.UpdateCATrustCommand, stderr.String(), err)
+						return false, fmt.Errorf("error running %s: %s: %w", constants.UpdateCATrustCommand, stderr.String(), err)
 					}
 				} else {
 					if dn.nodeWriter != nil {
 						dn.nodeWriter.Eventf(corev1.EventTypeWarning, "FailedServiceRestart", fmt.Sprintf("Restarting %s service failed. Error: %v", serviceName, err))
 					}
-					return fmt.Errorf("could not apply update: restarting %s service failed. Error: %w", serviceName, err)
+					return false, fmt.Errorf("could not apply update: restarting %s service failed. Error: %w", serviceName, err)
 				}
 			}
 			// TODO: Add a new MCN Condition to the API for service restarts?
@@ -243,36 +254,35 @@ func (dn *Daemon) performPostConfigChangeNodeDisruptionAction(postConfigChangeAc
 			// Execute a generic service reload defined by the action object
 			serviceName := string(action.Reload.ServiceName)
 			if err := dn.executeReloadServiceNodeDisruptionAction(serviceName, reloadService(serviceName)); err != nil {
-				return err
+				return false, err
 			}
 
 		case opv1.SpecialStatusAction:
 			// The special action type requires a CRIO reload
 			if err := dn.executeReloadServiceNodeDisruptionAction(constants.CRIOServiceName, reloadService(constants.CRIOServiceName)); err != nil {
-				return err
+				return false, err
 			}
 
 		case opv1.DaemonReloadStatusAction:
 			// Execute daemon-reload
 			if err := dn.executeReloadServiceNodeDisruptionAction(constants.DaemonReloadCommand, reloadDaemon()); err != nil {
-				return err
+				return false, err
 			}
 		}
 	}
 
 	// We are here, which means a reboot was not needed to apply the configuration.
-	return dn.finishRebootlessUpdate()
+	return true, nil
 }
 
-// performPostConfigChangeAction takes action based on what postConfigChangeAction has been asked.
-// For non-reboot action, it applies configuration, updates node's config and state.
-// In the end uncordon node to schedule workload.
-// If at any point an error occurs, we reboot the node so that node has correct configuration.
-func (dn *Daemon) performPostConfigChangeAction(postConfigChangeActions []string, configName string) error {
+// runPostConfigChangeActions takes action based on what postConfigChangeAction has been asked,
+// and returns true if a reboot was not needed, in which case the update is finished by
+// finishRebootlessUpdate.
+func (dn *Daemon) runPostConfigChangeActions(postConfigChangeActions []string, configName string) (bool, error) {
 	// Get MCP associated with node
 	pool, err := helpers.GetPrimaryPoolNameForMCN(dn.mcpLister, dn.node)
 	if err != nil {
-		return err
+		return false, err
 	}
 
 	if ctrlcommon.InSlice(postConfigChangeActionReboot, postConfigChangeActions) {
@@ -290,7 +300,7 @@ func (dn *Daemon) performPostConfigChangeAction(postConfigChangeActions []string
 			klog.Errorf("Error making MCN for rebooting: %v", err)
 		}
 		logSystem("Rebooting node")
-		return dn.reboot(fmt.Sprintf("Node will reboot into config %s", configName))
+		return false, dn.reboot(fmt.Sprintf("Node will reboot into config %s", configName))
 	}
 
 	if ctrlcommon.InSlice(postConfigChangeActionNone, postConfigChangeActions) {
@@ -320,7 +330,7 @@ func (dn *Daemon) performPostConfigChangeAction(postConfigChangeActions []string
 			if dn.nodeWriter != nil {
 				dn.nodeWriter.Eventf(corev1.EventTypeWarning, "FailedServiceReload", fmt.Sprintf("Reloading %s service failed. Error: %v", serviceName, err))
 			}
-			return fmt.Errorf("could not apply update: reloading %s configuration failed. Error: %w", serviceName, err)
+			return false, fmt.Errorf("could not apply update: reloading %s configuration failed. Error: %w", serviceName, err)
 		}
 
 		err := upgrademonitor.GenerateAndApplyMachineConfigNodes(
@@ -349,7 +359,7 @@ func (dn *Daemon) performPostConfigChangeAction(postConfigChangeActions []string
 		cmd.Stdout = os.Stdout
 		cmd.Stderr = &stderr
 		if err := cmd.Run(); err != nil {
-			return fmt.Errorf("error running %s: %s: %w", constants.UpdateCATrustCommand, string(stderr.Bytes()), err)
+			return false, fmt.Errorf("error running %s: %s: %w", constants.UpdateCATrustCommand, string(stderr.Bytes()), err)
 		}
 
 		serviceName := constants.CRIOServiceName
@@ -358,14 +368,14 @@ func (dn *Daemon) performPostConfigChangeAction(postConfigChangeActions []string
 			if dn.nodeWriter != nil {
 				dn.nodeWriter.Eventf(corev1.EventTypeWarning, "FailedServiceReload", fmt.Sprintf("Reloading %s service failed. Error: %v", serviceName, err))
 			}
-			return fmt.Errorf("could not apply update: reloading %s configuration failed. Error: %w", serviceName, err)
+			return false, fmt.Errorf("could not apply update: reloading %s configuration failed. Error: %w", serviceName, err)
 		}
 		logSystem("%s config restarted successfully! Desired config %s has been applied, skipping reboot", serviceName, configName)
 
 	}
 
 	// We are here, which means a reboot was not needed to apply the configuration.
-	return dn.finishRebootlessUpdate()
+	return true, nil
 }
 
 func setRunningKargsWithCmdline(config *mcfgv1.MachineConfig, requestedKargs []string, cmdline []byte) error {
@@ -537,7 +547,7 @@ func removePendingDeployment() error {
 // applyOSChanges extracts the OS image and adds coreos-extensions repo if we have either OS update or package layering to perform
 func (dn *CoreOSDaemon) applyOSChanges(mcDiff machineConfigDiff, oldConfig, newConfig *mcfgv1.MachineConfig) (retErr error) {
 	// We previously did not emit this event when kargs changed, so we still don't
-	if mcDiff.osUpdate || mcDiff.extensions || mcDiff.kernelType || mcDiff.oclEnabled {
+	if mcDiff.OSUpdate || mcDiff.Extensions || mcDiff.KernelType || mcDiff.oclEnabled {
 		// We emitted this event before, so keep it
 		if dn.nodeWriter != nil {
 			dn.nodeWriter.Eventf(corev1.EventTypeNormal, "InClusterUpgrade", fmt.Sprintf("Updating from oscontainer %s", newConfig.Spec.OSImageURL))
@@ -553,9 +563,9 @@ func (dn *CoreOSDaemon) applyOSChanges(mcDiff machineConfigDiff, oldConfig, newC
 	// to make sure we don't break that use case, but realtime kernel update and extensions update always ran
 	// if they were in use, so we also need to preserve that behavior.
 	// https://issues.redhat.com/browse/OCPBUGS-4049
-	if mcDiff.osUpdate || mcDiff.extensions || mcDiff.kernelType || mcDiff.kargs || mcDiff.oclEnabled ||
-		canonicalizeKernelType(newConfig.Spec.KernelType) == ctrlcommon.KernelTypeRealtime ||
-		canonicalizeKernelType(newConfig.Spec.KernelType) == ctrlcommon.KernelType64kPages {
+	if mcDiff.OSUpdate || mcDiff.Extensions || mcDiff.KernelType || mcDiff.Kargs || mcDiff.oclEnabled ||
+		ctrlcommon.CanonicalizeKernelType(newConfig.Spec.KernelType) == ctrlcommon.KernelTypeRealtime ||
+		ctrlcommon.CanonicalizeKernelType(newConfig.Spec.KernelType) == ctrlcommon.KernelType64kPages {
 
 		// Throw started/staged events only if there is any update required for the OS
 		if dn.nodeWriter != nil {
@@ -564,7 +574,7 @@ func (dn *CoreOSDaemon) applyOSChanges(mcDiff machineConfigDiff, oldConfig, newC
 				// osChangesString() can return empty in cases where the above diffs are false,
 				// but the node uses a non standard kernel, so let's make it a bit more
 				// informative in such cases
-				reason = fmt.Sprintf("Updating to a target config with %s kernel", canonicalizeKernelType(newConfig.Spec.KernelType))
+				reason = fmt.Sprintf("Updating to a target config with %s kernel", ctrlcommon.CanonicalizeKernelType(newConfig.Spec.KernelType))
 			}
 			dn.nodeWriter.Eventf(corev1.EventTypeNormal, "OSUpdateStarted", reason)
 		}
@@ -659,80 +669,6 @@ func calculatePostConfigChangeActionFromMCDiffs(diffFileSet []string) (actions [
 	return actions
 }
 
-// calculatePostConfigChangeNodeDisruptionActionFromMCDiffs takes action based on the cluster's Node disruption policies.
-func calculatePostConfigChangeNodeDisruptionActionFromMCDiffs(diffSSH bool, diffFileSet, diffUnitSet []string, clusterPolicies opv1.NodeDisruptionPolicyClusterStatus) []opv1.NodeDisruptionPolicyStatusAction {
-	actions := []opv1.NodeDisruptionPolicyStatusAction{}
-
-	// Step through all file based policies, and build out the actions object
-	for _, diffPath := range diffFileSet {
-		pathFound, actionsFound := ctrlcommon.FindClosestFilePolicyPathMatch(diffPath, clusterPolicies.Files)
-		if pathFound {
-			klog.Infof("NodeDisruptionPolicy %v found for diff file %s", actionsFound, diffPath)
-			actions = append(actions, actionsFound...)
-		} else {
-			// If this file path has no policy defined, default to reboot
-			klog.V(4).Infof("no policy found for diff path %s", diffPath)
-			return []opv1.NodeDisruptionPolicyStatusAction{{
-				Type: opv1.RebootStatusAction,
-			}}
-		}
-	}
-
-	// Step through all unit based policies, and build out the actions object
-	for _, diffUnit := range diffUnitSet {
-		unitFound := false
-		for _, policyUnit := range clusterPolicies.Units {
-			klog.V(4).Infof("comparing policy unit name %s to diff unit name %s", string(policyUnit.Name), diffUnit)
-			if string(policyUnit.Name) == diffUnit {
-				klog.Infof("NodeDisruptionPolicy %v found for diff unit %s!", policyUnit.Actions, diffUnit)
-				actions = append(actions, policyUnit.Actions...)
-				unitFound = true
-				break
-			}
-		}
-		if !unitFound {
-			// If this unit has no policy defined, default to reboot
-			klog.V(4).Infof("no policy found for diff unit %s", diffUnit)
-			return []opv1.NodeDisruptionPolicyStatusAction{{
-				Type: opv1.RebootStatusAction,
-			}}
-		}
-	}
-
-	// SSH only has one possible policy(and there is a default), so blindly add that if there is an SSH diff
-	if diffSSH {
-		klog.Infof("SSH diff detected, applying SSH policy %v", clusterPolicies.SSHKey.Actions)
-		actions = append(actions, clusterPolicies.SSHKey.Actions...)
-	}
-
-	// If any of the actions need a reboot, then just return a single Reboot action
-	if apihelpers.CheckNodeDisruptionActionsForTargetActions(actions, opv1.RebootStatusAction) {
-		return []opv1.NodeDisruptionPolicyStatusAction{{
-			Type: opv1.RebootStatusAction,
-		}}
-	}
-
-	// If there is a "None" action in conjunction with other kinds of actions, strip out the "None" action elements as it is redundant
-	if apihelpers.CheckNodeDisruptionActionsForTargetActions(actions, opv1.NoneStatusAction) {
-		if apihelpers.CheckNodeDisruptionActionsForTargetActions(actions, opv1.DrainStatusAction, opv1.ReloadStatusAction, opv1.RestartStatusAction, opv1.DaemonReloadStatusAction, opv1.SpecialStatusAction) {
-			finalActions := []opv1.NodeDisruptionPolicyStatusAction{}
-			for _, action := range actions {
-				if action.Type != opv1.NoneStatusAction {
-					finalActions = append(finalActions, action)
-				}
-			}
-			return finalActions
-		}
-		// If we're here, this means that the action list has only "None" actions; return a single "None" Action
-		return []opv1.NodeDisruptionPolicyStatusAction{{
-			Type: opv1.NoneStatusAction,
-		}}
-	}
-
-	// If we're here, return as is - this means action list had zero "None" actions in the list
-	return actions
-}
-
 func calculatePostConfigChangeAction(diff *machineConfigDiff, diffFileSet []string) ([]string, error) {
 	// If a machine-config-daemon-force file is present, it means the user wants to
 	// move to desired state without additional validation. We will reboot the node in
@@ -745,13 +681,19 @@ func calculatePostConfigChangeAction(diff *machineConfigDiff, diffFileSet []stri
 		return []string{postConfigChangeActionReboot}, nil
 	}
 
-	if diff.osUpdate || diff.kargs || diff.fips || diff.units || diff.kernelType || diff.extensions {
+	return calculatePostConfigChangeActionsForDiff(diff, diffFileSet), nil
+}
+
+// calculatePostConfigChangeActionsForDiff returns the legacy post config change
+// actions for a MachineConfig diff.
+func calculatePostConfigChangeActionsForDiff(diff *machineConfigDiff, diffFileSet []string) []string {
+	if diff.OSUpdate || diff.Kargs || diff.FIPS || diff.Units || diff.KernelType || diff.Extensions {
 		// must reboot
-		return []string{postConfigChangeActionReboot}, nil
+		return []string{postConfigChangeActionReboot}
 	}
 
 	// Calculate actions based on file, unit and ssh diffs
-	return calculatePostConfigChangeActionFromMCDiffs(diffFileSet), nil
+	return calculatePostConfigChangeActionFromMCDiffs(diffFileSet)
 }
 
 // calculatePostConfigChangeNodeDisruptionAction takes action based on the cluster's Node disruption policies.
@@ -796,22 +738,7 @@ func (dn *Daemon) calculatePostConfigChangeNodeDisruptionAction(diff *machineCon
 		}}, nil
 	}
 
-	if diff.osUpdate || diff.kargs || diff.fips || diff.kernelType || diff.extensions {
-		// must reboot
-		return []opv1.NodeDisruptionPolicyStatusAction{{
-			Type: opv1.RebootStatusAction,
-		}}, nil
-	}
-	if !diff.files && !diff.units && !diff.passwd {
-		// This is a diff which requires no actions
-		klog.Infof("No changes in files, units or SSH keys, no NodeDisruptionPolicies are in effect")
-		return []opv1.NodeDisruptionPolicyStatusAction{{
-			Type: opv1.NoneStatusAction,
-		}}, nil
-	}
-
-	// Calculate actions based on file, unit and ssh diffs
-	nodeDisruptionActions := calculatePostConfigChangeNodeDisruptionActionFromMCDiffs(diff.passwd, diffFileSet, diffUnitSet, mcop.Status.NodeDisruptionPolicyStatus.ClusterPolicies)
+	nodeDisruptionActions := ctrlcommon.CalculateNodeDisruptionActionsForDiff(&diff.MachineConfigDiff, diffFileSet, diffUnitSet, mcop.Status.NodeDisruptionPolicyStatus.ClusterPolicies)
 
 	// Print out node disruption actions for debug purposes
 	klog.Infof("Calculated node disruption actions:")
@@ -911,9 +838,15 @@ func (dn *Daemon) updateOnClusterLayering(oldConfig, newConfig *mcfgv1.MachineCo
 		return &unreconcilableErr{wrappedErr}
 	}
 
-	if err := dn.performDrain(); err != nil {
-		return err
-	}
+	// Record the update for the node status. It always reboots, so it is
+	// finished once completed on the next boot.
+	dn.beginUpdateRecord(oldConfig, newConfig, canonicalizeMachineConfigImage(oldImage, oldConfig).Spec.OSImageURL,
+		canonicalizeMachineConfigImage(newImage, newConfig).Spec.OSImageURL, true, nil, []string{postConfigChangeActionReboot})
+	defer func() {
+		if retErr != nil || !dn.rebootQueued {
+			dn.finishUpdateRecord(newConfigName, retErr)
+		}
+	}()
 
 	if mcDiff.revertFromOCL {
 		klog.Infof("%s empty, reverting to osImageURL %s from MachineConfig %s", constants.DesiredImageAnnotationKey, newConfig.Spec.OSImageURL, newConfig.Name)
@@ -940,12 +873,31 @@ func (dn *Daemon) updateOnClusterLayering(oldConfig, newConfig *mcfgv1.MachineCo
 	klog.Infof("Old MachineConfig %s / Image %s -> New MachineConfig %s / Image %s", oldConfigName, oldConfigCopy.Spec.OSImageURL, newConfigName, newConfigCopy.Spec.OSImageURL)
 
 	coreOSDaemon := CoreOSDaemon{dn}
-	if err := coreOSDaemon.applyOSChanges(*mcDiff, oldConfigCopy, newConfigCopy); err != nil {
-		return err
+	osApplied := false
+	applyOSChanges := func() error {
+		dn.updateRecorder.startStep(updateStepOS)
+		if err := coreOSDaemon.applyOSChanges(*mcDiff, oldConfigCopy, newConfigCopy); err != nil {
+			return err
+		}
+		osApplied = true
+		return nil
+	}
+
+	// If the pool asks for it, stage the new image before the drain so that
+	// the workloads only stop for the reboot.
+	stageOS := dn.shouldStageOSBeforeDrain()
+	if stageOS {
+		if err := coreOSDaemon.removeSupersededStagedDeployment(newConfigCopy.Spec.OSImageURL); err != nil {
+			return err
+		}
+		if err := applyOSChanges(); err != nil {
+			return err
+		}
+		logSystem("Staged OS image %s before drain", newConfigCopy.Spec.OSImageURL)
 	}
 
 	defer func() {
-		if retErr != nil {
+		if retErr != nil && osApplied {
 			if err := coreOSDaemon.applyOSChanges(*mcDiff, newConfigCopy, oldConfigCopy); err != nil {
 				errs := kubeErrs.NewAggregate([]error{err, retErr})
 				retErr = fmt.Errorf("error rolling back changes to OS: %w", errs)
@@ -954,39 +906,71 @@ func (dn *Daemon) updateOnClusterLayering(oldConfig, newConfig *mcfgv1.MachineCo
 		}
 	}()
 
-	// update files on disk that need updating
-	if err := dn.updateFiles(oldIgnConfig, newIgnConfig, skipCertificateWrite); err != nil {
+	dn.updateRecorder.startStep(updateStepDrain)
+	if err := dn.performDrain(); err != nil {
 		return err
 	}
 
-	defer func() {
-		if retErr != nil {
-			if err := dn.updateFiles(newIgnConfig, oldIgnConfig, skipCertificateWrite); err != nil {
-				errs := kubeErrs.NewAggregate([]error{err, retErr})
-				retErr = fmt.Errorf("error rolling back files writes: %w", errs)
-				return
-			}
+	if !stageOS {
+		if err := applyOSChanges(); err != nil {
+			return err
 		}
+	}
+
+	dn.updateRecorder.startStep(updateStepFiles)
+
+	// update files on disk that need updating, in a transaction restoring them
+	// exactly if the update fails
+	tx, err := dn.beginUpdateFilesTransaction(newConfig.GetName(), oldIgnConfig, newIgnConfig)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		retErr = dn.endUpdateFilesTransaction(tx, retErr)
 	}()
 
+	if err := dn.updateFiles(oldIgnConfig, newIgnConfig, skipCertificateWrite); err != nil {
+		return err
+	}
+
 	// update file permissions
 	if err := dn.updateKubeConfigPermission(); err != nil {
 		return err
 	}
 
+	// create, modify and delete the users and groups before their SSH keys
+	// and password hashes are set
+	if diff.Passwd {
+		// registered first, so the accounts changed before a failure are
+		// rolled back too
+		defer func() {
+			if retErr != nil {
+				if err := dn.updateUsersAndGroups(oldIgnConfig, newIgnConfig); err != nil {
+					errs := kubeErrs.NewAggregate([]error{err, retErr})
+					retErr = fmt.Errorf("error rolling back users and groups updates: %w", errs)
+					return
+				}
+			}
+		}()
+
+		if err := dn.updateUsersAndGroups(newIgnConfig, oldIgnConfig); err != nil {
+			return err
+		}
+	}
+
 	// only update passwd if it has changed (do not nullify)
 	// we do not need to include SetPasswordHash in this, since only updateSSHKeys has issues on firstboot.
 	// For on-cluster builds, this needs to be performed here instead of during
 	// the image build process. This is bceause rpm-ostree will not touch files
 	// in /home/core. See: https://issues.redhat.com/browse/OCPBUGS-18458
-	if diff.passwd {
+	if diff.Passwd {
 		if err := dn.updateSSHKeys(newIgnConfig.Passwd.Users, oldIgnConfig.Passwd.Users); err != nil {
 			return err
 		}
 
 		defer func() {
 			if retErr != nil {
-				if err := dn.updateSSHKeys(newIgnConfig.Passwd.Users, oldIgnConfig.Passwd.Users); err != nil {
+				if err := dn.updateSSHKeys(oldIgnConfig.Passwd.Users, newIgnConfig.Passwd.Users); err != nil {
 					errs := kubeErrs.NewAggregate([]error{err, retErr})
 					retErr = fmt.Errorf("error rolling back SSH keys updates: %w", errs)
 					return
@@ -1003,7 +987,7 @@ func (dn *Daemon) updateOnClusterLayering(oldConfig, newConfig *mcfgv1.MachineCo
 
 	defer func() {
 		if retErr != nil {
-			if err := dn.SetPasswordHash(newIgnConfig.Passwd.Users, oldIgnConfig.Passwd.Users); err != nil {
+			if err := dn.SetPasswordHash(oldIgnConfig.Passwd.Users, newIgnConfig.Passwd.Users); err != nil {
 				errs := kubeErrs.NewAggregate([]error{err, retErr})
 				retErr = fmt.Errorf("error rolling back password hash updates: %w", errs)
 				return
@@ -1012,7 +996,7 @@ func (dn *Daemon) updateOnClusterLayering(oldConfig, newConfig *mcfgv1.MachineCo
 	}()
 
 	// Update the kernal args if there is a difference
-	if diff.kargs && dn.os.IsCoreOSVariant() {
+	if diff.Kargs && dn.os.IsCoreOSVariant() {
 		if err := coreOSDaemon.updateKernelArguments(oldConfig.Spec.KernelArguments, newConfig.Spec.KernelArguments); err != nil {
 			return err
 		}
@@ -1121,6 +1105,18 @@ func (dn *Daemon) update(oldConfig, newConfig *mcfgv1.MachineConfig, skipCertifi
 		dn.reportMachineNodeDegradeStatus(retErr, pool)
 	}()
 
+	// An update applied without a reboot is finished, running the health
+	// gates and uncordoning the node, once the deferred rollbacks below are
+	// no longer needed and the files transaction is committed. A failure
+	// to finish it leaves the node degraded on the new config, which is
+	// recorded on disk, and it is finished again on the next sync.
+	rebootless := false
+	defer func() {
+		if retErr == nil && rebootless {
+			retErr = dn.finishRebootlessUpdate()
+		}
+	}()
+
 	oldConfigName := oldConfig.GetName()
 	newConfigName := newConfig.GetName()
 
@@ -1239,7 +1235,47 @@ func (dn *Daemon) update(oldConfig, newConfig *mcfgv1.MachineConfig, skipCertifi
 	if err != nil {
 		klog.Errorf("Error making MCN spec for Update Compatible: %v", err)
 	}
+
+	// Record the update for the node status. An update which reboots is
+	// finished once completed on the next boot.
+	dn.beginUpdateRecord(oldConfig, newConfig, oldConfig.Spec.OSImageURL, newConfig.Spec.OSImageURL, drain, nodeDisruptionActions, actions)
+	defer func() {
+		if retErr != nil || !dn.rebootQueued {
+			dn.finishUpdateRecord(newConfigName, retErr)
+		}
+	}()
+
+	// If the pool asks for it, stage the OS changes before the drain so that
+	// the workloads only stop for the reboot.
+	osStaged := false
+	if dn.shouldStageOSBeforeDrain() {
+		coreOSDaemon := CoreOSDaemon{dn}
+		if err := coreOSDaemon.removeSupersededStagedDeployment(newConfig.Spec.OSImageURL); err != nil {
+			return err
+		}
+		if drain {
+			dn.updateRecorder.startStep(updateStepOS)
+			if err := coreOSDaemon.applyOSChanges(*diff, oldConfig, newConfig); err != nil {
+				return err
+			}
+			osStaged = true
+
+			defer func() {
+				if retErr != nil {
+					if err := coreOSDaemon.applyOSChanges(*diff, newConfig, oldConfig); err != nil {
+						errs := kubeErrs.NewAggregate([]error{err, retErr})
+						retErr = fmt.Errorf("error rolling back changes to OS: %w", errs)
+						return
+					}
+				}
+			}()
+
+			logSystem("Staged OS changes of %s before drain", newConfigName)
+		}
+	}
+
 	if drain {
+		dn.updateRecorder.startStep(updateStepDrain)
 		if err := dn.performDrain(); err != nil {
 			return err
 		}
@@ -1266,10 +1302,10 @@ func (dn *Daemon) update(oldConfig, newConfig *mcfgv1.MachineConfig, skipCertifi
 	}
 
 	updatesNeeded := []string{"not", "not"}
-	if diff.passwd {
+	if diff.Passwd {
 		updatesNeeded[1] = ""
 	}
-	if diff.osUpdate || diff.extensions || diff.kernelType {
+	if diff.OSUpdate || diff.Extensions || diff.KernelType {
 		updatesNeeded[0] = ""
 	}
 
@@ -1287,36 +1323,57 @@ func (dn *Daemon) update(oldConfig, newConfig *mcfgv1.MachineConfig, skipCertifi
 		klog.Errorf("Error making MCN for Updating Files and OS: %v", err)
 	}
 
-	// update files on disk that need updating
-	if err := dn.updateFiles(oldIgnConfig, newIgnConfig, skipCertificateWrite); err != nil {
+	dn.updateRecorder.startStep(updateStepFiles)
+
+	// update files on disk that need updating, in a transaction restoring them
+	// exactly if the update fails
+	tx, err := dn.beginUpdateFilesTransaction(newConfig.GetName(), oldIgnConfig, newIgnConfig)
+	if err != nil {
 		return err
 	}
-
 	defer func() {
-		if retErr != nil {
-			if err := dn.updateFiles(newIgnConfig, oldIgnConfig, skipCertificateWrite); err != nil {
-				errs := kubeErrs.NewAggregate([]error{err, retErr})
-				retErr = fmt.Errorf("error rolling back files writes: %w", errs)
-				return
-			}
-		}
+		retErr = dn.endUpdateFilesTransaction(tx, retErr)
 	}()
 
+	if err := dn.updateFiles(oldIgnConfig, newIgnConfig, skipCertificateWrite); err != nil {
+		return err
+	}
+
 	// update file permissions
 	if err := dn.updateKubeConfigPermission(); err != nil {
 		return err
 	}
 
+	// create, modify and delete the users and groups before their SSH keys
+	// and password hashes are set
+	if diff.Passwd {
+		// registered first, so the accounts changed before a failure are
+		// rolled back too
+		defer func() {
+			if retErr != nil {
+				if err := dn.updateUsersAndGroups(oldIgnConfig, newIgnConfig); err != nil {
+					errs := kubeErrs.NewAggregate([]error{err, retErr})
+					retErr = fmt.Errorf("error rolling back users and groups updates: %w", errs)
+					return
+				}
+			}
+		}()
+
+		if err := dn.updateUsersAndGroups(newIgnConfig, oldIgnConfig); err != nil {
+			return err
+		}
+	}
+
 	// only update passwd if it has changed (do not nullify)
 	// we do not need to include SetPasswordHash in this, since only updateSSHKeys has issues on firstboot.
-	if diff.passwd {
+	if diff.Passwd {
 		if err := dn.updateSSHKeys(newIgnConfig.Passwd.Users, oldIgnConfig.Passwd.Users); err != nil {
 			return err
 		}
 
 		defer func() {
 			if retErr != nil {
-				if err := dn.updateSSHKeys(newIgnConfig.Passwd.Users, oldIgnConfig.Passwd.Users); err != nil {
+				if err := dn.updateSSHKeys(oldIgnConfig.Passwd.Users, newIgnConfig.Passwd.Users); err != nil {
 					errs := kubeErrs.NewAggregate([]error{err, retErr})
 					retErr = fmt.Errorf("error rolling back SSH keys updates: %w", errs)
 					return
@@ -1332,7 +1389,7 @@ func (dn *Daemon) update(oldConfig, newConfig *mcfgv1.MachineConfig, skipCertifi
 
 	defer func() {
 		if retErr != nil {
-			if err := dn.SetPasswordHash(newIgnConfig.Passwd.Users, oldIgnConfig.Passwd.Users); err != nil {
+			if err := dn.SetPasswordHash(oldIgnConfig.Passwd.Users, newIgnConfig.Passwd.Users); err != nil {
 				errs := kubeErrs.NewAggregate([]error{err, retErr})
 				retErr = fmt.Errorf("error rolling back password hash updates: %w", errs)
 				return
@@ -1340,7 +1397,10 @@ func (dn *Daemon) update(oldConfig, newConfig *mcfgv1.MachineConfig, skipCertifi
 		}
 	}()
 
-	if dn.os.IsCoreOSVariant() {
+	if osStaged {
+		klog.Info("OS changes were staged before the drain, skipping.")
+	} else if dn.os.IsCoreOSVariant() {
+		dn.updateRecorder.startStep(updateStepOS)
 		coreOSDaemon := CoreOSDaemon{dn}
 		if err := coreOSDaemon.applyOSChanges(*diff, oldConfig, newConfig); err != nil {
 			return err
@@ -1400,11 +1460,14 @@ func (dn *Daemon) update(oldConfig, newConfig *mcfgv1.MachineConfig, skipCertifi
 		klog.Errorf("Error making MCN for Updated Files and OS: %v", err)
 	}
 
+	dn.updateRecorder.startStep(updateStepPostConfigChangeActions)
 	if fg != nil && fg.Enabled(features.FeatureGateNodeDisruptionPolicy) {
-		return dn.performPostConfigChangeNodeDisruptionAction(nodeDisruptionActions, newConfig.GetName())
+		rebootless, err = dn.runPostConfigChangeNodeDisruptionActions(nodeDisruptionActions, newConfig.GetName())
+		return err
 	}
 	// If we're here, FeatureGateNodeDisruptionPolicy is off/errored, so perform legacy action
-	return dn.performPostConfigChangeAction(actions, newConfig.GetName())
+	rebootless, err = dn.runPostConfigChangeActions(actions, newConfig.GetName())
+	return err
 }
 
 // This is currently a subsection copied over from update() since we need to be more nuanced. Should eventually
@@ -1420,29 +1483,44 @@ func (dn *Daemon) updateHypershift(oldConfig, newConfig *mcfgv1.MachineConfig, d
 		return fmt.Errorf("parsing new Ignition config failed: %w", err)
 	}
 
-	// update files on disk that need updating
+	// update files on disk that need updating, in a transaction restoring them
+	// exactly if the update fails
 	// We should't skip the certificate write in HyperShift since it does not run the extra daemon process
+	tx, err := dn.beginUpdateFilesTransaction(newConfig.GetName(), oldIgnConfig, newIgnConfig)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		retErr = dn.endUpdateFilesTransaction(tx, retErr)
+	}()
+
 	if err := dn.updateFiles(oldIgnConfig, newIgnConfig, false); err != nil {
 		return err
 	}
 
+	// registered first, so the accounts changed before a failure are
+	// rolled back too
 	defer func() {
 		if retErr != nil {
-			if err := dn.updateFiles(newIgnConfig, oldIgnConfig, false); err != nil {
+			if err := dn.updateUsersAndGroups(oldIgnConfig, newIgnConfig); err != nil {
 				errs := kubeErrs.NewAggregate([]error{err, retErr})
-				retErr = fmt.Errorf("error rolling back files writes: %w", errs)
+				retErr = fmt.Errorf("error rolling back users and groups updates: %w", errs)
 				return
 			}
 		}
 	}()
 
+	if err := dn.updateUsersAndGroups(newIgnConfig, oldIgnConfig); err != nil {
+		return err
+	}
+
 	if err := dn.updateSSHKeys(newIgnConfig.Passwd.Users, oldIgnConfig.Passwd.Users); err != nil {
 		return err
 	}
 
 	defer func() {
 		if retErr != nil {
-			if err := dn.updateSSHKeys(newIgnConfig.Passwd.Users, oldIgnConfig.Passwd.Users); err != nil {
+			if err := dn.updateSSHKeys(oldIgnConfig.Passwd.Users, newIgnConfig.Passwd.Users); err != nil {
 				errs := kubeErrs.NewAggregate([]error{err, retErr})
 				retErr = fmt.Errorf("error rolling back SSH keys updates: %w", errs)
 				return
@@ -1496,14 +1574,7 @@ func (dn *Daemon) removeRollback() error {
 // and the MCO would just operate on that.  For now we're just doing this to get
 // improved logging.
 type machineConfigDiff struct {
-	osUpdate      bool
-	kargs         bool
-	fips          bool
-	passwd        bool
-	files         bool
-	units         bool
-	kernelType    bool
-	extensions    bool
+	ctrlcommon.MachineConfigDiff
 	oclEnabled    bool
 	revertFromOCL bool
 }
@@ -1521,59 +1592,31 @@ func (mcDiff *machineConfigDiff) isEmpty() bool {
 // osChangesString generates a human-readable set of changes from the diff
 func (mcDiff *machineConfigDiff) osChangesString() string {
 	changes := []string{}
-	if mcDiff.osUpdate {
+	if mcDiff.OSUpdate {
 		changes = append(changes, "Upgrading OS")
 	}
-	if mcDiff.extensions {
+	if mcDiff.Extensions {
 		changes = append(changes, "Installing extensions")
 	}
-	if mcDiff.kernelType {
+	if mcDiff.KernelType {
 		changes = append(changes, "Changing kernel type")
 	}
-	if mcDiff.kargs {
+	if mcDiff.Kargs {
 		changes = append(changes, "Changing kernel arguments")
 	}
 
 	return strings.Join(changes, "; ")
 }
 
-// canonicalizeKernelType returns a valid kernelType. We consider empty("") and default kernelType as same
-func canonicalizeKernelType(kernelType string) string {
-	if kernelType == ctrlcommon.KernelTypeRealtime {
-		return ctrlcommon.KernelTypeRealtime
-	} else if kernelType == ctrlcommon.KernelType64kPages {
-		return ctrlcommon.KernelType64kPages
-	}
-	return ctrlcommon.KernelTypeDefault
-}
-
-// newMachineConfigDiff compares two MachineConfig objects.
+// newMachineConfigDiff compares two MachineConfig objects. The OS is
+// updated if the force file exists.
 func newMachineConfigDiff(oldConfig, newConfig *mcfgv1.MachineConfig) (*machineConfigDiff, error) {
-	oldIgn, err := ctrlcommon.ParseAndConvertConfig(oldConfig.Spec.Config.Raw)
-	if err != nil {
-		return nil, fmt.Errorf("parsing old Ignition config failed with error: %w", err)
-	}
-	newIgn, err := ctrlcommon.ParseAndConvertConfig(newConfig.Spec.Config.Raw)
+	diff, err := ctrlcommon.NewMachineConfigDiff(oldConfig, newConfig)
 	if err != nil {
-		return nil, fmt.Errorf("parsing new Ignition config failed with error: %w", err)
+		return nil, err
 	}
-
-	// Both nil and empty slices are of zero length,
-	// consider them as equal while comparing KernelArguments in both MachineConfigs
-	kargsEmpty := len(oldConfig.Spec.KernelArguments) == 0 && len(newConfig.Spec.KernelArguments) == 0
-	extensionsEmpty := len(oldConfig.Spec.Extensions) == 0 && len(newConfig.Spec.Extensions) == 0
-
-	force := forceFileExists()
-	return &machineConfigDiff{
-		osUpdate:   oldConfig.Spec.OSImageURL != newConfig.Spec.OSImageURL || force,
-		kargs:      !(kargsEmpty || reflect.DeepEqual(oldConfig.Spec.KernelArguments, newConfig.Spec.KernelArguments)),
-		fips:       oldConfig.Spec.FIPS != newConfig.Spec.FIPS,
-		passwd:     !reflect.DeepEqual(oldIgn.Passwd, newIgn.Passwd),
-		files:      !reflect.DeepEqual(oldIgn.Storage.Files, newIgn.Storage.Files),
-		units:      !reflect.DeepEqual(oldIgn.Systemd.Units, newIgn.Systemd.Units),
-		kernelType: canonicalizeKernelType(oldConfig.Spec.KernelType) != canonicalizeKernelType(newConfig.Spec.KernelType),
-		extensions: !(extensionsEmpty || reflect.DeepEqual(oldConfig.Spec.Extensions, newConfig.Spec.Extensions)),
-	}, nil
+	diff.OSUpdate = diff.OSUpdate || forceFileExists()
+	return &machineConfigDiff{MachineConfigDiff: *diff}, nil
 }
 
 func newMachineConfigDiffFromLayered(oldConfig, newConfig *mcfgv1.MachineConfig, oldImage, newImage string) (*machineConfigDiff, error) {
@@ -1585,7 +1628,7 @@ func newMachineConfigDiffFromLayered(oldConfig, newConfig *mcfgv1.MachineConfig,
 	mcDiff.oclEnabled = true
 	// If the new OS image is empty, that means we are in a revert-from-OCL situation.
 	mcDiff.revertFromOCL = newImage == ""
-	mcDiff.osUpdate = oldImage != newImage || forceFileExists()
+	mcDiff.OSUpdate = oldImage != newImage || forceFileExists()
 	return mcDiff, nil
 }
 
@@ -1606,13 +1649,7 @@ func newMachineConfigDiffFromLayered(oldConfig, newConfig *mcfgv1.MachineConfig,
 // (/proc/sys/crypto/fips_enabled) and can determine if there is a mismatch
 // between the MachineConfig and the actual on-disk state.
 func reconcilable(oldConfig, newConfig *mcfgv1.MachineConfig) (*machineConfigDiff, error) {
-	if err := ctrlcommon.IsRenderedConfigReconcilable(oldConfig, newConfig); err != nil {
-		return nil, fmt.Errorf("configs %s, %s are not reconcilable: %w", oldConfig.Name, newConfig.Name, err)
-	}
-
-	// FIPS section
-	// We do not allow update to FIPS for a running cluster, so any changes here will be an error
-	if err := checkFIPS(oldConfig, newConfig); err != nil {
+	if err := checkReconcilable(oldConfig, newConfig); err != nil {
 		return nil, err
 	}
 
@@ -1625,6 +1662,18 @@ func reconcilable(oldConfig, newConfig *mcfgv1.MachineConfig) (*machineConfigDif
 	return mcDiff, nil
 }
 
+// checkReconcilable runs the checks of reconcilable, without computing the
+// diff of the configs.
+func checkReconcilable(oldConfig, newConfig *mcfgv1.MachineConfig) error {
+	if err := ctrlcommon.IsRenderedConfigReconcilable(oldConfig, newConfig); err != nil {
+		return fmt.Errorf("configs %s, %s are not reconcilable: %w", oldConfig.Name, newConfig.Name, err)
+	}
+
+	// FIPS section
+	// We do not allow update to FIPS for a running cluster, so any changes here will be an error
+	return checkFIPS(oldConfig, newConfig)
+}
+
 func processFips(handler func(bool) error) error {
 	content, err := os.ReadFile(fipsFile)
 	if err != nil {
@@ -1853,8 +1902,8 @@ func (dn *CoreOSDaemon) switchKernel(oldConfig, newConfig *mcfgv1.MachineConfig)
 		return nil
 	}
 
-	oldKtype := canonicalizeKernelType(oldConfig.Spec.KernelType)
-	newKtype := canonicalizeKernelType(newConfig.Spec.KernelType)
+	oldKtype := ctrlcommon.CanonicalizeKernelType(oldConfig.Spec.KernelType)
+	newKtype := ctrlcommon.CanonicalizeKernelType(newConfig.Spec.KernelType)
 
 	// In the OS update path, we removed overrides for kernel-rt.  So if the target (new) config
 	// is also default (i.e. throughput) then we have nothing to do.
@@ -1921,9 +1970,17 @@ func (dn *CoreOSDaemon) switchKernel(oldConfig, newConfig *mcfgv1.MachineConfig)
 // touched.
 func (dn *Daemon) updateFiles(oldIgnConfig, newIgnConfig ign3types.Config, skipCertificateWrite bool) error {
 	klog.Info("Updating files")
+	// Directories are written first so files can be written into them, and
+	// links last so they can point at either.
+	if err := dn.writeDirectories(newIgnConfig.Storage.Directories); err != nil {
+		return err
+	}
 	if err := dn.writeFiles(newIgnConfig.Storage.Files, skipCertificateWrite); err != nil {
 		return err
 	}
+	if err := dn.writeLinks(newIgnConfig.Storage.Links); err != nil {
+		return err
+	}
 	if err := dn.writeUnits(newIgnConfig.Systemd.Units); err != nil {
 		return err
 	}
@@ -1931,11 +1988,16 @@ func (dn *Daemon) updateFiles(oldIgnConfig, newIgnConfig ign3types.Config, skipC
 }
 
 func restorePath(path string) error {
-	if out, err := exec.Command("cp", "-a", "--reflink=auto", origFileName(path), path).CombinedOutput(); err != nil {
-		return fmt.Errorf("restoring %q from orig file %q: %s: %w", path, origFileName(path), string(out), err)
+	return restorePathFrom(origFileName(path), path)
+}
+
+// restorePathFrom restores a path from a backup, removing the backup.
+func restorePathFrom(backup, path string) error {
+	if out, err := exec.Command("cp", "-a", "--reflink=auto", backup, path).CombinedOutput(); err != nil {
+		return fmt.Errorf("restoring %q from backup %q: %s: %w", path, backup, string(out), err)
 	}
-	if err := os.Remove(origFileName(path)); err != nil {
-		return fmt.Errorf("deleting orig file %q: %w", origFileName(path), err)
+	if err := os.Remove(backup); err != nil {
+		return fmt.Errorf("deleting backup %q: %w", backup, err)
 	}
 	return nil
 }
@@ -1977,7 +2039,7 @@ func (dn *Daemon) isPathInDropins(path string, systemd *ign3types.Systemd) bool
 }
 
 // deleteStaleData performs a diff of the new and the old Ignition config. It then deletes
-// all the files, units that are present in the old config but not in the new one.
+// all the files, units, links and directories that are present in the old config but not in the new one.
 // this function will error out if it fails to delete a file (with the exception
 // of simply warning if the error is ENOENT since that's the desired state).
 //
@@ -2013,6 +2075,11 @@ func (dn *Daemon) deleteStaleData(oldIgnConfig, newIgnConfig ign3types.Config) e
 		if skipBecauseCert {
 			continue
 		}
+		if restored, err := restoreAppendBase(f.Path); err != nil {
+			return err
+		} else if restored {
+			continue
+		}
 		if _, err := os.Stat(noOrigFileStampName(f.Path)); err == nil {
 			if delErr := os.Remove(noOrigFileStampName(f.Path)); delErr != nil {
 				return fmt.Errorf("deleting noorig file stamp %q: %w", noOrigFileStampName(f.Path), delErr)
@@ -2150,6 +2217,13 @@ func (dn *Daemon) deleteStaleData(oldIgnConfig, newIgnConfig ign3types.Config) e
 		}
 	}
 
+	if err := deleteStaleLinks(oldIgnConfig.Storage.Links, newIgnConfig.Storage.Links); err != nil {
+		return err
+	}
+	if err := deleteStaleDirectories(oldIgnConfig.Storage.Directories, newIgnConfig.Storage.Directories); err != nil {
+		return err
+	}
+
 	// nolint:revive // because i disagree that returning this directly would be cleaner
 	if err := dn.workaroundOcpBugs33694(); err != nil {
 		return err
@@ -2158,6 +2232,86 @@ func (dn *Daemon) deleteStaleData(oldIgnConfig, newIgnConfig ign3types.Config) e
 	return nil
 }
 
+// deleteStaleLinks removes the links present in the old config but not in the
+// new one, restoring the file they replaced if there was one. Like
+// directories, only links the MCD recorded creating are removed: links written
+// by Ignition at install time have no stamp, and are kept (BZ1677198).
+func deleteStaleLinks(oldLinks, newLinks []ign3types.Link) error {
+	newLinkSet := make(map[string]struct{})
+	for _, l := range newLinks {
+		newLinkSet[l.Path] = struct{}{}
+	}
+	for _, l := range oldLinks {
+		if _, ok := newLinkSet[l.Path]; ok {
+			continue
+		}
+		if _, err := os.Stat(noOrigFileStampName(l.Path)); err != nil {
+			if _, err := os.Stat(origFileName(l.Path)); err == nil {
+				// cp would follow the link, so remove it first
+				if err := os.Remove(l.Path); err != nil && !os.IsNotExist(err) {
+					return fmt.Errorf("unable to delete %s: %w", l.Path, err)
+				}
+				if err := restorePath(l.Path); err != nil {
+					return err
+				}
+				klog.V(2).Infof("Restored file %q", l.Path)
+				continue
+			}
+			klog.Infof("Not removing link %q: it was not created by the MCD", l.Path)
+			continue
+		}
+		if err := os.Remove(noOrigFileStampName(l.Path)); err != nil {
+			return fmt.Errorf("deleting noorig file stamp %q: %w", noOrigFileStampName(l.Path), err)
+		}
+		klog.V(2).Infof("Deleting stale link: %s", l.Path)
+		if err := os.Remove(l.Path); err != nil {
+			newErr := fmt.Errorf("unable to delete %s: %w", l.Path, err)
+			if !os.IsNotExist(err) {
+				return newErr
+			}
+			// otherwise, just warn
+			klog.Warningf("%v", newErr)
+		}
+		klog.Infof("Removed stale link %q", l.Path)
+	}
+	return nil
+}
+
+// deleteStaleDirectories removes the directories present in the old config
+// but not in the new one. Only directories created by the MCD are removed, and
+// only if they are empty: their contents may not be ours to delete.
+func deleteStaleDirectories(oldDirs, newDirs []ign3types.Directory) error {
+	newDirSet := make(map[string]struct{})
+	for _, d := range newDirs {
+		newDirSet[d.Path] = struct{}{}
+	}
+	var stale []string
+	for _, d := range oldDirs {
+		if _, ok := newDirSet[d.Path]; !ok {
+			stale = append(stale, d.Path)
+		}
+	}
+	// remove nested directories before their parents
+	sort.Sort(sort.Reverse(sort.StringSlice(stale)))
+	for _, path := range stale {
+		if _, err := os.Stat(noOrigFileStampName(path)); err != nil {
+			klog.Infof("Not removing directory %q: it was not created by the MCD", path)
+			continue
+		}
+		klog.V(2).Infof("Deleting stale directory: %s", path)
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			// the directory is kept until whatever else is in it is gone
+			klog.Warningf("Not removing directory %q: %v", path, err)
+			continue
+		}
+		if err := os.Remove(noOrigFileStampName(path)); err != nil {
+			return fmt.Errorf("deleting noorig file stamp %q: %w", noOrigFileStampName(path), err)
+		}
+		klog.Infof("Removed stale directory %q", path)
+	}
+	return nil
+}
+
 // Previous versions of the MCD leaked some enablement symlinks. We clean a
 // known problematic subset of those here. See also:
 // https://issues.redhat.com/browse/OCPBUGS-33694?focusedId=24917003#comment-24917003
@@ -2293,12 +2447,26 @@ func (dn *Daemon) writeUnits(units []ign3types.Unit) error {
 	return nil
 }
 
-// writeFiles writes the given files to disk.
-// it doesn't fetch remote files and expects a flattened config file.
+// writeFiles writes the given files to disk, after fetching the contents of
+// their remote sources.
 func (dn *Daemon) writeFiles(files []ign3types.File, skipCertificateWrite bool) error {
+	files, err := dn.resolveFileSources(files)
+	if err != nil {
+		return err
+	}
 	return writeFiles(files, skipCertificateWrite)
 }
 
+// writeDirectories creates the given directories.
+func (dn *Daemon) writeDirectories(dirs []ign3types.Directory) error {
+	return writeDirectories(dirs)
+}
+
+// writeLinks creates the given links.
+func (dn *Daemon) writeLinks(links []ign3types.Link) error {
+	return writeLinks(links)
+}
+
 // Ensures that both the SSH root directory (/home/core/.ssh) as well as any
 // subdirectories are created with the correct (0700) permissions.
 func createSSHKeyDir(authKeyDir string) error {
@@ -2382,20 +2550,26 @@ func (dn *Daemon) SetPasswordHash(newUsers, oldUsers []ign3types.PasswdUser) err
 	klog.Info("Checking if absent users need to be disconfigured")
 
 	// checking if old users need to be deconfigured
-	deconfigureAbsentUsers(newUsers, oldUsers)
+	if err := dn.deconfigureAbsentUsers(newUsers, oldUsers); err != nil {
+		return err
+	}
 
+	coreExists := true
 	var uErr user.UnknownUserError
 	switch _, err := user.Lookup(constants.CoreUserName); {
 	case err == nil:
 	case errors.As(err, &uErr):
-		klog.Info("core user does not exist, and creating users is not supported, so ignoring configuration specified for core user")
-		return nil
+		klog.Info("core user does not exist, and creating the core user is not supported, so ignoring configuration specified for core user")
+		coreExists = false
 	default:
 		return fmt.Errorf("failed to check if user core exists: %w", err)
 	}
 
 	// SetPasswordHash sets the password hash of the specified user.
 	for _, u := range newUsers {
+		if u.Name == constants.CoreUserName && !coreExists {
+			continue
+		}
 		pwhash := "*"
 		if u.PasswordHash != nil && *u.PasswordHash != "" {
 			pwhash = *u.PasswordHash
@@ -2439,23 +2613,39 @@ func (dn *Daemon) updateSSHKeys(newUsers, oldUsers []ign3types.PasswdUser) error
 	klog.Info("updating SSH keys")
 
 	// Checking to see if absent users need to be deconfigured
-	deconfigureAbsentUsers(newUsers, oldUsers)
+	if err := dn.deconfigureAbsentUsers(newUsers, oldUsers); err != nil {
+		return err
+	}
+
+	// Users other than core get their keys in their own home directory.
+	if !dn.mock {
+		for _, u := range newUsers {
+			if u.Name == constants.CoreUserName {
+				continue
+			}
+			if err := dn.updateUserSSHKeys(u); err != nil {
+				return err
+			}
+		}
+	}
 
 	var uErr user.UnknownUserError
 	switch _, err := user.Lookup(constants.CoreUserName); {
 	case err == nil:
 	case errors.As(err, &uErr):
-		klog.Info("core user does not exist, and creating users is not supported, so ignoring configuration specified for core user")
+		klog.Info("core user does not exist, and creating the core user is not supported, so ignoring configuration specified for core user")
 		return nil
 	default:
 		return fmt.Errorf("failed to check if user core exists: %w", err)
 	}
 
-	// we're also appending all keys for any user to core, so for now
-	// we pass this to atomicallyWriteSSHKeys to write.
-	// we know these users are "core" ones also cause this slice went through Reconcilable
+	// we pass the keys of the core user, which may be split across several
+	// entries, to atomicallyWriteSSHKeys to write.
 	var concatSSHKeys string
 	for _, u := range newUsers {
+		if u.Name != constants.CoreUserName {
+			continue
+		}
 		for _, k := range u.SSHAuthorizedKeys {
 			concatSSHKeys = concatSSHKeys + string(k) + "\n"
 		}
@@ -2488,13 +2678,25 @@ func (dn *Daemon) updateSSHKeys(newUsers, oldUsers []ign3types.PasswdUser) error
 	return nil
 }
 
-func deconfigureAbsentUsers(newUsers, oldUsers []ign3types.PasswdUser) {
+func (dn *Daemon) deconfigureAbsentUsers(newUsers, oldUsers []ign3types.PasswdUser) error {
 	for _, oldUser := range oldUsers {
 		if !isUserPresent(oldUser, newUsers) {
+			// users other than core are locked, or deleted by
+			// updateUsersAndGroups if the MCD created them
+			if oldUser.Name != constants.CoreUserName {
+				if dn.mock {
+					continue
+				}
+				if err := dn.lockAbsentUser(oldUser.Name); err != nil {
+					return err
+				}
+				continue
+			}
 			klog.Infof("Absent user detected, deconfiguring the password for user %s\n", oldUser.Name)
 			deconfigureUser(oldUser)
 		}
 	}
+	return nil
 }
 
 func isUserPresent(user ign3types.PasswdUser, userList []ign3types.PasswdUser) bool {
@@ -2838,6 +3040,9 @@ func (dn *Daemon) reboot(rationale string) error {
 		dn.nodeWriter.Eventf(corev1.EventTypeNormal, "Reboot", rationale)
 	}
 	logSystem("initiating reboot: %s", rationale)
+	dn.updateRecorder.startStep(updateStepReboot)
+	// The update may have staged a new deployment.
+	dn.refreshOSStatus()
 
 	if dn.node != nil {
 		Rebooting := make(map[string]string)
@@ -2861,7 +3066,9 @@ func (dn *Daemon) reboot(rationale string) error {
 	}
 	// if we're here, reboot went through successfully, so we set rebootQueued
 	// and we wait for GracefulNodeShutdown
+	dn.statusLock.Lock()
 	dn.rebootQueued = true
+	dn.statusLock.Unlock()
 	logSystem("reboot successful")
 
 	return nil
@@ -2870,15 +3077,15 @@ func (dn *Daemon) reboot(rationale string) error {
 func (dn *CoreOSDaemon) applyLayeredOSChanges(mcDiff machineConfigDiff, oldConfig, newConfig *mcfgv1.MachineConfig) (retErr error) {
 	// Override the computed diff if the booted state differs from the oldConfig
 	// https://issues.redhat.com/browse/OCPBUGS-2757
-	if mcDiff.osUpdate && dn.bootedOSImageURL == newConfig.Spec.OSImageURL {
+	if mcDiff.OSUpdate && dn.bootedOSImageURL == newConfig.Spec.OSImageURL {
 		klog.Infof("Already in desired image %s", newConfig.Spec.OSImageURL)
-		mcDiff.osUpdate = false
+		mcDiff.OSUpdate = false
 	}
 
 	var osExtensionsContentDir string
 	var err error
 
-	if newConfig.Spec.BaseOSExtensionsContainerImage != "" && (mcDiff.osUpdate || mcDiff.extensions || mcDiff.kernelType) && !mcDiff.oclEnabled {
+	if newConfig.Spec.BaseOSExtensionsContainerImage != "" && (mcDiff.OSUpdate || mcDiff.Extensions || mcDiff.KernelType) && !mcDiff.oclEnabled {
 		// TODO(jkyros): the original intent was that we use the extensions container as a service, but that currently results
 		// in a lot of complexity due to boostrap and firstboot where the service isn't easily available, so for now we are going
 		// to extract them to disk like we did previously.
@@ -2919,7 +3126,7 @@ func (dn *CoreOSDaemon) applyLayeredOSChanges(mcDiff machineConfigDiff, oldConfi
 	if !mcDiff.oclEnabled {
 		// If we have an OS update *or* a kernel type change, then we must undo the kernel swap
 		// enablement.
-		if mcDiff.osUpdate || mcDiff.kernelType {
+		if mcDiff.OSUpdate || mcDiff.KernelType {
 			if err := dn.queueRevertKernelSwap(); err != nil {
 				mcdPivotErr.Inc()
 				return err
@@ -2928,7 +3135,7 @@ func (dn *CoreOSDaemon) applyLayeredOSChanges(mcDiff machineConfigDiff, oldConfi
 	}
 
 	// Update OS
-	if mcDiff.osUpdate {
+	if mcDiff.OSUpdate {
 		if err := dn.updateLayeredOS(newConfig); err != nil {
 			mcdPivotErr.Inc()
 			return err
@@ -2946,7 +3153,7 @@ func (dn *CoreOSDaemon) applyLayeredOSChanges(mcDiff machineConfigDiff, oldConfi
 	// if we're here, we've successfully pivoted, or pivoting wasn't necessary, so we reset the error gauge
 	mcdPivotErr.Set(0)
 
-	if mcDiff.kargs {
+	if mcDiff.Kargs {
 		if err := dn.updateKernelArguments(oldConfig.Spec.KernelArguments, newConfig.Spec.KernelArguments); err != nil {
 			return err
 		}
@@ -2958,7 +3165,7 @@ func (dn *CoreOSDaemon) applyLayeredOSChanges(mcDiff machineConfigDiff, oldConfi
 	}
 
 	// Switch to real time kernel
-	if mcDiff.osUpdate || mcDiff.kernelType {
+	if mcDiff.OSUpdate || mcDiff.KernelType {
 		if err := dn.switchKernel(oldConfig, newConfig); err != nil {
 			return err
 		}
@@ -0,0 +1,220 @@
+package daemon
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog/v2"
+
+	"github.com/openshift/machine-config-operator/pkg/helpers"
+	"github.com/openshift/machine-config-operator/pkg/upgrademonitor"
+)
+
+const (
+	// updateHistoryLimit is the number of updates kept in the update history
+	// on disk.
+	updateHistoryLimit = 50
+
+	// updateHistoryMachineConfigNodeEntries is the number of the latest
+	// updates listed on the MachineConfigNode.
+	updateHistoryMachineConfigNodeEntries = 5
+
+	// updateHistoryErrorLimit is the number of characters of the error of
+	// each update listed on the MachineConfigNode, which keeps the condition
+	// message well below its limit of 32768 characters.
+	updateHistoryErrorLimit = 4096
+
+	// updateOutcomeSucceeded and updateOutcomeFailed are the outcomes of an
+	// update.
+	updateOutcomeSucceeded = "Succeeded"
+	updateOutcomeFailed    = "Failed"
+)
+
+// UpdateHistoryEntry is the record of a finished update in the update
+// history of the node.
+type UpdateHistoryEntry struct {
+	OldConfig string `json:"oldConfig"`
+	NewConfig string `json:"newConfig"`
+	OldImage  string `json:"oldImage,omitempty"`
+	NewImage  string `json:"newImage,omitempty"`
+	// Diff summarizes what the update changed.
+	Diff    string    `json:"diff"`
+	Actions []string  `json:"actions"`
+	Start   time.Time `json:"start"`
+	End     time.Time `json:"end"`
+	// DrainDuration and RebootDuration are how long the drain and the reboot
+	// took, if the update drained or rebooted.
+	DrainDuration  *metav1.Duration `json:"drainDuration,omitempty"`
+	RebootDuration *metav1.Duration `json:"rebootDuration,omitempty"`
+	Outcome        string           `json:"outcome"`
+	Error          string           `json:"error,omitempty"`
+}
+
+// newUpdateHistoryEntry returns the update history entry of a finished update
+// record.
+func newUpdateHistoryEntry(record *UpdateRecord) UpdateHistoryEntry {
+	entry := UpdateHistoryEntry{
+		OldConfig: record.Plan.OldConfig,
+		NewConfig: record.Plan.NewConfig,
+		OldImage:  record.OldImage,
+		NewImage:  record.NewImage,
+		Diff:      record.Plan.diffSummary(),
+		Actions:   record.Plan.Actions,
+		Start:     record.Start,
+		Outcome:   updateOutcomeSucceeded,
+		Error:     record.Error,
+	}
+	if record.End != nil {
+		entry.End = *record.End
+	}
+	if record.Error != "" {
+		entry.Outcome = updateOutcomeFailed
+	}
+	for _, step := range record.Steps {
+		if step.End == nil {
+			continue
+		}
+		duration := &metav1.Duration{Duration: step.End.Sub(step.Start)}
+		switch step.Name {
+		case updateStepDrain:
+			entry.DrainDuration = duration
+		case updateStepReboot:
+			entry.RebootDuration = duration
+		}
+	}
+	return entry
+}
+
+// diffSummary summarizes what an update plan changes in a single line.
+func (p *UpdatePlan) diffSummary() string {
+	var changes []string
+	count := func(n int, what string) {
+		if n > 0 {
+			changes = append(changes, fmt.Sprintf("%d %s", n, what))
+		}
+	}
+	count(len(p.FilesToWrite), "files written")
+	count(len(p.FilesToDelete), "files deleted")
+	count(len(p.DirectoriesToWrite), "directories written")
+	count(len(p.DirectoriesToDelete), "directories deleted")
+	count(len(p.LinksToWrite), "links written")
+	count(len(p.LinksToDelete), "links deleted")
+	count(len(p.UnitsToWrite), "units written")
+	count(len(p.UnitsToDelete), "units deleted")
+	count(len(p.UnitsToEnable)+len(p.UnitsToDisable)+len(p.UnitsToMask), "units enabled, disabled or masked")
+	if p.PasswdUpdate {
+		changes = append(changes, "users")
+	}
+	if len(p.KernelArgumentsToAdd) > 0 || len(p.KernelArgumentsToRemove) > 0 {
+		changes = append(changes, "kernel arguments")
+	}
+	if len(p.ExtensionsToAdd) > 0 || len(p.ExtensionsToRemove) > 0 {
+		changes = append(changes, "extensions")
+	}
+	if p.KernelType != nil {
+		changes = append(changes, "kernel type")
+	}
+	if p.FIPS != nil {
+		changes = append(changes, "FIPS")
+	}
+	if p.OSImage != nil {
+		changes = append(changes, "OS image")
+	}
+	if len(changes) == 0 {
+		return "no changes"
+	}
+	return strings.Join(changes, ", ")
+}
+
+// readUpdateHistory returns the update history, oldest first.
+func readUpdateHistory(path string) ([]UpdateHistoryEntry, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var history []UpdateHistoryEntry
+	if err := json.Unmarshal(data, &history); err != nil {
+		return nil, fmt.Errorf("could not parse update history %s: %w", path, err)
+	}
+	return history, nil
+}
+
+// appendUpdateHistory adds an entry to the update history, dropping the
+// oldest entries beyond the limit, and returns the new history.
+func appendUpdateHistory(path string, entry UpdateHistoryEntry, limit int) ([]UpdateHistoryEntry, error) {
+	history, err := readUpdateHistory(path)
+	if err != nil {
+		// A corrupt history must not prevent recording new updates.
+		klog.Warningf("Discarding update history: %v", err)
+		history = nil
+	}
+	history = append(history, entry)
+	if len(history) > limit {
+		history = history[len(history)-limit:]
+	}
+	data, err := json.Marshal(history)
+	if err != nil {
+		return nil, err
+	}
+	if err := writeFileAtomicallyWithDefaults(path, data); err != nil {
+		return nil, err
+	}
+	return history, nil
+}
+
+// formatUpdateHistory lists the latest n updates of the history, newest
+// first, one per line.
+func formatUpdateHistory(history []UpdateHistoryEntry, n int) string {
+	var lines []string
+	for i := len(history) - 1; i >= 0 && len(lines) < n; i-- {
+		entry := history[i]
+		line := fmt.Sprintf("%s %s -> %s: %s in %s (%s; actions: %s", entry.End.UTC().Format(time.RFC3339), entry.OldConfig, entry.NewConfig,
+			entry.Outcome, entry.End.Sub(entry.Start).Round(time.Second), entry.Diff, strings.Join(entry.Actions, ", "))
+		if entry.DrainDuration != nil {
+			line += fmt.Sprintf("; drain: %s", entry.DrainDuration.Round(time.Second))
+		}
+		if entry.RebootDuration != nil {
+			line += fmt.Sprintf("; reboot: %s", entry.RebootDuration.Round(time.Second))
+		}
+		line += ")"
+		if entry.Error != "" {
+			line += ": " + truncate(entry.Error, updateHistoryErrorLimit)
+		}
+		lines = append(lines, line)
+	}
+	return strings.Join(lines, "\n")
+}
+
+// reportUpdateHistory lists the latest updates of the node on its
+// MachineConfigNode.
+func (dn *Daemon) reportUpdateHistory(history []UpdateHistoryEntry) {
+	if len(history) == 0 || dn.mcpLister == nil {
+		return
+	}
+	pool, err := helpers.GetPrimaryPoolNameForMCN(dn.mcpLister, dn.node)
+	if err != nil {
+		klog.Errorf("Could not get pool to report update history: %v", err)
+		return
+	}
+	latest := history[len(history)-1]
+	err = upgrademonitor.GenerateAndApplyMachineConfigNodes(
+		&upgrademonitor.Condition{State: upgrademonitor.MachineConfigNodeUpdateHistory, Reason: "Update" + latest.Outcome, Message: formatUpdateHistory(history, updateHistoryMachineConfigNodeEntries)},
+		nil,
+		metav1.ConditionTrue,
+		metav1.ConditionFalse,
+		dn.node,
+		dn.mcfgClient,
+		dn.featureGatesAccessor,
+		pool,
+	)
+	if err != nil {
+		klog.Errorf("Error making MCN for update history: %v", err)
+	}
+}
@@ -0,0 +1,125 @@
+package daemon
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestNewUpdateHistoryEntry(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	at := func(minutes int) *time.Time {
+		ts := start.Add(time.Duration(minutes) * time.Minute)
+		return &ts
+	}
+	record := &UpdateRecord{
+		Plan: &UpdatePlan{
+			OldConfig:            "rendered-worker-1",
+			NewConfig:            "rendered-worker-2",
+			FilesToWrite:         []string{"/etc/a", "/etc/b"},
+			UnitsToEnable:        []string{"a.service"},
+			KernelArgumentsToAdd: []string{"nosmt"},
+			OSImage:              &UpdatePlanChange{From: "registry/os@sha256:1", To: "registry/os@sha256:2"},
+			DrainRequired:        true,
+			Actions:              []string{postConfigChangeActionReboot},
+		},
+		OldImage: "registry/os@sha256:1",
+		NewImage: "registry/os@sha256:2",
+		Start:    start,
+		End:      at(10),
+		Steps: []UpdateStep{
+			{Name: updateStepDrain, Start: start, End: at(2)},
+			{Name: updateStepFiles, Start: *at(2), End: at(3)},
+			{Name: updateStepReboot, Start: *at(3), End: at(10)},
+		},
+	}
+
+	entry := newUpdateHistoryEntry(record)
+	assert.Equal(t, UpdateHistoryEntry{
+		OldConfig:      "rendered-worker-1",
+		NewConfig:      "rendered-worker-2",
+		OldImage:       "registry/os@sha256:1",
+		NewImage:       "registry/os@sha256:2",
+		Diff:           "2 files written, 1 units enabled, disabled or masked, kernel arguments, OS image",
+		Actions:        []string{postConfigChangeActionReboot},
+		Start:          start,
+		End:            *at(10),
+		DrainDuration:  &metav1.Duration{Duration: 2 * time.Minute},
+		RebootDuration: &metav1.Duration{Duration: 7 * time.Minute},
+		Outcome:        updateOutcomeSucceeded,
+	}, entry)
+
+	record.Error = "reboot failed"
+	entry = newUpdateHistoryEntry(record)
+	assert.Equal(t, updateOutcomeFailed, entry.Outcome)
+	assert.Equal(t, "reboot failed", entry.Error)
+
+	assert.Equal(t, "no changes", (&UpdatePlan{}).diffSummary())
+}
+
+func TestAppendUpdateHistory(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "updatehistory")
+
+	history, err := readUpdateHistory(path)
+	require.NoError(t, err)
+	assert.Empty(t, history)
+
+	for i := 1; i <= 4; i++ {
+		history, err = appendUpdateHistory(path, UpdateHistoryEntry{NewConfig: fmt.Sprintf("rendered-worker-%d", i)}, 3)
+		require.NoError(t, err)
+	}
+	require.Len(t, history, 3)
+	assert.Equal(t, "rendered-worker-2", history[0].NewConfig)
+	assert.Equal(t, "rendered-worker-4", history[2].NewConfig)
+
+	onDisk, err := readUpdateHistory(path)
+	require.NoError(t, err)
+	assert.Equal(t, history, onDisk)
+}
+
+func TestFormatUpdateHistory(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	history := []UpdateHistoryEntry{
+		{
+			OldConfig: "rendered-worker-1",
+			NewConfig: "rendered-worker-2",
+			Diff:      "1 files written",
+			Actions:   []string{postConfigChangeActionNone},
+			Start:     start,
+			End:       start.Add(30 * time.Second),
+			Outcome:   updateOutcomeSucceeded,
+		},
+		{
+			OldConfig:      "rendered-worker-2",
+			NewConfig:      "rendered-worker-3",
+			Diff:           "OS image",
+			Actions:        []string{postConfigChangeActionReboot},
+			Start:          start.Add(time.Hour),
+			End:            start.Add(time.Hour + 10*time.Minute),
+			DrainDuration:  &metav1.Duration{Duration: 2 * time.Minute},
+			RebootDuration: &metav1.Duration{Duration: 5 * time.Minute},
+			Outcome:        updateOutcomeFailed,
+			Error:          "health gate failed",
+		},
+	}
+
+	assert.Equal(t, "2024-01-01T01:10:00Z rendered-worker-2 -> rendered-worker-3: Failed in 10m0s (OS image; actions: reboot; drain: 2m0s; reboot: 5m0s): health gate failed\n"+
+		"2024-01-01T00:00:30Z rendered-worker-1 -> rendered-worker-2: Succeeded in 30s (1 files written; actions: none)", formatUpdateHistory(history, 5))
+	assert.Equal(t, "2024-01-01T01:10:00Z rendered-worker-2 -> rendered-worker-3: Failed in 10m0s (OS image; actions: reboot; drain: 2m0s; reboot: 5m0s): health gate failed",
+		formatUpdateHistory(history, 1))
+
+	// Long errors, e.g. with the output of a command, are truncated so the
+	// condition message stays within its limit.
+	long := history[1]
+	long.Error = strings.Repeat("x", 40000)
+	history = []UpdateHistoryEntry{long, long, long, long, long}
+	formatted := formatUpdateHistory(history, updateHistoryMachineConfigNodeEntries)
+	assert.Less(t, len(formatted), 32768)
+	assert.Contains(t, formatted, "[35904 more chars]")
+}
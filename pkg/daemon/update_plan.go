@@ -0,0 +1,453 @@
+package daemon
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+
+	ign3types "github.com/coreos/ignition/v2/config/v3_5/types"
+	mcfgv1 "github.com/openshift/api/machineconfiguration/v1"
+	opv1 "github.com/openshift/api/operator/v1"
+	mcfgclientset "github.com/openshift/client-go/machineconfiguration/clientset/versioned"
+	mcopclientset "github.com/openshift/client-go/operator/clientset/versioned"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/client-go/kubernetes"
+
+	ctrlcommon "github.com/openshift/machine-config-operator/pkg/controller/common"
+	"github.com/openshift/machine-config-operator/pkg/daemon/constants"
+)
+
+// Output formats of an update plan.
+const (
+	UpdatePlanOutputText = "text"
+	UpdatePlanOutputJSON = "json"
+)
+
+// UpdatePlan describes what the MCD would do to a node to update it from one
+// MachineConfig to another, without doing it.
+type UpdatePlan struct {
+	OldConfig string `json:"oldConfig"`
+	NewConfig string `json:"newConfig"`
+	// Unreconcilable is why the MCD cannot apply the update, if it cannot.
+	Unreconcilable string `json:"unreconcilable,omitempty"`
+
+	FilesToWrite        []string `json:"filesToWrite,omitempty"`
+	FilesToDelete       []string `json:"filesToDelete,omitempty"`
+	DirectoriesToWrite  []string `json:"directoriesToWrite,omitempty"`
+	DirectoriesToDelete []string `json:"directoriesToDelete,omitempty"`
+	LinksToWrite        []string `json:"linksToWrite,omitempty"`
+	LinksToDelete       []string `json:"linksToDelete,omitempty"`
+	UnitsToWrite        []string `json:"unitsToWrite,omitempty"`
+	UnitsToDelete       []string `json:"unitsToDelete,omitempty"`
+	UnitsToEnable       []string `json:"unitsToEnable,omitempty"`
+	UnitsToDisable      []string `json:"unitsToDisable,omitempty"`
+	UnitsToMask         []string `json:"unitsToMask,omitempty"`
+	// PasswdUpdate is true when users, groups, SSH keys or password hashes change.
+	PasswdUpdate bool `json:"passwdUpdate,omitempty"`
+
+	KernelArgumentsToAdd    []string          `json:"kernelArgumentsToAdd,omitempty"`
+	KernelArgumentsToRemove []string          `json:"kernelArgumentsToRemove,omitempty"`
+	ExtensionsToAdd         []string          `json:"extensionsToAdd,omitempty"`
+	ExtensionsToRemove      []string          `json:"extensionsToRemove,omitempty"`
+	KernelType              *UpdatePlanChange `json:"kernelType,omitempty"`
+	FIPS                    *UpdatePlanChange `json:"fips,omitempty"`
+	// OSImage is the rebase of the OS, if any.
+	OSImage *UpdatePlanChange `json:"osImage,omitempty"`
+
+	DrainRequired bool `json:"drainRequired"`
+	// Actions are what the MCD does once the changes are written, e.g.
+	// "Reboot", "Reload crio.service" or "None".
+	Actions []string `json:"actions"`
+}
+
+// UpdatePlanChange is a setting changed by an update.
+type UpdatePlanChange struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+// UpdatePlanOptions are the cluster settings an update plan depends on.
+type UpdatePlanOptions struct {
+	// NodeDisruptionPolicies are the node disruption policies of the cluster.
+	// If nil, the actions are calculated as when the NodeDisruptionPolicy
+	// feature gate is disabled.
+	NodeDisruptionPolicies *opv1.NodeDisruptionPolicyClusterStatus
+	// ImageRegistryDrainOverride is true when the configmap forcing a drain
+	// for image registry changes exists.
+	ImageRegistryDrainOverride bool
+	// Force is true when the machine-config-daemon-force file exists on
+	// the node, in which case the node reboots whatever the changes are. It
+	// is the only source of the force file for the plan.
+	Force bool
+}
+
+// PlanUpdate computes the plan of an update from the old to the new
+// MachineConfig, with the same logic as the update itself. It does not change
+// anything on the node.
+func PlanUpdate(oldConfig, newConfig *mcfgv1.MachineConfig, opts UpdatePlanOptions) (*UpdatePlan, error) {
+	oldConfig = canonicalizeEmptyMC(oldConfig)
+	plan := &UpdatePlan{OldConfig: oldConfig.GetName(), NewConfig: newConfig.GetName(), Actions: []string{}}
+
+	oldIgnConfig, err := ctrlcommon.ParseAndConvertConfig(oldConfig.Spec.Config.Raw)
+	if err != nil {
+		return nil, fmt.Errorf("parsing old Ignition config failed: %w", err)
+	}
+	newIgnConfig, err := ctrlcommon.ParseAndConvertConfig(newConfig.Spec.Config.Raw)
+	if err != nil {
+		return nil, fmt.Errorf("parsing new Ignition config failed: %w", err)
+	}
+
+	if err := checkReconcilable(oldConfig, newConfig); err != nil {
+		plan.Unreconcilable = err.Error()
+		return plan, nil
+	}
+	// The force file is looked up by the caller, under the root of the node
+	// the plan is for, so the diff does not look at the one of this process.
+	commonDiff, err := ctrlcommon.NewMachineConfigDiff(oldConfig, newConfig)
+	if err != nil {
+		return nil, fmt.Errorf("error creating machineConfigDiff: %w", err)
+	}
+	diff := &machineConfigDiff{MachineConfigDiff: *commonDiff}
+	diff.OSUpdate = diff.OSUpdate || opts.Force
+
+	diffFileSet := ctrlcommon.CalculateConfigFileDiffs(&oldIgnConfig, &newIgnConfig)
+	diffUnitSet := ctrlcommon.CalculateConfigUnitDiffs(&oldIgnConfig, &newIgnConfig)
+	planFiles(plan, oldIgnConfig, newIgnConfig, diffFileSet)
+	planUnits(plan, oldIgnConfig, newIgnConfig)
+	plan.PasswdUpdate = diff.Passwd
+
+	if diff.Kargs {
+		oldKargs := sets.New(parseKernelArguments(oldConfig.Spec.KernelArguments)...)
+		newKargs := sets.New(parseKernelArguments(newConfig.Spec.KernelArguments)...)
+		plan.KernelArgumentsToAdd = sortedDifference(newKargs, oldKargs)
+		plan.KernelArgumentsToRemove = sortedDifference(oldKargs, newKargs)
+	}
+	if diff.Extensions {
+		oldExtensions := sets.New(oldConfig.Spec.Extensions...)
+		newExtensions := sets.New(newConfig.Spec.Extensions...)
+		plan.ExtensionsToAdd = sortedDifference(newExtensions, oldExtensions)
+		plan.ExtensionsToRemove = sortedDifference(oldExtensions, newExtensions)
+	}
+	if diff.KernelType {
+		plan.KernelType = &UpdatePlanChange{From: ctrlcommon.CanonicalizeKernelType(oldConfig.Spec.KernelType), To: ctrlcommon.CanonicalizeKernelType(newConfig.Spec.KernelType)}
+	}
+	if diff.FIPS {
+		plan.FIPS = &UpdatePlanChange{From: fmt.Sprint(oldConfig.Spec.FIPS), To: fmt.Sprint(newConfig.Spec.FIPS)}
+	}
+	if oldConfig.Spec.OSImageURL != newConfig.Spec.OSImageURL {
+		plan.OSImage = &UpdatePlanChange{From: oldConfig.Spec.OSImageURL, To: newConfig.Spec.OSImageURL}
+	}
+
+	switch {
+	case opts.Force:
+		plan.DrainRequired = true
+		plan.Actions = append(plan.Actions, postConfigChangeActionReboot)
+	case opts.NodeDisruptionPolicies != nil:
+		actions := ctrlcommon.CalculateNodeDisruptionActionsForDiff(&diff.MachineConfigDiff, diffFileSet, diffUnitSet, *opts.NodeDisruptionPolicies)
+		if plan.DrainRequired, err = isDrainRequiredForNodeDisruptionActions(actions, oldIgnConfig, newIgnConfig, opts.ImageRegistryDrainOverride); err != nil {
+			return nil, err
+		}
+		for _, action := range actions {
+			plan.Actions = append(plan.Actions, ctrlcommon.NodeDisruptionActionToString(action))
+		}
+	default:
+		actions := calculatePostConfigChangeActionsForDiff(diff, diffFileSet)
+		if plan.DrainRequired, err = isDrainRequired(actions, diffFileSet, oldIgnConfig, newIgnConfig, opts.ImageRegistryDrainOverride); err != nil {
+			return nil, err
+		}
+		plan.Actions = append(plan.Actions, actions...)
+	}
+
+	return plan, nil
+}
+
+// PlanClusterUpdate computes the plan of updating a node from its current
+// config to the given config, or to its desired config if configName is
+// empty, with the node disruption policies of the cluster.
+func PlanClusterUpdate(ctx context.Context, kubeClient kubernetes.Interface, mcfgClient mcfgclientset.Interface, mcopClient mcopclientset.Interface, nodeName, configName string, force bool) (*UpdatePlan, error) {
+	node, err := kubeClient.CoreV1().Nodes().Get(ctx, nodeName, metav1.GetOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("could not get node %s: %w", nodeName, err)
+	}
+	currentConfigName, err := getNodeAnnotation(node, constants.CurrentMachineConfigAnnotationKey)
+	if err != nil {
+		return nil, err
+	}
+	if configName == "" {
+		if configName, err = getNodeAnnotation(node, constants.DesiredMachineConfigAnnotationKey); err != nil {
+			return nil, err
+		}
+	}
+
+	oldConfig, err := mcfgClient.MachineconfigurationV1().MachineConfigs().Get(ctx, currentConfigName, metav1.GetOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("could not get current MachineConfig %s: %w", currentConfigName, err)
+	}
+	newConfig, err := mcfgClient.MachineconfigurationV1().MachineConfigs().Get(ctx, configName, metav1.GetOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("could not get MachineConfig %s: %w", configName, err)
+	}
+
+	opts := UpdatePlanOptions{Force: force}
+	mcop, err := mcopClient.OperatorV1().MachineConfigurations().Get(ctx, ctrlcommon.MCOOperatorKnobsObjectName, metav1.GetOptions{})
+	if err != nil && !apierrors.IsNotFound(err) {
+		return nil, fmt.Errorf("could not get MachineConfiguration %s: %w", ctrlcommon.MCOOperatorKnobsObjectName, err)
+	}
+	// The operator only populates the policy status when the
+	// NodeDisruptionPolicy feature is enabled.
+	if err == nil && !reflect.DeepEqual(mcop.Status.NodeDisruptionPolicyStatus.ClusterPolicies, opv1.NodeDisruptionPolicyClusterStatus{}) {
+		opts.NodeDisruptionPolicies = &mcop.Status.NodeDisruptionPolicyStatus.ClusterPolicies
+	}
+
+	_, err = kubeClient.CoreV1().ConfigMaps(ctrlcommon.MCONamespace).Get(ctx, constants.ImageRegistryDrainOverrideConfigmap, metav1.GetOptions{})
+	if err != nil && !apierrors.IsNotFound(err) {
+		return nil, fmt.Errorf("could not get configmap %s: %w", constants.ImageRegistryDrainOverrideConfigmap, err)
+	}
+	opts.ImageRegistryDrainOverride = err == nil
+
+	return PlanUpdate(oldConfig, newConfig, opts)
+}
+
+// PlanOnceFrom computes the plan of running the daemon once from the given
+// MachineConfig or Ignition config, as RunOnceFrom would run it.
+func (dn *Daemon) PlanOnceFrom(onceFrom string) (*UpdatePlan, error) {
+	configi, contentFrom, err := dn.senseAndLoadOnceFrom(onceFrom)
+	if err != nil {
+		return nil, err
+	}
+	switch c := configi.(type) {
+	case ign3types.Config:
+		// Only the files and units of the Ignition config are written, and
+		// the node always reboots.
+		plan := &UpdatePlan{NewConfig: onceFrom, Actions: []string{postConfigChangeActionReboot}}
+		paths := []string{}
+		for _, f := range c.Storage.Files {
+			paths = append(paths, f.Path)
+		}
+		planFiles(plan, ign3types.Config{}, c, paths)
+		planUnits(plan, ign3types.Config{}, c)
+		return plan, nil
+	case mcfgv1.MachineConfig:
+		var oldConfig *mcfgv1.MachineConfig
+		// A remote MachineConfig is applied on top of the current config of
+		// the node, a local one on top of an empty config.
+		if contentFrom == onceFromRemoteConfig {
+			odc, err := dn.getCurrentConfigOnDisk()
+			if err != nil && !os.IsNotExist(err) {
+				return nil, err
+			}
+			if odc != nil {
+				oldConfig = odc.currentConfig
+			}
+		}
+		return PlanUpdate(oldConfig, &c, UpdatePlanOptions{Force: forceFileExists()})
+	}
+	return nil, fmt.Errorf("unsupported onceFrom type provided")
+}
+
+// sortedDifference returns the sorted items of a which are not in b, or nil.
+func sortedDifference(a, b sets.Set[string]) []string {
+	diff := a.Difference(b)
+	if diff.Len() == 0 {
+		return nil
+	}
+	return sets.List(diff)
+}
+
+// planFiles lists the files, directories and links written and deleted by the
+// update, as updateFiles does.
+func planFiles(plan *UpdatePlan, oldIgnConfig, newIgnConfig ign3types.Config, diffFileSet []string) {
+	changed := sets.New(diffFileSet...)
+	newFiles := sets.New[string]()
+	for _, f := range newIgnConfig.Storage.Files {
+		newFiles.Insert(f.Path)
+		if changed.Has(f.Path) {
+			plan.FilesToWrite = append(plan.FilesToWrite, f.Path)
+		}
+	}
+	for _, f := range oldIgnConfig.Storage.Files {
+		if !newFiles.Has(f.Path) {
+			plan.FilesToDelete = append(plan.FilesToDelete, f.Path)
+		}
+	}
+	sort.Strings(plan.FilesToWrite)
+	sort.Strings(plan.FilesToDelete)
+
+	newDirs := sets.New[string]()
+	for _, d := range newIgnConfig.Storage.Directories {
+		newDirs.Insert(d.Path)
+		if changed.Has(d.Path) {
+			plan.DirectoriesToWrite = append(plan.DirectoriesToWrite, d.Path)
+		}
+	}
+	for _, d := range oldIgnConfig.Storage.Directories {
+		if !newDirs.Has(d.Path) {
+			plan.DirectoriesToDelete = append(plan.DirectoriesToDelete, d.Path)
+		}
+	}
+	sort.Strings(plan.DirectoriesToWrite)
+	sort.Strings(plan.DirectoriesToDelete)
+
+	newLinks := sets.New[string]()
+	for _, l := range newIgnConfig.Storage.Links {
+		newLinks.Insert(l.Path)
+		if changed.Has(l.Path) {
+			plan.LinksToWrite = append(plan.LinksToWrite, l.Path)
+		}
+	}
+	for _, l := range oldIgnConfig.Storage.Links {
+		if !newLinks.Has(l.Path) {
+			plan.LinksToDelete = append(plan.LinksToDelete, l.Path)
+		}
+	}
+	sort.Strings(plan.LinksToWrite)
+	sort.Strings(plan.LinksToDelete)
+}
+
+// planUnits lists the units and dropins written and deleted by the update,
+// and the units whose enablement or mask changes.
+func planUnits(plan *UpdatePlan, oldIgnConfig, newIgnConfig ign3types.Config) {
+	oldUnits := map[string]ign3types.Unit{}
+	for _, u := range oldIgnConfig.Systemd.Units {
+		oldUnits[u.Name] = u
+	}
+	oldDropins := sets.New[string]()
+	for _, u := range oldIgnConfig.Systemd.Units {
+		for _, d := range u.Dropins {
+			if d.Contents != nil && *d.Contents != "" {
+				oldDropins.Insert(filepath.Join(pathSystemd, u.Name+".d", d.Name))
+			}
+		}
+	}
+
+	newUnits := sets.New[string]()
+	newDropins := sets.New[string]()
+	for _, u := range newIgnConfig.Systemd.Units {
+		newUnits.Insert(u.Name)
+		old, existed := oldUnits[u.Name]
+
+		for _, d := range u.Dropins {
+			if d.Contents == nil || *d.Contents == "" {
+				continue
+			}
+			path := filepath.Join(pathSystemd, u.Name+".d", d.Name)
+			newDropins.Insert(path)
+			if !existed || !oldDropins.Has(path) || !dropinUnchanged(old, d) {
+				plan.UnitsToWrite = append(plan.UnitsToWrite, path)
+			}
+		}
+
+		masked := u.Mask != nil && *u.Mask
+		if masked {
+			if !existed || old.Mask == nil || !*old.Mask {
+				plan.UnitsToMask = append(plan.UnitsToMask, u.Name)
+			}
+		} else if u.Contents != nil && *u.Contents != "" && (!existed || old.Contents == nil || *old.Contents != *u.Contents) {
+			plan.UnitsToWrite = append(plan.UnitsToWrite, filepath.Join(pathSystemd, u.Name))
+		}
+
+		if u.Enabled != nil && (!existed || old.Enabled == nil || *old.Enabled != *u.Enabled) {
+			if *u.Enabled {
+				plan.UnitsToEnable = append(plan.UnitsToEnable, u.Name)
+			} else {
+				plan.UnitsToDisable = append(plan.UnitsToDisable, u.Name)
+			}
+		}
+	}
+
+	for _, u := range oldIgnConfig.Systemd.Units {
+		if !newUnits.Has(u.Name) {
+			plan.UnitsToDelete = append(plan.UnitsToDelete, filepath.Join(pathSystemd, u.Name))
+		}
+	}
+	for _, path := range sets.List(oldDropins.Difference(newDropins)) {
+		plan.UnitsToDelete = append(plan.UnitsToDelete, path)
+	}
+
+	for _, list := range [][]string{plan.UnitsToWrite, plan.UnitsToDelete, plan.UnitsToEnable, plan.UnitsToDisable, plan.UnitsToMask} {
+		sort.Strings(list)
+	}
+}
+
+func dropinUnchanged(unit ign3types.Unit, dropin ign3types.Dropin) bool {
+	for _, d := range unit.Dropins {
+		if d.Name == dropin.Name {
+			return d.Contents != nil && *d.Contents == *dropin.Contents
+		}
+	}
+	return false
+}
+
+// WriteUpdatePlan writes the plan in the given output format.
+func WriteUpdatePlan(w io.Writer, plan *UpdatePlan, output string) error {
+	switch output {
+	case UpdatePlanOutputJSON:
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(plan)
+	case UpdatePlanOutputText, "":
+		_, err := io.WriteString(w, plan.String())
+		return err
+	default:
+		return fmt.Errorf("unknown output format %q: must be %s or %s", output, UpdatePlanOutputText, UpdatePlanOutputJSON)
+	}
+}
+
+// String returns the plan in a human readable form.
+func (p *UpdatePlan) String() string {
+	b := &strings.Builder{}
+	fmt.Fprintf(b, "Update from %s to %s\n", p.OldConfig, p.NewConfig)
+	if p.Unreconcilable != "" {
+		fmt.Fprintf(b, "\nThe update cannot be applied: %s\n", p.Unreconcilable)
+		return b.String()
+	}
+
+	section := func(title string, items []string) {
+		if len(items) == 0 {
+			return
+		}
+		fmt.Fprintf(b, "\n%s:\n", title)
+		for _, item := range items {
+			fmt.Fprintf(b, "  %s\n", item)
+		}
+	}
+	change := func(title string, c *UpdatePlanChange) {
+		if c != nil {
+			fmt.Fprintf(b, "\n%s: %s -> %s\n", title, c.From, c.To)
+		}
+	}
+
+	section("Files to write", p.FilesToWrite)
+	section("Files to delete", p.FilesToDelete)
+	section("Directories to write", p.DirectoriesToWrite)
+	section("Directories to delete", p.DirectoriesToDelete)
+	section("Links to write", p.LinksToWrite)
+	section("Links to delete", p.LinksToDelete)
+	section("Units to write", p.UnitsToWrite)
+	section("Units to delete", p.UnitsToDelete)
+	section("Units to enable", p.UnitsToEnable)
+	section("Units to disable", p.UnitsToDisable)
+	section("Units to mask", p.UnitsToMask)
+	if p.PasswdUpdate {
+		fmt.Fprintf(b, "\nUsers, groups, SSH keys and password hashes will be updated\n")
+	}
+	section("Kernel arguments to add", p.KernelArgumentsToAdd)
+	section("Kernel arguments to remove", p.KernelArgumentsToRemove)
+	section("Extensions to add", p.ExtensionsToAdd)
+	section("Extensions to remove", p.ExtensionsToRemove)
+	change("Kernel type", p.KernelType)
+	change("FIPS", p.FIPS)
+	change("OS image", p.OSImage)
+
+	fmt.Fprintf(b, "\nDrain required: %t\n", p.DrainRequired)
+	fmt.Fprintf(b, "Actions: %s\n", strings.Join(p.Actions, ", "))
+	return b.String()
+}
@@ -0,0 +1,210 @@
+package daemon
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"testing"
+
+	ign3types "github.com/coreos/ignition/v2/config/v3_5/types"
+	mcfgv1 "github.com/openshift/api/machineconfiguration/v1"
+	opv1 "github.com/openshift/api/operator/v1"
+	"github.com/openshift/client-go/machineconfiguration/clientset/versioned/fake"
+	mcopfake "github.com/openshift/client-go/operator/clientset/versioned/fake"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	k8sfake "k8s.io/client-go/kubernetes/fake"
+
+	ctrlcommon "github.com/openshift/machine-config-operator/pkg/controller/common"
+	"github.com/openshift/machine-config-operator/pkg/daemon/constants"
+	"github.com/openshift/machine-config-operator/test/helpers"
+)
+
+func newPlanMachineConfig(name string, files []ign3types.File, units []ign3types.Unit) *mcfgv1.MachineConfig {
+	ignCfg := ctrlcommon.NewIgnConfig()
+	ignCfg.Storage.Files = files
+	ignCfg.Systemd.Units = units
+	return helpers.CreateMachineConfigFromIgnitionWithMetadata(ignCfg, name, "worker")
+}
+
+func TestPlanUpdate(t *testing.T) {
+	oldConfig := newPlanMachineConfig("rendered-worker-1",
+		[]ign3types.File{
+			ctrlcommon.NewIgnFile("/etc/kept", "kept\n"),
+			ctrlcommon.NewIgnFile("/etc/changed", "old\n"),
+			ctrlcommon.NewIgnFile("/etc/removed", "removed\n"),
+		},
+		[]ign3types.Unit{
+			{Name: "kept.service", Contents: helpers.StrToPtr("[Unit]"), Enabled: helpers.BoolToPtr(true)},
+			{Name: "removed.service", Contents: helpers.StrToPtr("[Unit]")},
+		})
+	oldConfig.Spec.KernelArguments = []string{"nosmt", "foo=bar"}
+	oldConfig.Spec.OSImageURL = "registry/os@sha256:1"
+
+	newConfig := newPlanMachineConfig("rendered-worker-2",
+		[]ign3types.File{
+			ctrlcommon.NewIgnFile("/etc/kept", "kept\n"),
+			ctrlcommon.NewIgnFile("/etc/changed", "new\n"),
+			ctrlcommon.NewIgnFile("/etc/added", "added\n"),
+		},
+		[]ign3types.Unit{
+			{Name: "kept.service", Contents: helpers.StrToPtr("[Unit]"), Enabled: helpers.BoolToPtr(false)},
+			{Name: "added.service", Contents: helpers.StrToPtr("[Unit]"), Enabled: helpers.BoolToPtr(true)},
+			{Name: "masked.service", Mask: helpers.BoolToPtr(true)},
+		})
+	newConfig.Spec.KernelArguments = []string{"nosmt", "baz"}
+	newConfig.Spec.Extensions = []string{"usbguard"}
+	newConfig.Spec.OSImageURL = "registry/os@sha256:2"
+
+	plan, err := PlanUpdate(oldConfig, newConfig, UpdatePlanOptions{})
+	require.NoError(t, err)
+
+	assert.Equal(t, &UpdatePlan{
+		OldConfig:               "rendered-worker-1",
+		NewConfig:               "rendered-worker-2",
+		FilesToWrite:            []string{"/etc/added", "/etc/changed"},
+		FilesToDelete:           []string{"/etc/removed"},
+		UnitsToWrite:            []string{"/etc/systemd/system/added.service"},
+		UnitsToDelete:           []string{"/etc/systemd/system/removed.service"},
+		UnitsToEnable:           []string{"added.service"},
+		UnitsToDisable:          []string{"kept.service"},
+		UnitsToMask:             []string{"masked.service"},
+		KernelArgumentsToAdd:    []string{"baz"},
+		KernelArgumentsToRemove: []string{"foo=bar"},
+		ExtensionsToAdd:         []string{"usbguard"},
+		OSImage:                 &UpdatePlanChange{From: "registry/os@sha256:1", To: "registry/os@sha256:2"},
+		DrainRequired:           true,
+		Actions:                 []string{postConfigChangeActionReboot},
+	}, plan)
+}
+
+func TestPlanUpdateActions(t *testing.T) {
+	// Removing an unqualified search registry is not a safe change.
+	oldConfig := newPlanMachineConfig("old", []ign3types.File{ctrlcommon.NewIgnFile(constants.ContainerRegistryConfPath, `unqualified-search-registries = ["a.io", "b.io"]`)}, nil)
+	newConfig := newPlanMachineConfig("new", []ign3types.File{ctrlcommon.NewIgnFile(constants.ContainerRegistryConfPath, `unqualified-search-registries = ["a.io"]`)}, nil)
+
+	testCases := []struct {
+		name            string
+		opts            UpdatePlanOptions
+		expectedActions []string
+		expectedDrain   bool
+	}{
+		{
+			name:            "legacy actions",
+			expectedActions: []string{postConfigChangeActionReloadCrio},
+			expectedDrain:   true,
+		},
+		{
+			name:            "legacy actions with the image registry drain override",
+			opts:            UpdatePlanOptions{ImageRegistryDrainOverride: true},
+			expectedActions: []string{postConfigChangeActionReloadCrio},
+		},
+		{
+			name: "node disruption policies",
+			opts: UpdatePlanOptions{NodeDisruptionPolicies: &opv1.NodeDisruptionPolicyClusterStatus{
+				Files: []opv1.NodeDisruptionPolicyStatusFile{{
+					Path:    constants.ContainerRegistryConfPath,
+					Actions: []opv1.NodeDisruptionPolicyStatusAction{{Type: opv1.DrainStatusAction}, {Type: opv1.RestartStatusAction, Restart: &opv1.RestartService{ServiceName: "crio.service"}}},
+				}},
+			}},
+			expectedActions: []string{"Drain", "Restart crio.service"},
+			expectedDrain:   true,
+		},
+		{
+			name:            "force file",
+			opts:            UpdatePlanOptions{Force: true},
+			expectedActions: []string{postConfigChangeActionReboot},
+			expectedDrain:   true,
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			plan, err := PlanUpdate(oldConfig, newConfig, testCase.opts)
+			require.NoError(t, err)
+			assert.Equal(t, []string{constants.ContainerRegistryConfPath}, plan.FilesToWrite)
+			assert.Equal(t, testCase.expectedActions, plan.Actions)
+			assert.Equal(t, testCase.expectedDrain, plan.DrainRequired)
+		})
+	}
+}
+
+func TestPlanUpdateUnreconcilable(t *testing.T) {
+	oldConfig := newPlanMachineConfig("old", nil, nil)
+	ignCfg := ctrlcommon.NewIgnConfig()
+	ignCfg.Storage.Disks = []ign3types.Disk{{Device: "/dev/sdb"}}
+	newConfig := helpers.CreateMachineConfigFromIgnitionWithMetadata(ignCfg, "new", "worker")
+
+	plan, err := PlanUpdate(oldConfig, newConfig, UpdatePlanOptions{})
+	require.NoError(t, err)
+	assert.NotEmpty(t, plan.Unreconcilable)
+	assert.Empty(t, plan.Actions)
+}
+
+func TestPlanClusterUpdate(t *testing.T) {
+	oldConfig := newPlanMachineConfig("rendered-worker-1", nil, nil)
+	newConfig := newPlanMachineConfig("rendered-worker-2", []ign3types.File{ctrlcommon.NewIgnFile("/etc/added", "added\n")}, nil)
+	node := &corev1.Node{ObjectMeta: metav1.ObjectMeta{
+		Name: "node",
+		Annotations: map[string]string{
+			constants.CurrentMachineConfigAnnotationKey: "rendered-worker-1",
+			constants.DesiredMachineConfigAnnotationKey: "rendered-worker-2",
+		},
+	}}
+	mcop := &opv1.MachineConfiguration{
+		ObjectMeta: metav1.ObjectMeta{Name: ctrlcommon.MCOOperatorKnobsObjectName},
+		Status: opv1.MachineConfigurationStatus{NodeDisruptionPolicyStatus: opv1.NodeDisruptionPolicyStatus{
+			ClusterPolicies: opv1.NodeDisruptionPolicyClusterStatus{
+				Files: []opv1.NodeDisruptionPolicyStatusFile{{
+					Path:    "/etc/added",
+					Actions: []opv1.NodeDisruptionPolicyStatusAction{{Type: opv1.NoneStatusAction}},
+				}},
+			},
+		}},
+	}
+
+	plan, err := PlanClusterUpdate(context.TODO(), k8sfake.NewSimpleClientset(node), fake.NewSimpleClientset(oldConfig, newConfig), mcopfake.NewSimpleClientset(mcop), "node", "", false)
+	require.NoError(t, err)
+	assert.Equal(t, "rendered-worker-1", plan.OldConfig)
+	assert.Equal(t, "rendered-worker-2", plan.NewConfig)
+	assert.Equal(t, []string{"/etc/added"}, plan.FilesToWrite)
+	assert.Equal(t, []string{string(opv1.NoneStatusAction)}, plan.Actions)
+	assert.False(t, plan.DrainRequired)
+
+	_, err = PlanClusterUpdate(context.TODO(), k8sfake.NewSimpleClientset(node), fake.NewSimpleClientset(oldConfig), mcopfake.NewSimpleClientset(), "node", "", false)
+	assert.Error(t, err)
+}
+
+func TestWriteUpdatePlan(t *testing.T) {
+	plan := &UpdatePlan{
+		OldConfig:     "rendered-worker-1",
+		NewConfig:     "rendered-worker-2",
+		FilesToWrite:  []string{"/etc/added"},
+		KernelType:    &UpdatePlanChange{From: ctrlcommon.KernelTypeDefault, To: ctrlcommon.KernelTypeRealtime},
+		DrainRequired: true,
+		Actions:       []string{postConfigChangeActionReboot},
+	}
+
+	buf := &bytes.Buffer{}
+	require.NoError(t, WriteUpdatePlan(buf, plan, UpdatePlanOutputText))
+	assert.Equal(t, `Update from rendered-worker-1 to rendered-worker-2
+
+Files to write:
+  /etc/added
+
+Kernel type: default -> realtime
+
+Drain required: true
+Actions: reboot
+`, buf.String())
+
+	buf.Reset()
+	require.NoError(t, WriteUpdatePlan(buf, plan, UpdatePlanOutputJSON))
+	decoded := &UpdatePlan{}
+	require.NoError(t, json.Unmarshal(buf.Bytes(), decoded))
+	assert.Equal(t, plan, decoded)
+
+	assert.Error(t, WriteUpdatePlan(buf, plan, "yaml"))
+}
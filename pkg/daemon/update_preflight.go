@@ -0,0 +1,337 @@
+package daemon
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	goruntime "runtime"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/containers/image/v5/types"
+	digest "github.com/opencontainers/go-digest"
+	features "github.com/openshift/api/features"
+	mcfgv1 "github.com/openshift/api/machineconfiguration/v1"
+	mcfgalphav1 "github.com/openshift/api/machineconfiguration/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	kubeErrs "k8s.io/apimachinery/pkg/util/errors"
+	"k8s.io/klog/v2"
+
+	ctrlcommon "github.com/openshift/machine-config-operator/pkg/controller/common"
+	"github.com/openshift/machine-config-operator/pkg/daemon/constants"
+	"github.com/openshift/machine-config-operator/pkg/helpers"
+	"github.com/openshift/machine-config-operator/pkg/upgrademonitor"
+)
+
+const (
+	// sysrootPath is where rpm-ostree writes new deployments.
+	sysrootPath = "/sysroot"
+
+	// minSysrootFreeBytes is the free space required on /sysroot for a new
+	// deployment when the size of the OS image is unknown, and at least
+	// required otherwise.
+	minSysrootFreeBytes uint64 = 1 << 30
+
+	// maxKernelCmdlineLength is the size of the kernel command line buffer,
+	// including its terminating NUL, on the architectures we support except
+	// s390x.
+	maxKernelCmdlineLength = 2048
+
+	// maxS390xKernelCmdlineLength is the size of the kernel command line
+	// buffer on s390x.
+	maxS390xKernelCmdlineLength = 4096
+
+	// extensionsRepoDir is where the RPMs of the extensions are in the
+	// extensions image.
+	extensionsRepoDir = "usr/share/rpm-ostree/extensions"
+)
+
+// updatePreflightErr is returned when an update fails its preflight checks.
+// The checks run before the node is drained, so it keeps running workloads.
+type updatePreflightErr struct {
+	error
+}
+
+// imageInspect, sysrootFreeBytes, kernelCmdline and extractExtensionsImage
+// can be replaced by tests.
+var (
+	imageInspect = func(imgURL string) (*types.ImageInspectInfo, *digest.Digest, error) {
+		return ImageInspect(imgURL, "")
+	}
+
+	sysrootFreeBytes = func() (uint64, error) {
+		var stat syscall.Statfs_t
+		if err := syscall.Statfs(sysrootPath, &stat); err != nil {
+			return 0, err
+		}
+		return stat.Bavail * uint64(stat.Bsize), nil
+	}
+
+	kernelCmdline = func() (string, error) {
+		content, err := os.ReadFile(CmdLineFile)
+		if err != nil {
+			return "", err
+		}
+		return strings.TrimSpace(string(content)), nil
+	}
+
+	extractExtensionsImage = ExtractExtensionsImage
+)
+
+// checkImageReachable checks that the manifest of an image can be fetched
+// from its registry with the pull secret, and returns the size of its layers
+// or 0 if unknown.
+func checkImageReachable(imgURL string) (uint64, error) {
+	info, _, err := imageInspect(imgURL)
+	if err != nil {
+		return 0, fmt.Errorf("image %s is not reachable: %w", imgURL, err)
+	}
+	var size uint64
+	for _, layer := range info.LayersData {
+		if layer.Size > 0 {
+			size += uint64(layer.Size)
+		}
+	}
+	return size, nil
+}
+
+// checkSysrootFreeSpace checks that /sysroot has room for a new deployment of
+// an OS image of the given size.
+func checkSysrootFreeSpace(imageSize uint64) error {
+	required := imageSize
+	if required < minSysrootFreeBytes {
+		required = minSysrootFreeBytes
+	}
+	free, err := sysrootFreeBytes()
+	if err != nil {
+		return fmt.Errorf("could not get free space on %s: %w", sysrootPath, err)
+	}
+	if free < required {
+		return fmt.Errorf("%s has %d MiB free, a new deployment needs %d MiB", sysrootPath, free>>20, required>>20)
+	}
+	return nil
+}
+
+// checkKernelArguments checks that the kernel arguments of a config can be
+// passed to rpm-ostree.
+func checkKernelArguments(kargs []string) error {
+	parsed := parseKernelArguments(kargs)
+	for _, arg := range parsed {
+		if strings.Count(arg, `"`)%2 != 0 {
+			return fmt.Errorf("kernel argument %q has unbalanced quotes", arg)
+		}
+		if strings.HasPrefix(arg, "=") {
+			return fmt.Errorf("kernel argument %q has no key", arg)
+		}
+		for _, r := range arg {
+			if r < ' ' || r == 0x7f {
+				return fmt.Errorf("kernel argument %q has control characters", arg)
+			}
+		}
+	}
+	return nil
+}
+
+// kernelCmdlineLimit returns the size of the kernel command line buffer on an
+// architecture.
+func kernelCmdlineLimit(arch string) int {
+	if arch == "s390x" {
+		return maxS390xKernelCmdlineLength
+	}
+	return maxKernelCmdlineLength
+}
+
+// checkKernelCmdlineLength checks that the booted kernel command line, with
+// the kernel arguments of the old config replaced by those of the new one,
+// fits in the kernel command line buffer of limit bytes.
+func checkKernelCmdlineLength(cmdline string, oldKargs, newKargs []string, limit int) error {
+	args := splitKernelArguments(cmdline)
+	for _, old := range parseKernelArguments(oldKargs) {
+		for i, arg := range args {
+			if arg == old {
+				args = append(args[:i], args[i+1:]...)
+				break
+			}
+		}
+	}
+	args = append(args, parseKernelArguments(newKargs)...)
+	// The buffer holds the terminating NUL.
+	if length := len(strings.Join(args, " ")); length >= limit {
+		return fmt.Errorf("the kernel command line would be %d characters long, it is limited to %d", length, limit-1)
+	}
+	return nil
+}
+
+// extensionPackages returns the packages installed for the extensions of a
+// config: the packages of each extension on RHCOS, and the extensions
+// themselves on FCOS.
+func (dn *Daemon) extensionPackages(config *mcfgv1.MachineConfig) ([]string, error) {
+	if dn.os.IsEL() {
+		return ctrlcommon.GetPackagesForSupportedExtensions(config.Spec.Extensions)
+	}
+	return config.Spec.Extensions, nil
+}
+
+// checkExtensionPackages checks that an extensions image can be pulled and
+// has RPMs for all the given packages.
+func checkExtensionPackages(imgURL string, pkgs []string) error {
+	contentDir, err := extractExtensionsImage(imgURL)
+	if contentDir != "" {
+		defer os.RemoveAll(contentDir)
+	}
+	if err != nil {
+		return fmt.Errorf("image %s could not be extracted: %w", imgURL, err)
+	}
+
+	available := map[string]bool{}
+	repoDir := filepath.Join(contentDir, extensionsRepoDir)
+	err = filepath.WalkDir(repoDir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.IsDir() && strings.HasSuffix(d.Name(), ".rpm") {
+			available[rpmPackageName(d.Name())] = true
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("could not list the packages of image %s: %w", imgURL, err)
+	}
+
+	var missing []string
+	for _, pkg := range pkgs {
+		if !available[pkg] {
+			missing = append(missing, pkg)
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("image %s does not have packages %v", imgURL, missing)
+	}
+	return nil
+}
+
+// rpmPackageName returns the name of the package of an RPM file named
+// name-version-release.arch.rpm.
+func rpmPackageName(fileName string) string {
+	name := strings.TrimSuffix(fileName, ".rpm")
+	for i := 0; i < 2; i++ {
+		if idx := strings.LastIndex(name, "-"); idx > 0 {
+			name = name[:idx]
+		}
+	}
+	return name
+}
+
+// runUpdatePreflightChecks checks that an update can be applied before the
+// node is drained for it: the new OS image can be pulled and fits on
+// /sysroot, its kernel arguments are valid and its extensions are available.
+// A failed check degrades the node while it keeps running workloads.
+func (dn *Daemon) runUpdatePreflightChecks(oldConfig, newConfig *mcfgv1.MachineConfig, oldImage, newImage string) error {
+	// Only CoreOS nodes pull OS images and apply kernel arguments and
+	// extensions.
+	if !dn.os.IsCoreOSVariant() {
+		return nil
+	}
+	// This allows skip behavior based upon the presence of
+	// the forcefile: /run/machine-config-daemon-force.
+	if forceFileExists() {
+		klog.Infof("Skipping update preflight checks; %s present", constants.MachineConfigDaemonForceFile)
+		return nil
+	}
+
+	start := time.Now()
+	oldConfig = canonicalizeEmptyMC(oldConfig)
+	var (
+		mcDiff *machineConfigDiff
+		err    error
+	)
+	if oldImage != "" || newImage != "" {
+		mcDiff, err = newMachineConfigDiffFromLayered(oldConfig, newConfig, oldImage, newImage)
+	} else {
+		mcDiff, err = newMachineConfigDiff(oldConfig, newConfig)
+	}
+	if err != nil {
+		return err
+	}
+	newOSImageURL := canonicalizeMachineConfigImage(newImage, newConfig).Spec.OSImageURL
+
+	var errs []error
+	if mcDiff.OSUpdate && newOSImageURL != "" && newOSImageURL != dn.bootedOSImageURL {
+		imageSize, err := dn.checkOSImageAvailable(newOSImageURL)
+		if err != nil {
+			errs = append(errs, err)
+		}
+		if err := checkSysrootFreeSpace(imageSize); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if mcDiff.Kargs {
+		if err := checkKernelArguments(newConfig.Spec.KernelArguments); err != nil {
+			errs = append(errs, err)
+		}
+		if cmdline, err := kernelCmdline(); err != nil {
+			errs = append(errs, fmt.Errorf("could not read the kernel command line: %w", err))
+		} else if err := checkKernelCmdlineLength(cmdline, oldConfig.Spec.KernelArguments, newConfig.Spec.KernelArguments, kernelCmdlineLimit(goruntime.GOARCH)); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if mcDiff.Extensions && !mcDiff.oclEnabled && len(newConfig.Spec.Extensions) > 0 {
+		if pkgs, err := dn.extensionPackages(newConfig); err != nil {
+			errs = append(errs, err)
+		} else if extensionsImage := newConfig.Spec.BaseOSExtensionsContainerImage; extensionsImage != "" {
+			if err := checkExtensionPackages(extensionsImage, pkgs); err != nil {
+				errs = append(errs, fmt.Errorf("extensions are not available: %w", err))
+			}
+		}
+	}
+
+	if len(errs) == 0 {
+		klog.Infof("Update preflight checks successful (took %s)", time.Since(start))
+		return nil
+	}
+
+	err = &updatePreflightErr{fmt.Errorf("update preflight checks for %s failed: %w", newConfig.Name, kubeErrs.NewAggregate(errs))}
+	klog.Error(err)
+	if dn.nodeWriter != nil {
+		dn.nodeWriter.Eventf(corev1.EventTypeWarning, "UpdatePreflightCheckFailed", err.Error())
+	}
+	if dn.mcpLister == nil {
+		return err
+	}
+	if pool, poolErr := helpers.GetPrimaryPoolNameForMCN(dn.mcpLister, dn.node); poolErr == nil {
+		Nerr := upgrademonitor.GenerateAndApplyMachineConfigNodes(
+			&upgrademonitor.Condition{State: mcfgalphav1.MachineConfigNodeUpdatePrepared, Reason: string(mcfgalphav1.MachineConfigNodeUpdatePrepared), Message: fmt.Sprintf("Update failed preflight checks: %s", err)},
+			nil,
+			metav1.ConditionUnknown,
+			metav1.ConditionFalse,
+			dn.node,
+			dn.mcfgClient,
+			dn.featureGatesAccessor,
+			pool,
+		)
+		if Nerr != nil {
+			klog.Errorf("Error making MCN for update preflight checks failed: %v", Nerr)
+		}
+	}
+	return err
+}
+
+// checkOSImageAvailable checks that the new OS image can be pulled, unless it
+// is already pinned on the node, and returns its size or 0 if unknown.
+func (dn *Daemon) checkOSImageAvailable(imgURL string) (uint64, error) {
+	if dn.featureGatesAccessor != nil {
+		fg, err := dn.featureGatesAccessor.CurrentFeatureGates()
+		if err != nil {
+			return 0, err
+		}
+		if fg.Enabled(features.FeatureGatePinnedImages) {
+			if present, err := isImagePresent(imgURL); err == nil && present {
+				return 0, nil
+			}
+		}
+	}
+	return checkImageReachable(imgURL)
+}
@@ -0,0 +1,130 @@
+package daemon
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/containers/image/v5/types"
+	digest "github.com/opencontainers/go-digest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	ctrlcommon "github.com/openshift/machine-config-operator/pkg/controller/common"
+	"github.com/openshift/machine-config-operator/pkg/daemon/osrelease"
+	"github.com/openshift/machine-config-operator/test/helpers"
+)
+
+func TestCheckKernelArguments(t *testing.T) {
+	assert.NoError(t, checkKernelArguments([]string{"nosmt", `foo="bar baz"`, "hugepages=1 hugepagesz=1G"}))
+	assert.Error(t, checkKernelArguments([]string{`foo="bar`}))
+	assert.Error(t, checkKernelArguments([]string{"=bar"}))
+	assert.Error(t, checkKernelArguments([]string{"foo=\x01"}))
+}
+
+func TestCheckKernelCmdlineLength(t *testing.T) {
+	base := "BOOT_IMAGE=(hd0,gpt3)/ostree/rhcos/vmlinuz root=UUID=1 rw"
+	old := strings.Repeat("a", 1000)
+	cmdline := base + " " + old
+
+	// The kernel arguments of the old config are replaced.
+	assert.NoError(t, checkKernelCmdlineLength(cmdline, []string{old}, []string{strings.Repeat("b", 1900)}, maxKernelCmdlineLength))
+	// The base command line counts towards the limit.
+	assert.Error(t, checkKernelCmdlineLength(cmdline, []string{old}, []string{strings.Repeat("b", 2000)}, maxKernelCmdlineLength))
+	assert.Error(t, checkKernelCmdlineLength(cmdline, nil, []string{strings.Repeat("b", 1000)}, maxKernelCmdlineLength))
+	// s390x has a larger buffer.
+	assert.NoError(t, checkKernelCmdlineLength(cmdline, nil, []string{strings.Repeat("b", 2000)}, kernelCmdlineLimit("s390x")))
+	assert.Equal(t, maxKernelCmdlineLength, kernelCmdlineLimit("amd64"))
+}
+
+func TestCheckExtensionPackages(t *testing.T) {
+	origExtract := extractExtensionsImage
+	t.Cleanup(func() { extractExtensionsImage = origExtract })
+	extractExtensionsImage = func(string) (string, error) {
+		dir := t.TempDir()
+		repoDir := filepath.Join(dir, extensionsRepoDir, "x86_64")
+		require.NoError(t, os.MkdirAll(repoDir, 0o755))
+		for _, rpm := range []string{"usbguard-1.0.0-15.el9.x86_64.rpm", "kernel-devel-5.14.0-427.el9.x86_64.rpm"} {
+			require.NoError(t, os.WriteFile(filepath.Join(repoDir, rpm), nil, 0o644))
+		}
+		return dir, nil
+	}
+
+	assert.NoError(t, checkExtensionPackages("registry/extensions", []string{"usbguard", "kernel-devel"}))
+	err := checkExtensionPackages("registry/extensions", []string{"usbguard", "kernel-headers"})
+	assert.ErrorContains(t, err, "[kernel-headers]")
+
+	extractExtensionsImage = func(string) (string, error) {
+		return "", errors.New("unauthorized")
+	}
+	assert.ErrorContains(t, checkExtensionPackages("registry/extensions", []string{"usbguard"}), "unauthorized")
+}
+
+func TestCheckSysrootFreeSpace(t *testing.T) {
+	origFreeBytes := sysrootFreeBytes
+	t.Cleanup(func() { sysrootFreeBytes = origFreeBytes })
+	sysrootFreeBytes = func() (uint64, error) {
+		return 3 << 30, nil
+	}
+
+	assert.NoError(t, checkSysrootFreeSpace(0))
+	assert.NoError(t, checkSysrootFreeSpace(2<<30))
+	assert.Error(t, checkSysrootFreeSpace(4<<30))
+}
+
+func TestRunUpdatePreflightChecks(t *testing.T) {
+	fcos, err := osrelease.LoadOSRelease("ID=fedora\nVARIANT_ID=coreos\n", "ID=fedora\nVARIANT_ID=coreos\n")
+	require.NoError(t, err)
+
+	origInspect, origFreeBytes, origCmdline := imageInspect, sysrootFreeBytes, kernelCmdline
+	t.Cleanup(func() {
+		imageInspect = origInspect
+		sysrootFreeBytes = origFreeBytes
+		kernelCmdline = origCmdline
+	})
+	kernelCmdline = func() (string, error) {
+		return "root=UUID=1 rw", nil
+	}
+	var inspectErr error
+	imageInspect = func(string) (*types.ImageInspectInfo, *digest.Digest, error) {
+		if inspectErr != nil {
+			return nil, nil, inspectErr
+		}
+		return &types.ImageInspectInfo{LayersData: []types.ImageInspectLayer{{Size: 1 << 30}, {Size: 1 << 30}}}, nil, nil
+	}
+	free := uint64(3 << 30)
+	sysrootFreeBytes = func() (uint64, error) {
+		return free, nil
+	}
+
+	dn := &Daemon{os: fcos, bootedOSImageURL: "registry/os@sha256:1"}
+	oldConfig := helpers.CreateMachineConfigFromIgnitionWithMetadata(ctrlcommon.NewIgnConfig(), "rendered-worker-1", "worker")
+	oldConfig.Spec.OSImageURL = "registry/os@sha256:1"
+	newConfig := oldConfig.DeepCopy()
+	newConfig.Name = "rendered-worker-2"
+	newConfig.Spec.OSImageURL = "registry/os@sha256:2"
+	newConfig.Spec.KernelArguments = []string{"nosmt"}
+
+	assert.NoError(t, dn.runUpdatePreflightChecks(oldConfig, newConfig, "", ""))
+
+	inspectErr = errors.New("unauthorized")
+	free = 1 << 20
+	newConfig.Spec.KernelArguments = []string{`foo="bar`}
+	err = dn.runUpdatePreflightChecks(oldConfig, newConfig, "", "")
+	var preflightErr *updatePreflightErr
+	require.True(t, errors.As(err, &preflightErr))
+	assert.Contains(t, err.Error(), "unauthorized")
+	assert.Contains(t, err.Error(), "MiB free")
+	assert.Contains(t, err.Error(), "unbalanced quotes")
+
+	// The OS image is not checked once booted.
+	dn.bootedOSImageURL = newConfig.Spec.OSImageURL
+	newConfig.Spec.KernelArguments = []string{"nosmt"}
+	assert.NoError(t, dn.runUpdatePreflightChecks(oldConfig, newConfig, "", ""))
+
+	// Only CoreOS nodes are checked.
+	dn = &Daemon{os: osrelease.OperatingSystem{}}
+	assert.NoError(t, dn.runUpdatePreflightChecks(oldConfig, newConfig, "", ""))
+}
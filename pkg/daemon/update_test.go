@@ -51,15 +51,18 @@ func setupTempDirWithEtc(t *testing.T) (string, func()) {
 
 	oldOrigParentDirPath := origParentDirPath
 	oldNoOrigParentDirPath := noOrigParentDirPath
+	oldAppendParentDirPath := appendParentDirPath
 
 	// Override these package variables so files get written to our testing location
 	origParentDirPath = filepath.Join(testDir, origParentDirPath)
 	noOrigParentDirPath = filepath.Join(testDir, noOrigParentDirPath)
+	appendParentDirPath = filepath.Join(testDir, appendParentDirPath)
 
 	return testDir, func() {
 		// Make sure path variables get put back for other tests
 		origParentDirPath = oldOrigParentDirPath
 		noOrigParentDirPath = oldNoOrigParentDirPath
+		appendParentDirPath = oldAppendParentDirPath
 	}
 }
 
@@ -96,7 +99,7 @@ func TestMachineConfigDiff(t *testing.T) {
 	diff, err = newMachineConfigDiff(oldConfig, newConfig)
 	assert.Nil(t, err)
 	assert.False(t, diff.isEmpty())
-	assert.True(t, diff.osUpdate)
+	assert.True(t, diff.OSUpdate)
 
 	emptyMc := canonicalizeEmptyMC(nil)
 	otherEmptyMc := canonicalizeEmptyMC(nil)
@@ -168,7 +171,7 @@ func TestMachineConfigDiff(t *testing.T) {
 			diff, err = newMachineConfigDiff(testCase.baseMC, newMC)
 			assert.Nil(t, err)
 			assert.False(t, diff.isEmpty())
-			assert.True(t, diff.passwd)
+			assert.True(t, diff.Passwd)
 		})
 	}
 }
@@ -197,27 +200,27 @@ func TestReconcilableDiff(t *testing.T) {
 
 	diff, err := reconcilable(oldConfig, newConfig)
 	checkReconcilableResults(t, "add file", err)
-	assert.Equal(t, diff.osUpdate, false)
-	assert.Equal(t, diff.passwd, false)
-	assert.Equal(t, diff.units, false)
-	assert.Equal(t, diff.files, true)
+	assert.Equal(t, diff.OSUpdate, false)
+	assert.Equal(t, diff.Passwd, false)
+	assert.Equal(t, diff.Units, false)
+	assert.Equal(t, diff.Files, true)
 
 	newConfig = newMachineConfigFromFiles(nil)
 	diff, err = reconcilable(oldConfig, newConfig)
 	checkReconcilableResults(t, "remove all files", err)
-	assert.Equal(t, diff.osUpdate, false)
-	assert.Equal(t, diff.passwd, false)
-	assert.Equal(t, diff.units, false)
-	assert.Equal(t, diff.files, true)
+	assert.Equal(t, diff.OSUpdate, false)
+	assert.Equal(t, diff.Passwd, false)
+	assert.Equal(t, diff.Units, false)
+	assert.Equal(t, diff.Files, true)
 
 	newConfig = newMachineConfigFromFiles(oldFiles)
 	newConfig.Spec.OSImageURL = "example.com/rhel-coreos:new"
 	diff, err = reconcilable(oldConfig, newConfig)
 	checkReconcilableResults(t, "os update", err)
-	assert.Equal(t, diff.osUpdate, true)
-	assert.Equal(t, diff.passwd, false)
-	assert.Equal(t, diff.units, false)
-	assert.Equal(t, diff.files, false)
+	assert.Equal(t, diff.OSUpdate, true)
+	assert.Equal(t, diff.Passwd, false)
+	assert.Equal(t, diff.Units, false)
+	assert.Equal(t, diff.Files, false)
 }
 
 func TestKernelAguments(t *testing.T) {
@@ -354,6 +357,84 @@ func TestWriteFiles(t *testing.T) {
 	}
 }
 
+func TestWriteAndDeleteDirectoriesAndLinks(t *testing.T) {
+	testDir, cleanup := setupTempDirWithEtc(t)
+	defer cleanup()
+
+	d := newMockDaemon()
+
+	// use current user so test doesn't try to chown to root
+	uid, gid := os.Getuid(), os.Getgid()
+	owned := func(path string) ign3types.Node {
+		return ign3types.Node{Path: path, User: ign3types.NodeUser{ID: &uid}, Group: ign3types.NodeGroup{ID: &gid}}
+	}
+
+	parent := filepath.Join(testDir, "parent")
+	nested := filepath.Join(parent, "nested")
+	existing := filepath.Join(testDir, "existing")
+	require.NoError(t, os.Mkdir(existing, 0o700))
+	target := filepath.Join(testDir, "target")
+	require.NoError(t, os.WriteFile(target, []byte("target\n"), 0o644))
+	symlink := filepath.Join(testDir, "symlink")
+	hardlink := filepath.Join(testDir, "hardlink")
+
+	dirs := []ign3types.Directory{
+		{Node: owned(parent)},
+		{Node: owned(nested), DirectoryEmbedded1: ign3types.DirectoryEmbedded1{Mode: helpers.IntToPtr(0o1750)}},
+		{Node: owned(existing), DirectoryEmbedded1: ign3types.DirectoryEmbedded1{Mode: helpers.IntToPtr(0o755)}},
+	}
+	links := []ign3types.Link{
+		{Node: owned(symlink), LinkEmbedded1: ign3types.LinkEmbedded1{Target: &target}},
+		{Node: owned(hardlink), LinkEmbedded1: ign3types.LinkEmbedded1{Target: &target, Hard: helpers.BoolToPtr(true)}},
+	}
+
+	require.NoError(t, d.writeDirectories(dirs))
+	require.NoError(t, d.writeLinks(links))
+	assert.NoError(t, checkV3Directories(dirs))
+	assert.NoError(t, checkV3Links(links))
+
+	fi, err := os.Stat(nested)
+	require.NoError(t, err)
+	assert.Equal(t, os.ModeDir|os.ModeSticky|0o750, fi.Mode())
+
+	// Writing again is a no-op.
+	require.NoError(t, d.writeDirectories(dirs))
+	require.NoError(t, d.writeLinks(links))
+	assert.NoError(t, checkV3Links(links))
+
+	// A file in the way of a directory is an error.
+	assert.Error(t, writeDirectories([]ign3types.Directory{{Node: owned(target)}}))
+
+	// Unrelated contents keep a stale directory around.
+	unrelated := filepath.Join(parent, "unrelated")
+	require.NoError(t, os.WriteFile(unrelated, nil, 0o644))
+
+	// Links written at install time have no stamp.
+	installLink := filepath.Join(filepath.Dir(target), "install-link")
+	require.NoError(t, os.Symlink(target, installLink))
+	links = append(links, ign3types.Link{Node: owned(installLink), LinkEmbedded1: ign3types.LinkEmbedded1{Target: &target}})
+
+	require.NoError(t, deleteStaleLinks(links, nil))
+	require.NoError(t, deleteStaleDirectories(dirs, nil))
+	for _, path := range []string{symlink, hardlink, nested} {
+		_, err := os.Lstat(path)
+		assert.True(t, os.IsNotExist(err), path)
+	}
+	// Links the MCD did not create are kept.
+	_, err = os.Lstat(installLink)
+	assert.NoError(t, err)
+	// Directories the MCD did not create are kept.
+	assert.DirExists(t, existing)
+	assert.DirExists(t, parent)
+	assert.FileExists(t, target)
+
+	require.NoError(t, os.Remove(unrelated))
+	require.NoError(t, deleteStaleDirectories(dirs, nil))
+	assert.NoDirExists(t, parent)
+	_, err = os.Stat(noOrigFileStampName(parent))
+	assert.True(t, os.IsNotExist(err))
+}
+
 // This test provides a false sense of security. Given the combination of the
 // mock mode in the MCD coupled with the inputs into this test, it effectively
 // no-ops and does not test what we think it tests.
@@ -403,7 +484,7 @@ func TestInvalidIgnConfig(t *testing.T) {
 	newMcfg = helpers.CreateMachineConfigFromIgnition(newIgnConfig)
 	diff, err := reconcilable(oldMcfg, newMcfg)
 	assert.Nil(t, err, "Expected no error. Absolute paths should not fail general ignition validation")
-	assert.Equal(t, diff.files, true)
+	assert.Equal(t, diff.Files, true)
 }
 
 func TestDropinCheck(t *testing.T) {
@@ -88,6 +88,9 @@ const (
 	mccEventsRoleBindingTargetManifestPath                            = "manifests/machineconfigcontroller/events-rolebinding-target.yaml"
 	mccClusterRoleBindingManifestPath                                 = "manifests/machineconfigcontroller/clusterrolebinding.yaml"
 	mccServiceAccountManifestPath                                     = "manifests/machineconfigcontroller/sa.yaml"
+	mccLifecycleHooksRoleManifestPath                                 = "manifests/machineconfigcontroller/lifecycle-hooks-role.yaml"
+	mccLifecycleHooksRoleBindingManifestPath                          = "manifests/machineconfigcontroller/lifecycle-hooks-rolebinding.yaml"
+	mccLifecycleHookServiceAccountManifestPath                        = "manifests/machineconfigcontroller/lifecycle-hook-sa.yaml"
 	mccKubeRbacProxyConfigMapPath                                     = "manifests/machineconfigcontroller/kube-rbac-proxy-config.yaml"
 	mccKubeRbacProxyPrometheusRolePath                                = "manifests/machineconfigcontroller/prometheus-rbac.yaml"
 	mccKubeRbacProxyPrometheusRoleBindingPath                         = "manifests/machineconfigcontroller/prometheus-rolebinding-target.yaml"
@@ -120,12 +123,16 @@ const (
 	mcdKubeRbacProxyPrometheusRoleBindingPath       = "manifests/machineconfigdaemon/prometheus-rolebinding-target.yaml"
 	mcdRolePath                                     = "manifests/machineconfigdaemon/role.yaml"
 	mcdRoleBindingPath                              = "manifests/machineconfigdaemon/rolebinding.yaml"
+	mcdFileSourcesRolePath                          = "manifests/machineconfigdaemon/file-sources-role.yaml"
+	mcdFileSourcesRoleBindingPath                   = "manifests/machineconfigdaemon/file-sources-rolebinding.yaml"
 	mcdMCNGuardValidatingAdmissionPolicyPath        = "manifests/machineconfigdaemon/mcn-guards-validatingadmissionpolicy.yaml"
 	mcdMCNGuardValidatingAdmissionPolicyBindingPath = "manifests/machineconfigdaemon/mcn-guards-validatingadmissionpolicybinding.yaml"
 
 	// Machine Config Server manifest paths
 	mcsClusterRoleManifestPath                    = "manifests/machineconfigserver/clusterrole.yaml"
 	mcsClusterRoleBindingManifestPath             = "manifests/machineconfigserver/clusterrolebinding.yaml"
+	mcsFileSourcesRoleManifestPath                = "manifests/machineconfigserver/file-sources-role.yaml"
+	mcsFileSourcesRoleBindingManifestPath         = "manifests/machineconfigserver/file-sources-rolebinding.yaml"
 	mcsCSRBootstrapRoleBindingManifestPath        = "manifests/machineconfigserver/csr-bootstrap-role-binding.yaml"
 	mcsCSRRenewalRoleBindingManifestPath          = "manifests/machineconfigserver/csr-renewal-role-binding.yaml"
 	mcsServiceAccountManifestPath                 = "manifests/machineconfigserver/sa.yaml"
@@ -1121,12 +1128,14 @@ func (optr *Operator) syncMachineConfigController(config *renderConfig, _ *confi
 		},
 		roles: []string{
 			mccKubeRbacProxyPrometheusRolePath,
+			mccLifecycleHooksRoleManifestPath,
 		},
 		roleBindings: []string{
 			mccEventsRoleBindingDefaultManifestPath,
 			mccEventsRoleBindingTargetManifestPath,
 			mccKubeRbacProxyPrometheusRoleBindingPath,
 			mopRoleBindingManifestPath,
+			mccLifecycleHooksRoleBindingManifestPath,
 		},
 		clusterRoleBindings: []string{
 			mccClusterRoleBindingManifestPath,
@@ -1137,6 +1146,7 @@ func (optr *Operator) syncMachineConfigController(config *renderConfig, _ *confi
 		serviceAccounts: []string{
 			mccServiceAccountManifestPath,
 			mopServiceAccountManifestPath,
+			mccLifecycleHookServiceAccountManifestPath,
 		},
 		validatingAdmissionPolicies: []string{
 			mccMachineConfigurationGuardsValidatingAdmissionPolicyPath,
@@ -1586,12 +1596,14 @@ func (optr *Operator) syncMachineConfigDaemon(config *renderConfig, _ *configv1.
 		roles: []string{
 			mcdKubeRbacProxyPrometheusRolePath,
 			mcdRolePath,
+			mcdFileSourcesRolePath,
 		},
 		roleBindings: []string{
 			mcdEventsRoleBindingDefaultManifestPath,
 			mcdEventsRoleBindingTargetManifestPath,
 			mcdKubeRbacProxyPrometheusRoleBindingPath,
 			mcdRoleBindingPath,
+			mcdFileSourcesRoleBindingPath,
 		},
 		clusterRoleBindings: []string{
 			mcdClusterRoleBindingManifestPath,
@@ -1639,6 +1651,12 @@ func (optr *Operator) syncMachineConfigServer(config *renderConfig, _ *configv1.
 		clusterRoles: []string{
 			mcsClusterRoleManifestPath,
 		},
+		roles: []string{
+			mcsFileSourcesRoleManifestPath,
+		},
+		roleBindings: []string{
+			mcsFileSourcesRoleBindingManifestPath,
+		},
 		clusterRoleBindings: []string{
 			mcsClusterRoleBindingManifestPath,
 			mcsCSRBootstrapRoleBindingManifestPath,
@@ -112,6 +112,13 @@ func (bsc *bootstrapServer) GetConfig(cr poolRequest) (*runtime.RawExtension, er
 		return nil, fmt.Errorf("failed to migrate kernel args %w", err)
 	}
 
+	// There is no cluster to read in-cluster file sources from yet.
+	if err := resolveInClusterFileSources(&ignConf, func(*ctrlcommon.InClusterSource) ([]byte, error) {
+		return nil, fmt.Errorf("in-cluster file sources are not supported when bootstrapping")
+	}); err != nil {
+		return nil, err
+	}
+
 	fileName = path.Join(bsc.serverBaseDir, "controller-config", "machine-config-controller.yaml")
 	klog.Infof("reading file %q", fileName)
 	data, err = os.ReadFile(fileName)
@@ -1,6 +1,7 @@
 package server
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -15,8 +16,10 @@ import (
 	"github.com/openshift/machine-config-operator/internal/clients"
 	ctrlcommon "github.com/openshift/machine-config-operator/pkg/controller/common"
 	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/client-go/informers"
+	corev1client "k8s.io/client-go/kubernetes/typed/core/v1"
 	corelisterv1 "k8s.io/client-go/listers/core/v1"
 	"k8s.io/client-go/tools/cache"
 	clientcmdv1 "k8s.io/client-go/tools/clientcmd/api/v1"
@@ -42,6 +45,9 @@ type clusterServer struct {
 	machineConfigLister     v1.MachineConfigLister
 	controllerConfigLister  v1.ControllerConfigLister
 	configMapLister         corelisterv1.ConfigMapLister
+	// secretClient reads the Secrets referenced by file sources. They are not
+	// cached, so the MCS only reads the Secrets a served config references.
+	secretClient corev1client.SecretsGetter
 
 	kubeconfigFunc kubeconfigFunc
 	apiserverURL   string
@@ -101,6 +107,7 @@ func NewClusterServer(kubeConfig, apiserverURL string) (Server, error) {
 		machineConfigLister:     mcLister,
 		controllerConfigLister:  ccLister,
 		configMapLister:         cmLister,
+		secretClient:            kubeClient.CoreV1(),
 		kubeconfigFunc:          func() ([]byte, []byte, error) { return kubeconfigFromSecret(bootstrapTokenDir, apiserverURL, nil) },
 		apiserverURL:            apiserverURL,
 	}, nil
@@ -161,6 +168,10 @@ func (cs *clusterServer) GetConfig(cr poolRequest) (*runtime.RawExtension, error
 		return nil, fmt.Errorf("failed to migrate kernel args %w", err)
 	}
 
+	if err := resolveInClusterFileSources(&ignConf, cs.getInClusterSourceData); err != nil {
+		return nil, err
+	}
+
 	addDataAndMaybeAppendToIgnition(caBundleFilePath, cc.Spec.KubeAPIServerServingCAData, &ignConf)
 	addDataAndMaybeAppendToIgnition(cloudProviderCAPath, cc.Spec.CloudProviderCAData, &ignConf)
 	appenders := getAppenders(currConf, cr.version, cs.kubeconfigFunc, []string{}, "")
@@ -177,6 +188,29 @@ func (cs *clusterServer) GetConfig(cr poolRequest) (*runtime.RawExtension, error
 	return &runtime.RawExtension{Raw: rawConf}, nil
 }
 
+// getInClusterSourceData returns the contents of the key of the ConfigMap or
+// Secret referenced by a file source.
+func (cs *clusterServer) getInClusterSourceData(ref *ctrlcommon.InClusterSource) ([]byte, error) {
+	if ref.IsSecret() {
+		if cs.secretClient == nil {
+			return nil, fmt.Errorf("no secret client")
+		}
+		secret, err := cs.secretClient.Secrets(ref.Namespace()).Get(context.TODO(), ref.Name, metav1.GetOptions{})
+		if err != nil {
+			return nil, err
+		}
+		return ctrlcommon.SecretSourceData(secret, ref.Key)
+	}
+	if cs.configMapLister == nil {
+		return nil, fmt.Errorf("no configmap lister")
+	}
+	cm, err := cs.configMapLister.ConfigMaps(ref.Namespace()).Get(ref.Name)
+	if err != nil {
+		return nil, err
+	}
+	return ctrlcommon.ConfigMapSourceData(cm, ref.Key)
+}
+
 // kubeconfigFromSecret creates a kubeconfig with the certificate
 // and token files in secretDir. If caData is provided, it will instead
 // use that to populate the kubeconfig
@@ -232,6 +232,33 @@ func MigrateKernelArgsIfNecessary(conf *ign3types.Config, mc *mcfgv1.MachineConf
 	return nil
 }
 
+// resolveInClusterFileSources inlines the contents of the in-cluster file
+// sources of a config, which Ignition cannot fetch, after verifying them
+// against their hash. HTTPS sources are left for Ignition to fetch.
+func resolveInClusterFileSources(ignConf *ign3types.Config, getData func(ref *ctrlcommon.InClusterSource) ([]byte, error)) error {
+	for idx, file := range ignConf.Storage.Files {
+		if !ctrlcommon.IsRemoteFileSource(file.Contents.Source) {
+			continue
+		}
+		ref, err := ctrlcommon.ParseInClusterSource(*file.Contents.Source)
+		if err != nil {
+			return fmt.Errorf("could not resolve source of file %q: %w", file.Path, err)
+		}
+		if ref == nil {
+			continue
+		}
+		contents, err := getData(ref)
+		if err != nil {
+			return fmt.Errorf("could not read %s %s for file %q: %w", ref.Resource, ref.Name, file.Path, err)
+		}
+		if err := ctrlcommon.VerifyFileSourceHash(file.Contents.Verification, contents); err != nil {
+			return fmt.Errorf("could not verify contents of file %q: %w", file.Path, err)
+		}
+		ignConf.Storage.Files[idx] = ctrlcommon.InlineFileContents(file, contents)
+	}
+	return nil
+}
+
 func addDataAndMaybeAppendToIgnition(path string, data []byte, ignConf *ign3types.Config) {
 	exists := false
 	for idx, file := range ignConf.Storage.Files {
@@ -1,6 +1,7 @@
 package server
 
 import (
+	"crypto/sha256"
 	"encoding/base64"
 	"encoding/json"
 	"fmt"
@@ -21,6 +22,9 @@ import (
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/labels"
+	k8sfake "k8s.io/client-go/kubernetes/fake"
+	corelisterv1 "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
 
 	mcfgv1 "github.com/openshift/api/machineconfiguration/v1"
 	ctrlcommon "github.com/openshift/machine-config-operator/pkg/controller/common"
@@ -478,6 +482,53 @@ func getTestControllerConfig() *mcfgv1.ControllerConfig {
 	}
 }
 
+func TestResolveInClusterFileSources(t *testing.T) {
+	hash := fmt.Sprintf("sha256-%x", sha256.Sum256([]byte("contents")))
+	cmSource := ctrlcommon.InClusterSource{Resource: "configmaps", Name: "bundles", Key: "ca"}.String()
+	secretSource := ctrlcommon.InClusterSource{Resource: "secrets", Name: "tokens", Key: "token"}.String()
+	httpsSource := "https://example.com/contents"
+	newConf := func() ign3types.Config {
+		conf := ctrlcommon.NewIgnConfig()
+		for path, source := range map[string]*string{"/etc/ca": &cmSource, "/etc/token": &secretSource, "/etc/remote": &httpsSource} {
+			conf.Storage.Files = append(conf.Storage.Files, ign3types.File{
+				Node:          ign3types.Node{Path: path},
+				FileEmbedded1: ign3types.FileEmbedded1{Contents: ign3types.Resource{Source: source, Verification: ign3types.Verification{Hash: &hash}}},
+			})
+		}
+		return conf
+	}
+	newServer := func(data string) *clusterServer {
+		cmIndexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+		require.NoError(t, cmIndexer.Add(&corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Name: "bundles", Namespace: ctrlcommon.MCONamespace},
+			Data:       map[string]string{"ca": data},
+		}))
+		return &clusterServer{
+			configMapLister: corelisterv1.NewConfigMapLister(cmIndexer),
+			secretClient: k8sfake.NewSimpleClientset(&corev1.Secret{
+				ObjectMeta: metav1.ObjectMeta{Name: "tokens", Namespace: ctrlcommon.FileSourcesNamespace},
+				Data:       map[string][]byte{"token": []byte(data)},
+			}).CoreV1(),
+		}
+	}
+
+	// In-cluster sources are inlined, https sources are left for Ignition.
+	conf := newConf()
+	require.NoError(t, resolveInClusterFileSources(&conf, newServer("contents").getInClusterSourceData))
+	for _, file := range conf.Storage.Files {
+		if file.Path == "/etc/remote" {
+			assert.Equal(t, httpsSource, *file.Contents.Source)
+			continue
+		}
+		contents, err := ctrlcommon.DecodeIgnitionFileContents(file.Contents.Source, nil)
+		require.NoError(t, err)
+		assert.Equal(t, "contents", string(contents), file.Path)
+	}
+
+	conf = newConf()
+	assert.ErrorContains(t, resolveInClusterFileSources(&conf, newServer("other").getInClusterSourceData), "hash mismatch")
+}
+
 func TestKubeconfigFromSecret(t *testing.T) {
 	tests := []struct {
 		SecretPath string
@@ -22,6 +22,11 @@ import (
 
 const NotYetSet = "not-yet-set"
 
+// MachineConfigNodeUpdateHistory is the condition listing the latest updates
+// of the node. It is informational and does not take part in the progress of
+// an update.
+const MachineConfigNodeUpdateHistory mcfgalphav1.StateProgress = "UpdateHistory"
+
 type Condition struct {
 	State   mcfgalphav1.StateProgress
 	Reason  string
@@ -190,7 +195,8 @@ func generateAndApplyMachineConfigNodes(
 		// also set all other ones to false and update last transition time.
 		for i, condition := range newMCNode.Status.Conditions {
 			switch {
-			case condition.Type == string(mcfgalphav1.MachineConfigNodeUpdated) && condition.Status == metav1.ConditionTrue && condition.Type != newParentCondition.Type:
+			case condition.Type == string(mcfgalphav1.MachineConfigNodeUpdated) && condition.Status == metav1.ConditionTrue && condition.Type != newParentCondition.Type &&
+				newParentCondition.Type != string(MachineConfigNodeUpdateHistory):
 				// if this happens, it is because we manually updated the MCO.
 				// so, if we get a parent state == unknown or true or ANYTHING and updated also == true but it isn't the parent, set updated == false
 				newC := metav1.Condition{
@@ -218,7 +224,7 @@ func generateAndApplyMachineConfigNodes(
 				}
 				newParentCondition.DeepCopyInto(&condition)
 
-			case condition.Status != metav1.ConditionFalse && reset:
+			case condition.Status != metav1.ConditionFalse && reset && condition.Type != string(MachineConfigNodeUpdateHistory):
 				condition.Status = metav1.ConditionFalse
 				condition.Message = fmt.Sprintf("Action during update to %s: %s", newMCNode.Spec.ConfigVersion.Desired, condition.Message)
 				condition.LastTransitionTime = metav1.Now()